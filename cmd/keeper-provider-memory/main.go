@@ -0,0 +1,26 @@
+// Command keeper-provider-memory is the reference keeper provider plugin.
+// It keeps secrets in memory for as long as keeper runs it.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/keeper/pkg/api"
+	"github.com/keeper/pkg/plugin"
+	"github.com/keeper/pkg/plugin/memory"
+)
+
+func main() {
+	err := plugin.Serve("memory", func(ctx context.Context, parameters map[string]interface{}) (api.Provider, error) {
+		for name := range parameters {
+			return nil, fmt.Errorf("memory provider: unknown parameter %q", name)
+		}
+		return memory.New(), nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -3,85 +3,303 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/providers"
 	"github.com/spf13/cobra"
 )
 
+// backupPassphraseEnv holds the passphrase of backups when set
+const backupPassphraseEnv = "KEEPER_BACKUP_PASSPHRASE"
+
 var (
 	backupFormat      string
 	includeVersions   bool
-	compressBackup    bool
+	compressBackup    string
 	encryptBackup     bool
+	backupKeyFile     string
 	overwriteExisting bool
 	restoreVersions   bool
+	restoreConflict   string
+	restoreNames      []string
+	restoreTags       []string
+	restoreSchema     string
+	restoreDryRun     bool
 )
 
 var backupCmd = &cobra.Command{
-	Use:   "backup [directory]",
-	Short: "Backup all secrets to a directory",
-	Args:  cobra.ExactArgs(1),
+	Use:   "backup [path]",
+	Short: "Backup all secrets to an archive",
+	Long: `Write every secret and schema to a single tar archive, optionally
+compressed with zstd or gzip. Given a directory, the archive is created in
+it with a timestamped name.
+
+Archives are encrypted by default, with a key derived from a passphrase read
+from KEEPER_BACKUP_PASSPHRASE or the terminal, or with a random key kept in
+--key-file, which is generated if it does not exist. The manifest listing
+the secrets, schemas, versions and checksums is always readable.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		dir := args[0]
+		p, err := archiveProvider()
+		if err != nil {
+			return err
+		}
 
-		// Create backup directory if it doesn't exist
-		if err := os.MkdirAll(dir, 0700); err != nil {
-			return fmt.Errorf("failed to create backup directory: %w", err)
+		compression := compressBackup
+		if compression == "none" {
+			compression = backup.CompressionNone
 		}
 
-		// Set backup directory in provider config
-		if err := provider.SetBackupDir(dir); err != nil {
-			return fmt.Errorf("failed to set backup directory: %w", err)
+		path := args[0]
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			name := "keeper-" + time.Now().Format("20060102-150405") + backup.Extension(compression)
+			path = filepath.Join(path, name)
+		}
+
+		opts := backup.Options{
+			Format:      backupFormat,
+			Compression: compression,
+			Versions:    includeVersions,
+		}
+		if encryptBackup {
+			key, err := backupKey(true)
+			if err != nil {
+				return err
+			}
+			opts.Key = key
+		}
+		p.SetBackupOptions(opts)
+
+		if err := p.SetBackupDir(path); err != nil {
+			return fmt.Errorf("failed to set backup path: %w", err)
 		}
 
 		// Backup secrets
-		if err := provider.Backup(cmd.Context()); err != nil {
+		if err := p.Backup(cmd.Context()); err != nil {
 			return fmt.Errorf("failed to backup secrets: %w", err)
 		}
 
-		fmt.Printf("Successfully backed up secrets to %s\n", dir)
+		fmt.Printf("Successfully backed up secrets to %s\n", path)
 		return nil
 	},
 }
 
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify [path]",
+	Short: "Check that a backup can be restored",
+	Long: `Check every entry of a backup archive against the checksum in its
+manifest, decrypt it with --key-file or the backup passphrase, and validate
+secrets against their schemas. Directory backups written by older versions
+are decrypted with the master key of the store.
+
+Missing, corrupt and invalid items are listed, and the command exits with
+a non-zero status if there are any, so that scheduled jobs can alert on a
+bad backup.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("failed to open backup: %w", err)
+		}
+
+		p, err := archiveProvider()
+		if err != nil {
+			return err
+		}
+
+		key, err := backupKey(false)
+		if err != nil {
+			return err
+		}
+		p.SetBackupOptions(backup.Options{Key: key})
+		if err := p.SetBackupDir(path); err != nil {
+			return fmt.Errorf("failed to set backup path: %w", err)
+		}
+
+		report, err := p.VerifyBackup(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to verify backup: %w", err)
+		}
+
+		fmt.Printf("Checked %d secrets, %d previous versions and %d schemas in %s\n", report.Secrets, report.Versions, report.Schemas, path)
+		if report.OK() {
+			fmt.Println("Backup is OK")
+			return nil
+		}
+
+		fmt.Printf("Found %d problems:\n", len(report.Problems))
+		for _, problem := range report.Problems {
+			fmt.Printf("- %s\n", problem)
+		}
+		return fmt.Errorf("backup verification failed")
+	},
+}
+
 var restoreCmd = &cobra.Command{
-	Use:   "restore [directory]",
-	Short: "Restore secrets from a backup directory",
-	Args:  cobra.ExactArgs(1),
+	Use:   "restore [path]",
+	Short: "Restore secrets from a backup",
+	Long: `Restore secrets from a backup archive, or from a backup directory written
+by older versions. Encrypted archives are opened with --key-file or a
+passphrase read from KEEPER_BACKUP_PASSPHRASE or the terminal.
+
+The restore plan lists each secret of the backup as new, changed or
+identical to the store. New secrets are created, identical ones left alone
+and changed ones handled by --conflict:
+
+  skip         keep the secret in the store (default)
+  overwrite    store the backup as a new version
+  keep-newest  keep whichever was updated last
+  rename       restore the backup as <name>.restored
+
+Secrets are restored all at once: if any of them fails, none is written.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		dir := args[0]
+		path := args[0]
+
+		// Check if backup exists
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return fmt.Errorf("backup does not exist: %s", path)
+		}
 
-		// Check if backup directory exists
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			return fmt.Errorf("backup directory does not exist: %s", dir)
+		p, err := archiveProvider()
+		if err != nil {
+			return err
 		}
 
-		// Set backup directory in provider config
-		if err := provider.SetBackupDir(dir); err != nil {
-			return fmt.Errorf("failed to set backup directory: %w", err)
+		key, err := backupKey(false)
+		if err != nil {
+			return err
+		}
+		p.SetBackupOptions(backup.Options{Key: key})
+
+		// Set backup path in provider config
+		if err := p.SetBackupDir(path); err != nil {
+			return fmt.Errorf("failed to set backup path: %w", err)
+		}
+
+		opts := backup.RestoreOptions{
+			Conflict: restoreConflict,
+			Names:    restoreNames,
+			Tags:     restoreTags,
+			Schema:   restoreSchema,
+			Versions: restoreVersions,
+			DryRun:   restoreDryRun,
+		}
+		if overwriteExisting {
+			if cmd.Flags().Changed("conflict") && restoreConflict != backup.ConflictOverwrite {
+				return fmt.Errorf("--overwrite cannot be combined with --conflict %s", restoreConflict)
+			}
+			opts.Conflict = backup.ConflictOverwrite
 		}
 
 		// Restore secrets
-		if err := provider.Restore(cmd.Context()); err != nil {
+		plan, err := p.RestoreBackup(cmd.Context(), opts)
+		if err != nil {
 			return fmt.Errorf("failed to restore secrets: %w", err)
 		}
 
-		fmt.Printf("Successfully restored secrets from %s\n", dir)
+		printRestorePlan(plan)
+		restored := len(plan.Restored())
+		if restoreDryRun {
+			fmt.Printf("Would restore %d secrets from %s\n", restored, path)
+			return nil
+		}
+
+		fmt.Printf("Successfully restored %d secrets from %s\n", restored, path)
 		return nil
 	},
 }
 
+// printRestorePlan prints what a restore does with each secret
+func printRestorePlan(plan *backup.RestorePlan) {
+	for _, item := range plan.Items {
+		action := "skip"
+		switch {
+		case item.Target == "":
+		case item.Target != item.Name:
+			action = "restore as " + item.Target
+		case item.Status == backup.StatusNew:
+			action = "create"
+		default:
+			action = "overwrite"
+		}
+		if len(item.Versions) > 0 {
+			action += fmt.Sprintf(" with %d previous versions", len(item.Versions))
+		}
+
+		fmt.Printf("%-9s %s (%s)\n", item.Status, item.Name, action)
+	}
+
+	fmt.Printf("%d new, %d changed, %d identical\n",
+		plan.Count(backup.StatusNew), plan.Count(backup.StatusChanged), plan.Count(backup.StatusIdentical))
+}
+
+// archiveProvider returns the provider if it writes backup archives
+func archiveProvider() (backup.Archiver, error) {
+	a, ok := provider.(backup.Archiver)
+	if !ok || !providers.CapabilitiesOf(provider).Backup {
+		return nil, fmt.Errorf("provider does not support backup archives")
+	}
+	return a, nil
+}
+
+// backupKey returns the key of backups: the key in --key-file, generated
+// for new backups if missing, or else a passphrase asked for only if an
+// archive needs it
+func backupKey(create bool) (*backup.Key, error) {
+	if backupKeyFile == "" {
+		env := keychain.PassphraseFromEnv(backupPassphraseEnv)
+		prompt := keychain.PassphrasePrompt("Backup passphrase: ")
+		return backup.PassphraseKey(func() ([]byte, error) {
+			if passphrase, err := env(); err == nil {
+				return passphrase, nil
+			}
+			passphrase, err := prompt()
+			if err != nil {
+				return nil, fmt.Errorf("%w (set %s, use --key-file or --encrypt=false)", err, backupPassphraseEnv)
+			}
+			return passphrase, nil
+		}), nil
+	}
+
+	if _, err := os.Stat(backupKeyFile); os.IsNotExist(err) && create {
+		key, err := backup.GenerateKeyFile(backupKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Generated backup key %s, keep it safe: backups cannot be restored without it\n", backupKeyFile)
+		return key, nil
+	}
+
+	return backup.ReadKeyFile(backupKeyFile)
+}
+
 func init() {
 	// Backup flags
 	backupCmd.Flags().StringVar(&backupFormat, "format", "json", "Backup format (json or yaml)")
 	backupCmd.Flags().BoolVar(&includeVersions, "versions", false, "Include version history in backup")
-	backupCmd.Flags().BoolVar(&compressBackup, "compress", false, "Compress backup file")
+	backupCmd.Flags().StringVar(&compressBackup, "compress", "none", "Compress backup file: none, zstd or gzip")
 	backupCmd.Flags().BoolVar(&encryptBackup, "encrypt", true, "Encrypt backup file")
+	backupCmd.Flags().StringVar(&backupKeyFile, "key-file", "", "Encrypt with the backup key in this file instead of a passphrase")
 
 	// Restore flags
-	restoreCmd.Flags().BoolVar(&overwriteExisting, "overwrite", false, "Overwrite existing secrets")
-	restoreCmd.Flags().BoolVar(&restoreVersions, "versions", false, "Restore version history")
+	restoreCmd.Flags().BoolVar(&overwriteExisting, "overwrite", false, "Overwrite existing secrets, like --conflict overwrite")
+	restoreCmd.Flags().BoolVar(&restoreVersions, "versions", false, "Restore the version history of secrets created by the restore")
+	restoreCmd.Flags().StringVar(&restoreConflict, "conflict", backup.ConflictSkip, "What to do with changed secrets: skip, overwrite, keep-newest or rename")
+	restoreCmd.Flags().StringSliceVar(&restoreNames, "name", nil, "Only restore secrets matching these globs or in these folders")
+	restoreCmd.Flags().StringSliceVar(&restoreTags, "tags", nil, "Only restore secrets with all of these tags")
+	restoreCmd.Flags().StringVar(&restoreSchema, "schema", "", "Only restore secrets with this schema")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Show the restore plan without changing anything")
+	restoreCmd.Flags().StringVar(&backupKeyFile, "key-file", "", "Decrypt with the backup key in this file instead of a passphrase")
+
+	// Verify flags
+	backupVerifyCmd.Flags().StringVar(&backupKeyFile, "key-file", "", "Decrypt with the backup key in this file instead of a passphrase")
 
 	// Add commands
+	backupCmd.AddCommand(backupVerifyCmd)
 	rootCmd.AddCommand(backupCmd)
 	rootCmd.AddCommand(restoreCmd)
 }
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/keeper/internal/providers"
+)
+
+// versionedProvider returns the provider if it keeps the version history
+// of secrets
+func versionedProvider() (providers.Versioner, error) {
+	versioned, ok := provider.(providers.Versioner)
+	if !ok || !providers.CapabilitiesOf(provider).Versions {
+		return nil, fmt.Errorf("provider does not support secret versions")
+	}
+	return versioned, nil
+}
+
+// folderProvider returns the provider if it organises secrets in folders
+func folderProvider() (providers.Hierarchical, error) {
+	h, ok := provider.(providers.Hierarchical)
+	if !ok || !providers.CapabilitiesOf(provider).Folders {
+		return nil, fmt.Errorf("provider does not support folders")
+	}
+	return h, nil
+}
@@ -2,37 +2,162 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/keeper/internal/providers"
 	"github.com/spf13/cobra"
 )
 
+var (
+	cleanupDryRun bool
+	cleanupGrace  string
+	cleanupAction string
+)
+
 var cleanupCmd = &cobra.Command{
 	Use:   "cleanup",
 	Short: "Clean up expired secrets",
+	Long: `Find the secrets past their expiry time, set with "kpr set --expires" or
+by the TTL of their schema, and apply an action to them:
+
+  delete   move the secret to the trash
+  archive  move the secret into the archive folder
+  disable  keep the secret, recording when it was disabled
+
+Secrets are left alone until the grace period after their expiry has
+passed, and secrets in the archive folder are never cleaned up. The
+defaults come from the cleanup section of config.yaml.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Get secrets that have expired
-		secrets, err := provider.ListSecrets(cmd.Context())
+		action, grace, folder, err := cleanupPolicy(cmd)
 		if err != nil {
-			return fmt.Errorf("failed to list secrets: %w", err)
+			return err
 		}
 
-		// Count expired secrets
-		expired := 0
-		for _, secret := range secrets {
-			if secret.UpdatedAt.Add(24 * time.Hour).Before(time.Now()) {
-				if err := provider.DeleteSecret(cmd.Context(), secret.Name); err != nil {
-					return fmt.Errorf("failed to delete expired secret %s: %w", secret.Name, err)
+		// Only expiry metadata is needed to find expired secrets
+		now := time.Now()
+		var expired []*providers.Secret
+		pages := providers.ListSecretPages(cmd.Context(), provider, providers.ListOptions{})
+		for pages.More() {
+			page, err := pages.NextPage(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to list secrets: %w", err)
+			}
+
+			for _, secret := range page.Secrets {
+				if !secret.Expired(now.Add(-grace)) {
+					continue
+				}
+				if action == "disable" && secret.Metadata[providers.DisabledAtKey] != "" {
+					continue
+				}
+				// Archived secrets are kept whatever the action
+				if strings.HasPrefix(secret.Name, folder+"/") {
+					continue
 				}
-				expired++
+				expired = append(expired, secret)
 			}
 		}
 
-		fmt.Printf("Successfully cleaned up %d expired secrets\n", expired)
+		for _, secret := range expired {
+			expiresAt, _ := secret.ExpiresAt()
+			if cleanupDryRun {
+				fmt.Printf("Would %s %s (expired %s)\n", action, secret.Name, expiresAt.Local().Format("2006-01-02 15:04:05"))
+				continue
+			}
+
+			if err := cleanupSecret(cmd, secret.Name, action, folder, now); err != nil {
+				return fmt.Errorf("failed to %s expired secret %s: %w", action, secret.Name, err)
+			}
+		}
+
+		if cleanupDryRun {
+			fmt.Printf("Would clean up %d expired secrets\n", len(expired))
+			return nil
+		}
+		fmt.Printf("Successfully cleaned up %d expired secrets\n", len(expired))
 		return nil
 	},
 }
 
+// cleanupPolicy returns the action, grace period and archive folder of a
+// cleanup, from the flags or else the config
+func cleanupPolicy(cmd *cobra.Command) (string, time.Duration, string, error) {
+	action, graceText, folder := cleanupAction, cleanupGrace, "archive"
+	if cfg != nil {
+		if !cmd.Flags().Changed("action") && cfg.Cleanup.Action != "" {
+			action = cfg.Cleanup.Action
+		}
+		if !cmd.Flags().Changed("grace") && cfg.Cleanup.Grace != "" {
+			graceText = cfg.Cleanup.Grace
+		}
+		if cfg.Cleanup.ArchiveFolder != "" {
+			folder = cfg.Cleanup.ArchiveFolder
+		}
+	}
+
+	switch action {
+	case "delete", "archive", "disable":
+	default:
+		return "", 0, "", fmt.Errorf("unknown cleanup action %q (expected delete, archive or disable)", action)
+	}
+
+	var grace time.Duration
+	if graceText != "" {
+		var err error
+		if grace, err = providers.ParseDuration(graceText); err != nil {
+			return "", 0, "", fmt.Errorf("invalid grace period: %w", err)
+		}
+	}
+
+	folder, err := providers.NormalizeName(folder)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("invalid archive folder: %w", err)
+	}
+
+	return action, grace, folder, nil
+}
+
+// cleanupSecret applies a cleanup action to an expired secret
+func cleanupSecret(cmd *cobra.Command, name, action, folder string, now time.Time) error {
+	ctx := providers.WithGetOptions(cmd.Context(), providers.GetOptions{AllowExpired: true, AllowDisabled: true})
+	if action == "delete" {
+		return provider.DeleteSecret(ctx, name)
+	}
+
+	secret, err := provider.GetSecret(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if action == "disable" {
+		secret.Metadata[providers.DisabledAtKey] = now.UTC().Format(time.RFC3339)
+		return provider.SetSecret(ctx, secret)
+	}
+
+	// Archive by storing a copy in the archive folder and deleting the
+	// original. The copy no longer expires, and records when it was archived.
+	archived := *secret
+	archived.Name = folder + "/" + name
+	archived.Metadata = make(map[string]string, len(secret.Metadata))
+	for k, v := range secret.Metadata {
+		archived.Metadata[k] = v
+	}
+	delete(archived.Metadata, providers.ExpiresAtKey)
+	archived.Metadata[providers.ArchivedAtKey] = now.UTC().Format(time.RFC3339)
+	if _, err := provider.GetSecret(ctx, archived.Name); err == nil {
+		return fmt.Errorf("secret %s already exists", archived.Name)
+	}
+	if err := provider.SetSecret(ctx, &archived); err != nil {
+		return err
+	}
+	return provider.DeleteSecret(ctx, name)
+}
+
 func init() {
 	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().BoolVar(&cleanupDryRun, "dry-run", false, "Show what would be cleaned up without changing anything")
+	cleanupCmd.Flags().StringVar(&cleanupGrace, "grace", "", "Only clean up secrets expired longer ago, such as 7d")
+	cleanupCmd.Flags().StringVar(&cleanupAction, "action", "delete", "What to do with expired secrets: delete, archive or disable")
 }
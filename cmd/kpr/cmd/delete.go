@@ -6,13 +6,30 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var deleteRecursive bool
+
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
 	Use:   "delete [name]",
 	Short: "Delete a secret",
-	Args:  cobra.ExactArgs(1),
+	Long: `Delete a secret. With --recursive, delete a folder and every secret
+in it. Deleted secrets can be restored from the trash with kpr trash restore
+until they are purged.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
+		if deleteRecursive {
+			h, err := folderProvider()
+			if err != nil {
+				return err
+			}
+			if err := h.DeleteFolder(cmd.Context(), name); err != nil {
+				return fmt.Errorf("failed to delete folder: %w", err)
+			}
+			fmt.Printf("Successfully deleted folder %s\n", name)
+			return nil
+		}
+
 		if err := provider.DeleteSecret(cmd.Context(), name); err != nil {
 			return fmt.Errorf("failed to delete secret: %w", err)
 		}
@@ -23,4 +40,6 @@ var deleteCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(deleteCmd)
+
+	deleteCmd.Flags().BoolVarP(&deleteRecursive, "recursive", "r", false, "Delete a folder and everything in it")
 }
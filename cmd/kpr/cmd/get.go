@@ -2,18 +2,45 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/keeper/internal/providers"
 	"github.com/spf13/cobra"
 )
 
+var allowExpired bool
+
 // getCmd represents the get command
 var getCmd = &cobra.Command{
-	Use:   "get [name]",
+	Use:   "get [name[@version]]",
 	Short: "Get a secret",
-	Args:  cobra.ExactArgs(1),
+	Long: `Get a secret. Append @<version> to the name to get an earlier
+version, as listed by "kpr history". Secrets past their expiry time are
+refused unless --allow-expired is given, and secrets disabled by
+"kpr cleanup" are always refused.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		name := args[0]
-		secret, err := provider.GetSecret(cmd.Context(), name)
+		name, version, err := parseVersionedName(args[0])
+		if err != nil {
+			return err
+		}
+
+		opts := providers.GetOptions{AllowExpired: allowExpired}
+
+		var secret *providers.Secret
+		if version > 0 {
+			versioned, err := versionedProvider()
+			if err != nil {
+				return err
+			}
+			if secret, err = versioned.GetSecretVersion(cmd.Context(), name, version); err == nil {
+				err = opts.Check(secret, time.Now())
+			}
+		} else {
+			secret, err = providers.GetSecret(cmd.Context(), provider, name, opts)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to get secret: %w", err)
 		}
@@ -21,6 +48,9 @@ var getCmd = &cobra.Command{
 		// Print secret details
 		fmt.Printf("Name: %s\n", secret.Name)
 		fmt.Printf("Value: %s\n", secret.Value)
+		if secret.Version > 0 {
+			fmt.Printf("Version: %d\n", secret.Version)
+		}
 		if len(secret.Tags) > 0 {
 			fmt.Printf("Tags: %v\n", secret.Tags)
 		}
@@ -40,6 +70,23 @@ var getCmd = &cobra.Command{
 	},
 }
 
+// parseVersionedName splits "name@version" into its parts. A name without
+// a version suffix returns version 0.
+func parseVersionedName(arg string) (string, int, error) {
+	i := strings.LastIndex(arg, "@")
+	if i < 0 {
+		return arg, 0, nil
+	}
+
+	version, err := strconv.Atoi(arg[i+1:])
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("invalid version in %q", arg)
+	}
+	return arg[:i], version, nil
+}
+
 func init() {
 	rootCmd.AddCommand(getCmd)
+
+	getCmd.Flags().BoolVar(&allowExpired, "allow-expired", false, "Return the secret even if it has expired")
 }
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [name]",
+	Short: "List the versions of a secret",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		versioned, err := versionedProvider()
+		if err != nil {
+			return err
+		}
+
+		name := args[0]
+		versions, err := versioned.ListSecretVersions(cmd.Context(), name)
+		if err != nil {
+			return fmt.Errorf("failed to get history: %w", err)
+		}
+
+		fmt.Printf("Found %d versions of %s:\n", len(versions), name)
+		for i := len(versions) - 1; i >= 0; i-- {
+			secret := versions[i]
+			current := ""
+			if i == len(versions)-1 {
+				current = " (current)"
+			}
+			fmt.Printf("- %d%s\n", secret.Version, current)
+			fmt.Printf("  Updated: %s\n", secret.UpdatedAt.Format("2006-01-02 15:04:05"))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
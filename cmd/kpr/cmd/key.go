@@ -1,11 +1,23 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 
+	"github.com/keeper/internal/providers"
+	"github.com/keeper/internal/providers/local"
 	"github.com/spf13/cobra"
 )
 
+var (
+	resumeRotation   bool
+	rollbackRotation bool
+	skipConfirm      bool
+)
+
 // keyCmd represents the key command
 var keyCmd = &cobra.Command{
 	Use:   "key",
@@ -16,8 +28,48 @@ var keyCmd = &cobra.Command{
 var keyRotateCmd = &cobra.Command{
 	Use:   "rotate",
 	Short: "Rotate encryption key",
+	Long: `Generate a new master key and re-wrap the data keys of every secret and
+backup under it. Values are only re-encrypted in files written by older
+versions, to bring them to the current format. Progress is checkpointed, so
+an interrupted rotation can be continued with --resume or undone with
+--rollback. The old key is deleted only after every
+file has been verified against the new key.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		fmt.Println("Key rotation not implemented yet")
+		p, ok := provider.(providers.KeyRotator)
+		if !ok {
+			return fmt.Errorf("provider does not support master key rotation")
+		}
+
+		progress := func(done, total int) {
+			fmt.Printf("\rRe-wrapped %d/%d files", done, total)
+			if done == total {
+				fmt.Println()
+			}
+		}
+
+		switch {
+		case resumeRotation && rollbackRotation:
+			return fmt.Errorf("--resume and --rollback are mutually exclusive")
+		case resumeRotation:
+			if err := p.ResumeRotation(cmd.Context(), progress); err != nil {
+				return fmt.Errorf("failed to resume key rotation: %w", err)
+			}
+		case rollbackRotation:
+			if err := p.RollbackRotation(cmd.Context(), progress); err != nil {
+				return fmt.Errorf("failed to roll back key rotation: %w", err)
+			}
+			fmt.Println("Key rotation rolled back")
+			return nil
+		default:
+			if err := p.RotateMasterKey(cmd.Context(), progress); err != nil {
+				if errors.Is(err, local.ErrRotationInProgress) {
+					return fmt.Errorf("%w; run with --resume or --rollback", err)
+				}
+				return fmt.Errorf("failed to rotate key: %w", err)
+			}
+		}
+
+		fmt.Println("Master key rotated successfully")
 		return nil
 	},
 }
@@ -26,13 +78,50 @@ var keyRotateCmd = &cobra.Command{
 var keyDeleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete encryption key",
+	Long: `Delete the master key of the store from its keychain. Every secret and
+backup encrypted under it becomes unreadable, so the deletion has to be
+confirmed by typing yes, or with --yes.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		fmt.Println("Key deletion not implemented yet")
+		p, ok := provider.(providers.KeyDeleter)
+		if !ok {
+			return fmt.Errorf("provider does not support master key deletion")
+		}
+
+		if !skipConfirm {
+			fmt.Fprint(cmd.OutOrStdout(), "Deleting the master key makes every secret encrypted under it unreadable. Type yes to continue: ")
+			confirmed, err := confirm(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				fmt.Println("Key deletion cancelled")
+				return nil
+			}
+		}
+
+		if err := p.DeleteMasterKey(); err != nil {
+			return fmt.Errorf("failed to delete key: %w", err)
+		}
+
+		fmt.Println("Master key deleted successfully")
 		return nil
 	},
 }
 
+// confirm reads an answer and reports whether it is yes
+func confirm(r io.Reader) (bool, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	return strings.EqualFold(strings.TrimSpace(line), "yes"), nil
+}
+
 func init() {
+	keyRotateCmd.Flags().BoolVar(&resumeRotation, "resume", false, "Resume an interrupted rotation")
+	keyRotateCmd.Flags().BoolVar(&rollbackRotation, "rollback", false, "Roll back an interrupted rotation")
+	keyDeleteCmd.Flags().BoolVarP(&skipConfirm, "yes", "y", false, "Delete the key without asking for confirmation")
+
 	keyCmd.AddCommand(keyRotateCmd)
 	keyCmd.AddCommand(keyDeleteCmd)
 	rootCmd.AddCommand(keyCmd)
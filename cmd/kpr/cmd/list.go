@@ -2,68 +2,203 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/keeper/internal/providers"
+	"github.com/keeper/internal/providers/router"
 	"github.com/spf13/cobra"
 )
 
 var (
-	prefix string
+	prefix        string
+	listRecursive bool
+	listTree      bool
+	listValues    bool
+	listLimit     int
+	listPageToken string
 )
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
-	Use:   "list [prefix]",
+	Use:   "list [folder]",
 	Short: "List secrets",
+	Long: `List the secrets and subfolders in a folder, such as "app/".
+Use --recursive to list the whole subtree, or --tree to show it as a tree.
+
+Secrets are fetched and printed a page at a time, without their values
+unless --values is given. Use --limit to print a single page, and
+--page-token to continue from where it ended.
+
+When mounts are configured, the listings of the providers mounted in the
+folder are merged, and each entry names the provider it comes from.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// List all secrets
-		secrets, err := provider.ListSecrets(cmd.Context())
+		folder := ""
+		if len(args) > 0 {
+			folder = args[0]
+		}
+		folder, err := providers.NormalizeFolder(folder)
 		if err != nil {
-			return fmt.Errorf("failed to list secrets: %w", err)
+			return err
 		}
 
-		// Filter by prefix if provided
-		if len(args) > 0 {
-			prefix := args[0]
-			var filtered []*providers.Secret
-			for _, secret := range secrets {
-				if strings.HasPrefix(secret.Name, prefix) {
-					filtered = append(filtered, secret)
-				}
-			}
-			secrets = filtered
+		opts := providers.ListOptions{
+			PageSize:      listLimit,
+			PageToken:     listPageToken,
+			IncludeValues: listValues,
 		}
-
-		// Print secrets
-		if len(secrets) == 0 {
-			fmt.Println("No secrets found")
-			return nil
+		if folder != "" {
+			opts.Prefix = folder + "/"
 		}
 
-		fmt.Printf("Found %d secrets:\n", len(secrets))
-		for _, secret := range secrets {
-			fmt.Printf("- %s\n", secret.Name)
-			if len(secret.Tags) > 0 {
-				fmt.Printf("  Tags: %s\n", strings.Join(secret.Tags, ", "))
+		var (
+			names   []string
+			folders = make(map[string]bool)
+			count   int
+			next    string
+		)
+		pages := providers.ListSecretPages(cmd.Context(), provider, opts)
+		for pages.More() {
+			page, err := pages.NextPage(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to list secrets: %w", err)
 			}
-			if secret.Schema != "" {
-				fmt.Printf("  Schema: %s\n", secret.Schema)
-			}
-			if len(secret.Metadata) > 0 {
-				fmt.Println("  Metadata:")
-				for k, v := range secret.Metadata {
-					fmt.Printf("    %s: %s\n", k, v)
+
+			for _, secret := range page.Secrets {
+				rel := strings.TrimPrefix(secret.Name, opts.Prefix)
+				switch {
+				case listTree:
+					names = append(names, secret.Name+origin(secret.Name))
+				case listRecursive || !strings.Contains(rel, "/"):
+					printSecret(secret)
+					count++
+				default:
+					// Show each subfolder once, in place of its secrets
+					sub := opts.Prefix + rel[:strings.Index(rel, "/")+1]
+					if !folders[sub] {
+						fmt.Printf("- %s%s\n", sub, origin(sub))
+						folders[sub] = true
+					}
 				}
 			}
-			fmt.Printf("  Created: %s\n", secret.CreatedAt.Format("2006-01-02 15:04:05"))
-			fmt.Printf("  Updated: %s\n", secret.UpdatedAt.Format("2006-01-02 15:04:05"))
+
+			if listLimit > 0 {
+				next = page.NextPageToken
+				break
+			}
 		}
 
+		switch {
+		case listTree:
+			printTree(folder, names)
+		case count == 0 && len(folders) == 0:
+			fmt.Println("No secrets found")
+		default:
+			fmt.Printf("Found %d secrets\n", count)
+		}
+
+		if next != "" {
+			fmt.Printf("More secrets follow, continue with --page-token %s\n", next)
+		}
 		return nil
 	},
 }
 
+// printSecret prints a listed secret
+func printSecret(secret *providers.Secret) {
+	fmt.Printf("- %s%s\n", secret.Name, origin(secret.Name))
+	if secret.Value != "" {
+		fmt.Printf("  Value: %s\n", secret.Value)
+	}
+	if len(secret.Tags) > 0 {
+		fmt.Printf("  Tags: %s\n", strings.Join(secret.Tags, ", "))
+	}
+	if secret.Schema != "" {
+		fmt.Printf("  Schema: %s\n", secret.Schema)
+	}
+	if len(secret.Metadata) > 0 {
+		fmt.Println("  Metadata:")
+		for k, v := range secret.Metadata {
+			fmt.Printf("    %s: %s\n", k, v)
+		}
+	}
+	fmt.Printf("  Created: %s\n", secret.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Updated: %s\n", secret.UpdatedAt.Format("2006-01-02 15:04:05"))
+}
+
+// origin returns the provider a secret or folder is mounted at, for
+// annotating listings that span mounts
+func origin(name string) string {
+	r, ok := provider.(*router.Router)
+	if !ok {
+		return ""
+	}
+	if o := r.Origin(strings.TrimSuffix(name, "/")); o != "" {
+		return fmt.Sprintf(" [%s]", o)
+	}
+	return ""
+}
+
+// printTree prints secret names below a folder as a tree
+func printTree(folder string, secretNames []string) {
+	root := strings.Trim(folder, "/")
+	if root == "" {
+		fmt.Println(".")
+	} else {
+		fmt.Println(root + "/")
+	}
+
+	var names [][]string
+	for _, secretName := range secretNames {
+		name := strings.TrimPrefix(secretName, root+"/")
+		names = append(names, strings.Split(name, "/"))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.Join(names[i], "/") < strings.Join(names[j], "/")
+	})
+
+	printTreeLevel(names, "")
+}
+
+// printTreeLevel prints one level of a tree of split names
+func printTreeLevel(names [][]string, indent string) {
+	// Group names by their first segment, keeping their order
+	var keys []string
+	children := make(map[string][][]string)
+	for _, name := range names {
+		key := name[0]
+		if len(name) > 1 {
+			key += "/"
+		}
+		if _, ok := children[key]; !ok {
+			keys = append(keys, key)
+		}
+		if len(name) > 1 {
+			children[key] = append(children[key], name[1:])
+		} else {
+			children[key] = nil
+		}
+	}
+
+	for i, key := range keys {
+		branch, next := "├── ", "│   "
+		if i == len(keys)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Println(indent + branch + key)
+		if len(children[key]) > 0 {
+			printTreeLevel(children[key], indent+next)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().BoolVarP(&listRecursive, "recursive", "r", false, "List secrets in subfolders too")
+	listCmd.Flags().BoolVar(&listTree, "tree", false, "Show the folder as a tree")
+	listCmd.Flags().BoolVar(&listValues, "values", false, "Show secret values")
+	listCmd.Flags().IntVar(&listLimit, "limit", 0, "Print a single page of at most this many secrets")
+	listCmd.Flags().StringVar(&listPageToken, "page-token", "", "Continue a listing from a page token")
 }
@@ -1,12 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
+	"github.com/keeper/internal/providers"
 	"github.com/spf13/cobra"
 )
 
+// metadataContext returns the context of a metadata command. Metadata can
+// be read and changed past expiry, which is how an expiry is extended or a
+// disabled secret enabled again.
+func metadataContext(cmd *cobra.Command) context.Context {
+	return providers.WithGetOptions(cmd.Context(), providers.GetOptions{AllowExpired: true, AllowDisabled: true})
+}
+
 var metadataCmd = &cobra.Command{
 	Use:   "metadata",
 	Short: "Manage secret metadata",
@@ -17,8 +26,9 @@ var metadataSetCmd = &cobra.Command{
 	Short: "Set metadata for a secret",
 	Args:  cobra.MinimumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := metadataContext(cmd)
 		name := args[0]
-		secret, err := provider.GetSecret(cmd.Context(), name)
+		secret, err := provider.GetSecret(ctx, name)
 		if err != nil {
 			return fmt.Errorf("failed to get secret: %w", err)
 		}
@@ -42,7 +52,7 @@ var metadataSetCmd = &cobra.Command{
 		}
 
 		// Save updated secret
-		if err := provider.SetSecret(cmd.Context(), secret); err != nil {
+		if err := provider.SetSecret(ctx, secret); err != nil {
 			return fmt.Errorf("failed to update secret: %w", err)
 		}
 
@@ -57,7 +67,7 @@ var metadataGetCmd = &cobra.Command{
 	Args:  cobra.RangeArgs(1, 2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
-		secret, err := provider.GetSecret(cmd.Context(), name)
+		secret, err := provider.GetSecret(metadataContext(cmd), name)
 		if err != nil {
 			return fmt.Errorf("failed to get secret: %w", err)
 		}
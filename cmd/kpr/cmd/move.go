@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// moveCmd represents the move command
+var moveCmd = &cobra.Command{
+	Use:   "move [folder] [destination]",
+	Short: "Move a folder of secrets",
+	Long: `Move every secret in a folder, with its version history, to another
+folder. Use "/" as the destination to move the secrets to the top level.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		h, err := folderProvider()
+		if err != nil {
+			return err
+		}
+
+		if err := h.MoveFolder(cmd.Context(), args[0], args[1]); err != nil {
+			return fmt.Errorf("failed to move folder: %w", err)
+		}
+
+		fmt.Printf("Successfully moved %s to %s\n", args[0], args[1])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(moveCmd)
+}
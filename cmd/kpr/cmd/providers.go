@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/keeper/internal/providers"
+	_ "github.com/keeper/internal/providers/all"
+	"github.com/spf13/cobra"
+)
+
+var providersCmd = &cobra.Command{
+	Use:         "providers",
+	Short:       "Inspect the available secret providers",
+	Annotations: map[string]string{withoutStore: "true"},
+}
+
+var providersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List provider types and their parameters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for i, r := range providers.Registered() {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s - %s\n", r.Type, r.Description)
+			for _, param := range r.Params {
+				fmt.Printf("  %-22s %-9s %-10s %s\n", param.Name, param.Type, paramDefault(param), param.Description)
+			}
+		}
+		return nil
+	},
+}
+
+// paramDefault describes whether a provider parameter is required or
+// what its default is
+func paramDefault(param providers.Param) string {
+	switch {
+	case param.Required:
+		return "required"
+	case param.Default == nil:
+		return "-"
+	default:
+		return fmt.Sprint(param.Default)
+	}
+}
+
+func init() {
+	providersCmd.AddCommand(providersListCmd)
+	rootCmd.AddCommand(providersCmd)
+}
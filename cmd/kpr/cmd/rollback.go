@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [name] [version]",
+	Short: "Restore an earlier version of a secret",
+	Long: `Restore an earlier version of a secret. The restored value is stored
+as a new version, so a rollback can itself be rolled back.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		versioned, err := versionedProvider()
+		if err != nil {
+			return err
+		}
+
+		name := args[0]
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 1 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+
+		secret, err := versioned.RollbackSecret(cmd.Context(), name, version)
+		if err != nil {
+			return fmt.Errorf("failed to roll back secret: %w", err)
+		}
+
+		fmt.Printf("Successfully rolled back secret %s to version %d (now version %d)\n", name, version, secret.Version)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+}
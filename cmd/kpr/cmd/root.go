@@ -1,21 +1,31 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
-	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/config"
 	"github.com/keeper/internal/providers"
-	"github.com/keeper/internal/providers/local"
+	"github.com/keeper/internal/providers/router"
 	"github.com/spf13/cobra"
 )
 
 var (
-	configDir string
-	provider  providers.Provider
+	configDir      string
+	providerName   string
+	lockTimeout    time.Duration
+	lockTimeoutSet bool
+	provider       providers.Provider
+	cfg            *config.Config
 )
 
+// withoutStore is the annotation of commands that run without opening a
+// provider, along with their subcommands
+const withoutStore = "without_store"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "kpr",
@@ -28,26 +38,94 @@ It supports storing secrets with metadata, schemas, and versioning.`,
 			return fmt.Errorf("failed to create config directory: %w", err)
 		}
 
-		// Initialize keychain
-		kc, err := keychain.New()
+		var err error
+		cfg, err = config.Load(filepath.Join(configDir, "config.yaml"))
 		if err != nil {
-			return fmt.Errorf("failed to initialize keychain: %w", err)
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if cfg.Encryption.KeyFile == "" {
+			cfg.Encryption.KeyFile = filepath.Join(configDir, "master.key")
+		}
+		if cfg.DefaultDataDir == "" {
+			cfg.DefaultDataDir = configDir
 		}
+		if cfg.PluginDir == "" {
+			cfg.PluginDir = filepath.Join(configDir, "plugins")
+		}
+		providers.RegisterPlugins([]string{cfg.PluginDir})
 
-		// Initialize provider
-		p, err := local.New(configDir, kc)
-		if err != nil {
-			return fmt.Errorf("failed to initialize provider: %w", err)
+		lockTimeoutSet = cmd.Flags().Changed("lock-timeout")
+		if !needsStore(cmd) {
+			return nil
 		}
 
-		// Initialize provider
-		if err := p.Initialize(cmd.Context()); err != nil {
-			return fmt.Errorf("failed to initialize provider: %w", err)
+		// Route secrets through the mount table unless a provider is named
+		if providerName == "" && len(cfg.Mounts) > 0 {
+			for prefix, name := range cfg.Mounts {
+				if _, err := cfg.Provider(name); err != nil {
+					return fmt.Errorf("invalid mount %q: %w", prefix, err)
+				}
+			}
+			r, err := router.New(cfg.Mounts, openProvider)
+			if err != nil {
+				return fmt.Errorf("failed to load mounts: %w", err)
+			}
+			provider = r
+			return nil
 		}
 
+		p, err := openProvider(cmd.Context(), providerName)
+		if err != nil {
+			return err
+		}
 		provider = p
 		return nil
 	},
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		if provider == nil {
+			return nil
+		}
+		return provider.Close()
+	},
+}
+
+// needsStore reports whether a command opens a provider: every command does
+// except help, shell completion and those annotated withoutStore
+func needsStore(cmd *cobra.Command) bool {
+	switch cmd.Name() {
+	case "help", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return false
+	}
+	for c := cmd; c != nil; c = c.Parent() {
+		if _, ok := c.Annotations[withoutStore]; ok {
+			return false
+		}
+		if c.Name() == "completion" && c.HasParent() && !c.Parent().HasParent() {
+			return false
+		}
+	}
+	return true
+}
+
+// openProvider opens and initializes a provider named in the configuration
+func openProvider(ctx context.Context, name string) (providers.Provider, error) {
+	pc, err := cfg.Provider(name)
+	if err != nil {
+		return nil, err
+	}
+	if pc.Type == "local" && lockTimeoutSet {
+		pc.Parameters["lock_timeout"] = lockTimeout
+	}
+
+	p, err := providers.Open(ctx, pc.Type, pc.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize provider: %w", err)
+	}
+	if err := p.Initialize(ctx); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("failed to initialize provider: %w", err)
+	}
+	return p, nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -66,4 +144,6 @@ func init() {
 	}
 
 	rootCmd.PersistentFlags().StringVar(&configDir, "config", filepath.Join(home, ".keeper"), "config directory")
+	rootCmd.PersistentFlags().StringVar(&providerName, "provider", "", "provider to use, as named in config.yaml (default: default_provider)")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", 10*time.Second, "how long to wait for another kpr process to release the store")
 }
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/keeper/internal/providers"
 	"github.com/spf13/cobra"
 )
 
@@ -29,8 +30,9 @@ type SchemaField struct {
 }
 
 var schemaCmd = &cobra.Command{
-	Use:   "schema",
-	Short: "Manage secret schemas",
+	Use:         "schema",
+	Short:       "Manage secret schemas",
+	Annotations: map[string]string{withoutStore: "true"},
 }
 
 var schemaAddCmd = &cobra.Command{
@@ -63,6 +65,15 @@ var schemaAddCmd = &cobra.Command{
 		if len(schema.Fields) == 0 {
 			return fmt.Errorf("schema must define at least one field")
 		}
+		if schema.TTL != "" {
+			ttl, err := providers.ParseDuration(schema.TTL)
+			if err != nil {
+				return fmt.Errorf("invalid schema ttl: %w", err)
+			}
+			if ttl == 0 {
+				return fmt.Errorf("schema ttl must be positive")
+			}
+		}
 
 		// Save schema
 		schemasDir := filepath.Join(configDir, "schemas")
@@ -10,14 +10,33 @@ import (
 )
 
 var (
-	searchTags      []string
-	searchSchema    string
+	searchTags     []string
+	searchSchema   string
+	searchMetadata []string
 	createdAfter   string
+	updatedAfter   string
 )
 
 var searchCmd = &cobra.Command{
-	Use:   "search",
+	Use:   "search [query]",
 	Short: "Search for secrets",
+	Long: `Search for secrets by flags, by a query expression, or both.
+
+Queries combine terms with AND, OR, NOT and parentheses:
+
+  name:app/**      name glob, where * stays within a folder and ** does not
+  name:/^app-/     name regular expression (a bare glob or /re/ also works)
+  tag:db           secrets with a tag
+  schema:database  secrets with a schema
+  meta.env=prod    metadata value; meta.env!=prod and meta.env also work
+  updated<30d      updated in the last 30 days; created works the same way
+  expires<7d       expiring within 7 days, or already expired
+  created>2024-01-01
+
+For example:
+
+  kpr search 'tag:db AND meta.env=prod AND NOT tag:deprecated'`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Parse search options
 		opts := providers.SearchOptions{
@@ -33,10 +52,45 @@ var searchCmd = &cobra.Command{
 			opts.CreatedAfter = t
 		}
 
-		// Search for secrets
-		secrets, err := provider.SearchSecrets(cmd.Context(), opts)
-		if err != nil {
-			return fmt.Errorf("failed to search secrets: %w", err)
+		if updatedAfter != "" {
+			t, err := time.Parse("2006-01-02", updatedAfter)
+			if err != nil {
+				return fmt.Errorf("invalid date format for updated-after: %w", err)
+			}
+			opts.UpdatedAfter = t
+		}
+
+		// A metadata key on its own matches any value
+		if len(searchMetadata) > 0 {
+			opts.Metadata = make(map[string]string)
+			for _, m := range searchMetadata {
+				key, value, _ := strings.Cut(m, "=")
+				opts.Metadata[key] = value
+			}
+		}
+
+		// Search for secrets, applying the flags on top of a query
+		var secrets []*providers.Secret
+		if len(args) == 1 {
+			query, err := providers.ParseQuery(args[0])
+			if err != nil {
+				return err
+			}
+
+			matches, err := providers.QuerySecrets(cmd.Context(), provider, query)
+			if err != nil {
+				return fmt.Errorf("failed to search secrets: %w", err)
+			}
+			for _, secret := range matches {
+				if opts.Matches(secret) {
+					secrets = append(secrets, secret)
+				}
+			}
+		} else {
+			var err error
+			if secrets, err = providers.SearchSecrets(cmd.Context(), provider, opts); err != nil {
+				return fmt.Errorf("failed to search secrets: %w", err)
+			}
 		}
 
 		// Print results
@@ -71,6 +125,8 @@ var searchCmd = &cobra.Command{
 func init() {
 	searchCmd.Flags().StringSliceVar(&searchTags, "tags", nil, "Filter by tags (comma-separated)")
 	searchCmd.Flags().StringVar(&searchSchema, "schema", "", "Filter by schema")
+	searchCmd.Flags().StringSliceVar(&searchMetadata, "metadata", nil, "Filter by metadata (comma-separated key=value pairs or keys)")
 	searchCmd.Flags().StringVar(&createdAfter, "created-after", "", "Filter by creation date (YYYY-MM-DD)")
+	searchCmd.Flags().StringVar(&updatedAfter, "updated-after", "", "Filter by last update date (YYYY-MM-DD)")
 	rootCmd.AddCommand(searchCmd)
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/keeper/internal/providers"
 	"github.com/spf13/cobra"
@@ -12,6 +13,7 @@ var (
 	tags     []string
 	schema   string
 	metadata []string
+	expires  string
 )
 
 var setCmd = &cobra.Command{
@@ -47,6 +49,15 @@ var setCmd = &cobra.Command{
 			}
 		}
 
+		// Set expiry, which takes precedence over the schema TTL
+		if expires != "" {
+			expiresAt, err := providers.ParseExpiry(expires, time.Now())
+			if err != nil {
+				return err
+			}
+			secret.SetExpiresAt(expiresAt)
+		}
+
 		// Set secret
 		if err := provider.SetSecret(cmd.Context(), secret); err != nil {
 			return fmt.Errorf("failed to set secret: %w", err)
@@ -61,5 +72,6 @@ func init() {
 	setCmd.Flags().StringSliceVar(&tags, "tags", nil, "Tags for the secret (comma-separated)")
 	setCmd.Flags().StringVar(&schema, "schema", "", "Schema for the secret")
 	setCmd.Flags().StringSliceVar(&metadata, "metadata", nil, "Metadata for the secret (comma-separated key=value pairs)")
+	setCmd.Flags().StringVar(&expires, "expires", "", "Expire the secret after a duration such as 24h or 30d, or at a date")
 	rootCmd.AddCommand(setCmd)
 }
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	encryptSnapshots bool
+	snapshotAt       string
+	snapshotDryRun   bool
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Take and restore point-in-time snapshots",
+	Long: `Snapshots record the whole store at a point in time. Each snapshot only
+writes the secrets that changed since the previous one, so they are cheap
+to take often, for example hourly from cron.
+
+Snapshots are kept in the directory set by the snapshots section of
+config.yaml, <config>/snapshots by default, and are encrypted like backups
+with a passphrase read from KEEPER_BACKUP_PASSPHRASE or the terminal, or
+with --key-file.`,
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Take a snapshot of the store",
+	Long: `Take a snapshot of every secret and schema, then prune old snapshots.
+The newest snapshot of each of the last 24 hours, 7 days and 12 months is
+kept by default; the hourly, daily and monthly counts can be changed in
+the snapshots section of config.yaml.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := snapshotProvider()
+		if err != nil {
+			return err
+		}
+
+		var key *backup.Key
+		if encryptSnapshots {
+			if key, err = backupKey(true); err != nil {
+				return err
+			}
+		}
+		repo, err := backup.OpenRepository(snapshotDir(), key)
+		if err != nil {
+			return err
+		}
+
+		snapshot, err := p.TakeSnapshot(cmd.Context(), repo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created snapshot %s of %d secrets (%d changed)\n", snapshot.ID, len(snapshot.Secrets), snapshot.Changed)
+
+		pruned, err := repo.Prune(snapshotRetention())
+		if err != nil {
+			return fmt.Errorf("failed to prune snapshots: %w", err)
+		}
+		for _, old := range pruned {
+			fmt.Printf("Pruned snapshot %s\n", old.ID)
+		}
+
+		return nil
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:         "list",
+	Short:       "List snapshots",
+	Annotations: map[string]string{withoutStore: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo, err := backup.OpenRepository(snapshotDir(), nil)
+		if err != nil {
+			return err
+		}
+
+		snapshots, err := repo.Snapshots()
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("No snapshots found")
+			return nil
+		}
+
+		for _, snapshot := range snapshots {
+			fmt.Printf("%-20s %s  %d secrets, %d changed\n",
+				snapshot.ID, snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"), len(snapshot.Secrets), snapshot.Changed)
+		}
+		return nil
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Return the store to a snapshot",
+	Long: `Return the whole store to the latest snapshot taken at or before --at,
+given as a time, a date, a snapshot ID or a duration ago such as 2h or 3d.
+
+Secrets that changed since the snapshot are stored as new versions, so the
+restore can itself be rolled back, and secrets created since are moved to
+the trash. Nothing is written if any secret fails to restore.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := snapshotProvider()
+		if err != nil {
+			return err
+		}
+
+		at, err := parseSnapshotTime(snapshotAt, time.Now())
+		if err != nil {
+			return err
+		}
+
+		key, err := backupKey(false)
+		if err != nil {
+			return err
+		}
+		repo, err := backup.OpenRepository(snapshotDir(), key)
+		if err != nil {
+			return err
+		}
+
+		snapshot, err := repo.At(at)
+		if err != nil {
+			return err
+		}
+
+		plan, err := p.RestoreSnapshot(cmd.Context(), repo, snapshot, snapshotDryRun)
+		if err != nil {
+			return err
+		}
+
+		printRestorePlan(plan)
+		for _, name := range plan.Removed {
+			fmt.Printf("%-9s %s (delete)\n", "removed", name)
+		}
+
+		created := snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05")
+		if snapshotDryRun {
+			fmt.Printf("Would restore %d and delete %d secrets to return to snapshot %s (%s)\n", len(plan.Restored()), len(plan.Removed), snapshot.ID, created)
+			return nil
+		}
+
+		fmt.Printf("Successfully restored %d and deleted %d secrets to return to snapshot %s (%s)\n", len(plan.Restored()), len(plan.Removed), snapshot.ID, created)
+		return nil
+	},
+}
+
+// snapshotProvider returns the provider if it takes snapshots
+func snapshotProvider() (backup.Snapshotter, error) {
+	s, ok := provider.(backup.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("provider does not support snapshots")
+	}
+	return s, nil
+}
+
+// snapshotDir returns the directory of the snapshot repository
+func snapshotDir() string {
+	if cfg.Snapshots.Dir != "" {
+		return cfg.Snapshots.Dir
+	}
+	return filepath.Join(configDir, "snapshots")
+}
+
+// snapshotRetention returns the retention policy of snapshots, from
+// config.yaml or the defaults
+func snapshotRetention() backup.Retention {
+	policy := backup.DefaultRetention
+	if cfg.Snapshots.Hourly != nil {
+		policy.Hourly = *cfg.Snapshots.Hourly
+	}
+	if cfg.Snapshots.Daily != nil {
+		policy.Daily = *cfg.Snapshots.Daily
+	}
+	if cfg.Snapshots.Monthly != nil {
+		policy.Monthly = *cfg.Snapshots.Monthly
+	}
+	return policy
+}
+
+// parseSnapshotTime parses the time of a snapshot to restore: a duration
+// ago, a snapshot ID, or a date or time in local time
+func parseSnapshotTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+	if d, err := providers.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102-150405", value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a duration such as 2h, a date, a time or a snapshot ID", value)
+}
+
+func init() {
+	snapshotCreateCmd.Flags().BoolVar(&encryptSnapshots, "encrypt", true, "Encrypt the snapshot repository when it is created")
+	snapshotCreateCmd.Flags().StringVar(&backupKeyFile, "key-file", "", "Encrypt with the backup key in this file instead of a passphrase")
+
+	snapshotRestoreCmd.Flags().StringVar(&snapshotAt, "at", "", "Restore the latest snapshot at or before this time (default latest)")
+	snapshotRestoreCmd.Flags().BoolVar(&snapshotDryRun, "dry-run", false, "Show the restore plan without changing anything")
+	snapshotRestoreCmd.Flags().StringVar(&backupKeyFile, "key-file", "", "Decrypt with the backup key in this file instead of a passphrase")
+
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
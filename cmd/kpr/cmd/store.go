@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/keeper/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+var migrateTo string
+
+// storeCmd represents the store command
+var storeCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Manage the local store",
+}
+
+// storeInfoCmd represents the store info command
+var storeInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show the storage engine of the local store",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, ok := provider.(providers.StorageMigrator)
+		if !ok {
+			return fmt.Errorf("provider does not support storage engines")
+		}
+
+		fmt.Printf("Storage engine: %s\n", p.StorageEngine())
+		return nil
+	},
+}
+
+// storeMigrateCmd represents the store migrate command
+var storeMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert the local store to another storage engine",
+	Long: `Convert the local store between the directory layout, which keeps one
+JSON file per secret version ("dir"), and a single embedded database file
+with indexes on tags, schema and timestamps ("db"). Every secret and its
+version history are copied, and the old copy is removed only once the new
+one is complete.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, ok := provider.(providers.StorageMigrator)
+		if !ok {
+			return fmt.Errorf("provider does not support storage engines")
+		}
+
+		n, err := p.MigrateStorage(cmd.Context(), migrateTo)
+		if err != nil {
+			return fmt.Errorf("failed to migrate store: %w", err)
+		}
+
+		fmt.Printf("Migrated %d records to the %s storage engine\n", n, migrateTo)
+		return nil
+	},
+}
+
+// storeReindexCmd represents the store reindex command
+var storeReindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the search index of the local store",
+	Long: `Rebuild the search index from the stored secrets. Searches rebuild a
+missing or stale index on their own, so this is only needed after changing
+secret files by hand.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, ok := provider.(providers.Indexer)
+		if !ok {
+			return fmt.Errorf("provider does not keep a search index")
+		}
+
+		if err := p.RebuildIndex(); err != nil {
+			return fmt.Errorf("failed to rebuild search index: %w", err)
+		}
+
+		fmt.Println("Search index rebuilt")
+		return nil
+	},
+}
+
+func init() {
+	storeMigrateCmd.Flags().StringVar(&migrateTo, "to", "", "Target storage engine (dir or db)")
+	storeMigrateCmd.MarkFlagRequired("to")
+
+	storeCmd.AddCommand(storeInfoCmd)
+	storeCmd.AddCommand(storeMigrateCmd)
+	storeCmd.AddCommand(storeReindexCmd)
+	rootCmd.AddCommand(storeCmd)
+}
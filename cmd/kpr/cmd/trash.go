@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keeper/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+var purgeOlderThan string
+
+// trashCmd represents the trash command
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manage deleted secrets",
+	Long: `Deleted secrets are kept in the trash, with their version history, until
+they are restored or purged. Secrets stay in the trash of the local store
+for trash_retention_days (30 by default). Cloud providers keep them for
+their own recovery window.`,
+}
+
+// trashListCmd represents the trash list command
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List deleted secrets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		trash, err := recoverableProvider()
+		if err != nil {
+			return err
+		}
+
+		deleted, err := trash.ListDeletedSecrets(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to list trash: %w", err)
+		}
+
+		if len(deleted) == 0 {
+			fmt.Println("Trash is empty")
+			return nil
+		}
+
+		fmt.Printf("Found %d deleted secrets:\n", len(deleted))
+		for _, secret := range deleted {
+			fmt.Printf("- %s\n", secret.Name)
+			if secret.Version > 0 {
+				fmt.Printf("  Version: %d\n", secret.Version)
+			}
+			fmt.Printf("  Deleted: %s\n", secret.DeletedAt.Local().Format("2006-01-02 15:04:05"))
+			if secret.DeletedBy != "" {
+				fmt.Printf("  Deleted by: %s\n", secret.DeletedBy)
+			}
+			if !secret.PurgeAt.IsZero() {
+				fmt.Printf("  Purged after: %s\n", secret.PurgeAt.Local().Format("2006-01-02 15:04:05"))
+			}
+		}
+
+		return nil
+	},
+}
+
+// trashRestoreCmd represents the trash restore command
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore [name]",
+	Short: "Restore a deleted secret",
+	Long: `Restore the most recently deleted secret with the given name, with its
+version history. For the local store, a folder name restores every secret
+deleted from the folder.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		trash, err := recoverableProvider()
+		if err != nil {
+			return err
+		}
+
+		name := args[0]
+		if err := trash.RecoverSecret(cmd.Context(), name); err != nil {
+			return fmt.Errorf("failed to restore secret: %w", err)
+		}
+
+		fmt.Printf("Successfully restored %s\n", name)
+		return nil
+	},
+}
+
+// trashPurgeCmd represents the trash purge command
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete secrets in the trash",
+	Long: `Permanently delete secrets deleted longer ago than --older-than. Pass
+--older-than 0s to empty the whole trash.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		trash, err := recoverableProvider()
+		if err != nil {
+			return err
+		}
+
+		age, err := providers.ParseDuration(purgeOlderThan)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		before := time.Now().Add(-age)
+
+		purged, err := trash.PurgeDeletedSecrets(cmd.Context(), before)
+		if err != nil {
+			return fmt.Errorf("failed to purge trash: %w", err)
+		}
+
+		fmt.Printf("Successfully purged %d secrets\n", purged)
+		return nil
+	},
+}
+
+// recoverableProvider returns the provider if it keeps deleted secrets
+func recoverableProvider() (providers.Recoverable, error) {
+	trash, ok := provider.(providers.Recoverable)
+	if !ok || !providers.CapabilitiesOf(provider).Trash {
+		return nil, fmt.Errorf("provider does not keep deleted secrets")
+	}
+	return trash, nil
+}
+
+func init() {
+	trashPurgeCmd.Flags().StringVar(&purgeOlderThan, "older-than", "", "Purge secrets deleted longer ago, such as 30d; 0s purges every secret")
+	trashPurgeCmd.MarkFlagRequired("older-than")
+
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashPurgeCmd)
+	rootCmd.AddCommand(trashCmd)
+}
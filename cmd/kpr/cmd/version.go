@@ -9,8 +9,9 @@ import (
 )
 
 var versionCmd = &cobra.Command{
-	Use:   "version",
-	Short: "Print version information",
+	Use:         "version",
+	Short:       "Print version information",
+	Annotations: map[string]string{withoutStore: "true"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Get build info
 		info, ok := debug.ReadBuildInfo()
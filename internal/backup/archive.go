@@ -0,0 +1,558 @@
+// Package backup reads and writes keeper backup archives.
+//
+// An archive is a tar stream, optionally compressed with gzip or zstd. It
+// starts with manifest.json, which lists every entry with its checksum,
+// followed by the entries:
+//
+//	secrets/<name>.<format>            current version of a secret
+//	history/<name>/<version>.<format>  previous version of a secret
+//	schemas/<name>.json                schema
+//
+// When the archive is encrypted, each entry is an envelope sealed with the
+// backup key and bound to its path. The manifest is never encrypted, so
+// that archives can be listed and checked without the key.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/providers"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ManifestPath is the path of the manifest within an archive
+	ManifestPath = "manifest.json"
+
+	// manifestVersion is the current archive format version
+	manifestVersion = 1
+)
+
+// Formats and compressions of archives
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ErrChecksumMismatch is returned when an entry does not match the
+// checksum recorded in the manifest
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Manifest describes the contents of an archive
+type Manifest struct {
+	Version       int         `json:"version"`
+	KeeperVersion string      `json:"keeper_version"`
+	CreatedAt     time.Time   `json:"created_at"`
+	Format        string      `json:"format"`
+	Compression   string      `json:"compression,omitempty"`
+	Encryption    *Encryption `json:"encryption,omitempty"`
+
+	Secrets  []Entry `json:"secrets"`
+	Versions []Entry `json:"versions,omitempty"`
+	Schemas  []Entry `json:"schemas,omitempty"`
+}
+
+// Entry is a file in an archive
+type Entry struct {
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"`
+	Schema  string `json:"schema,omitempty"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// Options controls how archives are written and read
+type Options struct {
+	// Format is the encoding of secrets, json or yaml
+	Format string
+
+	// Compression is gzip, zstd or empty for none. Archives are
+	// decompressed whatever their compression.
+	Compression string
+
+	// Key encrypts new archives and decrypts existing ones. Nil writes
+	// plaintext archives.
+	Key *Key
+
+	// Versions includes the previous versions of secrets
+	Versions bool
+}
+
+// record is the encoding of a secret in an archive
+type record struct {
+	Name      string            `json:"name" yaml:"name"`
+	Value     string            `json:"value" yaml:"value"`
+	Version   int               `json:"version" yaml:"version"`
+	Schema    string            `json:"schema,omitempty" yaml:"schema,omitempty"`
+	Tags      []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at" yaml:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" yaml:"updated_at"`
+}
+
+// newRecord returns the encoding of a secret
+func newRecord(secret *providers.Secret) record {
+	return record{
+		Name:      secret.Name,
+		Value:     secret.Value,
+		Version:   secret.Version,
+		Schema:    secret.Schema,
+		Tags:      secret.Tags,
+		Metadata:  secret.Metadata,
+		CreatedAt: secret.CreatedAt,
+		UpdatedAt: secret.UpdatedAt,
+	}
+}
+
+// secret returns the secret encoded by a record
+func (r record) secret() *providers.Secret {
+	return &providers.Secret{
+		Name:      r.Name,
+		Value:     r.Value,
+		Version:   r.Version,
+		Schema:    r.Schema,
+		Tags:      r.Tags,
+		Metadata:  r.Metadata,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
+
+// Writer writes an archive. Entries are buffered until Close, which
+// writes the manifest ahead of them.
+type Writer struct {
+	w        io.Writer
+	opts     Options
+	cipher   *entryCipher
+	manifest *Manifest
+	entries  map[string][]byte
+}
+
+// NewWriter returns a Writer writing an archive to w
+func NewWriter(w io.Writer, opts Options) (*Writer, error) {
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	if opts.Format != FormatJSON && opts.Format != FormatYAML {
+		return nil, fmt.Errorf("unsupported backup format %q (expected json or yaml)", opts.Format)
+	}
+	switch opts.Compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unsupported backup compression %q (expected gzip or zstd)", opts.Compression)
+	}
+
+	bw := &Writer{
+		w:    w,
+		opts: opts,
+		manifest: &Manifest{
+			Version:       manifestVersion,
+			KeeperVersion: keeperVersion(),
+			CreatedAt:     time.Now().UTC(),
+			Format:        opts.Format,
+			Compression:   opts.Compression,
+			Secrets:       []Entry{},
+		},
+		entries: make(map[string][]byte),
+	}
+
+	if opts.Key != nil {
+		c, enc, err := opts.Key.newCipher()
+		if err != nil {
+			return nil, err
+		}
+		bw.cipher = c
+		bw.manifest.Encryption = enc
+	}
+
+	return bw, nil
+}
+
+// AddSecret adds the current version of a secret
+func (w *Writer) AddSecret(secret *providers.Secret) error {
+	entry, err := w.addRecord(SecretPath(secret.Name, w.opts.Format), secret)
+	if err != nil {
+		return err
+	}
+	w.manifest.Secrets = append(w.manifest.Secrets, *entry)
+	return nil
+}
+
+// AddVersion adds a previous version of a secret
+func (w *Writer) AddVersion(secret *providers.Secret) error {
+	entry, err := w.addRecord(VersionPath(secret.Name, secret.Version, w.opts.Format), secret)
+	if err != nil {
+		return err
+	}
+	w.manifest.Versions = append(w.manifest.Versions, *entry)
+	return nil
+}
+
+// AddSchema adds a schema file
+func (w *Writer) AddSchema(name string, data []byte) error {
+	entry, err := w.add("schemas/"+name+".json", data)
+	if err != nil {
+		return err
+	}
+	entry.Name = name
+	w.manifest.Schemas = append(w.manifest.Schemas, *entry)
+	return nil
+}
+
+// addRecord encodes a secret and adds it at path
+func (w *Writer) addRecord(path string, secret *providers.Secret) (*Entry, error) {
+	rec := newRecord(secret)
+
+	var data []byte
+	var err error
+	if w.opts.Format == FormatYAML {
+		data, err = yaml.Marshal(rec)
+	} else {
+		data, err = json.MarshalIndent(rec, "", "  ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secret %s: %w", secret.Name, err)
+	}
+
+	entry, err := w.add(path, data)
+	if err != nil {
+		return nil, err
+	}
+	entry.Name = secret.Name
+	entry.Version = secret.Version
+	entry.Schema = secret.Schema
+	return entry, nil
+}
+
+// add encrypts an entry if needed and records it in the manifest
+func (w *Writer) add(path string, data []byte) (*Entry, error) {
+	if _, ok := w.entries[path]; ok {
+		return nil, fmt.Errorf("duplicate backup entry %s", path)
+	}
+
+	if w.cipher != nil {
+		var err error
+		if data, err = w.cipher.seal(data, path); err != nil {
+			return nil, err
+		}
+	}
+
+	w.entries[path] = data
+	return &Entry{Path: path, Size: int64(len(data)), SHA256: checksum(data)}, nil
+}
+
+// Close writes the archive
+func (w *Writer) Close() error {
+	out := w.w
+	var compressor io.WriteCloser
+	switch w.opts.Compression {
+	case CompressionGzip:
+		compressor = gzip.NewWriter(out)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		compressor = zw
+	}
+	if compressor != nil {
+		out = compressor
+	}
+
+	manifest, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	tw := tar.NewWriter(out)
+	if err := writeTarFile(tw, ManifestPath, manifest, w.manifest.CreatedAt); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(w.entries))
+	for path := range w.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := writeTarFile(tw, path, w.entries[path], w.manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("failed to compress archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Manifest returns the manifest of the archive being written
+func (w *Writer) Manifest() *Manifest {
+	return w.manifest
+}
+
+// Archive is an archive read into memory
+type Archive struct {
+	Manifest *Manifest
+
+	files  map[string][]byte
+	cipher *entryCipher
+}
+
+// Open reads the archive at path
+func Open(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Read reads an archive, detecting its compression
+func Read(r io.Reader) (*Archive, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	var in io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress backup: %w", err)
+		}
+		defer gr.Close()
+		in = gr
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress backup: %w", err)
+		}
+		defer zr.Close()
+		in = zr
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from backup: %w", hdr.Name, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	data, ok := files[ManifestPath]
+	if !ok {
+		return nil, fmt.Errorf("backup has no manifest")
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+
+	return &Archive{Manifest: &manifest, files: files}, nil
+}
+
+// Encrypted reports whether the entries of the archive are encrypted
+func (a *Archive) Encrypted() bool {
+	return a.Manifest.Encryption != nil
+}
+
+// Unlock checks a key against an encrypted archive and uses it to decrypt
+// entries. It does nothing for plaintext archives.
+func (a *Archive) Unlock(key *Key) error {
+	if !a.Encrypted() {
+		return nil
+	}
+	if key == nil {
+		return ErrKeyRequired
+	}
+
+	c, err := key.openCipher(a.Manifest.Encryption)
+	if err != nil {
+		return err
+	}
+	a.cipher = c
+	return nil
+}
+
+// Entry returns the content of an entry, checking it against the manifest
+// and decrypting it
+func (a *Archive) Entry(entry Entry) ([]byte, error) {
+	data, ok := a.files[entry.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing from the backup", entry.Path)
+	}
+	if checksum(data) != entry.SHA256 {
+		return nil, fmt.Errorf("%s: %w", entry.Path, ErrChecksumMismatch)
+	}
+
+	if !a.Encrypted() {
+		return data, nil
+	}
+	if a.cipher == nil {
+		return nil, ErrKeyRequired
+	}
+	return a.cipher.open(data, entry.Path)
+}
+
+// Secret decodes the secret stored in an entry
+func (a *Archive) Secret(entry Entry) (*providers.Secret, error) {
+	data, err := a.Entry(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec record
+	if a.Manifest.Format == FormatYAML {
+		err = yaml.Unmarshal(data, &rec)
+	} else {
+		err = json.Unmarshal(data, &rec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", entry.Path, err)
+	}
+	if rec.Name != entry.Name {
+		return nil, fmt.Errorf("%s holds secret %s instead of %s", entry.Path, rec.Name, entry.Name)
+	}
+
+	return rec.secret(), nil
+}
+
+// Secrets decodes the current version of every secret in the archive
+func (a *Archive) Secrets() ([]*providers.Secret, error) {
+	return a.decodeAll(a.Manifest.Secrets)
+}
+
+// Versions decodes every previous version in the archive, oldest first
+// for each secret
+func (a *Archive) Versions() ([]*providers.Secret, error) {
+	return a.decodeAll(a.Manifest.Versions)
+}
+
+// decodeAll decodes the secrets stored in entries
+func (a *Archive) decodeAll(entries []Entry) ([]*providers.Secret, error) {
+	secrets := make([]*providers.Secret, 0, len(entries))
+	for _, entry := range entries {
+		secret, err := a.Secret(entry)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// SecretPath returns the path of the current version of a secret
+func SecretPath(name, format string) string {
+	return "secrets/" + name + "." + format
+}
+
+// VersionPath returns the path of a previous version of a secret
+func VersionPath(name string, version int, format string) string {
+	return "history/" + name + "/" + strconv.Itoa(version) + "." + format
+}
+
+// Extension returns the file extension of archives with a compression
+func Extension(compression string) string {
+	switch compression {
+	case CompressionGzip:
+		return ".tar.gz"
+	case CompressionZstd:
+		return ".tar.zst"
+	}
+	return ".tar"
+}
+
+// IsArchive reports whether path looks like an archive rather than a
+// directory backup written by older versions
+func IsArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.zst"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// writeTarFile writes a regular file to a tar archive
+func writeTarFile(tw *tar.Writer, path string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    path,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// checksum returns the hex SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// keeperVersion returns the version of the running keeper binary
+func keeperVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
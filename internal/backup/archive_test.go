@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSecret(name, value string, version int) *providers.Secret {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return &providers.Secret{
+		Name:      name,
+		Value:     value,
+		Version:   version,
+		Tags:      []string{"prod"},
+		Metadata:  map[string]string{"owner": "ops"},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func writeArchive(t *testing.T, opts Options) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, opts)
+	require.NoError(t, err)
+	require.NoError(t, w.AddSecret(testSecret("app/db", "s3cret", 2)))
+	require.NoError(t, w.AddVersion(testSecret("app/db", "old", 1)))
+	require.NoError(t, w.AddSchema("db", []byte(`{"name":"db"}`)))
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{Format: FormatYAML, Compression: CompressionGzip},
+		{Compression: CompressionZstd},
+	} {
+		archive, err := Read(bytes.NewReader(writeArchive(t, opts)))
+		require.NoError(t, err)
+		assert.False(t, archive.Encrypted())
+
+		secrets, err := archive.Secrets()
+		require.NoError(t, err)
+		require.Len(t, secrets, 1)
+		assert.Equal(t, testSecret("app/db", "s3cret", 2), secrets[0])
+
+		versions, err := archive.Versions()
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, "old", versions[0].Value)
+
+		require.Len(t, archive.Manifest.Schemas, 1)
+		schema, err := archive.Entry(archive.Manifest.Schemas[0])
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"db"}`, string(schema))
+	}
+}
+
+func TestArchive_Passphrase(t *testing.T) {
+	passphrase := func(value string) *Key {
+		return PassphraseKey(func() ([]byte, error) { return []byte(value), nil })
+	}
+
+	data := writeArchive(t, Options{Key: passphrase("correct horse")})
+	assert.NotContains(t, string(data), "s3cret")
+
+	archive, err := Read(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.True(t, archive.Encrypted())
+
+	_, err = archive.Secrets()
+	assert.ErrorIs(t, err, ErrKeyRequired)
+	assert.ErrorIs(t, archive.Unlock(passphrase("wrong")), ErrWrongKey)
+
+	require.NoError(t, archive.Unlock(passphrase("correct horse")))
+	secrets, err := archive.Secrets()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", secrets[0].Value)
+}
+
+func TestArchive_KeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateKeyFile(filepath.Join(dir, "backup.key"))
+	require.NoError(t, err)
+	other, err := GenerateKeyFile(filepath.Join(dir, "other.key"))
+	require.NoError(t, err)
+
+	archive, err := Read(bytes.NewReader(writeArchive(t, Options{Key: key})))
+	require.NoError(t, err)
+	assert.ErrorIs(t, archive.Unlock(other), ErrWrongKey)
+	assert.ErrorIs(t, archive.Unlock(PassphraseKey(keychain.PassphraseFromEnv("UNSET_BACKUP_PASSPHRASE"))), ErrWrongKey)
+
+	read, err := ReadKeyFile(filepath.Join(dir, "backup.key"))
+	require.NoError(t, err)
+	require.NoError(t, archive.Unlock(read))
+
+	// Entries moved to another path fail to decrypt
+	entry := archive.Manifest.Secrets[0]
+	archive.files["secrets/moved.json"] = archive.files[entry.Path]
+	entry.Path = "secrets/moved.json"
+	_, err = archive.Entry(entry)
+	assert.Error(t, err)
+}
+
+func TestArchive_Corrupt(t *testing.T) {
+	archive, err := Read(bytes.NewReader(writeArchive(t, Options{})))
+	require.NoError(t, err)
+
+	entry := archive.Manifest.Secrets[0]
+	archive.files[entry.Path] = append([]byte{}, archive.files[entry.Path]...)
+	archive.files[entry.Path][0] ^= 0xff
+	_, err = archive.Secret(entry)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	delete(archive.files, entry.Path)
+	_, err = archive.Secret(entry)
+	assert.Error(t, err)
+}
@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/keychain"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// KeyTypePassphrase marks archives encrypted with a key derived from a
+	// passphrase
+	KeyTypePassphrase = "passphrase"
+
+	// KeyTypeFile marks archives encrypted with a random key kept in a file
+	KeyTypeFile = "key-file"
+
+	// keyCheck is sealed with the archive key to detect wrong keys
+	keyCheck = "keeper-backup-check"
+)
+
+var (
+	// ErrKeyRequired is returned when an encrypted archive is read without a key
+	ErrKeyRequired = errors.New("backup is encrypted and no key was given")
+
+	// ErrWrongKey is returned when a key does not open an archive
+	ErrWrongKey = errors.New("wrong backup key or passphrase")
+)
+
+// Encryption describes how the entries of an archive are encrypted
+type Encryption struct {
+	Algorithm string `json:"algorithm"`
+	KeyType   string `json:"key_type"`
+
+	// KeyID identifies the key of a key file
+	KeyID string `json:"key_id,omitempty"`
+
+	// KDF holds the parameters deriving the key from a passphrase
+	KDF *keychain.KDFParams `json:"kdf,omitempty"`
+
+	// Check is a known value sealed with the key
+	Check string `json:"check"`
+}
+
+// Key encrypts and decrypts the entries of archives. A passphrase key is
+// derived again for every archive, with the salt recorded in its manifest.
+type Key struct {
+	passphrase keychain.PassphraseSource
+	raw        []byte
+}
+
+// PassphraseKey returns a key derived from a passphrase, which is only
+// read when an archive needs it
+func PassphraseKey(passphrase keychain.PassphraseSource) *Key {
+	return &Key{passphrase: passphrase}
+}
+
+// ReadKeyFile reads a backup key written by GenerateKeyFile
+func ReadKeyFile(path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup key: %w", err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != crypto.KeySize {
+		return nil, fmt.Errorf("invalid backup key in %s", path)
+	}
+
+	return &Key{raw: raw}, nil
+}
+
+// GenerateKeyFile writes a new random backup key to path
+func GenerateKeyFile(path string) (*Key, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup key %s already exists", path)
+	}
+
+	raw, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data := []byte(base64.StdEncoding.EncodeToString(raw) + "\n")
+	if err := fileutil.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write backup key: %w", err)
+	}
+
+	return &Key{raw: raw}, nil
+}
+
+// newCipher returns the cipher and encryption details of a new archive
+func (k *Key) newCipher() (*entryCipher, *Encryption, error) {
+	enc := &Encryption{Algorithm: crypto.AlgorithmAES256GCM}
+	if k.raw != nil {
+		enc.KeyType = KeyTypeFile
+		enc.KeyID = keyID(k.raw)
+	} else {
+		kdf := keychain.DefaultKDFParams()
+		kdf.Salt = make([]byte, 16)
+		if _, err := rand.Read(kdf.Salt); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		enc.KeyType = KeyTypePassphrase
+		enc.KDF = &kdf
+	}
+
+	c, err := k.cipher(enc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	check, err := c.seal([]byte(keyCheck), keyCheck)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc.Check = base64.StdEncoding.EncodeToString(check)
+
+	return c, enc, nil
+}
+
+// openCipher returns the cipher of an existing archive, checking that the
+// key matches
+func (k *Key) openCipher(enc *Encryption) (*entryCipher, error) {
+	if enc.Algorithm != crypto.AlgorithmAES256GCM {
+		return nil, fmt.Errorf("unsupported backup encryption %q", enc.Algorithm)
+	}
+	if k.raw == nil && enc.KeyType == KeyTypeFile {
+		return nil, fmt.Errorf("backup is encrypted with a key file: %w", ErrWrongKey)
+	}
+	if k.raw != nil && enc.KeyType == KeyTypePassphrase {
+		return nil, fmt.Errorf("backup is encrypted with a passphrase: %w", ErrWrongKey)
+	}
+	if k.raw != nil && enc.KeyID != keyID(k.raw) {
+		return nil, fmt.Errorf("backup is encrypted with key %s: %w", enc.KeyID, ErrWrongKey)
+	}
+
+	c, err := k.cipher(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := base64.StdEncoding.DecodeString(enc.Check)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key check: %w", err)
+	}
+	if _, err := c.open(check, keyCheck); err != nil {
+		return nil, ErrWrongKey
+	}
+
+	return c, nil
+}
+
+// cipher returns the cipher of the key for the given encryption details
+func (k *Key) cipher(enc *Encryption) (*entryCipher, error) {
+	if k.raw != nil {
+		return &entryCipher{keyID: enc.KeyID, key: k.raw}, nil
+	}
+
+	if enc.KDF == nil || enc.KDF.Algorithm != "argon2id" {
+		return nil, fmt.Errorf("unsupported backup key derivation")
+	}
+
+	passphrase, err := k.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	// Derive once per key, since prompts and Argon2id are both slow
+	key := argon2.IDKey(passphrase, enc.KDF.Salt, enc.KDF.Time, enc.KDF.Memory, enc.KDF.Threads, crypto.KeySize)
+	k.passphrase = func() ([]byte, error) { return passphrase, nil }
+
+	return &entryCipher{keyID: KeyTypePassphrase, key: key}, nil
+}
+
+// keyID returns a fingerprint of a backup key
+func keyID(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// entryCipher seals archive entries with envelopes, binding each to its
+// path in the archive
+type entryCipher struct {
+	keyID string
+	key   []byte
+}
+
+// CurrentKeyID implements the crypto.KeyStore interface
+func (c *entryCipher) CurrentKeyID() (string, error) {
+	return c.keyID, nil
+}
+
+// MasterKey implements the crypto.KeyStore interface
+func (c *entryCipher) MasterKey(id string) ([]byte, error) {
+	if id != c.keyID {
+		return nil, ErrWrongKey
+	}
+	return c.key, nil
+}
+
+// seal encrypts the entry stored at path
+func (c *entryCipher) seal(data []byte, path string) ([]byte, error) {
+	env, err := crypto.Seal(c, data, []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", path, err)
+	}
+	return env.Marshal(), nil
+}
+
+// open decrypts the entry stored at path
+func (c *entryCipher) open(data []byte, path string) ([]byte, error) {
+	env, err := crypto.UnmarshalEnvelope(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+
+	plaintext, err := crypto.Open(c, env, []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,33 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/keeper/internal/providers"
+)
+
+// Archiver is implemented by providers that back up their secrets to
+// archives and restore them selectively
+type Archiver interface {
+	providers.Backuper
+
+	// SetBackupOptions sets the format, compression and key of backups
+	SetBackupOptions(opts Options)
+
+	// RestoreBackup plans a restore from the backup and, unless it is a
+	// dry run, carries it out
+	RestoreBackup(ctx context.Context, opts RestoreOptions) (*RestorePlan, error)
+
+	// VerifyBackup checks that the backup can be restored
+	VerifyBackup(ctx context.Context) (*VerifyReport, error)
+}
+
+// Snapshotter is implemented by providers that take point-in-time
+// snapshots of their secrets
+type Snapshotter interface {
+	// TakeSnapshot records every secret in a snapshot repository
+	TakeSnapshot(ctx context.Context, repo *Repository) (*Snapshot, error)
+
+	// RestoreSnapshot returns the store to a snapshot
+	RestoreSnapshot(ctx context.Context, repo *Repository, snapshot *Snapshot, dryRun bool) (*RestorePlan, error)
+}
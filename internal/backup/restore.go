@@ -0,0 +1,261 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/keeper/internal/providers"
+)
+
+// Policies for secrets that exist in both the backup and the store
+const (
+	// ConflictSkip keeps the secret in the store
+	ConflictSkip = "skip"
+
+	// ConflictOverwrite stores the backup as a new version of the secret
+	ConflictOverwrite = "overwrite"
+
+	// ConflictKeepNewest keeps whichever was updated last
+	ConflictKeepNewest = "keep-newest"
+
+	// ConflictRename restores the backup next to the secret, as
+	// <name>.restored
+	ConflictRename = "rename"
+)
+
+// Statuses of a secret in a backup compared to the store
+const (
+	StatusNew       = "new"
+	StatusChanged   = "changed"
+	StatusIdentical = "identical"
+)
+
+// RestoreOptions selects what a restore writes
+type RestoreOptions struct {
+	// Conflict is the policy for changed secrets, skip by default
+	Conflict string
+
+	// Names restores only secrets matching one of these globs, or in one
+	// of these folders
+	Names []string
+
+	// Tags restores only secrets with all of these tags
+	Tags []string
+
+	// Schema restores only secrets with this schema
+	Schema string
+
+	// Versions restores the history of secrets that are created by the
+	// restore. Secrets that are overwritten keep their own history.
+	Versions bool
+
+	// DryRun plans the restore without writing anything
+	DryRun bool
+}
+
+// RestoreItem is the plan for a secret of a backup
+type RestoreItem struct {
+	Name   string
+	Status string
+
+	// Target is the name the secret is restored as, or empty if it is
+	// left alone
+	Target string
+
+	// Secret is the secret in the backup, and Versions its history,
+	// oldest first
+	Secret   *providers.Secret
+	Versions []*providers.Secret
+}
+
+// Creates reports whether the restore creates the target secret
+func (i *RestoreItem) Creates() bool {
+	return i.Target != "" && (i.Status == StatusNew || i.Target != i.Name)
+}
+
+// RestorePlan lists what a restore does with each selected secret
+type RestorePlan struct {
+	Items []*RestoreItem
+
+	// Removed lists the secrets of the store that are not in a snapshot
+	// and are deleted by restoring it
+	Removed []string
+}
+
+// Count returns the number of items with a status
+func (p *RestorePlan) Count(status string) int {
+	n := 0
+	for _, item := range p.Items {
+		if item.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Restored returns the items that are written by the restore
+func (p *RestorePlan) Restored() []*RestoreItem {
+	var items []*RestoreItem
+	for _, item := range p.Items {
+		if item.Target != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks the options
+func (o RestoreOptions) Validate() error {
+	switch o.Conflict {
+	case "", ConflictSkip, ConflictOverwrite, ConflictKeepNewest, ConflictRename:
+	default:
+		return fmt.Errorf("unknown conflict policy %q (expected skip, overwrite, keep-newest or rename)", o.Conflict)
+	}
+	for _, pattern := range o.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid name pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether a secret is selected by the options
+func (o RestoreOptions) Selects(secret *providers.Secret) bool {
+	if o.Schema != "" && secret.Schema != o.Schema {
+		return false
+	}
+	for _, tag := range o.Tags {
+		if !hasTag(secret, tag) {
+			return false
+		}
+	}
+	if len(o.Names) == 0 {
+		return true
+	}
+
+	for _, pattern := range o.Names {
+		pattern = strings.Trim(pattern, "/")
+		if ok, _ := path.Match(pattern, secret.Name); ok || strings.HasPrefix(secret.Name, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanRestore compares the secrets of a backup with those of the store,
+// which current looks up, and decides what to restore. Versions holds the
+// previous versions of the backed up secrets.
+func PlanRestore(secrets, versions []*providers.Secret, current func(name string) (*providers.Secret, error), opts RestoreOptions) (*RestorePlan, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	history := make(map[string][]*providers.Secret)
+	if opts.Versions {
+		for _, version := range versions {
+			history[version.Name] = append(history[version.Name], version)
+		}
+	}
+
+	plan := &RestorePlan{}
+	taken := make(map[string]bool)
+	for _, secret := range secrets {
+		if !opts.Selects(secret) {
+			continue
+		}
+
+		existing, err := current(secret.Name)
+		if err != nil && !errors.Is(err, providers.ErrSecretNotFound) {
+			return nil, fmt.Errorf("failed to read secret %s: %w", secret.Name, err)
+		}
+
+		item := &RestoreItem{Name: secret.Name, Secret: secret}
+		switch {
+		case existing == nil:
+			item.Status = StatusNew
+			item.Target = secret.Name
+		case sameContent(secret, existing):
+			item.Status = StatusIdentical
+		default:
+			item.Status = StatusChanged
+			switch opts.Conflict {
+			case ConflictOverwrite:
+				item.Target = secret.Name
+			case ConflictKeepNewest:
+				if secret.UpdatedAt.After(existing.UpdatedAt) {
+					item.Target = secret.Name
+				}
+			case ConflictRename:
+				if item.Target, err = renameTarget(secret.Name, current, taken); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if item.Creates() {
+			item.Versions = history[secret.Name]
+		}
+		if item.Target != "" {
+			taken[item.Target] = true
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	sort.Slice(plan.Items, func(i, j int) bool {
+		return plan.Items[i].Name < plan.Items[j].Name
+	})
+	return plan, nil
+}
+
+// renameTarget returns the first free name of the form <name>.restored
+// or <name>.restored-<n>
+func renameTarget(name string, current func(name string) (*providers.Secret, error), taken map[string]bool) (string, error) {
+	for n := 1; ; n++ {
+		target := name + ".restored"
+		if n > 1 {
+			target = fmt.Sprintf("%s-%d", target, n)
+		}
+		if taken[target] {
+			continue
+		}
+
+		_, err := current(target)
+		if errors.Is(err, providers.ErrSecretNotFound) {
+			return target, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret %s: %w", target, err)
+		}
+	}
+}
+
+// sameContent reports whether two secrets hold the same value, schema,
+// tags and metadata
+func sameContent(a, b *providers.Secret) bool {
+	if a.Value != b.Value || a.Schema != b.Schema || len(a.Tags) != len(b.Tags) || len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+	if len(a.Tags) > 0 && !reflect.DeepEqual(a.Tags, b.Tags) {
+		return false
+	}
+	for k, v := range a.Metadata {
+		if other, ok := b.Metadata[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTag reports whether a secret has a tag
+func hasTag(secret *providers.Secret, tag string) bool {
+	for _, t := range secret.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keeper/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRestore(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := map[string]*providers.Secret{
+		"app/db":             {Name: "app/db", Value: "current", UpdatedAt: old.Add(time.Hour)},
+		"app/key":            {Name: "app/key", Value: "same", Tags: []string{"prod"}},
+		"web/token":          {Name: "web/token", Value: "current", UpdatedAt: old.Add(-time.Hour)},
+		"web/token.restored": {Name: "web/token.restored", Value: "taken"},
+	}
+	current := func(name string) (*providers.Secret, error) {
+		if secret, ok := store[name]; ok {
+			return secret, nil
+		}
+		return nil, providers.ErrSecretNotFound
+	}
+
+	secrets := []*providers.Secret{
+		{Name: "app/db", Value: "backup", UpdatedAt: old},
+		{Name: "app/key", Value: "same", Tags: []string{"prod"}},
+		{Name: "app/new", Value: "new", Tags: []string{"prod"}},
+		{Name: "web/token", Value: "backup", UpdatedAt: old},
+	}
+	versions := []*providers.Secret{{Name: "app/new", Value: "older", Version: 1}}
+
+	targets := func(opts RestoreOptions) map[string]string {
+		plan, err := PlanRestore(secrets, versions, current, opts)
+		require.NoError(t, err)
+		result := make(map[string]string)
+		for _, item := range plan.Items {
+			result[item.Name] = item.Status + ":" + item.Target
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]string{
+		"app/db":    "changed:",
+		"app/key":   "identical:",
+		"app/new":   "new:app/new",
+		"web/token": "changed:",
+	}, targets(RestoreOptions{}))
+
+	assert.Equal(t, "changed:app/db", targets(RestoreOptions{Conflict: ConflictOverwrite})["app/db"])
+
+	keepNewest := targets(RestoreOptions{Conflict: ConflictKeepNewest})
+	assert.Equal(t, "changed:", keepNewest["app/db"])
+	assert.Equal(t, "changed:web/token", keepNewest["web/token"])
+
+	renamed := targets(RestoreOptions{Conflict: ConflictRename})
+	assert.Equal(t, "changed:app/db.restored", renamed["app/db"])
+	assert.Equal(t, "changed:web/token.restored-2", renamed["web/token"])
+
+	assert.Equal(t, map[string]string{"app/key": "identical:", "app/new": "new:app/new"}, targets(RestoreOptions{Tags: []string{"prod"}}))
+	assert.Len(t, targets(RestoreOptions{Names: []string{"app"}}), 3)
+	assert.Len(t, targets(RestoreOptions{Names: []string{"*/token"}}), 1)
+
+	plan, err := PlanRestore(secrets, versions, current, RestoreOptions{Versions: true})
+	require.NoError(t, err)
+	for _, item := range plan.Items {
+		if item.Name == "app/new" {
+			assert.Len(t, item.Versions, 1)
+		} else {
+			assert.Empty(t, item.Versions)
+		}
+	}
+
+	_, err = PlanRestore(secrets, versions, current, RestoreOptions{Conflict: "merge"})
+	assert.Error(t, err)
+}
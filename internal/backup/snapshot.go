@@ -0,0 +1,494 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/providers"
+)
+
+// A snapshot repository keeps point-in-time copies of a store:
+//
+//	repo.json               encryption details shared by every snapshot
+//	snapshots/<id>.json     list of the secrets and schemas in a snapshot
+//	blobs/<blob>            a secret version or schema
+//
+// Blobs are named after the secret name, version and update time, so a
+// snapshot only writes the secrets that changed since its parent and
+// shares the other blobs. Pruning a snapshot never breaks another one;
+// blobs are removed once no snapshot refers to them.
+const (
+	repoFile     = "repo.json"
+	snapshotsDir = "snapshots"
+	blobsDir     = "blobs"
+
+	// snapshotIDLayout names snapshots after their creation time in UTC
+	snapshotIDLayout = "20060102-150405"
+)
+
+// ErrNoSnapshot is returned when no snapshot matches a request
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+// Snapshot lists the secrets and schemas of a store at a point in time
+type Snapshot struct {
+	ID            string    `json:"id"`
+	Parent        string    `json:"parent,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	KeeperVersion string    `json:"keeper_version"`
+
+	Secrets []SnapshotEntry `json:"secrets"`
+	Schemas []SnapshotEntry `json:"schemas,omitempty"`
+
+	// Changed is the number of secrets written by the snapshot rather than
+	// shared with its parent
+	Changed int `json:"changed"`
+}
+
+// SnapshotEntry is a secret or schema in a snapshot
+type SnapshotEntry struct {
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"`
+	Blob    string `json:"blob"`
+	SHA256  string `json:"sha256"`
+}
+
+// Retention is a policy for pruning snapshots. The newest snapshot of
+// each of the last Hourly hours, Daily days and Monthly months is kept,
+// along with the newest snapshot overall.
+type Retention struct {
+	Hourly  int
+	Daily   int
+	Monthly int
+}
+
+// DefaultRetention keeps 24 hourly, 7 daily and 12 monthly snapshots
+var DefaultRetention = Retention{Hourly: 24, Daily: 7, Monthly: 12}
+
+// repoInfo is the content of repo.json
+type repoInfo struct {
+	Version    int         `json:"version"`
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// Repository is a directory of incremental snapshots
+type Repository struct {
+	dir    string
+	key    *Key
+	info   *repoInfo
+	cipher *entryCipher
+}
+
+// OpenRepository opens the snapshot repository in dir. The key encrypts
+// the repository when the first snapshot creates it, and is needed to
+// read or add snapshots of an encrypted repository afterwards.
+func OpenRepository(dir string, key *Key) (*Repository, error) {
+	r := &Repository{dir: dir, key: key}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, repoFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot repository: %w", err)
+	}
+
+	var info repoInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", repoFile, err)
+	}
+	if info.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported snapshot repository version %d", info.Version)
+	}
+	r.info = &info
+
+	return r, nil
+}
+
+// Encrypted reports whether the snapshots of the repository are encrypted.
+// A repository without snapshots is encrypted if it was opened with a key.
+func (r *Repository) Encrypted() bool {
+	if r.info == nil {
+		return r.key != nil
+	}
+	return r.info.Encryption != nil
+}
+
+// Snapshots returns the snapshots of the repository, oldest first
+func (r *Repository) Snapshots() ([]*Snapshot, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(r.dir, snapshotsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshots: %w", err)
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(r.dir, snapshotsDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", entry.Name(), err)
+		}
+
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal snapshot %s: %w", entry.Name(), err)
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// At returns the most recent snapshot taken at or before t
+func (r *Repository) At(t time.Time) (*Snapshot, error) {
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].CreatedAt.After(t) {
+			return snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w at or before %s", ErrNoSnapshot, t.Format(time.RFC3339))
+}
+
+// Create takes a snapshot of the given secrets and schemas. Only secrets
+// that changed since the latest snapshot are written.
+func (r *Repository) Create(secrets []*providers.Secret, schemas map[string][]byte) (*Snapshot, error) {
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	snapshot := &Snapshot{
+		ID:            r.newID(now),
+		CreatedAt:     now,
+		KeeperVersion: keeperVersion(),
+		Secrets:       []SnapshotEntry{},
+	}
+
+	shared := make(map[string]SnapshotEntry)
+	if len(snapshots) > 0 {
+		parent := snapshots[len(snapshots)-1]
+		snapshot.Parent = parent.ID
+		for _, entry := range append(parent.Secrets, parent.Schemas...) {
+			shared[entry.Blob] = entry
+		}
+	}
+
+	for _, secret := range secrets {
+		blob := secretBlob(secret)
+		entry, ok := shared[blob]
+		if !ok {
+			data, err := json.Marshal(newRecord(secret))
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal secret %s: %w", secret.Name, err)
+			}
+			if entry, err = r.writeBlob(blob, data); err != nil {
+				return nil, err
+			}
+			snapshot.Changed++
+		}
+
+		entry.Name = secret.Name
+		entry.Version = secret.Version
+		snapshot.Secrets = append(snapshot.Secrets, entry)
+	}
+
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		blob := contentBlob(schemas[name])
+		entry, ok := shared[blob]
+		if !ok {
+			if entry, err = r.writeBlob(blob, schemas[name]); err != nil {
+				return nil, err
+			}
+		}
+		entry.Name = name
+		snapshot.Schemas = append(snapshot.Schemas, entry)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	// The snapshot only exists once its list is written, after its blobs
+	if err := fileutil.WriteFile(r.snapshotPath(snapshot.ID), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// Secrets reads the secrets of a snapshot
+func (r *Repository) Secrets(snapshot *Snapshot) ([]*providers.Secret, error) {
+	secrets := make([]*providers.Secret, 0, len(snapshot.Secrets))
+	for _, entry := range snapshot.Secrets {
+		data, err := r.readBlob(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal blob %s: %w", entry.Blob, err)
+		}
+		if rec.Name != entry.Name {
+			return nil, fmt.Errorf("blob %s holds secret %s instead of %s", entry.Blob, rec.Name, entry.Name)
+		}
+		secrets = append(secrets, rec.secret())
+	}
+
+	return secrets, nil
+}
+
+// Schemas reads the schemas of a snapshot
+func (r *Repository) Schemas(snapshot *Snapshot) (map[string][]byte, error) {
+	schemas := make(map[string][]byte)
+	for _, entry := range snapshot.Schemas {
+		data, err := r.readBlob(entry)
+		if err != nil {
+			return nil, err
+		}
+		schemas[entry.Name] = data
+	}
+
+	return schemas, nil
+}
+
+// Prune removes the snapshots not kept by a retention policy, then the
+// blobs no remaining snapshot refers to. It returns the removed snapshots.
+func (r *Repository) Prune(policy Retention) ([]*Snapshot, error) {
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	keep := policy.Keep(snapshots)
+	var removed []*Snapshot
+	for _, snapshot := range snapshots {
+		if keep[snapshot.ID] {
+			continue
+		}
+		if err := os.Remove(r.snapshotPath(snapshot.ID)); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", snapshot.ID, err)
+		}
+		removed = append(removed, snapshot)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	// Snapshots taken concurrently may refer to blobs written since the
+	// snapshot list was read, so the list is read again
+	remaining, err := r.Snapshots()
+	if err != nil {
+		return removed, err
+	}
+	used := make(map[string]bool)
+	for _, snapshot := range remaining {
+		for _, entry := range append(snapshot.Secrets, snapshot.Schemas...) {
+			used[entry.Blob] = true
+		}
+	}
+
+	blobs, err := ioutil.ReadDir(filepath.Join(r.dir, blobsDir))
+	if err != nil {
+		return removed, fmt.Errorf("failed to read blobs: %w", err)
+	}
+	for _, blob := range blobs {
+		if used[blob.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.dir, blobsDir, blob.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove blob %s: %w", blob.Name(), err)
+		}
+	}
+
+	return removed, nil
+}
+
+// Keep returns the IDs of the snapshots kept by the policy
+func (p Retention) Keep(snapshots []*Snapshot) map[string]bool {
+	keep := make(map[string]bool)
+	if len(snapshots) == 0 {
+		return keep
+	}
+
+	newest := make([]*Snapshot, len(snapshots))
+	copy(newest, snapshots)
+	sort.Slice(newest, func(i, j int) bool {
+		return newest[i].CreatedAt.After(newest[j].CreatedAt)
+	})
+	keep[newest[0].ID] = true
+
+	for _, rule := range []struct {
+		count  int
+		layout string
+	}{
+		{p.Hourly, "2006-01-02 15"},
+		{p.Daily, "2006-01-02"},
+		{p.Monthly, "2006-01"},
+	} {
+		periods := make(map[string]bool)
+		for _, snapshot := range newest {
+			if len(periods) >= rule.count {
+				break
+			}
+			period := snapshot.CreatedAt.Local().Format(rule.layout)
+			if !periods[period] {
+				periods[period] = true
+				keep[snapshot.ID] = true
+			}
+		}
+	}
+
+	return keep
+}
+
+// init creates the repository on first use and prepares the cipher of an
+// encrypted repository
+func (r *Repository) init() error {
+	if r.info == nil {
+		info := &repoInfo{Version: manifestVersion}
+		if r.key != nil {
+			c, enc, err := r.key.newCipher()
+			if err != nil {
+				return err
+			}
+			r.cipher = c
+			info.Encryption = enc
+		}
+
+		for _, dir := range []string{snapshotsDir, blobsDir} {
+			if err := os.MkdirAll(filepath.Join(r.dir, dir), 0700); err != nil {
+				return fmt.Errorf("failed to create snapshot repository: %w", err)
+			}
+		}
+
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", repoFile, err)
+		}
+		if err := fileutil.WriteFile(filepath.Join(r.dir, repoFile), data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", repoFile, err)
+		}
+		r.info = info
+	}
+
+	return r.unlock()
+}
+
+// unlock prepares the cipher of an encrypted repository
+func (r *Repository) unlock() error {
+	if r.cipher != nil || r.info == nil || r.info.Encryption == nil {
+		return nil
+	}
+	if r.key == nil {
+		return ErrKeyRequired
+	}
+
+	c, err := r.key.openCipher(r.info.Encryption)
+	if err != nil {
+		return err
+	}
+	r.cipher = c
+	return nil
+}
+
+// writeBlob stores a blob, encrypting it if the repository is encrypted
+func (r *Repository) writeBlob(blob string, data []byte) (SnapshotEntry, error) {
+	if r.cipher != nil {
+		var err error
+		if data, err = r.cipher.seal(data, blobsDir+"/"+blob); err != nil {
+			return SnapshotEntry{}, err
+		}
+	}
+
+	if err := fileutil.WriteFile(filepath.Join(r.dir, blobsDir, blob), data, 0600); err != nil {
+		return SnapshotEntry{}, fmt.Errorf("failed to write blob %s: %w", blob, err)
+	}
+
+	return SnapshotEntry{Blob: blob, SHA256: checksum(data)}, nil
+}
+
+// readBlob reads a blob, checking and decrypting it
+func (r *Repository) readBlob(entry SnapshotEntry) ([]byte, error) {
+	if err := r.unlock(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, blobsDir, entry.Blob))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s of %s: %w", entry.Blob, entry.Name, err)
+	}
+	if checksum(data) != entry.SHA256 {
+		return nil, fmt.Errorf("blob %s of %s: %w", entry.Blob, entry.Name, ErrChecksumMismatch)
+	}
+
+	if r.cipher == nil {
+		return data, nil
+	}
+	return r.cipher.open(data, blobsDir+"/"+entry.Blob)
+}
+
+// newID returns an unused snapshot ID for a creation time
+func (r *Repository) newID(t time.Time) string {
+	base := t.Format(snapshotIDLayout)
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(r.snapshotPath(id)); os.IsNotExist(err) {
+			return id
+		}
+		id = base + "-" + strconv.Itoa(n)
+	}
+}
+
+// snapshotPath returns the path of the list of a snapshot
+func (r *Repository) snapshotPath(id string) string {
+	return filepath.Join(r.dir, snapshotsDir, id+".json")
+}
+
+// secretBlob returns the blob name of a secret version. Secrets keep
+// their name, version and update time until they change.
+func secretBlob(secret *providers.Secret) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("secret\x00%s\x00%d\x00%d", secret.Name, secret.Version, secret.UpdatedAt.UnixNano())))
+	return hex.EncodeToString(sum[:16])
+}
+
+// contentBlob returns the blob name of a schema
+func contentBlob(data []byte) string {
+	sum := sha256.Sum256(append([]byte("schema\x00"), data...))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keeper/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Incremental(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenRepository(dir, PassphraseKey(func() ([]byte, error) {
+		return []byte("passphrase"), nil
+	}))
+	require.NoError(t, err)
+
+	db, api := testSecret("app/db", "s3cret", 1), testSecret("app/api", "token", 1)
+	first, err := repo.Create([]*providers.Secret{db, api}, map[string][]byte{"db": []byte(`{"name":"db"}`)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, first.Changed)
+
+	changed := testSecret("app/db", "rotated", 2)
+	changed.UpdatedAt = changed.UpdatedAt.Add(time.Hour)
+	second, err := repo.Create([]*providers.Secret{changed, api}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.Parent)
+	assert.Equal(t, 1, second.Changed)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, blobsDir))
+	require.NoError(t, err)
+	assert.Len(t, blobs, 4)
+
+	reopened, err := OpenRepository(dir, PassphraseKey(func() ([]byte, error) {
+		return []byte("passphrase"), nil
+	}))
+	require.NoError(t, err)
+	assert.True(t, reopened.Encrypted())
+
+	snapshot, err := reopened.At(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, snapshot.ID)
+
+	secrets, err := reopened.Secrets(first)
+	require.NoError(t, err)
+	assert.Equal(t, []*providers.Secret{db, api}, secrets)
+
+	schemas, err := reopened.Schemas(first)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"db"}`, string(schemas["db"]))
+
+	_, err = reopened.At(first.CreatedAt.Add(-time.Second))
+	assert.ErrorIs(t, err, ErrNoSnapshot)
+
+	wrong, err := OpenRepository(dir, PassphraseKey(func() ([]byte, error) {
+		return []byte("wrong"), nil
+	}))
+	require.NoError(t, err)
+	_, err = wrong.Secrets(first)
+	assert.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestRepository_Prune(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenRepository(dir, nil)
+	require.NoError(t, err)
+
+	db := testSecret("app/db", "s3cret", 1)
+	first, err := repo.Create([]*providers.Secret{db}, nil)
+	require.NoError(t, err)
+
+	changed := testSecret("app/db", "rotated", 2)
+	second, err := repo.Create([]*providers.Secret{changed}, nil)
+	require.NoError(t, err)
+
+	removed, err := repo.Prune(Retention{})
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, first.ID, removed[0].ID)
+
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, blobsDir))
+	require.NoError(t, err)
+	assert.Len(t, blobs, 1)
+
+	secrets, err := repo.Secrets(second)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", secrets[0].Value)
+}
+
+func TestRetention_Keep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	var snapshots []*Snapshot
+	// A snapshot every 30 minutes for 40 days
+	for i := 0; i < 40*48; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Minute)
+		snapshots = append(snapshots, &Snapshot{ID: at.Format(snapshotIDLayout), CreatedAt: at})
+	}
+
+	keep := Retention{Hourly: 24, Daily: 7, Monthly: 12}.Keep(snapshots)
+
+	// 24 hours, plus the 6 days before today and the month before this one
+	assert.Len(t, keep, 24+6+1)
+	assert.True(t, keep[snapshots[len(snapshots)-1].ID])
+	assert.True(t, keep[time.Date(2024, 1, 31, 23, 30, 0, 0, time.Local).Format(snapshotIDLayout)])
+	assert.False(t, keep[snapshots[0].ID])
+
+	assert.Equal(t, map[string]bool{snapshots[len(snapshots)-1].ID: true}, Retention{}.Keep(snapshots))
+}
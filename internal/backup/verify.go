@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/keeper/internal/providers"
+)
+
+// Problem is a missing, corrupt or invalid item of a backup
+type Problem struct {
+	Path string
+	Err  error
+}
+
+// String returns a description of the problem
+func (p Problem) String() string {
+	if msg := p.Err.Error(); strings.Contains(msg, p.Path) {
+		return msg
+	}
+	return fmt.Sprintf("%s: %v", p.Path, p.Err)
+}
+
+// VerifyReport is the result of verifying a backup
+type VerifyReport struct {
+	Secrets  int
+	Versions int
+	Schemas  int
+	Problems []Problem
+}
+
+// OK reports whether the backup has no problems
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Add records a problem with an item of the backup
+func (r *VerifyReport) Add(path string, err error) {
+	r.Problems = append(r.Problems, Problem{Path: path, Err: err})
+}
+
+// SchemaLookup returns a schema by name
+type SchemaLookup func(name string) (*providers.Schema, error)
+
+// Verify checks every entry of the archive against the checksum in the
+// manifest, decrypts it and validates secrets against their schemas. The
+// schemas come from the archive, or from lookup for those it does not
+// hold. Entries that are missing or not listed in the manifest are
+// reported as well. The archive must be unlocked first if it is encrypted.
+func (a *Archive) Verify(lookup SchemaLookup) *VerifyReport {
+	report := &VerifyReport{}
+	listed := map[string]bool{ManifestPath: true}
+
+	schemas := make(map[string]*providers.Schema)
+	for _, entry := range a.Manifest.Schemas {
+		listed[entry.Path] = true
+		report.Schemas++
+
+		data, err := a.Entry(entry)
+		if err != nil {
+			report.Add(entry.Path, err)
+			continue
+		}
+
+		var schema providers.Schema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			report.Add(entry.Path, fmt.Errorf("invalid schema: %w", err))
+			continue
+		}
+		schemas[entry.Name] = &schema
+	}
+
+	schema := func(name string) (*providers.Schema, error) {
+		if s, ok := schemas[name]; ok {
+			return s, nil
+		}
+		if lookup == nil {
+			return nil, fmt.Errorf("schema %s is not in the backup", name)
+		}
+		s, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		schemas[name] = s
+		return s, nil
+	}
+
+	for _, entries := range [][]Entry{a.Manifest.Secrets, a.Manifest.Versions} {
+		for _, entry := range entries {
+			listed[entry.Path] = true
+
+			secret, err := a.Secret(entry)
+			if err == nil {
+				err = VerifySecret(secret, schema)
+			}
+			if err != nil {
+				report.Add(entry.Path, err)
+			}
+		}
+	}
+	report.Secrets = len(a.Manifest.Secrets)
+	report.Versions = len(a.Manifest.Versions)
+
+	var unlisted []string
+	for path := range a.files {
+		if !listed[path] {
+			unlisted = append(unlisted, path)
+		}
+	}
+	sort.Strings(unlisted)
+	for _, path := range unlisted {
+		report.Add(path, fmt.Errorf("not listed in the manifest"))
+	}
+
+	return report
+}
+
+// VerifySecret checks that a secret is valid and matches its schema
+func VerifySecret(secret *providers.Secret, lookup SchemaLookup) error {
+	if err := secret.Validate(); err != nil {
+		return err
+	}
+	if secret.Schema == "" {
+		return nil
+	}
+
+	schema, err := lookup(secret.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to load schema: %w", err)
+	}
+	if err := providers.ValidateSecret(secret, schema); err != nil {
+		return fmt.Errorf("does not match schema %s: %w", secret.Schema, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/keeper/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_Verify(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Options{})
+	require.NoError(t, err)
+	require.NoError(t, w.AddSchema("db", []byte(`{"name":"db","fields":{"host":{"type":"string","required":true}}}`)))
+	require.NoError(t, w.AddSecret(&providers.Secret{Name: "ok", Value: "1", Schema: "db", Metadata: map[string]string{"host": "h"}}))
+	require.NoError(t, w.AddSecret(&providers.Secret{Name: "invalid", Value: "1", Schema: "db"}))
+	require.NoError(t, w.AddSecret(&providers.Secret{Name: "unknown", Value: "1", Schema: "cache"}))
+	require.NoError(t, w.AddVersion(&providers.Secret{Name: "ok", Value: "0", Version: 1, Schema: "db", Metadata: map[string]string{"host": "h"}}))
+	require.NoError(t, w.Close())
+
+	archive, err := Read(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	report := archive.Verify(nil)
+	assert.Equal(t, 3, report.Secrets)
+	assert.Equal(t, 1, report.Versions)
+	assert.Equal(t, 1, report.Schemas)
+
+	var paths []string
+	for _, problem := range report.Problems {
+		paths = append(paths, problem.Path)
+	}
+	assert.ElementsMatch(t, []string{"secrets/invalid.json", "secrets/unknown.json"}, paths)
+
+	// Schemas missing from the archive are looked up elsewhere
+	report = archive.Verify(func(name string) (*providers.Schema, error) {
+		return &providers.Schema{Name: name}, nil
+	})
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, "secrets/invalid.json", report.Problems[0].Path)
+
+	// Corrupt, missing and unlisted entries are reported
+	archive.files["secrets/ok.json"] = []byte("{}")
+	delete(archive.files, "history/ok/1.json")
+	archive.files["extra"] = []byte("x")
+	report = archive.Verify(func(name string) (*providers.Schema, error) {
+		return &providers.Schema{Name: name}, nil
+	})
+	assert.False(t, report.OK())
+	paths = nil
+	for _, problem := range report.Problems {
+		paths = append(paths, problem.Path)
+	}
+	assert.ElementsMatch(t, []string{"secrets/ok.json", "secrets/invalid.json", "history/ok/1.json", "extra"}, paths)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -14,6 +16,52 @@ type Config struct {
 	Providers       map[string]ProviderConfig    `yaml:"providers"`
 	Encryption      EncryptionConfig            `yaml:"encryption"`
 	DefaultDataDir  string                      `yaml:"default_data_dir"`
+
+	// HistoryRetention is the number of previous versions kept per secret
+	HistoryRetention *int `yaml:"history_retention,omitempty"`
+
+	// TrashRetentionDays is how long deleted secrets stay recoverable;
+	// zero deletes secrets outright
+	TrashRetentionDays *int `yaml:"trash_retention_days,omitempty"`
+
+	// Cleanup sets how kpr cleanup treats expired secrets
+	Cleanup CleanupConfig `yaml:"cleanup,omitempty"`
+
+	// Snapshots sets where kpr snapshot keeps snapshots and how many
+	Snapshots SnapshotConfig `yaml:"snapshots,omitempty"`
+
+	// PluginDir holds provider plugins, keeper-provider-<type> executables,
+	// in addition to those on PATH. It is <config>/plugins by default.
+	PluginDir string `yaml:"plugin_dir,omitempty"`
+
+	// Mounts maps secret name prefixes, such as "prod", to the providers
+	// keeping them; "/" mounts a provider at the root. Commands route
+	// secrets through the mounts unless --provider is given.
+	Mounts map[string]string `yaml:"mounts,omitempty"`
+}
+
+// CleanupConfig holds the expiry policy applied by kpr cleanup
+type CleanupConfig struct {
+	// Action is delete (the default), archive or disable
+	Action string `yaml:"action,omitempty"`
+
+	// Grace is how long expired secrets are left alone, such as 7d
+	Grace string `yaml:"grace,omitempty"`
+
+	// ArchiveFolder receives archived secrets, "archive" by default
+	ArchiveFolder string `yaml:"archive_folder,omitempty"`
+}
+
+// SnapshotConfig holds the location and retention policy of snapshots.
+// The newest snapshot of each of the last Hourly hours, Daily days and
+// Monthly months is kept.
+type SnapshotConfig struct {
+	// Dir holds the snapshots, <config>/snapshots by default
+	Dir string `yaml:"dir,omitempty"`
+
+	Hourly  *int `yaml:"hourly,omitempty"`
+	Daily   *int `yaml:"daily,omitempty"`
+	Monthly *int `yaml:"monthly,omitempty"`
 }
 
 // ProviderConfig holds configuration for a specific provider
@@ -26,6 +74,32 @@ type ProviderConfig struct {
 type EncryptionConfig struct {
 	Algorithm string `yaml:"algorithm"`
 	KeyFile   string `yaml:"key_file"`
+
+	// Backend selects where master keys are kept: keyring (default), file,
+	// env or vault-transit
+	Backend string `yaml:"backend"`
+
+	// EncryptMetadata also encrypts secret metadata and tags
+	EncryptMetadata bool `yaml:"encrypt_metadata"`
+
+	// PassphraseEnv names the variable holding the key file passphrase
+	PassphraseEnv string `yaml:"passphrase_env"`
+
+	// PassphraseFD reads the key file passphrase from a file descriptor
+	PassphraseFD int `yaml:"passphrase_fd"`
+
+	// KeyEnvPrefix prefixes the variables read by the env backend
+	KeyEnvPrefix string `yaml:"key_env_prefix"`
+
+	Vault VaultTransitConfig `yaml:"vault"`
+}
+
+// VaultTransitConfig holds the settings of the vault-transit keychain backend
+type VaultTransitConfig struct {
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+	Mount   string `yaml:"mount"`
+	KeyName string `yaml:"key_name"`
 }
 
 // DefaultConfig returns a default configuration
@@ -35,23 +109,29 @@ func DefaultConfig() (*Config, error) {
 		return nil, fmt.Errorf("could not determine home directory: %w", err)
 	}
 
-	defaultDataDir := filepath.Join(home, ".keeper")
+	return defaultConfig(filepath.Join(home, ".keeper")), nil
+}
+
+// defaultConfig returns the default configuration of a data directory,
+// holding a local store
+func defaultConfig(dataDir string) *Config {
 	return &Config{
 		DefaultProvider: "local",
-		DefaultDataDir:  defaultDataDir,
+		DefaultDataDir:  dataDir,
 		Providers: map[string]ProviderConfig{
 			"local": {
 				Type: "local",
 				Parameters: map[string]interface{}{
-					"path": filepath.Join(defaultDataDir, "secrets"),
+					"path": dataDir,
 				},
 			},
 		},
 		Encryption: EncryptionConfig{
 			Algorithm: "aes-256-gcm",
-			KeyFile:   filepath.Join(defaultDataDir, "master.key"),
+			KeyFile:   filepath.Join(dataDir, "master.key"),
+			Backend:   "keyring",
 		},
-	}, nil
+	}
 }
 
 // Load reads configuration from the specified file
@@ -67,7 +147,7 @@ func Load(configPath string) (*Config, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return DefaultConfig()
+			return defaultConfig(filepath.Dir(configPath)), nil
 		}
 		return nil, fmt.Errorf("could not read config file: %w", err)
 	}
@@ -77,14 +157,103 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("could not parse config file: %w", err)
 	}
 
-	// Set default data directory if not specified
+	// The data directory defaults to the directory of the config file
 	if config.DefaultDataDir == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("could not determine home directory: %w", err)
-		}
-		config.DefaultDataDir = filepath.Join(home, ".keeper")
+		config.DefaultDataDir = filepath.Dir(configPath)
+	}
+	for _, path := range []*string{&config.DefaultDataDir, &config.Encryption.KeyFile, &config.PluginDir, &config.Snapshots.Dir} {
+		*path = ExpandHome(*path)
 	}
 
 	return &config, nil
 }
+
+// Provider returns the configuration of a named provider, or of the default
+// provider if name is empty. Unless configured otherwise, "local" is a
+// local store in the data directory. Local stores take the encryption, history
+// and trash settings of the configuration unless their parameters set them.
+func (c *Config) Provider(name string) (ProviderConfig, error) {
+	if name == "" {
+		name = c.DefaultProvider
+	}
+	if name == "" {
+		name = "local"
+	}
+
+	provider, ok := c.Providers[name]
+	if !ok && name == "local" {
+		provider, ok = ProviderConfig{Type: "local"}, true
+	}
+	if !ok {
+		names := make([]string, 0, len(c.Providers))
+		for configured := range c.Providers {
+			names = append(names, configured)
+		}
+		sort.Strings(names)
+		return ProviderConfig{}, fmt.Errorf("unknown provider %q, configured providers: %s", name, strings.Join(names, ", "))
+	}
+
+	parameters := make(map[string]interface{}, len(provider.Parameters))
+	for key, value := range provider.Parameters {
+		parameters[key] = value
+	}
+	if provider.Type == "local" {
+		for _, key := range []string{"path", "key_file"} {
+			if path, ok := parameters[key].(string); ok {
+				parameters[key] = ExpandHome(path)
+			}
+		}
+		for key, value := range c.localDefaults() {
+			if _, ok := parameters[key]; !ok {
+				parameters[key] = value
+			}
+		}
+	}
+
+	return ProviderConfig{Type: provider.Type, Parameters: parameters}, nil
+}
+
+// localDefaults returns the parameters local stores take from the rest of
+// the configuration
+func (c *Config) localDefaults() map[string]interface{} {
+	defaults := map[string]interface{}{
+		"path":             c.DefaultDataDir,
+		"encrypt_metadata": c.Encryption.EncryptMetadata,
+	}
+	for key, value := range map[string]string{
+		"keychain":        c.Encryption.Backend,
+		"key_file":        c.Encryption.KeyFile,
+		"passphrase_env":  c.Encryption.PassphraseEnv,
+		"key_env_prefix":  c.Encryption.KeyEnvPrefix,
+		"transit_address": c.Encryption.Vault.Address,
+		"transit_token":   c.Encryption.Vault.Token,
+		"transit_mount":   c.Encryption.Vault.Mount,
+		"transit_key":     c.Encryption.Vault.KeyName,
+	} {
+		if value != "" {
+			defaults[key] = value
+		}
+	}
+	if c.Encryption.PassphraseFD > 0 {
+		defaults["passphrase_fd"] = c.Encryption.PassphraseFD
+	}
+	if c.HistoryRetention != nil {
+		defaults["history_retention"] = *c.HistoryRetention
+	}
+	if c.TrashRetentionDays != nil {
+		defaults["trash_retention_days"] = *c.TrashRetentionDays
+	}
+	return defaults
+}
+
+// ExpandHome replaces a leading ~ in a path with the home directory
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
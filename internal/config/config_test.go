@@ -0,0 +1,75 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Provider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+default_provider: prod
+history_retention: 3
+encryption:
+  backend: file
+  key_file: /keys/master.key
+providers:
+  prod:
+    type: vault
+    parameters:
+      address: https://vault.example.com
+  archive:
+    type: local
+    parameters:
+      path: /archive
+      history_retention: 0
+mounts:
+  /: archive
+  prod: prod
+`), 0600))
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, dir, cfg.DefaultDataDir)
+	assert.Equal(t, map[string]string{"/": "archive", "prod": "prod"}, cfg.Mounts)
+
+	// The default provider is used when none is named
+	provider, err := cfg.Provider("")
+	require.NoError(t, err)
+	assert.Equal(t, "vault", provider.Type)
+	assert.Equal(t, map[string]interface{}{"address": "https://vault.example.com"}, provider.Parameters)
+
+	// Local stores take the settings of the configuration they do not override
+	provider, err = cfg.Provider("archive")
+	require.NoError(t, err)
+	assert.Equal(t, "/archive", provider.Parameters["path"])
+	assert.Equal(t, 0, provider.Parameters["history_retention"])
+	assert.Equal(t, "file", provider.Parameters["keychain"])
+	assert.Equal(t, "/keys/master.key", provider.Parameters["key_file"])
+
+	// "local" is the store in the data directory unless configured
+	provider, err = cfg.Provider("local")
+	require.NoError(t, err)
+	assert.Equal(t, "local", provider.Type)
+	assert.Equal(t, dir, provider.Parameters["path"])
+	assert.Equal(t, 3, provider.Parameters["history_retention"])
+
+	_, err = cfg.Provider("staging")
+	assert.EqualError(t, err, `unknown provider "staging", configured providers: archive, prod`)
+}
+
+func TestLoad_Missing(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := Load(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+
+	provider, err := cfg.Provider("")
+	require.NoError(t, err)
+	assert.Equal(t, "local", provider.Type)
+	assert.Equal(t, dir, provider.Parameters["path"])
+}
@@ -3,8 +3,6 @@ package commands
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -26,20 +24,8 @@ func NewGetCommand(cfg *config.Config) *GetCommand {
 
 // Execute executes the get command
 func (c *GetCommand) Execute(ctx context.Context, key string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -72,20 +58,8 @@ func NewSetCommand(cfg *config.Config) *SetCommand {
 
 // Execute executes the set command
 func (c *SetCommand) Execute(ctx context.Context, key, value string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -113,20 +87,8 @@ func NewListCommand(cfg *config.Config) *ListCommand {
 
 // Execute executes the list command
 func (c *ListCommand) Execute(ctx context.Context, prefix string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -157,20 +119,8 @@ func NewDeleteCommand(cfg *config.Config) *DeleteCommand {
 
 // Execute executes the delete command
 func (c *DeleteCommand) Execute(ctx context.Context, key string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -198,20 +148,8 @@ func NewRotateCommand(cfg *config.Config) *RotateCommand {
 
 // Execute executes the rotate command
 func (c *RotateCommand) Execute(ctx context.Context, key string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -239,20 +177,8 @@ func NewSetPolicyCommand(cfg *config.Config) *SetPolicyCommand {
 
 // Execute executes the set-policy command
 func (c *SetPolicyCommand) Execute(ctx context.Context, key string, interval time.Duration, length int, characterSet string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -288,20 +214,8 @@ func NewMetadataCommand(cfg *config.Config) *MetadataCommand {
 
 // Execute executes the metadata command
 func (c *MetadataCommand) Execute(ctx context.Context, key string) error {
-	// Create service config
-	svcConfig := service.Config{
-		Type: c.cfg.DefaultProvider,
-		Parameters: map[string]interface{}{
-			"secretsDir": filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"path":      filepath.Join(c.cfg.DefaultDataDir, "secrets"),
-			"token":     os.Getenv("VAULT_TOKEN"),
-			"address":   os.Getenv("VAULT_ADDR"),
-			"region":    os.Getenv("AWS_REGION"),
-		},
-	}
-
-	// Create service
-	svc, err := service.New(svcConfig)
+	// Create service for the default provider
+	svc, err := service.Open(c.cfg, "")
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
@@ -333,10 +247,6 @@ func (c *MetadataCommand) Execute(ctx context.Context, key string) error {
 		fmt.Printf("  Next Rotation: %s\n", metadata.RotationPolicy.NextRotation.Format(time.RFC3339))
 	}
 
-	if metadata.PreviousVersion != "" {
-		fmt.Println("\nPrevious Version Available: Yes")
-	}
-
 	return nil
 }
 
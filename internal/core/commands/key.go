@@ -1,37 +1,44 @@
 package commands
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/spf13/cobra"
-	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/config"
+	"github.com/keeper/internal/providers"
+	_ "github.com/keeper/internal/providers/all"
 )
 
 // AddKeyCommands adds key management commands to the root command
 func AddKeyCommands(root *cobra.Command) {
+	var providerName string
+
 	keyCmd := &cobra.Command{
 		Use:   "key",
 		Short: "Manage the master encryption key",
 		Long: `Manage the master encryption key used to encrypt secrets.
-This key is stored in your system's secure keychain.`,
+This key is stored in the keychain configured under encryption in config.yaml.`,
 	}
+	keyCmd.PersistentFlags().StringVar(&providerName, "provider", "", "provider to use, as named in config.yaml (default: default_provider)")
 
 	rotateCmd := &cobra.Command{
 		Use:   "rotate",
 		Short: "Rotate the master encryption key",
-		Long: `Generate a new master encryption key and re-encrypt all secrets.
+		Long: `Generate a new master encryption key and re-wrap the data keys of all secrets.
 This operation may take some time depending on the number of secrets.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			homeDir, err := os.UserHomeDir()
+			p, err := openProvider(cmd.Context(), providerName)
 			if err != nil {
-				return fmt.Errorf("failed to get home directory: %w", err)
+				return err
 			}
-			appDir := filepath.Join(homeDir, ".keeper")
+			defer p.Close()
 
-			km := keychain.New(appDir)
-			if err := km.RotateKey(); err != nil {
+			k, ok := p.(providers.KeyRotator)
+			if !ok {
+				return fmt.Errorf("provider does not support master key rotation")
+			}
+			if err := k.RotateMasterKey(cmd.Context(), nil); err != nil {
 				return fmt.Errorf("failed to rotate key: %w", err)
 			}
 
@@ -43,17 +50,20 @@ This operation may take some time depending on the number of secrets.`,
 	deleteCmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete the master encryption key",
-		Long: `Delete the master encryption key from the system keychain.
+		Long: `Delete the master encryption key from the configured keychain.
 WARNING: This will make all encrypted secrets unreadable!`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			homeDir, err := os.UserHomeDir()
+			p, err := openProvider(cmd.Context(), providerName)
 			if err != nil {
-				return fmt.Errorf("failed to get home directory: %w", err)
+				return err
 			}
-			appDir := filepath.Join(homeDir, ".keeper")
+			defer p.Close()
 
-			km := keychain.New(appDir)
-			if err := km.DeleteKey(); err != nil {
+			k, ok := p.(providers.KeyDeleter)
+			if !ok {
+				return fmt.Errorf("provider does not support master key deletion")
+			}
+			if err := k.DeleteMasterKey(); err != nil {
 				return fmt.Errorf("failed to delete key: %w", err)
 			}
 
@@ -66,3 +76,26 @@ WARNING: This will make all encrypted secrets unreadable!`,
 	keyCmd.AddCommand(deleteCmd)
 	root.AddCommand(keyCmd)
 }
+
+// openProvider opens and initializes a provider named in
+// ~/.keeper/config.yaml, or the default provider if name is empty
+func openProvider(ctx context.Context, name string) (providers.Provider, error) {
+	cfg, err := config.Load("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	pc, err := cfg.Provider(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := providers.Open(ctx, pc.Type, pc.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize provider: %w", err)
+	}
+	if err := p.Initialize(ctx); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("failed to initialize provider: %w", err)
+	}
+	return p, nil
+}
@@ -3,21 +3,28 @@ package service
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	vaultapi "github.com/hashicorp/vault/api"
-	awsprovider "github.com/keeper/internal/providers/aws"
-	"github.com/keeper/internal/providers/local"
-	"github.com/keeper/internal/providers/vault"
+	"github.com/keeper/internal/config"
 	"github.com/keeper/internal/providers"
+	_ "github.com/keeper/internal/providers/all"
 )
 
-// Config represents the configuration for a secret provider
+// Config represents the configuration for a secret provider. Type names a
+// registered provider type, and Parameters are checked against its schema.
 type Config struct {
 	Type       string
 	Parameters map[string]interface{}
 }
 
+// SecretMetadata describes a secret without its value
+type SecretMetadata struct {
+	Created        time.Time
+	LastModified   time.Time
+	UserMetadata   map[string]string
+	RotationPolicy *providers.RotationPolicy
+}
+
 // Service represents the secret management service
 type Service struct {
 	provider providers.Provider
@@ -25,66 +32,12 @@ type Service struct {
 
 // New creates a new Service with the given configuration
 func New(cfg Config) (*Service, error) {
-	var provider providers.Provider
-	var err error
-
-	switch cfg.Type {
-	case "local":
-		secretsDir, ok := cfg.Parameters["secretsDir"].(string)
-		if !ok {
-			return nil, fmt.Errorf("secretsDir parameter is required for local provider")
-		}
-		provider, err = local.New(secretsDir)
-
-	case "vault":
-		address, ok := cfg.Parameters["address"].(string)
-		if !ok {
-			return nil, fmt.Errorf("address parameter is required for vault provider")
-		}
-		token, ok := cfg.Parameters["token"].(string)
-		if !ok {
-			return nil, fmt.Errorf("token parameter is required for vault provider")
-		}
-		path, ok := cfg.Parameters["path"].(string)
-		if !ok {
-			return nil, fmt.Errorf("path parameter is required for vault provider")
-		}
-
-		vaultConfig := vaultapi.DefaultConfig()
-		vaultConfig.Address = address
-		if err := vaultConfig.ConfigureTLS(nil); err != nil {
-			return nil, fmt.Errorf("failed to configure TLS: %w", err)
-		}
-		vaultConfig.ConfigureTLS(&vaultapi.TLSConfig{Insecure: true})
-
-		client, err := vaultapi.NewClient(vaultConfig)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create vault client: %w", err)
-		}
-		client.SetToken(token)
-
-		provider, err = vault.New(client, path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create vault provider: %w", err)
-		}
-
-	case "aws":
-		region, ok := cfg.Parameters["region"].(string)
-		if !ok {
-			return nil, fmt.Errorf("region parameter is required for aws provider")
-		}
-
-		awsConfig := aws.Config{
-			Region: region,
-		}
-		provider, err = awsprovider.New(awsConfig)
-
-	default:
-		return nil, fmt.Errorf("unsupported provider type: %s", cfg.Type)
-	}
-
+	provider, err := providers.Open(context.Background(), cfg.Type, cfg.Parameters)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create provider: %w", err)
+		return nil, err
+	}
+	if err := provider.Initialize(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to initialize provider: %w", err)
 	}
 
 	return &Service{
@@ -92,19 +45,34 @@ func New(cfg Config) (*Service, error) {
 	}, nil
 }
 
+// Open creates a Service for a provider named in the configuration, or for
+// the default provider if name is empty
+func Open(cfg *config.Config, name string) (*Service, error) {
+	provider, err := cfg.Provider(name)
+	if err != nil {
+		return nil, err
+	}
+	return New(Config{Type: provider.Type, Parameters: provider.Parameters})
+}
+
 // Close closes the service and its provider
 func (s *Service) Close() error {
 	return s.provider.Close()
 }
 
-// GetSecret retrieves a secret
+// GetSecret retrieves a secret, refusing expired and disabled secrets
+// unless the GetOptions of ctx allow them
 func (s *Service) GetSecret(ctx context.Context, key string) (*providers.Secret, error) {
-	return s.provider.GetSecret(ctx, key)
+	return providers.GetSecret(ctx, s.provider, key, providers.GetOptionsOf(ctx))
 }
 
 // SetSecret stores a secret
 func (s *Service) SetSecret(ctx context.Context, key, value string, metadata map[string]string) error {
-	return s.provider.SetSecret(ctx, key, value, metadata)
+	secret := providers.NewSecret(key, value)
+	if metadata != nil {
+		secret.Metadata = metadata
+	}
+	return s.provider.SetSecret(ctx, secret)
 }
 
 // DeleteSecret removes a secret
@@ -114,25 +82,79 @@ func (s *Service) DeleteSecret(ctx context.Context, key string) error {
 
 // ListSecrets lists all secrets with the given prefix
 func (s *Service) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
-	return s.provider.ListSecrets(ctx, prefix)
+	secrets, err := providers.CollectPages(ctx, providers.ListSecretPages(ctx, s.provider, providers.ListOptions{Prefix: prefix}))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, secret.Name)
+	}
+	return keys, nil
+}
+
+// Capabilities reports the optional features of the provider
+func (s *Service) Capabilities() providers.Capabilities {
+	return providers.CapabilitiesOf(s.provider)
 }
 
 // GetRotationPolicy retrieves the rotation policy for a secret
 func (s *Service) GetRotationPolicy(ctx context.Context, key string) (*providers.RotationPolicy, error) {
-	return s.provider.GetRotationPolicy(ctx, key)
+	rotator, err := s.rotator()
+	if err != nil {
+		return nil, err
+	}
+	return rotator.GetRotationPolicy(ctx, key)
 }
 
 // SetRotationPolicy sets the rotation policy for a secret
 func (s *Service) SetRotationPolicy(ctx context.Context, key string, policy *providers.RotationPolicy) error {
-	return s.provider.SetRotationPolicy(ctx, key, policy)
+	rotator, err := s.rotator()
+	if err != nil {
+		return err
+	}
+	return rotator.SetRotationPolicy(ctx, key, policy)
 }
 
 // RotateSecret rotates a secret according to its rotation policy
 func (s *Service) RotateSecret(ctx context.Context, key string) error {
-	return s.provider.RotateSecret(ctx, key)
+	rotator, err := s.rotator()
+	if err != nil {
+		return err
+	}
+	return rotator.RotateSecret(ctx, key)
+}
+
+// GetSecretMetadata gets metadata for a secret, and its rotation policy
+// if the provider rotates secrets
+func (s *Service) GetSecretMetadata(ctx context.Context, key string) (*SecretMetadata, error) {
+	// Metadata stays readable past expiry, as it holds the expiry
+	ctx = providers.WithGetOptions(ctx, providers.GetOptions{AllowExpired: true, AllowDisabled: true})
+	secret, err := s.provider.GetSecret(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &SecretMetadata{
+		Created:      secret.CreatedAt,
+		LastModified: secret.UpdatedAt,
+		UserMetadata: secret.Metadata,
+	}
+	if rotator, err := s.rotator(); err == nil {
+		if metadata.RotationPolicy, err = rotator.GetRotationPolicy(ctx, key); err != nil {
+			return nil, fmt.Errorf("failed to get rotation policy: %w", err)
+		}
+	}
+
+	return metadata, nil
 }
 
-// GetSecretMetadata gets metadata for a secret
-func (s *Service) GetSecretMetadata(ctx context.Context, key string) (*providers.SecretMetadata, error) {
-	return s.provider.GetSecretMetadata(ctx, key)
+// rotator returns the provider if it rotates secrets
+func (s *Service) rotator() (providers.Rotator, error) {
+	rotator, ok := s.provider.(providers.Rotator)
+	if !ok || !providers.CapabilitiesOf(s.provider).Rotation {
+		return nil, fmt.Errorf("secret rotation: %w", providers.ErrNotSupported)
+	}
+	return rotator, nil
 }
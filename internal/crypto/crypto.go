@@ -7,22 +7,20 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-
-	"github.com/keeper/internal/keychain"
 )
 
+// KeySize is the size of an AES-256 key in bytes
+const KeySize = 32
+
 // Cipher handles encryption and decryption of data
 type Cipher struct {
 	gcm cipher.AEAD
 }
 
-// NewCipher creates a new cipher using the master key from keychain
-func NewCipher(appDir string) (*Cipher, error) {
-	// Get key from keychain
-	km := keychain.New(appDir)
-	key, err := km.GetMasterKey()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get master key: %w", err)
+// NewCipher creates a new cipher using the given master key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size: got %d bytes, want %d", len(key), KeySize)
 	}
 
 	block, err := aes.NewCipher(key)
@@ -38,19 +36,29 @@ func NewCipher(appDir string) (*Cipher, error) {
 	return &Cipher{gcm: gcm}, nil
 }
 
-// Encrypt encrypts data using AES-GCM
-func (c *Cipher) Encrypt(data []byte) (string, error) {
+// GenerateKey returns a new random key suitable for NewCipher
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// Encrypt encrypts data using AES-GCM, authenticating the additional data
+func (c *Cipher) Encrypt(data, additionalData []byte) (string, error) {
 	nonce := make([]byte, c.gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	encrypted := c.gcm.Seal(nonce, nonce, data, nil)
+	encrypted := c.gcm.Seal(nonce, nonce, data, additionalData)
 	return base64.StdEncoding.EncodeToString(encrypted), nil
 }
 
-// Decrypt decrypts data using AES-GCM
-func (c *Cipher) Decrypt(encryptedStr string) ([]byte, error) {
+// Decrypt decrypts data using AES-GCM. The additional data must match the
+// value passed to Encrypt.
+func (c *Cipher) Decrypt(encryptedStr string, additionalData []byte) ([]byte, error) {
 	encrypted, err := base64.StdEncoding.DecodeString(encryptedStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode base64: %w", err)
@@ -62,7 +70,7 @@ func (c *Cipher) Decrypt(encryptedStr string) ([]byte, error) {
 	}
 
 	nonce, ciphertext := encrypted[:nonceSize], encrypted[nonceSize:]
-	plaintext, err := c.gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := c.gcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
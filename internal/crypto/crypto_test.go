@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCipher_EncryptDecrypt(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	c, err := NewCipher(key)
+	require.NoError(t, err)
+
+	encrypted, err := c.Encrypt([]byte("mysecretpassword"), []byte("app/db"))
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "mysecretpassword")
+
+	decrypted, err := c.Decrypt(encrypted, []byte("app/db"))
+	require.NoError(t, err)
+	assert.Equal(t, "mysecretpassword", string(decrypted))
+
+	t.Run("Wrong Additional Data", func(t *testing.T) {
+		_, err := c.Decrypt(encrypted, []byte("app/other"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Wrong Key", func(t *testing.T) {
+		other, err := GenerateKey()
+		require.NoError(t, err)
+
+		wrong, err := NewCipher(other)
+		require.NoError(t, err)
+
+		_, err = wrong.Decrypt(encrypted, []byte("app/db"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid Key Size", func(t *testing.T) {
+		_, err := NewCipher([]byte("short"))
+		assert.Error(t, err)
+	})
+}
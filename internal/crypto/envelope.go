@@ -0,0 +1,263 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AlgorithmAES256GCM seals both the data key and the data with AES-256-GCM
+const AlgorithmAES256GCM = "AES-256-GCM"
+
+// envelopeMagic prefixes every serialized envelope
+var envelopeMagic = []byte("KPE\x01")
+
+var (
+	// ErrInvalidEnvelope is returned when an envelope cannot be parsed
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+
+	// ErrTampered is returned when the data key could be unwrapped but the
+	// data does not authenticate, which means the ciphertext was modified
+	// or moved to a different context than the one it was sealed for
+	ErrTampered = errors.New("ciphertext has been tampered with or moved")
+)
+
+// KeyStore resolves master keys by key ID
+type KeyStore interface {
+	// CurrentKeyID returns the ID of the master key used for new envelopes
+	CurrentKeyID() (string, error)
+
+	// MasterKey returns the master key with the given ID
+	MasterKey(id string) ([]byte, error)
+}
+
+// Envelope is data sealed with a random data key, which is itself wrapped
+// by a master key identified by KeyID
+type Envelope struct {
+	Algorithm  string
+	KeyID      string
+	WrappedKey []byte
+	Ciphertext []byte
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped by the current
+// master key. The additional data is authenticated but not stored; the same
+// value must be passed to Open.
+func Seal(keys KeyStore, plaintext, additionalData []byte) (*Envelope, error) {
+	keyID, err := keys.CurrentKeyID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current key ID: %w", err)
+	}
+
+	dataKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Envelope{
+		Algorithm:  AlgorithmAES256GCM,
+		Ciphertext: ciphertext,
+	}
+	if err := env.wrap(keys, keyID, dataKey); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// Open decrypts the envelope using the master key named in its header.
+// It returns ErrTampered when the additional data does not match the value
+// the envelope was sealed with.
+func Open(keys KeyStore, env *Envelope, additionalData []byte) ([]byte, error) {
+	dataKey, err := env.unwrap(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := open(dataKey, env.Ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrTampered
+	}
+
+	return plaintext, nil
+}
+
+// Rewrap re-wraps the data key under the master key with the given ID.
+// The ciphertext itself is left untouched.
+func Rewrap(keys KeyStore, env *Envelope, keyID string) error {
+	if env.KeyID == keyID {
+		return nil
+	}
+
+	dataKey, err := env.unwrap(keys)
+	if err != nil {
+		return err
+	}
+
+	return env.wrap(keys, keyID, dataKey)
+}
+
+// wrap seals the data key with the master key identified by keyID
+func (e *Envelope) wrap(keys KeyStore, keyID string, dataKey []byte) error {
+	masterKey, err := keys.MasterKey(keyID)
+	if err != nil {
+		return fmt.Errorf("failed to get master key %s: %w", keyID, err)
+	}
+
+	wrapped, err := seal(masterKey, dataKey, []byte(keyID))
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	e.KeyID = keyID
+	e.WrappedKey = wrapped
+	return nil
+}
+
+// unwrap recovers the data key with the master key named in the header
+func (e *Envelope) unwrap(keys KeyStore) ([]byte, error) {
+	if e.Algorithm != AlgorithmAES256GCM {
+		return nil, fmt.Errorf("unsupported algorithm %q", e.Algorithm)
+	}
+
+	masterKey, err := keys.MasterKey(e.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master key %s: %w", e.KeyID, err)
+	}
+
+	dataKey, err := open(masterKey, e.WrappedKey, []byte(e.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+// Marshal serializes the envelope as a self-describing binary blob
+func (e *Envelope) Marshal() []byte {
+	var buf bytes.Buffer
+	buf.Write(envelopeMagic)
+	writeField(&buf, []byte(e.Algorithm))
+	writeField(&buf, []byte(e.KeyID))
+	writeField(&buf, e.WrappedKey)
+	buf.Write(e.Ciphertext)
+	return buf.Bytes()
+}
+
+// UnmarshalEnvelope parses a blob produced by Envelope.Marshal
+func UnmarshalEnvelope(data []byte) (*Envelope, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return nil, ErrInvalidEnvelope
+	}
+
+	r := bytes.NewReader(data[len(envelopeMagic):])
+	alg, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, r.Len())
+	r.Read(ciphertext)
+
+	return &Envelope{
+		Algorithm:  string(alg),
+		KeyID:      string(keyID),
+		WrappedKey: wrapped,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// IsEnvelope reports whether data looks like a serialized envelope
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+// writeField writes a length-prefixed field
+func writeField(buf *bytes.Buffer, field []byte) {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(field)))
+	buf.Write(size[:n])
+	buf.Write(field)
+}
+
+// readField reads a length-prefixed field
+func readField(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return nil, ErrInvalidEnvelope
+	}
+
+	field := make([]byte, size)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+	return field, nil
+}
+
+// seal encrypts data with AES-256-GCM and returns nonce||ciphertext
+func seal(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// open decrypts a nonce||ciphertext blob produced by seal
+func open(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// newGCM creates an AES-GCM AEAD for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size: got %d bytes, want %d", len(key), KeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return gcm, nil
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKeys is an in-memory KeyStore for tests
+type memoryKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (m *memoryKeys) CurrentKeyID() (string, error) {
+	return m.current, nil
+}
+
+func (m *memoryKeys) MasterKey(id string) ([]byte, error) {
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", id)
+	}
+	return key, nil
+}
+
+func (m *memoryKeys) add(t *testing.T, id string) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	m.keys[id] = key
+}
+
+func TestEnvelope_SealOpenRewrap(t *testing.T) {
+	keys := &memoryKeys{current: "k1", keys: map[string][]byte{}}
+	keys.add(t, "k1")
+
+	env, err := Seal(keys, []byte("mysecretpassword"), []byte("prod/db"))
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmAES256GCM, env.Algorithm)
+	assert.Equal(t, "k1", env.KeyID)
+
+	t.Run("Marshal Round Trip", func(t *testing.T) {
+		parsed, err := UnmarshalEnvelope(env.Marshal())
+		require.NoError(t, err)
+		assert.Equal(t, env, parsed)
+
+		_, err = UnmarshalEnvelope([]byte("not an envelope"))
+		assert.ErrorIs(t, err, ErrInvalidEnvelope)
+	})
+
+	t.Run("Rewrap", func(t *testing.T) {
+		keys.add(t, "k2")
+		ciphertext := env.Ciphertext
+
+		require.NoError(t, Rewrap(keys, env, "k2"))
+		assert.Equal(t, "k2", env.KeyID)
+		assert.Equal(t, ciphertext, env.Ciphertext)
+
+		// The old generation is no longer needed to open the envelope
+		delete(keys.keys, "k1")
+		plaintext, err := Open(keys, env, []byte("prod/db"))
+		require.NoError(t, err)
+		assert.Equal(t, "mysecretpassword", string(plaintext))
+	})
+
+	t.Run("Bound To Additional Data", func(t *testing.T) {
+		_, err := Open(keys, env, []byte("dev/db"))
+		assert.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("Wrapped Key Bound To Key ID", func(t *testing.T) {
+		keys.keys["k3"] = keys.keys["k2"]
+		moved := *env
+		moved.KeyID = "k3"
+
+		_, err := Open(keys, &moved, []byte("prod/db"))
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrTampered)
+	})
+}
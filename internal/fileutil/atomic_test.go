@@ -0,0 +1,37 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret.json")
+
+	require.NoError(t, WriteFile(path, []byte("first"), 0600))
+	require.NoError(t, WriteFile(path, []byte("second"), 0600))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// No temp files are left behind
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	t.Run("Missing Directory", func(t *testing.T) {
+		err := WriteFile(filepath.Join(dir, "missing", "secret.json"), []byte("data"), 0600)
+		assert.Error(t, err)
+	})
+}
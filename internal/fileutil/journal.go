@@ -0,0 +1,127 @@
+package fileutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Op is a single file change recorded in a journal. Paths are relative to
+// the journal root.
+type Op struct {
+	Path   string `json:"path"`
+	Data   []byte `json:"data,omitempty"`
+	Remove bool   `json:"remove,omitempty"`
+}
+
+// journalRecord is the on-disk format of a journal
+type journalRecord struct {
+	Ops []Op `json:"ops"`
+}
+
+// Journal applies multi-file changes under a root directory through a
+// write-ahead log. The full set of changes is made durable before any file
+// is touched, so an interrupted commit can be replayed by Recover and the
+// tree never stays half-updated.
+type Journal struct {
+	root string
+	path string
+}
+
+// NewJournal creates a journal for the files under root, logging to the
+// named file in root
+func NewJournal(root, name string) *Journal {
+	root = filepath.Clean(root)
+	return &Journal{
+		root: root,
+		path: filepath.Join(root, name),
+	}
+}
+
+// Commit logs the operations, applies them and removes the log
+func (j *Journal) Commit(ops []Op) error {
+	for _, op := range ops {
+		if _, err := j.resolve(op.Path); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(journalRecord{Ops: ops})
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal: %w", err)
+	}
+	if err := WriteFile(j.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return j.apply(ops)
+}
+
+// Recover replays a journal left by an interrupted commit. It reports
+// whether there was anything to replay.
+func (j *Journal) Recover() (bool, error) {
+	data, err := ioutil.ReadFile(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	var record journalRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		// The journal is written atomically, so a corrupt one was never
+		// committed and none of its operations were applied
+		if err := Remove(j.path); err != nil {
+			return false, fmt.Errorf("failed to remove journal: %w", err)
+		}
+		return false, nil
+	}
+
+	if err := j.apply(record.Ops); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// apply performs the operations, which are idempotent, and removes the log
+func (j *Journal) apply(ops []Op) error {
+	for _, op := range ops {
+		path, err := j.resolve(op.Path)
+		if err != nil {
+			return err
+		}
+
+		if op.Remove {
+			if err := Remove(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", op.Path, err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", op.Path, err)
+		}
+		if err := WriteFile(path, op.Data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", op.Path, err)
+		}
+	}
+
+	if err := Remove(j.path); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// resolve returns the absolute path of an operation, rejecting paths that
+// would escape the journal root
+func (j *Journal) resolve(rel string) (string, error) {
+	path := filepath.Join(j.root, rel)
+	if filepath.IsAbs(rel) || !strings.HasPrefix(path, j.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid journal path %q", rel)
+	}
+	return path, nil
+}
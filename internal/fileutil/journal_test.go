@@ -0,0 +1,69 @@
+package fileutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	j := NewJournal(dir, "journal.json")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "old.json"), []byte("old"), 0600))
+
+	t.Run("Commit", func(t *testing.T) {
+		require.NoError(t, j.Commit([]Op{
+			{Path: "secrets/a.json", Data: []byte("a")},
+			{Path: "old.json", Remove: true},
+		}))
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "secrets", "a.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(data))
+
+		assert.NoFileExists(t, filepath.Join(dir, "old.json"))
+		assert.NoFileExists(t, filepath.Join(dir, "journal.json"))
+	})
+
+	t.Run("Recover Interrupted Commit", func(t *testing.T) {
+		// Simulate a crash after the journal was written
+		data, err := json.Marshal(journalRecord{Ops: []Op{
+			{Path: "secrets/b.json", Data: []byte("b")},
+		}})
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "journal.json"), data, 0600))
+
+		replayed, err := j.Recover()
+		require.NoError(t, err)
+		assert.True(t, replayed)
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, "secrets", "b.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "b", string(b))
+
+		replayed, err = j.Recover()
+		require.NoError(t, err)
+		assert.False(t, replayed)
+	})
+
+	t.Run("Corrupt Journal Is Discarded", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "journal.json"), []byte(`{"ops":[`), 0600))
+
+		replayed, err := j.Recover()
+		require.NoError(t, err)
+		assert.False(t, replayed)
+		_, err = os.Stat(filepath.Join(dir, "journal.json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Paths Outside Root Are Rejected", func(t *testing.T) {
+		assert.Error(t, j.Commit([]Op{{Path: "../escape.json", Data: []byte("x")}}))
+		assert.Error(t, j.Commit([]Op{{Path: "/etc/passwd", Data: []byte("x")}}))
+	})
+}
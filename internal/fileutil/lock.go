@@ -0,0 +1,109 @@
+package fileutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockRetryInterval is how often a contended lock is retried
+const lockRetryInterval = 50 * time.Millisecond
+
+// ErrLocked is returned when a lock could not be acquired in time
+var ErrLocked = errors.New("locked")
+
+// LockedError reports the process holding a contended lock, when known
+type LockedError struct {
+	PID int
+}
+
+// Error implements the error interface
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return "locked by another process"
+	}
+	return fmt.Sprintf("locked by pid %d", e.PID)
+}
+
+// Is makes LockedError match ErrLocked
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// FileLock is an advisory lock on a file, shared between processes. Any
+// number of shared locks can be held at once, while an exclusive lock
+// excludes all others. The holder of an exclusive lock records its pid in
+// the file so that waiting processes can report it.
+type FileLock struct {
+	f         *os.File
+	exclusive bool
+}
+
+// Lock acquires a shared or exclusive lock on the file at path, creating
+// it if needed. It waits up to timeout for a contended lock and then
+// returns a LockedError.
+func Lock(path string, exclusive bool, timeout time.Duration) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, &LockedError{PID: lockHolder(path)}
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	if exclusive {
+		pid := []byte(strconv.Itoa(os.Getpid()))
+		if err := f.Truncate(0); err == nil {
+			f.WriteAt(pid, 0)
+		}
+	}
+
+	return &FileLock{f: f, exclusive: exclusive}, nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	if l.exclusive {
+		l.f.Truncate(0)
+	}
+
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unlock: %w", err)
+	}
+	return nil
+}
+
+// lockHolder returns the pid recorded in a lock file, or 0 if unknown
+func lockHolder(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
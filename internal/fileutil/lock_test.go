@@ -0,0 +1,60 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.lock")
+
+	t.Run("Shared Locks Coexist", func(t *testing.T) {
+		a, err := Lock(path, false, 0)
+		require.NoError(t, err)
+		b, err := Lock(path, false, 0)
+		require.NoError(t, err)
+
+		_, err = Lock(path, true, 100*time.Millisecond)
+		assert.ErrorIs(t, err, ErrLocked)
+
+		require.NoError(t, a.Unlock())
+		require.NoError(t, b.Unlock())
+	})
+
+	t.Run("Exclusive Lock Reports Holder", func(t *testing.T) {
+		l, err := Lock(path, true, 0)
+		require.NoError(t, err)
+
+		_, err = Lock(path, false, 100*time.Millisecond)
+		require.ErrorIs(t, err, ErrLocked)
+
+		var locked *LockedError
+		require.ErrorAs(t, err, &locked)
+		assert.Equal(t, os.Getpid(), locked.PID)
+
+		require.NoError(t, l.Unlock())
+
+		l, err = Lock(path, true, 0)
+		require.NoError(t, err)
+		require.NoError(t, l.Unlock())
+	})
+
+	t.Run("Waits For Release", func(t *testing.T) {
+		l, err := Lock(path, true, 0)
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			l.Unlock()
+		}()
+
+		l, err = Lock(path, true, 5*time.Second)
+		require.NoError(t, err)
+		require.NoError(t, l.Unlock())
+	})
+}
@@ -0,0 +1,75 @@
+package keychain
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/keeper/internal/config"
+)
+
+// Backend names accepted in EncryptionConfig.Backend
+const (
+	BackendKeyring      = "keyring"
+	BackendFile         = "file"
+	BackendEnv          = "env"
+	BackendVaultTransit = "vault-transit"
+)
+
+// defaultPassphraseEnv is read by the file backend when no variable is configured
+const defaultPassphraseEnv = "KEEPER_PASSPHRASE"
+
+// Backend is a Keychain that can also generate, rename and check for keys.
+// All backends selectable from the configuration implement it.
+type Backend interface {
+	Keychain
+
+	// GenerateKey generates a new encryption key and stores it under name
+	GenerateKey(name string) error
+
+	// RenameKey moves a key to a new name
+	RenameKey(oldName, newName string) error
+
+	// KeyExists checks if a key exists
+	KeyExists(name string) (bool, error)
+}
+
+var (
+	_ Backend = (*KeychainImpl)(nil)
+	_ Backend = (*PassphraseKeychain)(nil)
+	_ Backend = (*EnvKeychain)(nil)
+	_ Backend = (*VaultTransitKeychain)(nil)
+)
+
+// Open creates the keychain backend selected by the configuration
+func Open(cfg config.EncryptionConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "", BackendKeyring:
+		return New()
+	case BackendFile:
+		return NewPassphrase(cfg.KeyFile, passphraseSource(cfg))
+	case BackendEnv:
+		return NewEnv(cfg.KeyEnvPrefix), nil
+	case BackendVaultTransit:
+		return NewVaultTransit(cfg.KeyFile, cfg.Vault)
+	default:
+		return nil, fmt.Errorf("unknown keychain backend %q", cfg.Backend)
+	}
+}
+
+// passphraseSource picks where the file backend reads its passphrase from:
+// a file descriptor if configured, then the environment, then the terminal
+func passphraseSource(cfg config.EncryptionConfig) PassphraseSource {
+	if cfg.PassphraseFD > 0 {
+		return PassphraseFromFD(uintptr(cfg.PassphraseFD))
+	}
+
+	name := cfg.PassphraseEnv
+	if name == "" {
+		name = defaultPassphraseEnv
+	}
+	if _, ok := os.LookupEnv(name); ok {
+		return PassphraseFromEnv(name)
+	}
+
+	return PassphrasePrompt("Passphrase for " + cfg.KeyFile + ": ")
+}
@@ -0,0 +1,132 @@
+package keychain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keeper/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("Default Is Keyring", func(t *testing.T) {
+		kc, err := Open(config.EncryptionConfig{})
+		require.NoError(t, err)
+		assert.IsType(t, &KeychainImpl{}, kc)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		kc, err := Open(config.EncryptionConfig{Backend: BackendFile, KeyFile: filepath.Join(dir, "master.key")})
+		require.NoError(t, err)
+		assert.IsType(t, &PassphraseKeychain{}, kc)
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		kc, err := Open(config.EncryptionConfig{Backend: BackendEnv})
+		require.NoError(t, err)
+		assert.IsType(t, &EnvKeychain{}, kc)
+	})
+
+	t.Run("Vault Transit", func(t *testing.T) {
+		kc, err := Open(config.EncryptionConfig{Backend: BackendVaultTransit, KeyFile: filepath.Join(dir, "vault.key")})
+		require.NoError(t, err)
+		assert.IsType(t, &VaultTransitKeychain{}, kc)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := Open(config.EncryptionConfig{Backend: "floppy"})
+		assert.Error(t, err)
+	})
+}
+
+func TestEnvKeychain(t *testing.T) {
+	kc := NewEnv("")
+	assert.Equal(t, "KEEPER_KEY_LOCAL_MASTER_KEY_CURRENT", kc.Variable("local-master-key/current"))
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	t.Setenv("KEEPER_KEY_LOCAL_MASTER_KEY", base64.StdEncoding.EncodeToString(key))
+
+	got, err := kc.Get("local-master-key")
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	_, err = kc.Get("other")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	assert.ErrorIs(t, kc.Set("other", key), ErrReadOnly)
+	assert.ErrorIs(t, kc.GenerateKey("other"), ErrReadOnly)
+
+	t.Run("Master Keys", func(t *testing.T) {
+		// Without a recorded generation the variable is used as the default key
+		keys := NewMasterKeys(kc, "local-master-key")
+		id, err := keys.Init()
+		require.NoError(t, err)
+		assert.Equal(t, DefaultKeyID, id)
+
+		master, err := keys.MasterKey(id)
+		require.NoError(t, err)
+		assert.Equal(t, key, master)
+
+		_, err = keys.CreateKey()
+		assert.ErrorIs(t, err, ErrReadOnly)
+	})
+}
+
+// fakeTransit emulates the encrypt and decrypt endpoints of Vault's transit
+// engine by prefixing the plaintext
+func fakeTransit(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+
+		data := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/keeper":
+			data["ciphertext"] = "vault:v1:" + body["plaintext"]
+		case "/v1/transit/decrypt/keeper":
+			data["plaintext"] = strings.TrimPrefix(body["ciphertext"], "vault:v1:")
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestVaultTransitKeychain(t *testing.T) {
+	server := fakeTransit(t)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "vault.key")
+	kc, err := NewVaultTransit(path, config.VaultTransitConfig{Address: server.URL, Token: "test"})
+	require.NoError(t, err)
+
+	_, err = kc.Get("master")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, kc.GenerateKey("master"))
+	key, err := kc.Get("master")
+	require.NoError(t, err)
+	assert.Len(t, key, keyLength)
+
+	require.NoError(t, kc.RenameKey("master", "renamed"))
+	exists, err := kc.KeyExists("master")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	renamed, err := kc.Get("renamed")
+	require.NoError(t, err)
+	assert.Equal(t, key, renamed)
+
+	require.NoError(t, kc.Delete("renamed"))
+	assert.ErrorIs(t, kc.Delete("renamed"), ErrKeyNotFound)
+}
@@ -0,0 +1,87 @@
+package keychain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultKeyEnvPrefix prefixes the variables read by EnvKeychain
+const defaultKeyEnvPrefix = "KEEPER_KEY_"
+
+// ErrReadOnly is returned when a backend cannot store or remove keys
+var ErrReadOnly = errors.New("keychain is read-only")
+
+// EnvKeychain reads base64-encoded keys from environment variables, which
+// suits CI jobs and containers where keys are injected by the platform.
+// The key "local-master-key" is read from KEEPER_KEY_LOCAL_MASTER_KEY.
+// Keys cannot be created or changed, so master key rotation is not
+// available with this backend.
+type EnvKeychain struct {
+	prefix string
+}
+
+// NewEnv creates an EnvKeychain reading variables with the given prefix
+func NewEnv(prefix string) *EnvKeychain {
+	if prefix == "" {
+		prefix = defaultKeyEnvPrefix
+	}
+	return &EnvKeychain{prefix: prefix}
+}
+
+// Variable returns the environment variable a key is read from
+func (k *EnvKeychain) Variable(name string) string {
+	return k.prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Get implements the Keychain interface
+func (k *EnvKeychain) Get(name string) ([]byte, error) {
+	encoded, ok := os.LookupEnv(k.Variable(name))
+	if !ok || encoded == "" {
+		return nil, ErrKeyNotFound
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key from %s: %w", k.Variable(name), err)
+	}
+
+	return key, nil
+}
+
+// Set implements the Keychain interface
+func (k *EnvKeychain) Set(name string, key []byte) error {
+	return fmt.Errorf("cannot store key %s: %w", name, ErrReadOnly)
+}
+
+// Delete implements the Keychain interface
+func (k *EnvKeychain) Delete(name string) error {
+	return fmt.Errorf("cannot delete key %s: %w", name, ErrReadOnly)
+}
+
+// GenerateKey implements the Backend interface
+func (k *EnvKeychain) GenerateKey(name string) error {
+	return fmt.Errorf("cannot generate key %s: %w", name, ErrReadOnly)
+}
+
+// RenameKey implements the Backend interface
+func (k *EnvKeychain) RenameKey(oldName, newName string) error {
+	return fmt.Errorf("cannot rename key %s: %w", oldName, ErrReadOnly)
+}
+
+// KeyExists checks if the variable for a key is set
+func (k *EnvKeychain) KeyExists(name string) (bool, error) {
+	value, ok := os.LookupEnv(k.Variable(name))
+	return ok && value != "", nil
+}
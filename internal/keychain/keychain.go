@@ -5,9 +5,11 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 
+	"github.com/keeper/internal/crypto"
 	"github.com/zalando/go-keyring"
 )
 
@@ -16,6 +18,9 @@ const (
 	keyLength     = 32 // 256 bits
 )
 
+// ErrKeyNotFound is returned when a key does not exist in the keychain
+var ErrKeyNotFound = errors.New("key not found")
+
 // Keychain is the interface for secure key storage
 type Keychain interface {
 	// Get retrieves a key by name
@@ -61,6 +66,9 @@ func (k *KeychainImpl) GenerateKey(name string) error {
 // GetKey retrieves a key from the keychain
 func (k *KeychainImpl) GetKey(name string) ([]byte, error) {
 	encoded, err := keyring.Get(k.serviceName, name)
+	if err == keyring.ErrNotFound {
+		return nil, ErrKeyNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get key from keychain: %w", err)
 	}
@@ -76,6 +84,9 @@ func (k *KeychainImpl) GetKey(name string) ([]byte, error) {
 // DeleteKey removes a key from the keychain
 func (k *KeychainImpl) DeleteKey(name string) error {
 	err := keyring.Delete(k.serviceName, name)
+	if err == keyring.ErrNotFound {
+		return ErrKeyNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete key from keychain: %w", err)
 	}
@@ -117,34 +128,35 @@ func (k *KeychainImpl) KeyExists(name string) (bool, error) {
 	return true, nil
 }
 
-// Encrypt encrypts data using the specified key
+// Encrypt seals data in an envelope whose data key is wrapped by the specified key
 func (k *KeychainImpl) Encrypt(keyName string, data []byte) ([]byte, error) {
-	key, err := k.GetKey(keyName)
-	if err != nil {
-		return nil, err
-	}
-
-	block, err := aes.NewCipher(key)
+	env, err := crypto.Seal(namedKeys{k: k, name: keyName}, data, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("failed to generate nonce: %w", err)
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
+	return env.Marshal(), nil
 }
 
-// Decrypt decrypts data using the specified key
+// Decrypt decrypts data produced by Encrypt with the specified key.
+// Envelopes sealed under any other key are rejected.
 func (k *KeychainImpl) Decrypt(keyName string, data []byte) ([]byte, error) {
+	if crypto.IsEnvelope(data) {
+		env, err := crypto.UnmarshalEnvelope(data)
+		if err != nil {
+			return nil, err
+		}
+		if env.KeyID != keyName {
+			return nil, fmt.Errorf("data is encrypted with key %s, not %s", env.KeyID, keyName)
+		}
+
+		plaintext, err := crypto.Open(namedKeys{k: k, name: keyName}, env, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt: %w", err)
+		}
+		return plaintext, nil
+	}
+
 	key, err := k.GetKey(keyName)
 	if err != nil {
 		return nil, err
@@ -174,6 +186,23 @@ func (k *KeychainImpl) Decrypt(keyName string, data []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
+// namedKeys adapts KeychainImpl to crypto.KeyStore, using the named key
+// for new envelopes
+type namedKeys struct {
+	k    *KeychainImpl
+	name string
+}
+
+// CurrentKeyID implements crypto.KeyStore
+func (n namedKeys) CurrentKeyID() (string, error) {
+	return n.name, nil
+}
+
+// MasterKey implements crypto.KeyStore
+func (n namedKeys) MasterKey(id string) ([]byte, error) {
+	return n.k.GetKey(id)
+}
+
 // Get implements the Keychain interface
 func (k *KeychainImpl) Get(name string) ([]byte, error) {
 	return k.GetKey(name)
@@ -0,0 +1,28 @@
+package keychain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeychainImpl_Decrypt(t *testing.T) {
+	keyring.MockInit()
+	k, err := New()
+	require.NoError(t, err)
+	require.NoError(t, k.GenerateKey("first"))
+	require.NoError(t, k.GenerateKey("second"))
+
+	data, err := k.Encrypt("first", []byte("secret"))
+	require.NoError(t, err)
+
+	plaintext, err := k.Decrypt("first", data)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	// The envelope names its key, which must be the one asked for
+	_, err = k.Decrypt("second", data)
+	assert.EqualError(t, err, "data is encrypted with key first, not second")
+}
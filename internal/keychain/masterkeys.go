@@ -0,0 +1,166 @@
+package keychain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keeper/internal/crypto"
+)
+
+// DefaultKeyID identifies the generation stored directly under the entry
+// name, as written by older versions or supplied by read-only backends
+const DefaultKeyID = "default"
+
+// MasterKeys manages the generations of a master key stored in a Keychain.
+// Each generation is stored under "<name>/<id>" and the ID used for new
+// data is recorded under "<name>/current", so that several generations can
+// coexist while a rotation is in progress.
+type MasterKeys struct {
+	kc   Keychain
+	name string
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// NewMasterKeys creates a MasterKeys for the given keychain entry name
+func NewMasterKeys(kc Keychain, name string) *MasterKeys {
+	return &MasterKeys{
+		kc:    kc,
+		name:  name,
+		cache: make(map[string][]byte),
+	}
+}
+
+// Init makes sure a current master key exists, generating the first
+// generation if the keychain holds none
+func (m *MasterKeys) Init() (string, error) {
+	id, err := m.CurrentKeyID()
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return "", err
+	}
+
+	if id, err = m.CreateKey(); err != nil {
+		return "", err
+	}
+	if err := m.SetCurrentKeyID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// CurrentKeyID returns the ID of the master key used for new data. When no
+// generation has been recorded, a key stored directly under the entry name
+// is used as DefaultKeyID.
+func (m *MasterKeys) CurrentKeyID() (string, error) {
+	id, err := m.kc.Get(m.name + "/current")
+	if err == nil {
+		return string(id), nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return "", err
+	}
+
+	if _, err := m.MasterKey(DefaultKeyID); err != nil {
+		return "", err
+	}
+	return DefaultKeyID, nil
+}
+
+// SetCurrentKeyID makes the given generation the one used for new data
+func (m *MasterKeys) SetCurrentKeyID(id string) error {
+	if _, err := m.MasterKey(id); err != nil {
+		return err
+	}
+	if err := m.kc.Set(m.name+"/current", []byte(id)); err != nil {
+		return fmt.Errorf("failed to set current master key: %w", err)
+	}
+	return nil
+}
+
+// MasterKey returns the master key generation with the given ID
+func (m *MasterKeys) MasterKey(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.cache[id]; ok {
+		return key, nil
+	}
+
+	key, err := m.kc.Get(m.entry(id))
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache[id] = key
+	return key, nil
+}
+
+// CreateKey generates and stores a new master key generation without
+// making it current
+func (m *MasterKeys) CreateKey() (string, error) {
+	id, err := newKeyID()
+	if err != nil {
+		return "", err
+	}
+	if gen, ok := m.kc.(interface{ GenerateKey(string) error }); ok {
+		if err := gen.GenerateKey(m.entry(id)); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	if err := m.storeKey(id, key); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteKey removes a master key generation
+func (m *MasterKeys) DeleteKey(id string) error {
+	m.mu.Lock()
+	delete(m.cache, id)
+	m.mu.Unlock()
+
+	return m.kc.Delete(m.entry(id))
+}
+
+// entry returns the keychain entry name of a generation
+func (m *MasterKeys) entry(id string) string {
+	if id == DefaultKeyID {
+		return m.name
+	}
+	return m.name + "/" + id
+}
+
+// storeKey stores a master key generation under the given ID
+func (m *MasterKeys) storeKey(id string, key []byte) error {
+	if err := m.kc.Set(m.entry(id), key); err != nil {
+		return fmt.Errorf("failed to store master key: %w", err)
+	}
+
+	m.mu.Lock()
+	m.cache[id] = key
+	m.mu.Unlock()
+	return nil
+}
+
+// newKeyID returns a sortable, unique master key ID
+func newKeyID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix), nil
+}
@@ -0,0 +1,409 @@
+package keychain
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/fileutil"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+const (
+	// keyFileVersion is the current key file format version
+	keyFileVersion = 1
+
+	// passphraseCheck is sealed with the derived key to detect wrong passphrases
+	passphraseCheck = "keeper-passphrase-check"
+
+	// freeAttempts is the number of wrong passphrases allowed before backoff
+	freeAttempts = 3
+
+	// maxBackoff caps the delay between attempts after repeated failures
+	maxBackoff = 15 * time.Minute
+)
+
+var (
+	// ErrWrongPassphrase is returned when the passphrase does not unlock the key file
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrTooManyAttempts is returned while unlocking is rate-limited
+	ErrTooManyAttempts = errors.New("too many failed passphrase attempts")
+)
+
+// KDFParams are the Argon2id parameters used to derive the key-encryption key
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+// DefaultKDFParams returns the parameters used for new key files
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm: "argon2id",
+		Time:      3,
+		Memory:    64 * 1024,
+		Threads:   4,
+	}
+}
+
+// keyFile is the on-disk format of a passphrase-protected key file
+type keyFile struct {
+	Version  int               `json:"version"`
+	KDF      KDFParams         `json:"kdf"`
+	Check    string            `json:"check"`
+	Keys     map[string]string `json:"keys"`
+	Failures int               `json:"failed_attempts,omitempty"`
+	LastFail time.Time         `json:"last_failure,omitempty"`
+}
+
+// PassphraseSource supplies the passphrase used to unlock a key file
+type PassphraseSource func() ([]byte, error)
+
+// PassphraseFromEnv reads the passphrase from an environment variable
+func PassphraseFromEnv(name string) PassphraseSource {
+	return func() ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return []byte(value), nil
+	}
+}
+
+// PassphraseFromFD reads the passphrase from the first line of an open
+// file descriptor, such as one passed by a parent process
+func PassphraseFromFD(fd uintptr) PassphraseSource {
+	return func() ([]byte, error) {
+		f := os.NewFile(fd, "passphrase")
+		if f == nil {
+			return nil, fmt.Errorf("invalid file descriptor %d", fd)
+		}
+		defer f.Close()
+
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+}
+
+// PassphrasePrompt asks for the passphrase on the terminal without echo
+func PassphrasePrompt(prompt string) PassphraseSource {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("cannot prompt for passphrase: stdin is not a terminal")
+		}
+
+		fmt.Fprint(os.Stderr, prompt)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return passphrase, nil
+	}
+}
+
+// PassphraseKeychain stores keys in a file, each sealed with a
+// key-encryption key derived from a passphrase with Argon2id. It needs no
+// OS keyring, which makes it suitable for headless hosts.
+type PassphraseKeychain struct {
+	path       string
+	passphrase PassphraseSource
+	kdf        KDFParams
+
+	mu  sync.Mutex
+	kek []byte
+}
+
+// NewPassphrase creates a PassphraseKeychain backed by the key file at path
+func NewPassphrase(path string, passphrase PassphraseSource) (*PassphraseKeychain, error) {
+	if path == "" {
+		return nil, fmt.Errorf("key file path is required")
+	}
+	if passphrase == nil {
+		return nil, fmt.Errorf("passphrase source is required")
+	}
+
+	return &PassphraseKeychain{
+		path:       path,
+		passphrase: passphrase,
+		kdf:        DefaultKDFParams(),
+	}, nil
+}
+
+// Get implements the Keychain interface
+func (k *PassphraseKeychain) Get(name string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, ok := file.Keys[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	if err := k.unlock(file); err != nil {
+		return nil, err
+	}
+
+	key, err := k.open(sealed, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %w", name, err)
+	}
+
+	return key, nil
+}
+
+// Set implements the Keychain interface
+func (k *PassphraseKeychain) Set(name string, key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return err
+	}
+
+	if err := k.unlock(file); err != nil {
+		return err
+	}
+
+	sealed, err := k.seal(key, name)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key %s: %w", name, err)
+	}
+
+	file.Keys[name] = sealed
+	return k.save(file)
+}
+
+// Delete implements the Keychain interface
+func (k *PassphraseKeychain) Delete(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := file.Keys[name]; !ok {
+		return ErrKeyNotFound
+	}
+
+	delete(file.Keys, name)
+	return k.save(file)
+}
+
+// GenerateKey generates a new encryption key and stores it in the key file
+func (k *PassphraseKeychain) GenerateKey(name string) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	return k.Set(name, key)
+}
+
+// RenameKey renames a key in the key file. The key is sealed again since
+// its name is bound to the ciphertext.
+func (k *PassphraseKeychain) RenameKey(oldName, newName string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return err
+	}
+
+	sealed, ok := file.Keys[oldName]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	if err := k.unlock(file); err != nil {
+		return err
+	}
+
+	key, err := k.open(sealed, oldName)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt key %s: %w", oldName, err)
+	}
+
+	if file.Keys[newName], err = k.seal(key, newName); err != nil {
+		return fmt.Errorf("failed to encrypt key %s: %w", newName, err)
+	}
+
+	delete(file.Keys, oldName)
+	return k.save(file)
+}
+
+// KeyExists checks if a key exists in the key file. It does not need the
+// passphrase.
+func (k *PassphraseKeychain) KeyExists(name string) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := file.Keys[name]
+	return ok, nil
+}
+
+// load reads the key file, returning a fresh one if it does not exist yet
+func (k *PassphraseKeychain) load() (*keyFile, error) {
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+
+		kdf := k.kdf
+		kdf.Salt = make([]byte, 16)
+		if _, err := rand.Read(kdf.Salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		return &keyFile{
+			Version: keyFileVersion,
+			KDF:     kdf,
+			Keys:    make(map[string]string),
+		}, nil
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if file.Version != keyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", file.Version)
+	}
+	if file.KDF.Algorithm != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation algorithm %q", file.KDF.Algorithm)
+	}
+	if file.Keys == nil {
+		file.Keys = make(map[string]string)
+	}
+
+	return &file, nil
+}
+
+// save writes the key file
+func (k *PassphraseKeychain) save(file *keyFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key file: %w", err)
+	}
+
+	if err := fileutil.WriteFile(k.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return nil
+}
+
+// unlock derives the key-encryption key from the passphrase and checks it
+// against the key file. Wrong passphrases are recorded in the key file and
+// further attempts are delayed with exponential backoff.
+func (k *PassphraseKeychain) unlock(file *keyFile) error {
+	if k.kek != nil {
+		return nil
+	}
+
+	if wait := backoff(file.Failures) - time.Since(file.LastFail); file.Failures > 0 && wait > 0 {
+		return fmt.Errorf("%w: try again in %s", ErrTooManyAttempts, wait.Round(time.Second))
+	}
+
+	passphrase, err := k.passphrase()
+	if err != nil {
+		return err
+	}
+	if len(passphrase) == 0 {
+		return fmt.Errorf("passphrase cannot be empty")
+	}
+
+	kek := argon2.IDKey(passphrase, file.KDF.Salt, file.KDF.Time, file.KDF.Memory, file.KDF.Threads, crypto.KeySize)
+
+	// A new key file records a check value for the passphrase
+	if file.Check == "" {
+		k.kek = kek
+		file.Check, err = k.seal([]byte(passphraseCheck), "")
+		if err != nil {
+			k.kek = nil
+			return fmt.Errorf("failed to initialize key file: %w", err)
+		}
+		return nil
+	}
+
+	k.kek = kek
+	if check, err := k.open(file.Check, ""); err != nil || string(check) != passphraseCheck {
+		k.kek = nil
+		file.Failures++
+		file.LastFail = time.Now()
+		if err := k.save(file); err != nil {
+			return err
+		}
+		return ErrWrongPassphrase
+	}
+
+	if file.Failures > 0 {
+		file.Failures = 0
+		file.LastFail = time.Time{}
+		return k.save(file)
+	}
+
+	return nil
+}
+
+// seal encrypts data with the key-encryption key, bound to the key name
+func (k *PassphraseKeychain) seal(data []byte, name string) (string, error) {
+	c, err := crypto.NewCipher(k.kek)
+	if err != nil {
+		return "", err
+	}
+	return c.Encrypt(data, []byte(name))
+}
+
+// open decrypts data sealed by seal
+func (k *PassphraseKeychain) open(sealed, name string) ([]byte, error) {
+	c, err := crypto.NewCipher(k.kek)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decrypt(sealed, []byte(name))
+}
+
+// backoff returns how long to wait after the given number of failures
+func backoff(failures int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	delay := time.Second << uint(failures-freeAttempts)
+	if delay > maxBackoff || delay <= 0 {
+		return maxBackoff
+	}
+	return delay
+}
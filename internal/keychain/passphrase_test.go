@@ -0,0 +1,71 @@
+package keychain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticPassphrase(p string) PassphraseSource {
+	return func() ([]byte, error) {
+		return []byte(p), nil
+	}
+}
+
+func TestPassphraseKeychain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+
+	kc, err := NewPassphrase(path, staticPassphrase("correct horse"))
+	require.NoError(t, err)
+
+	t.Run("Missing Key", func(t *testing.T) {
+		_, err := kc.Get("master")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+
+	t.Run("Set and Get", func(t *testing.T) {
+		require.NoError(t, kc.Set("master", []byte("0123456789abcdef0123456789abcdef")))
+
+		// A fresh instance must derive the same key from the passphrase
+		reopened, err := NewPassphrase(path, staticPassphrase("correct horse"))
+		require.NoError(t, err)
+
+		key, err := reopened.Get("master")
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789abcdef0123456789abcdef", string(key))
+
+		exists, err := reopened.KeyExists("master")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		require.NoError(t, kc.RenameKey("master", "renamed"))
+		require.NoError(t, kc.RenameKey("renamed", "master"))
+
+		key, err := kc.Get("master")
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789abcdef0123456789abcdef", string(key))
+	})
+
+	t.Run("Wrong Passphrase Is Rate Limited", func(t *testing.T) {
+		wrong, err := NewPassphrase(path, staticPassphrase("battery staple"))
+		require.NoError(t, err)
+
+		for i := 0; i < freeAttempts; i++ {
+			_, err := wrong.Get("master")
+			assert.ErrorIs(t, err, ErrWrongPassphrase)
+		}
+
+		_, err = wrong.Get("master")
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+		// The right passphrase is also delayed until the backoff expires
+		right, err := NewPassphrase(path, staticPassphrase("correct horse"))
+		require.NoError(t, err)
+		_, err = right.Get("master")
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+	})
+}
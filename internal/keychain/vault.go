@@ -0,0 +1,263 @@
+package keychain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/keeper/internal/config"
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/fileutil"
+)
+
+// vaultKeyFileVersion is the current wrapped key file format version
+const vaultKeyFileVersion = 1
+
+// vaultKeyFile is the on-disk format of the vault-transit key file
+type vaultKeyFile struct {
+	Version    int               `json:"version"`
+	TransitKey string            `json:"transit_key"`
+	Keys       map[string]string `json:"keys"`
+}
+
+// VaultTransitKeychain stores keys in a local file, each wrapped by a
+// Vault transit key. Keys never leave the host unwrapped and access to them
+// can be revoked centrally by revoking the Vault token.
+type VaultTransitKeychain struct {
+	path    string
+	client  *api.Client
+	mount   string
+	keyName string
+
+	mu sync.Mutex
+}
+
+// NewVaultTransit creates a VaultTransitKeychain backed by the key file at
+// path. The Vault address and token default to VAULT_ADDR and VAULT_TOKEN.
+func NewVaultTransit(path string, cfg config.VaultTransitConfig) (*VaultTransitKeychain, error) {
+	if path == "" {
+		return nil, fmt.Errorf("key file path is required")
+	}
+
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client: %w", err)
+	}
+	if cfg.Address != "" {
+		if err := client.SetAddress(cfg.Address); err != nil {
+			return nil, fmt.Errorf("failed to set vault address: %w", err)
+		}
+	}
+	if cfg.Token != "" {
+		client.SetToken(cfg.Token)
+	}
+
+	mount := cfg.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	keyName := cfg.KeyName
+	if keyName == "" {
+		keyName = "keeper"
+	}
+
+	return &VaultTransitKeychain{
+		path:    path,
+		client:  client,
+		mount:   mount,
+		keyName: keyName,
+	}, nil
+}
+
+// Get implements the Keychain interface
+func (k *VaultTransitKeychain) Get(name string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, ok := file.Keys[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return k.unwrap(file.TransitKey, wrapped)
+}
+
+// Set implements the Keychain interface
+func (k *VaultTransitKeychain) Set(name string, key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return err
+	}
+	if file.TransitKey != k.keyName {
+		return fmt.Errorf("key file is wrapped by transit key %s, not %s", file.TransitKey, k.keyName)
+	}
+
+	wrapped, err := k.wrap(key)
+	if err != nil {
+		return err
+	}
+
+	file.Keys[name] = wrapped
+	return k.save(file)
+}
+
+// Delete implements the Keychain interface
+func (k *VaultTransitKeychain) Delete(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := file.Keys[name]; !ok {
+		return ErrKeyNotFound
+	}
+
+	delete(file.Keys, name)
+	return k.save(file)
+}
+
+// GenerateKey generates a new encryption key and stores it wrapped by Vault
+func (k *VaultTransitKeychain) GenerateKey(name string) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	return k.Set(name, key)
+}
+
+// RenameKey renames a key in the key file. The wrapped key is moved as is,
+// without a round trip to Vault.
+func (k *VaultTransitKeychain) RenameKey(oldName, newName string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return err
+	}
+
+	wrapped, ok := file.Keys[oldName]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	file.Keys[newName] = wrapped
+	delete(file.Keys, oldName)
+	return k.save(file)
+}
+
+// KeyExists checks if a key exists in the key file. It does not contact Vault.
+func (k *VaultTransitKeychain) KeyExists(name string) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file, err := k.load()
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := file.Keys[name]
+	return ok, nil
+}
+
+// wrap encrypts a key with the transit key
+func (k *VaultTransitKeychain) wrap(key []byte) (string, error) {
+	secret, err := k.client.Logical().Write(k.mount+"/encrypt/"+k.keyName, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap key with vault: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("failed to wrap key with vault: empty response")
+	}
+
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok {
+		return "", fmt.Errorf("failed to wrap key with vault: missing ciphertext")
+	}
+
+	return ciphertext, nil
+}
+
+// unwrap decrypts a key wrapped by the given transit key
+func (k *VaultTransitKeychain) unwrap(transitKey, wrapped string) ([]byte, error) {
+	secret, err := k.client.Logical().Write(k.mount+"/decrypt/"+transitKey, map[string]interface{}{
+		"ciphertext": wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key with vault: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("failed to unwrap key with vault: empty response")
+	}
+
+	encoded, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to unwrap key with vault: missing plaintext")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
+	}
+
+	return key, nil
+}
+
+// load reads the key file, returning a fresh one if it does not exist yet
+func (k *VaultTransitKeychain) load() (*vaultKeyFile, error) {
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		return &vaultKeyFile{
+			Version:    vaultKeyFileVersion,
+			TransitKey: k.keyName,
+			Keys:       make(map[string]string),
+		}, nil
+	}
+
+	var file vaultKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if file.Version != vaultKeyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", file.Version)
+	}
+	if file.Keys == nil {
+		file.Keys = make(map[string]string)
+	}
+
+	return &file, nil
+}
+
+// save writes the key file
+func (k *VaultTransitKeychain) save(file *vaultKeyFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key file: %w", err)
+	}
+
+	if err := fileutil.WriteFile(k.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+// Package all registers every provider type built into keeper. Import it
+// for its side effects wherever providers are created by type.
+package all
+
+import (
+	_ "github.com/keeper/internal/providers/aws"
+	_ "github.com/keeper/internal/providers/azure"
+	_ "github.com/keeper/internal/providers/gcp"
+	_ "github.com/keeper/internal/providers/local"
+	_ "github.com/keeper/internal/providers/vault"
+)
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -13,19 +15,36 @@ import (
 	"github.com/pkg/errors"
 )
 
+// defaultRecoveryWindow is the number of days deleted secrets can be
+// restored by default, the longest Secrets Manager allows
+const defaultRecoveryWindow = 30
+
 // AWSProvider implements the Provider interface using AWS Secrets Manager
 type AWSProvider struct {
-	client *secretsmanager.Client
+	client         *secretsmanager.Client
+	recoveryWindow int
 }
 
 // New creates a new AWSProvider with the given configuration
 func New(cfg aws.Config) (*AWSProvider, error) {
 	client := secretsmanager.NewFromConfig(cfg)
 	return &AWSProvider{
-		client: client,
+		client:         client,
+		recoveryWindow: defaultRecoveryWindow,
 	}, nil
 }
 
+// SetRecoveryWindow sets for how many days deleted secrets can be
+// restored, between 7 and 30. Zero deletes secrets outright.
+func (p *AWSProvider) SetRecoveryWindow(days int) {
+	p.recoveryWindow = days
+}
+
+// Initialize implements the Provider interface
+func (p *AWSProvider) Initialize(ctx context.Context) error {
+	return nil
+}
+
 // GetSecret retrieves a secret from AWS Secrets Manager
 func (p *AWSProvider) GetSecret(ctx context.Context, key string) (*providers.Secret, error) {
 	input := &secretsmanager.GetSecretValueInput{
@@ -34,6 +53,10 @@ func (p *AWSProvider) GetSecret(ctx context.Context, key string) (*providers.Sec
 
 	result, err := p.client.GetSecretValue(ctx, input)
 	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, providers.ErrSecretNotFound
+		}
 		return nil, fmt.Errorf("failed to get secret: %w", err)
 	}
 
@@ -41,25 +64,29 @@ func (p *AWSProvider) GetSecret(ctx context.Context, key string) (*providers.Sec
 	if err := json.Unmarshal([]byte(*result.SecretString), &secret); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
 	}
+	secret.Name = key
 
 	return &secret, nil
 }
 
 // SetSecret stores a secret in AWS Secrets Manager
-func (p *AWSProvider) SetSecret(ctx context.Context, key, value string, metadata map[string]string) error {
+func (p *AWSProvider) SetSecret(ctx context.Context, secret *providers.Secret) error {
+	if err := secret.Validate(); err != nil {
+		return err
+	}
+
 	now := time.Now()
-	secret := providers.Secret{
-		Value:    value,
-		Metadata: metadata,
-		Created:  now,
-		Updated:  now,
+	if secret.CreatedAt.IsZero() {
+		secret.CreatedAt = now
 	}
+	secret.UpdatedAt = now
 
 	data, err := json.Marshal(secret)
 	if err != nil {
 		return fmt.Errorf("failed to marshal secret: %w", err)
 	}
 
+	key := secret.Name
 	input := &secretsmanager.CreateSecretInput{
 		Name:         aws.String(key),
 		SecretString: aws.String(string(data)),
@@ -86,11 +113,17 @@ func (p *AWSProvider) SetSecret(ctx context.Context, key, value string, metadata
 	return nil
 }
 
-// DeleteSecret deletes a secret from AWS Secrets Manager
+// DeleteSecret schedules the deletion of a secret from AWS Secrets Manager
+// at the end of the recovery window
 func (p *AWSProvider) DeleteSecret(ctx context.Context, key string) error {
 	input := &secretsmanager.DeleteSecretInput{
 		SecretId: aws.String(key),
 	}
+	if p.recoveryWindow > 0 {
+		input.RecoveryWindowInDays = aws.Int64(int64(p.recoveryWindow))
+	} else {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	}
 
 	_, err := p.client.DeleteSecret(ctx, input)
 	if err != nil {
@@ -100,35 +133,147 @@ func (p *AWSProvider) DeleteSecret(ctx context.Context, key string) error {
 	return nil
 }
 
-// ListSecrets lists all secrets in AWS Secrets Manager
-func (p *AWSProvider) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
-	input := &secretsmanager.ListSecretsInput{}
-	result, err := p.client.ListSecrets(ctx, input)
+// ListSecrets lists all secrets in AWS Secrets Manager, following every
+// page of results. Values are left out.
+func (p *AWSProvider) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
+	return providers.CollectPages(ctx, p.ListSecretPages(ctx, providers.ListOptions{}))
+}
+
+// ListSecretPages lists secrets a page at a time, resuming from the
+// NextToken of the previous page. Values are only fetched when asked for,
+// with one request per secret.
+func (p *AWSProvider) ListSecretPages(ctx context.Context, opts providers.ListOptions) providers.PageIterator {
+	return providers.TokenPages(opts.PageToken, func(ctx context.Context, token string) (*providers.SecretPage, error) {
+		input := listSecretsInput(opts.Prefix, opts.PageSize)
+		if token != "" {
+			input.NextToken = aws.String(token)
+		}
+
+		result, err := p.client.ListSecrets(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %w", err)
+		}
+
+		page := &providers.SecretPage{NextPageToken: aws.ToString(result.NextToken)}
+		for _, entry := range result.SecretList {
+			name := aws.ToString(entry.Name)
+			if !strings.HasPrefix(name, opts.Prefix) {
+				continue
+			}
+
+			secret := &providers.Secret{
+				Name:      name,
+				CreatedAt: aws.ToTime(entry.CreatedDate),
+				UpdatedAt: aws.ToTime(entry.LastChangedDate),
+			}
+			if opts.IncludeValues {
+				if secret, err = p.GetSecret(ctx, name); err != nil {
+					return nil, err
+				}
+				secret.Name = name
+			}
+			page.Secrets = append(page.Secrets, secret)
+		}
+
+		return page, nil
+	})
+}
+
+// ListDeletedSecrets returns the secrets scheduled for deletion, most
+// recently deleted first
+func (p *AWSProvider) ListDeletedSecrets(ctx context.Context) ([]*providers.DeletedSecret, error) {
+	input := listSecretsInput("", 0)
+	input.IncludePlannedDeletion = aws.Bool(true)
+
+	var deleted []*providers.DeletedSecret
+	pages := secretsmanager.NewListSecretsPaginator(p.client, input)
+	for pages.HasMorePages() {
+		result, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deleted secrets: %w", err)
+		}
+
+		for _, entry := range result.SecretList {
+			if entry.DeletedDate == nil {
+				continue
+			}
+			deleted = append(deleted, &providers.DeletedSecret{
+				Name:      aws.ToString(entry.Name),
+				DeletedAt: *entry.DeletedDate,
+				PurgeAt:   entry.DeletedDate.AddDate(0, 0, p.recoveryWindow),
+			})
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].DeletedAt.After(deleted[j].DeletedAt)
+	})
+	return deleted, nil
+}
+
+// RecoverSecret cancels the scheduled deletion of a secret
+func (p *AWSProvider) RecoverSecret(ctx context.Context, key string) error {
+	_, err := p.client.RestoreSecret(ctx, &secretsmanager.RestoreSecretInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore secret: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeletedSecrets deletes the secrets scheduled for deletion before a
+// given time without waiting for the end of their recovery window
+func (p *AWSProvider) PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error) {
+	deleted, err := p.ListDeletedSecrets(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list secrets: %w", err)
+		return 0, err
 	}
 
-	var keys []string
-	for _, secret := range result.SecretList {
-		if prefix == "" || aws.ToString(secret.Name) == prefix {
-			keys = append(keys, aws.ToString(secret.Name))
+	purged := 0
+	for _, secret := range deleted {
+		if !secret.DeletedAt.Before(before) {
+			continue
 		}
+
+		_, err := p.client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+			SecretId:                   aws.String(secret.Name),
+			ForceDeleteWithoutRecovery: aws.Bool(true),
+		})
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge secret %s: %w", secret.Name, err)
+		}
+		purged++
 	}
 
-	return keys, nil
+	return purged, nil
+}
+
+// listSecretsInput returns the input of a ListSecrets request. The name
+// filter of Secrets Manager ignores case, so results still need checking
+// against the prefix.
+func listSecretsInput(prefix string, pageSize int) *secretsmanager.ListSecretsInput {
+	input := &secretsmanager.ListSecretsInput{}
+	if prefix != "" {
+		input.Filters = []types.Filter{{
+			Key:    types.FilterNameStringTypeName,
+			Values: []string{prefix},
+		}}
+	}
+	if pageSize > 0 {
+		input.MaxResults = aws.Int32(int32(pageSize))
+	}
+	return input
 }
 
-// GetRotationPolicy retrieves the rotation policy for a secret
+// GetRotationPolicy retrieves the rotation policy for a secret, or nil if
+// it has none
 func (p *AWSProvider) GetRotationPolicy(ctx context.Context, key string) (*providers.RotationPolicy, error) {
 	secret, err := p.GetSecret(ctx, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get secret: %w", err)
 	}
 
-	if secret.Metadata == nil {
-		return nil, nil
-	}
-
 	policyJSON, ok := secret.Metadata["rotation_policy"]
 	if !ok {
 		return nil, nil
@@ -159,7 +304,7 @@ func (p *AWSProvider) SetRotationPolicy(ctx context.Context, key string, policy
 	}
 
 	secret.Metadata["rotation_policy"] = string(policyJSON)
-	return p.SetSecret(ctx, key, secret.Value, secret.Metadata)
+	return p.SetSecret(ctx, secret)
 }
 
 // RotateSecret rotates a secret according to its rotation policy
@@ -172,6 +317,9 @@ func (p *AWSProvider) RotateSecret(ctx context.Context, key string) error {
 	if err != nil {
 		return fmt.Errorf("failed to get rotation policy: %w", err)
 	}
+	if policy == nil {
+		policy = providers.DefaultRotationPolicy()
+	}
 
 	secret, err := p.GetSecret(ctx, key)
 	if err != nil {
@@ -179,48 +327,11 @@ func (p *AWSProvider) RotateSecret(ctx context.Context, key string) error {
 	}
 
 	// Generate new value based on policy
-	newValue := fmt.Sprintf("%d", providers.RandInt(policy.Length, policy.Length))
-
-	return p.SetSecret(ctx, key, newValue, secret.Metadata)
-}
-
-// GetSecretMetadata gets metadata for a secret
-func (p *AWSProvider) GetSecretMetadata(ctx context.Context, key string) (*providers.SecretMetadata, error) {
-	input := &secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(key),
+	if secret.Value, err = providers.GenerateValue(policy); err != nil {
+		return err
 	}
 
-	output, err := p.client.GetSecretValue(ctx, input)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get secret: %w", err)
-	}
-
-	var secret providers.Secret
-	if err := json.Unmarshal([]byte(*output.SecretString), &secret); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
-	}
-
-	// Get rotation policy if exists
-	var policy *providers.RotationPolicy
-	describeInput := &secretsmanager.DescribeSecretInput{
-		SecretId: aws.String(key),
-	}
-	describeOutput, err := p.client.DescribeSecret(ctx, describeInput)
-	if err == nil && describeOutput.RotationEnabled != nil && *describeOutput.RotationEnabled {
-		policy = &providers.RotationPolicy{
-			Interval:     time.Duration(*describeOutput.RotationRules.AutomaticallyAfterDays) * 24 * time.Hour,
-			LastRotation: *describeOutput.LastRotatedDate,
-			NextRotation: *describeOutput.NextRotationDate,
-		}
-	}
-
-	return &providers.SecretMetadata{
-		Created:         secret.Created,
-		LastModified:    secret.Updated,
-		UserMetadata:    secret.Metadata,
-		RotationPolicy:  policy,
-		PreviousVersion: "", // AWS Secrets Manager handles versions internally
-	}, nil
+	return p.SetSecret(ctx, secret)
 }
 
 // Close implements the Provider interface
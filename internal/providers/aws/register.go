@@ -0,0 +1,36 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/keeper/internal/providers"
+)
+
+func init() {
+	providers.Register(providers.Registration{
+		Type:        "aws",
+		Description: "AWS Secrets Manager",
+		Params: []providers.Param{
+			{Name: "region", Type: providers.ParamString, Required: true, Description: "AWS region of the secrets"},
+			{Name: "recovery_window_days", Type: providers.ParamInt, Default: defaultRecoveryWindow, Description: "days deleted secrets can be restored, 7 to 30, or 0 to delete outright"},
+		},
+		Factory: open,
+	})
+}
+
+// open creates an AWS provider from its registry parameters
+func open(ctx context.Context, params providers.Params) (providers.Provider, error) {
+	window := params.Int("recovery_window_days")
+	if window != 0 && (window < 7 || window > 30) {
+		return nil, fmt.Errorf("recovery_window_days must be between 7 and 30, or 0")
+	}
+
+	p, err := New(aws.Config{Region: params.String("region")})
+	if err != nil {
+		return nil, err
+	}
+	p.SetRecoveryWindow(window)
+	return p, nil
+}
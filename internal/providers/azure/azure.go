@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,6 +24,11 @@ func New(client *azsecrets.Client) (*AzureProvider, error) {
 	}, nil
 }
 
+// Initialize implements the Provider interface
+func (p *AzureProvider) Initialize(ctx context.Context) error {
+	return nil
+}
+
 // GetSecret retrieves a secret from Azure Key Vault
 func (p *AzureProvider) GetSecret(ctx context.Context, key string) (*providers.Secret, error) {
 	result, err := p.client.GetSecret(ctx, key, "", nil)
@@ -34,20 +40,21 @@ func (p *AzureProvider) GetSecret(ctx context.Context, key string) (*providers.S
 	if err := json.Unmarshal([]byte(*result.Value), &secret); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
 	}
+	secret.Name = key
 
 	return &secret, nil
 }
 
 // SetSecret stores a secret in Azure Key Vault
-func (p *AzureProvider) SetSecret(ctx context.Context, key, value string, metadata map[string]string) error {
-	secret := &providers.Secret{
-		Key:       key,
-		Value:     value,
-		Metadata:  metadata,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+func (p *AzureProvider) SetSecret(ctx context.Context, secret *providers.Secret) error {
+	if err := secret.Validate(); err != nil {
+		return err
 	}
 
+	key := secret.Name
+	secret.UpdatedAt = time.Now()
+	secret.CreatedAt = secret.UpdatedAt
+
 	// If secret already exists, preserve creation time
 	if existing, err := p.GetSecret(ctx, key); err == nil {
 		secret.CreatedAt = existing.CreatedAt
@@ -58,8 +65,9 @@ func (p *AzureProvider) SetSecret(ctx context.Context, key, value string, metada
 		return fmt.Errorf("failed to marshal secret: %w", err)
 	}
 
+	value := string(data)
 	params := azsecrets.SetSecretParameters{
-		Value: &data,
+		Value: &value,
 	}
 
 	_, err = p.client.SetSecret(ctx, key, params, nil)
@@ -70,7 +78,8 @@ func (p *AzureProvider) SetSecret(ctx context.Context, key, value string, metada
 	return nil
 }
 
-// DeleteSecret removes a secret from Azure Key Vault
+// DeleteSecret removes a secret from Azure Key Vault. Vaults with soft
+// delete enabled keep it recoverable until its scheduled purge date.
 func (p *AzureProvider) DeleteSecret(ctx context.Context, key string) error {
 	_, err := p.client.DeleteSecret(ctx, key, nil)
 	if err != nil {
@@ -79,15 +88,95 @@ func (p *AzureProvider) DeleteSecret(ctx context.Context, key string) error {
 	return nil
 }
 
-// ListSecrets lists all secrets in Azure Key Vault with the given prefix
-func (p *AzureProvider) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
-	pager := p.client.NewListSecretsPager(nil)
-	var keys []string
+// ListSecrets lists all secrets in Azure Key Vault. Values are left out.
+func (p *AzureProvider) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
+	return providers.CollectPages(ctx, p.ListSecretPages(ctx, providers.ListOptions{}))
+}
+
+// ListSecretPages lists secrets a page at a time, following the next links
+// of Key Vault. Key Vault listings cannot be resumed from a page token.
+// Values are only fetched when asked for, with one request per secret.
+func (p *AzureProvider) ListSecretPages(ctx context.Context, opts providers.ListOptions) providers.PageIterator {
+	var options *azsecrets.ListSecretsOptions
+	if opts.PageSize > 0 {
+		pageSize := int32(opts.PageSize)
+		options = &azsecrets.ListSecretsOptions{MaxResults: &pageSize}
+	}
+
+	pager := p.client.NewListSecretsPager(options)
+	return &secretPages{
+		provider: p,
+		opts:     opts,
+		more:     pager.More,
+		next:     pager.NextPage,
+	}
+}
+
+// secretPages is a PageIterator over the native pager of Key Vault
+type secretPages struct {
+	provider *AzureProvider
+	opts     providers.ListOptions
+	more     func() bool
+	next     func(ctx context.Context) (azsecrets.ListSecretsResponse, error)
+}
+
+// More implements the PageIterator interface
+func (s *secretPages) More() bool {
+	return s.more()
+}
+
+// NextPage implements the PageIterator interface
+func (s *secretPages) NextPage(ctx context.Context) (*providers.SecretPage, error) {
+	if s.opts.PageToken != "" {
+		return nil, fmt.Errorf("%w: Key Vault listings cannot be resumed", providers.ErrInvalidPageToken)
+	}
+
+	result, err := s.next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	page := &providers.SecretPage{}
+	for _, item := range result.Value {
+		if item.ID == nil {
+			continue
+		}
+
+		key := item.ID.Name()
+		if !strings.HasPrefix(key, s.opts.Prefix) {
+			continue
+		}
+
+		secret := &providers.Secret{Name: key}
+		if item.Attributes != nil {
+			if item.Attributes.Created != nil {
+				secret.CreatedAt = *item.Attributes.Created
+			}
+			if item.Attributes.Updated != nil {
+				secret.UpdatedAt = *item.Attributes.Updated
+			}
+		}
+		if s.opts.IncludeValues {
+			if secret, err = s.provider.GetSecret(ctx, key); err != nil {
+				return nil, err
+			}
+			secret.Name = key
+		}
+		page.Secrets = append(page.Secrets, secret)
+	}
+
+	return page, nil
+}
 
+// ListDeletedSecrets returns the soft-deleted secrets of the vault, most
+// recently deleted first
+func (p *AzureProvider) ListDeletedSecrets(ctx context.Context) ([]*providers.DeletedSecret, error) {
+	var deleted []*providers.DeletedSecret
+	pager := p.client.NewListDeletedSecretsPager(nil)
 	for pager.More() {
 		page, err := pager.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list secrets: %w", err)
+			return nil, fmt.Errorf("failed to list deleted secrets: %w", err)
 		}
 
 		for _, item := range page.Value {
@@ -95,14 +184,51 @@ func (p *AzureProvider) ListSecrets(ctx context.Context, prefix string) ([]strin
 				continue
 			}
 
-			key := *item.ID
-			if strings.HasPrefix(key, prefix) {
-				keys = append(keys, key)
+			secret := &providers.DeletedSecret{Name: item.ID.Name()}
+			if item.DeletedDate != nil {
+				secret.DeletedAt = *item.DeletedDate
+			}
+			if item.ScheduledPurgeDate != nil {
+				secret.PurgeAt = *item.ScheduledPurgeDate
 			}
+			deleted = append(deleted, secret)
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].DeletedAt.After(deleted[j].DeletedAt)
+	})
+	return deleted, nil
+}
+
+// RecoverSecret recovers a soft-deleted secret
+func (p *AzureProvider) RecoverSecret(ctx context.Context, key string) error {
+	if _, err := p.client.RecoverDeletedSecret(ctx, key, nil); err != nil {
+		return fmt.Errorf("failed to recover secret: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeletedSecrets permanently deletes the secrets soft-deleted before a
+// given time
+func (p *AzureProvider) PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error) {
+	deleted, err := p.ListDeletedSecrets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, secret := range deleted {
+		if !secret.DeletedAt.Before(before) {
+			continue
+		}
+		if _, err := p.client.PurgeDeletedSecret(ctx, secret.Name, nil); err != nil {
+			return purged, fmt.Errorf("failed to purge secret %s: %w", secret.Name, err)
 		}
+		purged++
 	}
 
-	return keys, nil
+	return purged, nil
 }
 
 // RotateSecret rotates a secret according to its rotation policy
@@ -125,14 +251,9 @@ func (p *AzureProvider) RotateSecret(ctx context.Context, key string) error {
 	}
 
 	// Generate new value
-	var newValue string
-	if policy.CustomGenerator != nil {
-		newValue, err = policy.CustomGenerator()
-		if err != nil {
-			return fmt.Errorf("failed to generate new value: %w", err)
-		}
-	} else {
-		newValue = fmt.Sprintf("rotated-%s-%d", secret.Value, time.Now().Unix())
+	newValue, err := providers.GenerateValue(policy)
+	if err != nil {
+		return fmt.Errorf("failed to generate new value: %w", err)
 	}
 
 	// Update metadata
@@ -144,7 +265,8 @@ func (p *AzureProvider) RotateSecret(ctx context.Context, key string) error {
 	secret.Metadata["next_rotation"] = time.Now().Add(policy.Interval).Format(time.RFC3339)
 
 	// Store the new value
-	return p.SetSecret(ctx, key, newValue, secret.Metadata)
+	secret.Value = newValue
+	return p.SetSecret(ctx, secret)
 }
 
 // GetRotationPolicy retrieves the rotation policy for a secret
@@ -189,5 +311,10 @@ func (p *AzureProvider) SetRotationPolicy(ctx context.Context, key string, polic
 	}
 
 	secret.Metadata["rotation_policy"] = string(policyJSON)
-	return p.SetSecret(ctx, key, secret.Value, secret.Metadata)
+	return p.SetSecret(ctx, secret)
+}
+
+// Close implements the Provider interface
+func (p *AzureProvider) Close() error {
+	return nil
 }
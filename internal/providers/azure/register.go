@@ -0,0 +1,39 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
+	"github.com/keeper/internal/providers"
+)
+
+func init() {
+	providers.Register(providers.Registration{
+		Type:        "azure",
+		Description: "Azure Key Vault",
+		Params: []providers.Param{
+			{Name: "vault_url", Type: providers.ParamString, Required: true, Description: "URL of the key vault, such as https://example.vault.azure.net"},
+			{Name: "tenant_id", Type: providers.ParamString, Description: "Microsoft Entra tenant to authenticate in"},
+		},
+		Factory: open,
+	})
+}
+
+// open creates an Azure provider from its registry parameters, with the
+// credentials of the environment, managed identity or Azure CLI
+func open(ctx context.Context, params providers.Params) (providers.Provider, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		TenantID: params.String("tenant_id"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get azure credentials: %w", err)
+	}
+
+	client, err := azsecrets.NewClient(params.String("vault_url"), cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key vault client: %w", err)
+	}
+	return New(client)
+}
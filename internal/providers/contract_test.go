@@ -0,0 +1,40 @@
+package providers_test
+
+import (
+	"github.com/keeper/internal/providers"
+	"github.com/keeper/internal/providers/aws"
+	"github.com/keeper/internal/providers/azure"
+	"github.com/keeper/internal/providers/gcp"
+	"github.com/keeper/internal/providers/local"
+	"github.com/keeper/internal/providers/vault"
+)
+
+// Every provider implements the contract, and the capabilities its
+// backend offers
+var (
+	_ providers.Provider     = (*local.LocalProvider)(nil)
+	_ providers.Versioner    = (*local.LocalProvider)(nil)
+	_ providers.Searcher     = (*local.LocalProvider)(nil)
+	_ providers.Backuper     = (*local.LocalProvider)(nil)
+	_ providers.Hierarchical = (*local.LocalProvider)(nil)
+	_ providers.Recoverable  = (*local.LocalProvider)(nil)
+	_ providers.Pager        = (*local.LocalProvider)(nil)
+	_ providers.KeyDeleter   = (*local.LocalProvider)(nil)
+
+	_ providers.Provider = (*vault.VaultProvider)(nil)
+	_ providers.Rotator  = (*vault.VaultProvider)(nil)
+
+	_ providers.Provider    = (*aws.AWSProvider)(nil)
+	_ providers.Rotator     = (*aws.AWSProvider)(nil)
+	_ providers.Recoverable = (*aws.AWSProvider)(nil)
+	_ providers.Pager       = (*aws.AWSProvider)(nil)
+
+	_ providers.Provider = (*gcp.GCPProvider)(nil)
+	_ providers.Rotator  = (*gcp.GCPProvider)(nil)
+	_ providers.Pager    = (*gcp.GCPProvider)(nil)
+
+	_ providers.Provider    = (*azure.AzureProvider)(nil)
+	_ providers.Rotator     = (*azure.AzureProvider)(nil)
+	_ providers.Recoverable = (*azure.AzureProvider)(nil)
+	_ providers.Pager       = (*azure.AzureProvider)(nil)
+)
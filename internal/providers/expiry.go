@@ -0,0 +1,102 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/keeper/pkg/api"
+)
+
+const (
+	// ExpiresAtKey is the metadata key holding the RFC 3339 expiry time of a secret
+	ExpiresAtKey = api.ExpiresAtKey
+
+	// DisabledAtKey is the metadata key recording when an expired secret was
+	// disabled by a cleanup
+	DisabledAtKey = "disabled_at"
+
+	// ArchivedAtKey is the metadata key recording when an expired secret was
+	// archived by a cleanup
+	ArchivedAtKey = "archived_at"
+)
+
+var (
+	// ErrSecretExpired is returned when reading a secret past its expiry time
+	ErrSecretExpired = errors.New("secret has expired")
+
+	// ErrSecretDisabled is returned when reading a secret disabled by a
+	// cleanup
+	ErrSecretDisabled = errors.New("secret is disabled")
+)
+
+// GetOptions represents options for reading a secret
+type GetOptions struct {
+	// AllowExpired returns secrets past their expiry time instead of
+	// ErrSecretExpired
+	AllowExpired bool
+
+	// AllowDisabled returns secrets disabled by a cleanup instead of
+	// ErrSecretDisabled. AllowExpired does not imply it.
+	AllowDisabled bool
+}
+
+// getOptionsKey is the context key of GetOptions
+type getOptionsKey struct{}
+
+// WithGetOptions returns a context under which providers that check expiry
+// themselves, such as the local provider, read secrets with opts
+func WithGetOptions(ctx context.Context, opts GetOptions) context.Context {
+	return context.WithValue(ctx, getOptionsKey{}, opts)
+}
+
+// GetOptionsOf returns the options for reading secrets under ctx. Without
+// WithGetOptions, expired and disabled secrets are refused.
+func GetOptionsOf(ctx context.Context) GetOptions {
+	opts, _ := ctx.Value(getOptionsKey{}).(GetOptions)
+	return opts
+}
+
+// GetSecret retrieves a secret from a provider, refusing expired and
+// disabled secrets unless opts allow them. The secret is checked here as
+// well as by the provider, for providers that do not check expiry.
+func GetSecret(ctx context.Context, p Provider, name string, opts GetOptions) (*Secret, error) {
+	secret, err := p.GetSecret(WithGetOptions(ctx, opts), name)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.Check(secret, time.Now()); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Check returns ErrSecretDisabled or ErrSecretExpired if a secret is
+// disabled or has expired by now, and opts do not allow it
+func (o GetOptions) Check(secret *Secret, now time.Time) error {
+	if disabledAt := secret.Metadata[DisabledAtKey]; disabledAt != "" && !o.AllowDisabled {
+		return fmt.Errorf("secret %s was disabled at %s: %w", secret.Name, disabledAt, ErrSecretDisabled)
+	}
+	if o.AllowExpired || !secret.Expired(now) {
+		return nil
+	}
+
+	expiresAt, _ := secret.ExpiresAt()
+	return fmt.Errorf("secret %s expired at %s: %w", secret.Name, expiresAt.Format(time.RFC3339), ErrSecretExpired)
+}
+
+// ParseExpiry parses an expiry given as a duration from now, such as 24h
+// or 30d, or as a date or RFC 3339 time
+func ParseExpiry(value string, now time.Time) (time.Time, error) {
+	if d, err := ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry %q: expected a duration such as 30d, or a date", value)
+}
@@ -0,0 +1,45 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	expiresAt, err := ParseExpiry("30d", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(30*24*time.Hour), expiresAt)
+
+	expiresAt, err = ParseExpiry("2024-04-01", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), expiresAt)
+
+	_, err = ParseExpiry("soon", now)
+	assert.Error(t, err)
+}
+
+func TestSecretExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	secret := &Secret{Name: "token"}
+	assert.False(t, secret.Expired(now))
+	assert.NoError(t, GetOptions{}.Check(secret, now))
+
+	secret.SetExpiresAt(now.Add(time.Hour))
+	assert.False(t, secret.Expired(now))
+	assert.True(t, secret.Expired(now.Add(time.Hour)))
+
+	err := GetOptions{}.Check(secret, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrSecretExpired)
+	assert.NoError(t, GetOptions{AllowExpired: true}.Check(secret, now.Add(2*time.Hour)))
+
+	// Disabled secrets are refused even when expired secrets are allowed
+	secret.Metadata[DisabledAtKey] = now.Add(time.Hour).Format(time.RFC3339)
+	err = GetOptions{AllowExpired: true}.Check(secret, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrSecretDisabled)
+	assert.NoError(t, GetOptions{AllowExpired: true, AllowDisabled: true}.Check(secret, now.Add(2*time.Hour)))
+}
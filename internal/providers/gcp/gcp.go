@@ -39,6 +39,11 @@ func New(ctx context.Context, projectID string, credentialsFile string) (*GCPPro
 	}, nil
 }
 
+// Initialize implements the Provider interface
+func (p *GCPProvider) Initialize(ctx context.Context) error {
+	return nil
+}
+
 // GetSecret retrieves a secret from GCP Secret Manager
 func (p *GCPProvider) GetSecret(ctx context.Context, key string) (*providers.Secret, error) {
 	name := fmt.Sprintf("projects/%s/secrets/%s/versions/latest", p.projectID, key)
@@ -53,20 +58,24 @@ func (p *GCPProvider) GetSecret(ctx context.Context, key string) (*providers.Sec
 	if err := json.Unmarshal(result.Payload.Data, &secret); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
 	}
+	secret.Name = key
 
 	return &secret, nil
 }
 
 // SetSecret stores a secret in GCP Secret Manager
-func (p *GCPProvider) SetSecret(ctx context.Context, key, value string, metadata map[string]string) error {
-	secret := &providers.Secret{
-		Key:       key,
-		Value:     value,
-		Metadata:  metadata,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+func (p *GCPProvider) SetSecret(ctx context.Context, secret *providers.Secret) error {
+	if err := secret.Validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if secret.CreatedAt.IsZero() {
+		secret.CreatedAt = now
 	}
+	secret.UpdatedAt = now
 
+	key := secret.Name
 	data, err := json.Marshal(secret)
 	if err != nil {
 		return fmt.Errorf("failed to marshal secret: %w", err)
@@ -118,30 +127,53 @@ func (p *GCPProvider) DeleteSecret(ctx context.Context, key string) error {
 	return nil
 }
 
-// ListSecrets lists all secrets in GCP Secret Manager with the given prefix
-func (p *GCPProvider) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
-	parent := fmt.Sprintf("projects/%s", p.projectID)
-	it := p.client.ListSecrets(ctx, &secretmanagerpb.ListSecretsRequest{
-		Parent: parent,
-	})
+// ListSecrets lists all secrets in GCP Secret Manager. Values are left out.
+func (p *GCPProvider) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
+	return providers.CollectPages(ctx, p.ListSecretPages(ctx, providers.ListOptions{}))
+}
 
-	var keys []string
-	for {
-		secret, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
+// ListSecretPages lists secrets a page at a time, resuming from the page
+// token of the previous page. Values are only fetched when asked for, with
+// one request per secret.
+func (p *GCPProvider) ListSecretPages(ctx context.Context, opts providers.ListOptions) providers.PageIterator {
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = providers.DefaultPageSize
+	}
+
+	return providers.TokenPages(opts.PageToken, func(ctx context.Context, token string) (*providers.SecretPage, error) {
+		it := p.client.ListSecrets(ctx, &secretmanagerpb.ListSecretsRequest{
+			Parent: fmt.Sprintf("projects/%s", p.projectID),
+		})
+
+		var results []*secretmanagerpb.Secret
+		next, err := iterator.NewPager(it, pageSize, token).NextPage(&results)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list secrets: %w", err)
 		}
 
-		key := path.Base(secret.Name)
-		if strings.HasPrefix(key, prefix) {
-			keys = append(keys, key)
+		page := &providers.SecretPage{NextPageToken: next}
+		for _, result := range results {
+			key := path.Base(result.Name)
+			if !strings.HasPrefix(key, opts.Prefix) {
+				continue
+			}
+
+			secret := &providers.Secret{
+				Name:      key,
+				CreatedAt: result.CreateTime.AsTime(),
+			}
+			if opts.IncludeValues {
+				if secret, err = p.GetSecret(ctx, key); err != nil {
+					return nil, err
+				}
+				secret.Name = key
+			}
+			page.Secrets = append(page.Secrets, secret)
 		}
-	}
 
-	return keys, nil
+		return page, nil
+	})
 }
 
 // RotateSecret rotates a secret according to its rotation policy
@@ -164,14 +196,9 @@ func (p *GCPProvider) RotateSecret(ctx context.Context, key string) error {
 	}
 
 	// Generate new value
-	var newValue string
-	if policy.CustomGenerator != nil {
-		newValue, err = policy.CustomGenerator()
-		if err != nil {
-			return fmt.Errorf("failed to generate new value: %w", err)
-		}
-	} else {
-		newValue = fmt.Sprintf("rotated-%s-%d", secret.Value, time.Now().Unix())
+	newValue, err := providers.GenerateValue(policy)
+	if err != nil {
+		return fmt.Errorf("failed to generate new value: %w", err)
 	}
 
 	// Update metadata
@@ -183,7 +210,8 @@ func (p *GCPProvider) RotateSecret(ctx context.Context, key string) error {
 	secret.Metadata["next_rotation"] = time.Now().Add(policy.Interval).Format(time.RFC3339)
 
 	// Store the new value
-	return p.SetSecret(ctx, key, newValue, secret.Metadata)
+	secret.Value = newValue
+	return p.SetSecret(ctx, secret)
 }
 
 // GetRotationPolicy retrieves the rotation policy for a secret
@@ -228,5 +256,10 @@ func (p *GCPProvider) SetRotationPolicy(ctx context.Context, key string, policy
 	}
 
 	secret.Metadata["rotation_policy"] = string(policyJSON)
-	return p.SetSecret(ctx, key, secret.Value, secret.Metadata)
+	return p.SetSecret(ctx, secret)
+}
+
+// Close closes the Secret Manager client
+func (p *GCPProvider) Close() error {
+	return p.client.Close()
 }
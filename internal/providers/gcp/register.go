@@ -0,0 +1,24 @@
+package gcp
+
+import (
+	"context"
+
+	"github.com/keeper/internal/providers"
+)
+
+func init() {
+	providers.Register(providers.Registration{
+		Type:        "gcp",
+		Description: "Google Cloud Secret Manager",
+		Params: []providers.Param{
+			{Name: "project", Type: providers.ParamString, Required: true, Description: "ID of the Google Cloud project"},
+			{Name: "credentials_file", Type: providers.ParamString, Description: "service account key file, application default credentials otherwise"},
+		},
+		Factory: open,
+	})
+}
+
+// open creates a GCP provider from its registry parameters
+func open(ctx context.Context, params providers.Params) (providers.Provider, error) {
+	return New(ctx, params.String("project"), params.String("credentials_file"))
+}
@@ -0,0 +1,152 @@
+package providers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/keeper/pkg/api"
+)
+
+// DefaultPageSize is the page size of providers without a native default
+const DefaultPageSize = 100
+
+// ErrInvalidPageToken is returned for page tokens that were not issued by
+// the provider
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// The paging types are part of the provider contract in pkg/api
+type (
+	ListOptions  = api.ListOptions
+	SecretPage   = api.SecretPage
+	PageIterator = api.PageIterator
+	Pager        = api.Pager
+)
+
+// ListSecretPages returns an iterator over the pages of secrets of a
+// provider. Providers implementing Pager page natively. The secrets of
+// other providers are listed at once and paged in memory, sorted by name.
+func ListSecretPages(ctx context.Context, p Provider, opts ListOptions) PageIterator {
+	if pager, ok := p.(Pager); ok && CapabilitiesOf(p).Paging {
+		return pager.ListSecretPages(ctx, opts)
+	}
+
+	var (
+		secrets map[string]*Secret
+		names   []string
+	)
+	return TokenPages(opts.PageToken, func(ctx context.Context, token string) (*SecretPage, error) {
+		if secrets == nil {
+			all, err := p.ListSecrets(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			secrets = make(map[string]*Secret, len(all))
+			for _, secret := range all {
+				if strings.HasPrefix(secret.Name, opts.Prefix) {
+					secrets[secret.Name] = secret
+					names = append(names, secret.Name)
+				}
+			}
+			sort.Strings(names)
+		}
+
+		pageNames, next, err := PaginateNames(names, opts.PageSize, token)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &SecretPage{NextPageToken: next}
+		for _, name := range pageNames {
+			secret := secrets[name]
+			if !opts.IncludeValues {
+				withoutValue := *secret
+				withoutValue.Value = ""
+				secret = &withoutValue
+			}
+			page.Secrets = append(page.Secrets, secret)
+		}
+		return page, nil
+	})
+}
+
+// CollectPages reads every page of a listing
+func CollectPages(ctx context.Context, pages PageIterator) ([]*Secret, error) {
+	var secrets []*Secret
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, page.Secrets...)
+	}
+	return secrets, nil
+}
+
+// TokenPages returns an iterator over pages fetched by page token,
+// starting at the given token. It stops after a page without a next token.
+func TokenPages(token string, fetch func(ctx context.Context, token string) (*SecretPage, error)) PageIterator {
+	return &tokenPages{token: token, fetch: fetch}
+}
+
+// tokenPages is a PageIterator over a fetch function
+type tokenPages struct {
+	token string
+	fetch func(ctx context.Context, token string) (*SecretPage, error)
+	done  bool
+}
+
+// More implements the PageIterator interface
+func (t *tokenPages) More() bool {
+	return !t.done
+}
+
+// NextPage implements the PageIterator interface
+func (t *tokenPages) NextPage(ctx context.Context) (*SecretPage, error) {
+	if t.done {
+		return nil, errors.New("no more pages")
+	}
+
+	page, err := t.fetch(ctx, t.token)
+	if err != nil {
+		return nil, err
+	}
+
+	t.token = page.NextPageToken
+	t.done = t.token == ""
+	return page, nil
+}
+
+// PaginateNames returns the page of sorted names that a page token points
+// to, and the token of the page after it. Tokens encode the last name of a
+// page, so a listing resumes in the right place even if secrets were added
+// or removed in between.
+func PaginateNames(names []string, pageSize int, token string) ([]string, string, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	start := 0
+	if token != "" {
+		last, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(last) == 0 {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidPageToken, token)
+		}
+		start = sort.SearchStrings(names, string(last))
+		if start < len(names) && names[start] == string(last) {
+			start++
+		}
+	}
+
+	end := start + pageSize
+	if end >= len(names) {
+		return names[start:], "", nil
+	}
+
+	page := names[start:end]
+	return page, base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1])), nil
+}
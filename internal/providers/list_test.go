@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listOnlyProvider is a Provider that can only list secrets
+type listOnlyProvider struct {
+	Provider
+	secrets []*Secret
+}
+
+func (p *listOnlyProvider) ListSecrets(ctx context.Context) ([]*Secret, error) {
+	return p.secrets, nil
+}
+
+func TestPaginateNames(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+
+	page, next, err := PaginateNames(names, 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, page)
+
+	page, next, err = PaginateNames(names, 2, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, page)
+
+	// Removing the last name of a page does not skip or repeat names
+	page, next, err = PaginateNames([]string{"a", "b", "c", "e"}, 2, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e"}, page)
+	assert.Empty(t, next)
+
+	_, _, err = PaginateNames(names, 2, "not a token!")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
+
+func TestListSecretPages(t *testing.T) {
+	ctx := context.Background()
+	p := &listOnlyProvider{secrets: []*Secret{
+		{Name: "web/key", Value: "3"},
+		{Name: "app/b", Value: "2"},
+		{Name: "app/a", Value: "1"},
+		{Name: "app/c", Value: "4"},
+	}}
+
+	var names []string
+	pages := ListSecretPages(ctx, p, ListOptions{Prefix: "app/", PageSize: 2})
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		require.NoError(t, err)
+		for _, secret := range page.Secrets {
+			assert.Empty(t, secret.Value)
+			names = append(names, secret.Name)
+		}
+	}
+	assert.Equal(t, []string{"app/a", "app/b", "app/c"}, names)
+
+	// The provider's secrets keep their values
+	assert.Equal(t, "1", p.secrets[2].Value)
+
+	page, err := ListSecretPages(ctx, p, ListOptions{PageSize: 1, IncludeValues: true}).NextPage(ctx)
+	require.NoError(t, err)
+	require.Len(t, page.Secrets, 1)
+	assert.Equal(t, "1", page.Secrets[0].Value)
+	assert.NotEmpty(t, page.NextPageToken)
+
+	page, err = ListSecretPages(ctx, p, ListOptions{PageSize: 1, PageToken: page.NextPageToken}).NextPage(ctx)
+	require.NoError(t, err)
+	require.Len(t, page.Secrets, 1)
+	assert.Equal(t, "app/b", page.Secrets[0].Name)
+}
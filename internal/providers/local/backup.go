@@ -0,0 +1,415 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/providers"
+)
+
+// SetBackupDir sets the path of the backup archive written by Backup and
+// read by Restore. Restore also accepts a directory of JSON files written
+// by older versions.
+func (p *LocalProvider) SetBackupDir(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backupDir = dir
+	return nil
+}
+
+// SetBackupOptions sets the format, compression and key of backups
+func (p *LocalProvider) SetBackupOptions(opts backup.Options) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backupOpts = opts
+}
+
+// Backup writes every secret, and optionally its history, to a backup
+// archive along with the schemas of the store
+func (p *LocalProvider) Backup(ctx context.Context) error {
+	unlock, err := p.rlock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if p.backupDir == "" {
+		return fmt.Errorf("backup path not set")
+	}
+
+	var buf bytes.Buffer
+	w, err := backup.NewWriter(&buf, p.backupOpts)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := p.listSecrets()
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	for _, secret := range secrets {
+		if err := w.AddSecret(secret); err != nil {
+			return err
+		}
+		if !p.backupOpts.Versions {
+			continue
+		}
+
+		versions, err := p.historyVersions(secret.Name)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			previous, err := p.readVersion(secret.Name, version)
+			if err != nil {
+				return err
+			}
+			if err := w.AddVersion(previous); err != nil {
+				return err
+			}
+		}
+	}
+
+	schemas, err := p.schemaFiles()
+	if err != nil {
+		return err
+	}
+	for name, data := range schemas {
+		if err := w.AddSchema(name, data); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.backupDir), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := fileutil.WriteFile(p.backupDir, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	return nil
+}
+
+// Restore restores every secret of a backup, storing changed secrets as
+// new versions
+func (p *LocalProvider) Restore(ctx context.Context) error {
+	_, err := p.RestoreBackup(ctx, backup.RestoreOptions{Conflict: backup.ConflictOverwrite})
+	return err
+}
+
+// RestoreBackup compares a backup with the store and restores the secrets
+// selected by opts, returning the plan it followed. All secrets are
+// committed at once, so a failure leaves none of them. Schemas missing
+// from the store are restored along with the secrets.
+func (p *LocalProvider) RestoreBackup(ctx context.Context, opts backup.RestoreOptions) (*backup.RestorePlan, error) {
+	unlock, err := p.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if p.backupDir == "" {
+		return nil, fmt.Errorf("backup path not set")
+	}
+
+	var secrets, versions []*providers.Secret
+	var schemas map[string][]byte
+	if backup.IsArchive(p.backupDir) {
+		secrets, versions, schemas, err = p.readArchive(p.backupDir)
+	} else {
+		secrets, err = p.readBackupDir(p.backupDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := backup.PlanRestore(secrets, versions, p.getSecret, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	// Encrypt any plaintext secrets left by older versions
+	if !p.migrated {
+		if err := p.migrateLegacySecrets(); err != nil {
+			return nil, fmt.Errorf("failed to migrate plaintext secrets: %w", err)
+		}
+		p.migrated = true
+	}
+
+	// Secrets are validated against restored schemas, which are removed
+	// again if the restore fails
+	written, err := p.restoreSchemas(schemas)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := p.restoreItemOps(plan.Restored())
+	if err == nil {
+		err = p.commit(ops)
+	}
+	if err != nil {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		return nil, fmt.Errorf("failed to restore secrets: %w", err)
+	}
+
+	return plan, nil
+}
+
+// restoreItemOps returns the storage operations that write the secrets of
+// a restore plan
+func (p *LocalProvider) restoreItemOps(items []*backup.RestoreItem) ([]storageOp, error) {
+	var ops []storageOp
+	for _, item := range items {
+		secret := *item.Secret
+		secret.Name = item.Target
+
+		itemOps, err := p.restoreOps(&secret, item.Versions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore secret %s: %w", item.Name, err)
+		}
+		ops = append(ops, itemOps...)
+	}
+
+	return ops, nil
+}
+
+// restoreOps returns the storage operations that restore a secret. A
+// secret restored with its history keeps its version numbers, unless the
+// store still holds history under its name: the restored versions then
+// follow that history rather than replace it. A secret restored without
+// its history is stored as a new version.
+func (p *LocalProvider) restoreOps(secret *providers.Secret, history []*providers.Secret) ([]storageOp, error) {
+	if err := p.prepareSecret(secret); err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return p.writeOps(secret)
+	}
+
+	existing, err := p.historyVersions(secret.Name)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	if n := len(existing); n > 0 && existing[n-1] >= history[0].Version {
+		offset = existing[n-1] - history[0].Version + 1
+	}
+
+	// Only the most recent versions are retained
+	var ops []storageOp
+	for len(existing)+len(history) > p.historyRetention {
+		if len(existing) == 0 {
+			history = history[1:]
+			continue
+		}
+		ops = append(ops, storageOp{Key: historyKey(secret.Name, existing[0]), Remove: true})
+		existing = existing[1:]
+	}
+
+	for _, previous := range history {
+		version := *previous
+		version.Name = secret.Name
+		version.Version += offset
+
+		data, err := p.encodeSecret(&version)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, storageOp{Key: historyKey(secret.Name, version.Version), Data: data})
+	}
+
+	secret.Version += offset
+	data, err := p.encodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, storageOp{Key: secretKey(secret.Name), Data: data, Secret: secret}), nil
+}
+
+// VerifyBackup checks that a backup can still be restored: that every
+// entry matches its checksum, decrypts and matches its schema. Directory
+// backups are checked against the master key of the store. It returns an
+// error only if the backup cannot be read at all.
+func (p *LocalProvider) VerifyBackup(ctx context.Context) (*backup.VerifyReport, error) {
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if p.backupDir == "" {
+		return nil, fmt.Errorf("backup path not set")
+	}
+
+	lookup := func(name string) (*providers.Schema, error) {
+		return providers.LoadSchema(filepath.Join(p.baseDir, "schemas", name+".json"))
+	}
+
+	if !backup.IsArchive(p.backupDir) {
+		return p.verifyBackupDir(p.backupDir, lookup)
+	}
+
+	archive, err := backup.Open(p.backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Unlock(p.backupOpts.Key); err != nil {
+		return nil, err
+	}
+
+	return archive.Verify(lookup), nil
+}
+
+// verifyBackupDir checks the secrets of a directory backup
+func (p *LocalProvider) verifyBackupDir(dir string, lookup backup.SchemaLookup) (*backup.VerifyReport, error) {
+	names, err := jsonFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	report := &backup.VerifyReport{Secrets: len(names)}
+	for _, name := range names {
+		path := filepath.FromSlash(name) + ".json"
+		data, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			report.Add(path, err)
+			continue
+		}
+
+		secret, err := p.decodeSecret(name, data)
+		if err == nil {
+			err = backup.VerifySecret(secret, lookup)
+		}
+		if err != nil {
+			report.Add(path, err)
+		}
+	}
+
+	return report, nil
+}
+
+// readArchive reads the secrets, previous versions and schemas of a
+// backup archive
+func (p *LocalProvider) readArchive(path string) ([]*providers.Secret, []*providers.Secret, map[string][]byte, error) {
+	archive, err := backup.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := archive.Unlock(p.backupOpts.Key); err != nil {
+		return nil, nil, nil, err
+	}
+
+	secrets, err := archive.Secrets()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	versions, err := archive.Versions()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	schemas := make(map[string][]byte)
+	for _, entry := range archive.Manifest.Schemas {
+		if schemas[entry.Name], err = archive.Entry(entry); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+	}
+
+	return secrets, versions, schemas, nil
+}
+
+// restoreSchemas writes the schemas missing from the store and returns
+// the paths it wrote
+func (p *LocalProvider) restoreSchemas(schemas map[string][]byte) ([]string, error) {
+	var written []string
+	for name, data := range schemas {
+		schemaPath := filepath.Join(p.baseDir, "schemas", name+".json")
+		if _, err := os.Stat(schemaPath); err == nil {
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(schemaPath), 0700)
+		if err == nil {
+			err = fileutil.WriteFile(schemaPath, data, 0600)
+		}
+		if err != nil {
+			for _, path := range written {
+				os.Remove(path)
+			}
+			return nil, fmt.Errorf("failed to restore schema %s: %w", name, err)
+		}
+		written = append(written, schemaPath)
+	}
+
+	return written, nil
+}
+
+// readBackupDir reads the secrets of a directory backup written by older
+// versions, one JSON file per secret
+func (p *LocalProvider) readBackupDir(dir string) ([]*providers.Secret, error) {
+	names, err := jsonFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var secrets []*providers.Secret
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup file %s: %w", name, err)
+		}
+
+		secret, err := p.decodeSecret(name, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode backup file %s: %w", name, err)
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+// schemaFiles returns the schema files of the store by schema name
+func (p *LocalProvider) schemaFiles() (map[string][]byte, error) {
+	dir := filepath.Join(p.baseDir, "schemas")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read schemas directory: %w", err)
+	}
+
+	schemas := make(map[string][]byte)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema %s: %w", entry.Name(), err)
+		}
+		schemas[strings.TrimSuffix(entry.Name(), ".json")] = data
+	}
+
+	return schemas, nil
+}
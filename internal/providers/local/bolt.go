@@ -0,0 +1,278 @@
+package local
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/providers"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// recordsBucket holds every record of the store by key
+	recordsBucket = []byte("records")
+
+	// Index buckets map <tag>\x00<name>, <schema>\x00<name> and
+	// <timestamp><name> to nothing, for the current versions of secrets
+	tagIndex     = []byte("index_tags")
+	schemaIndex  = []byte("index_schema")
+	createdIndex = []byte("index_created")
+	updatedIndex = []byte("index_updated")
+
+	// sealedIndex lists the secrets whose tags are encrypted and therefore
+	// missing from the tag index
+	sealedIndex = []byte("index_sealed")
+)
+
+// boltStorage keeps every record of the store in a single bbolt database
+// file. Changes are transactional, and secondary indexes on the tags,
+// schema and timestamps of current secret versions are updated in the same
+// transaction.
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// openBoltStorage opens the database at path. Read-only handles share the
+// file, a writable one waits up to timeout for other handles to close.
+func openBoltStorage(path string, writable bool, timeout time.Duration) (*boltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout:  timeout,
+		ReadOnly: !writable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if writable {
+		err := db.Update(func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{recordsBucket, tagIndex, schemaIndex, createdIndex, updatedIndex, sealedIndex} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create database buckets: %w", err)
+		}
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+// Read implements the storage interface
+func (s *boltStorage) Read(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, &os.PathError{Op: "read", Path: key, Err: os.ErrNotExist}
+	}
+	return data, nil
+}
+
+// List implements the storage interface
+func (s *boltStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Commit implements the storage interface
+func (s *boltStorage) Commit(ops []storageOp) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		for _, op := range ops {
+			key := []byte(op.Key)
+			name := strings.TrimPrefix(op.Key, "secrets/")
+			indexed := name != op.Key
+
+			if indexed {
+				if err := updateIndex(tx, name, records.Get(key), false); err != nil {
+					return err
+				}
+			}
+
+			if op.Remove {
+				if err := records.Delete(key); err != nil {
+					return fmt.Errorf("failed to delete %s: %w", op.Key, err)
+				}
+				continue
+			}
+
+			if err := records.Put(key, op.Data); err != nil {
+				return fmt.Errorf("failed to write %s: %w", op.Key, err)
+			}
+			if indexed {
+				if err := updateIndex(tx, name, op.Data, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Close implements the storage interface
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+// SearchIndex implements the indexedStorage interface. Secrets with
+// encrypted tags are always candidates for a tag search.
+func (s *boltStorage) SearchIndex(opts providers.SearchOptions) ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var candidates map[string]bool
+		narrow := func(matches map[string]bool) {
+			if candidates == nil {
+				candidates = matches
+				return
+			}
+			for name := range candidates {
+				if !matches[name] {
+					delete(candidates, name)
+				}
+			}
+		}
+
+		if opts.Schema != "" {
+			narrow(scanIndex(tx.Bucket(schemaIndex), []byte(opts.Schema+"\x00")))
+		}
+		for _, tag := range opts.Tags {
+			matches := scanIndex(tx.Bucket(tagIndex), []byte(tag+"\x00"))
+			for name := range scanIndex(tx.Bucket(sealedIndex), nil) {
+				matches[name] = true
+			}
+			narrow(matches)
+		}
+		if !opts.CreatedAfter.IsZero() {
+			narrow(scanTimeIndex(tx.Bucket(createdIndex), opts.CreatedAfter))
+		}
+
+		if candidates == nil {
+			candidates = scanIndex(tx.Bucket(recordsBucket), []byte("secrets/"))
+		}
+		for name := range candidates {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// updateIndex adds or removes the index entries of the secret file data
+// stored under name
+func updateIndex(tx *bolt.Tx, name string, data []byte, add bool) error {
+	if data == nil {
+		return nil
+	}
+
+	var file secretFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to index secret %s: %w", name, err)
+	}
+
+	entries := map[string][]byte{
+		string(createdIndex): timeKey(file.CreatedAt, name),
+		string(updatedIndex): timeKey(file.UpdatedAt, name),
+	}
+	if file.Schema != "" {
+		entries[string(schemaIndex)] = []byte(file.Schema + "\x00" + name)
+	}
+	if file.SealedMetadata {
+		entries[string(sealedIndex)] = []byte(name)
+	}
+
+	var err error
+	put := func(bucket string, key []byte) {
+		if err != nil {
+			return
+		}
+		if add {
+			err = tx.Bucket([]byte(bucket)).Put(key, nil)
+		} else {
+			err = tx.Bucket([]byte(bucket)).Delete(key)
+		}
+	}
+	for bucket, key := range entries {
+		put(bucket, key)
+	}
+	for _, tag := range file.Tags {
+		put(string(tagIndex), []byte(tag+"\x00"+name))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to index secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// scanIndex returns the names of the index entries starting with prefix
+func scanIndex(b *bolt.Bucket, prefix []byte) map[string]bool {
+	names := make(map[string]bool)
+	if b == nil {
+		return names
+	}
+
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		names[string(k[len(prefix):])] = true
+	}
+	return names
+}
+
+// scanTimeIndex returns the names of the timestamp index entries at or
+// after t
+func scanTimeIndex(b *bolt.Bucket, t time.Time) map[string]bool {
+	names := make(map[string]bool)
+	if b == nil {
+		return names
+	}
+
+	c := b.Cursor()
+	for k, _ := c.Seek(timeKey(t, "")); k != nil; k, _ = c.Next() {
+		names[string(k[8:])] = true
+	}
+	return names
+}
+
+// timeKey returns an index key that sorts by time, then by name
+func timeKey(t time.Time, name string) []byte {
+	key := make([]byte, 8, 8+len(name))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, name...)
+}
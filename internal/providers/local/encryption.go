@@ -0,0 +1,366 @@
+package local
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/providers"
+)
+
+const (
+	// masterKeyName is the keychain entry holding the local master keys
+	masterKeyName = "local-master-key"
+
+	// storeIDFile holds the random ID that binds ciphertexts to this store
+	storeIDFile = "store.id"
+
+	// formatVersion is the current on-disk secret format version.
+	// Version 0 files are plaintext, version 1 files are sealed directly
+	// with the master key, version 2 files are envelopes whose data key
+	// is wrapped by a master key generation, version 3 envelopes are
+	// additionally bound to the secret name and store ID, and version 4
+	// envelopes also authenticate the fields stored in plaintext.
+	formatVersion = 4
+)
+
+// secretFile is the on-disk envelope of a secret
+type secretFile struct {
+	Version   int               `json:"version"`
+	Name      string            `json:"name"`
+	Schema    string            `json:"schema,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Data      string            `json:"data,omitempty"`
+
+	// SecretVersion is the version of the secret, as opposed to the version
+	// of the file format
+	SecretVersion int `json:"secret_version,omitempty"`
+
+	// SealedMetadata is set when tags and metadata are encrypted with the
+	// value, so that indexes know they cannot see them
+	SealedMetadata bool `json:"sealed_metadata,omitempty"`
+
+	// Value is only present in legacy plaintext files
+	Value string `json:"value,omitempty"`
+}
+
+// secretPayload is the encrypted part of a secret file
+type secretPayload struct {
+	Value    string            `json:"value"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+}
+
+// getKeys returns the master key store, creating the first key on first use
+func (p *LocalProvider) getKeys() (*keychain.MasterKeys, error) {
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	if p.keys != nil {
+		return p.keys, nil
+	}
+	if p.keychain == nil {
+		return nil, fmt.Errorf("keychain is required for encryption")
+	}
+
+	keys := keychain.NewMasterKeys(p.keychain, masterKeyName)
+	if _, err := keys.Init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize master key: %w", err)
+	}
+
+	p.keys = keys
+	return keys, nil
+}
+
+// legacyCipher returns the cipher used by version 1 secret files
+func (p *LocalProvider) legacyCipher() (*crypto.Cipher, error) {
+	if p.keychain == nil {
+		return nil, fmt.Errorf("keychain is required for encryption")
+	}
+
+	key, err := p.keychain.Get(masterKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master key: %w", err)
+	}
+
+	return crypto.NewCipher(key)
+}
+
+// DeleteMasterKey removes the current master key from the keychain, along
+// with the pre-envelope key. Secrets encrypted under it become unreadable.
+func (p *LocalProvider) DeleteMasterKey() error {
+	if p.keychain == nil {
+		return fmt.Errorf("keychain is required for encryption")
+	}
+
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	keys := keychain.NewMasterKeys(p.keychain, masterKeyName)
+	id, err := keys.CurrentKeyID()
+	if err != nil {
+		return fmt.Errorf("failed to get master key: %w", err)
+	}
+	if err := keys.DeleteKey(id); err != nil {
+		return fmt.Errorf("failed to delete master key: %w", err)
+	}
+	for _, name := range []string{masterKeyName + "/current", masterKeyName} {
+		if err := p.keychain.Delete(name); err != nil && !errors.Is(err, keychain.ErrKeyNotFound) {
+			return fmt.Errorf("failed to delete master key: %w", err)
+		}
+	}
+
+	p.keys = nil
+	return nil
+}
+
+// getStoreID returns the ID of this store, creating it on first use
+func (p *LocalProvider) getStoreID() (string, error) {
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	if p.storeID != "" {
+		return p.storeID, nil
+	}
+
+	path := filepath.Join(p.baseDir, storeIDFile)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		p.storeID = strings.TrimSpace(string(data))
+		return p.storeID, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read store ID: %w", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate store ID: %w", err)
+	}
+	p.storeID = hex.EncodeToString(id)
+	if err := fileutil.WriteFile(path, []byte(p.storeID+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write store ID: %w", err)
+	}
+
+	return p.storeID, nil
+}
+
+// associatedData binds the ciphertext of a secret file to the secret name
+// and version, the store and the format version, so that a blob copied to
+// another name or store, or relabelled as another version, fails to
+// decrypt. From version 4, it also covers the schema, tags, metadata and
+// timestamps stored beside the ciphertext, so that they cannot be edited
+// either. Files written before versioning have no secret version.
+func (p *LocalProvider) associatedData(file *secretFile) ([]byte, error) {
+	storeID, err := p.getStoreID()
+	if err != nil {
+		return nil, err
+	}
+
+	ad := fmt.Sprintf("keeper:v%d:%s:%s", file.Version, storeID, file.Name)
+	if file.SecretVersion != 0 {
+		ad += fmt.Sprintf("@%d", file.SecretVersion)
+	}
+	if file.Version < 4 {
+		return []byte(ad), nil
+	}
+
+	header, err := file.headerDigest()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ad + ":" + header), nil
+}
+
+// headerDigest returns a hash of the fields a secret file stores in
+// plaintext, in a canonical encoding: map keys are sorted, and empty
+// fields are left out as they are in the file
+func (f *secretFile) headerDigest() (string, error) {
+	header, err := json.Marshal(struct {
+		Schema         string            `json:"schema,omitempty"`
+		Tags           []string          `json:"tags,omitempty"`
+		Metadata       map[string]string `json:"metadata,omitempty"`
+		CreatedAt      time.Time         `json:"created_at"`
+		UpdatedAt      time.Time         `json:"updated_at"`
+		SealedMetadata bool              `json:"sealed_metadata,omitempty"`
+	}{f.Schema, f.Tags, f.Metadata, f.CreatedAt, f.UpdatedAt, f.SealedMetadata})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal secret header: %w", err)
+	}
+
+	sum := sha256.Sum256(header)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// encodeSecret encrypts a secret into its on-disk representation
+func (p *LocalProvider) encodeSecret(secret *providers.Secret) ([]byte, error) {
+	keys, err := p.getKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	file := secretFile{
+		Version:   formatVersion,
+		Name:      secret.Name,
+		Schema:    secret.Schema,
+		CreatedAt: secret.CreatedAt,
+		UpdatedAt: secret.UpdatedAt,
+
+		SecretVersion: secret.Version,
+	}
+	payload := secretPayload{Value: secret.Value}
+	if p.encryptMetadata {
+		file.SealedMetadata = true
+		payload.Metadata = secret.Metadata
+		payload.Tags = secret.Tags
+	} else {
+		file.Metadata = secret.Metadata
+		file.Tags = secret.Tags
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secret payload: %w", err)
+	}
+
+	ad, err := p.associatedData(&file)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := crypto.Seal(keys, plaintext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	file.Data = base64.StdEncoding.EncodeToString(env.Marshal())
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secret: %w", err)
+	}
+
+	return data, nil
+}
+
+// decodeSecret decrypts the on-disk secret stored under name, accepting
+// files written in older formats
+func (p *LocalProvider) decodeSecret(name string, data []byte) (*providers.Secret, error) {
+	var file secretFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
+	}
+	if file.Version >= 3 && file.Name != name {
+		return nil, fmt.Errorf("secret %s: %w", name, crypto.ErrTampered)
+	}
+
+	secret := &providers.Secret{
+		Name:      file.Name,
+		Schema:    file.Schema,
+		Tags:      file.Tags,
+		Metadata:  file.Metadata,
+		Version:   file.SecretVersion,
+		CreatedAt: file.CreatedAt,
+		UpdatedAt: file.UpdatedAt,
+	}
+	if secret.Version == 0 {
+		secret.Version = 1
+	}
+
+	var plaintext []byte
+	switch file.Version {
+	case 0:
+		secret.Value = file.Value
+		return secret, nil
+	case 1:
+		c, err := p.legacyCipher()
+		if err != nil {
+			return nil, err
+		}
+		if plaintext, err = c.Decrypt(file.Data, nil); err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+		}
+	case 2, 3, formatVersion:
+		keys, err := p.getKeys()
+		if err != nil {
+			return nil, err
+		}
+		env, err := file.envelope()
+		if err != nil {
+			return nil, err
+		}
+
+		var ad []byte
+		if file.Version >= 3 {
+			if ad, err = p.associatedData(&file); err != nil {
+				return nil, err
+			}
+		}
+
+		plaintext, err = crypto.Open(keys, env, ad)
+		if errors.Is(err, crypto.ErrTampered) {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported secret format version %d", file.Version)
+	}
+
+	var payload secretPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secret payload: %w", err)
+	}
+
+	secret.Value = payload.Value
+	if payload.Metadata != nil {
+		secret.Metadata = payload.Metadata
+	}
+	if payload.Tags != nil {
+		secret.Tags = payload.Tags
+	}
+
+	return secret, nil
+}
+
+// envelope parses the encrypted data of a version 2 secret file
+func (f *secretFile) envelope() (*crypto.Envelope, error) {
+	raw, err := base64.StdEncoding.DecodeString(f.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret %s: %w", f.Name, err)
+	}
+	return crypto.UnmarshalEnvelope(raw)
+}
+
+// fileVersion returns the format version of a secret file, or -1 if the
+// file cannot be parsed
+func fileVersion(data []byte) int {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return -1
+	}
+	return header.Version
+}
+
+// isLegacyFile reports whether data is a secret file in an older format
+func isLegacyFile(data []byte) bool {
+	version := fileVersion(data)
+	return version >= 0 && version < formatVersion
+}
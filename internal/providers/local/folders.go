@@ -0,0 +1,243 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/keeper/internal/providers"
+)
+
+// ListFolder returns the secrets directly in a folder and the names of its
+// subfolders, or every secret in the subtree when recursive is set
+func (p *LocalProvider) ListFolder(ctx context.Context, folder string, recursive bool) ([]*providers.Secret, []string, error) {
+	folder, err := providers.NormalizeFolder(folder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	if recursive {
+		secrets, err := p.readSecrets(p.secretNames(folder))
+		return secrets, nil, err
+	}
+
+	keys, err := p.store.List(folderPrefix(folder))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read folder %s: %w", folder, err)
+	}
+
+	var names, folders []string
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, folderPrefix(folder))
+		i := strings.Index(rel, "/")
+		if i < 0 {
+			names = append(names, joinName(folder, rel))
+			continue
+		}
+
+		sub := joinName(folder, rel[:i]) + "/"
+		if len(folders) == 0 || folders[len(folders)-1] != sub {
+			folders = append(folders, sub)
+		}
+	}
+
+	secrets, err := p.readSecrets(names, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return secrets, folders, nil
+}
+
+// MoveFolder moves every secret in a folder, with its history, to another
+// folder. Ciphertexts are bound to secret names, so each version is
+// decrypted and sealed again under its new name. All of them are committed
+// at once.
+func (p *LocalProvider) MoveFolder(ctx context.Context, from, to string) error {
+	from, err := providers.NormalizeName(from)
+	if err != nil {
+		return err
+	}
+	to, err = providers.NormalizeFolder(to)
+	if err != nil {
+		return err
+	}
+	if to == from || strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("cannot move folder %s into itself", from)
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	names, err := p.secretNames(from)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("folder %s: %w", from, providers.ErrSecretNotFound)
+	}
+
+	var ops []storageOp
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		target := joinName(to, strings.TrimPrefix(name, from+"/"))
+		if _, err := p.store.Read(secretKey(target)); err == nil {
+			return fmt.Errorf("cannot move %s: secret %s already exists", name, target)
+		}
+
+		moveOps, err := p.moveOps(name, target)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, moveOps...)
+	}
+
+	if err := p.commit(ops); err != nil {
+		return fmt.Errorf("failed to move folder %s: %w", from, err)
+	}
+
+	return nil
+}
+
+// DeleteFolder deletes every secret in a folder and its subfolders, with
+// their history, in a single commit
+func (p *LocalProvider) DeleteFolder(ctx context.Context, folder string) error {
+	folder, err := providers.NormalizeName(folder)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	names, err := p.secretNames(folder)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("folder %s: %w", folder, providers.ErrSecretNotFound)
+	}
+
+	ops, err := p.removeOps(names)
+	if err != nil {
+		return err
+	}
+
+	if err := p.commit(ops); err != nil {
+		return fmt.Errorf("failed to delete folder %s: %w", folder, err)
+	}
+
+	return nil
+}
+
+// moveOps returns the storage operations that move a secret and its
+// history to a new name
+func (p *LocalProvider) moveOps(name, target string) ([]storageOp, error) {
+	secret, err := p.getSecret(name)
+	if err != nil {
+		return nil, err
+	}
+	secret.Name = target
+
+	data, err := p.encodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	ops := []storageOp{
+		{Key: secretKey(target), Data: data, Secret: secret},
+		{Key: secretKey(name), Remove: true},
+	}
+
+	versions, err := p.historyVersions(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		old, err := p.readVersion(name, version)
+		if err != nil {
+			return nil, err
+		}
+		old.Name = target
+
+		data, err := p.encodeSecret(old)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops,
+			storageOp{Key: historyKey(target, version), Data: data},
+			storageOp{Key: historyKey(name, version), Remove: true},
+		)
+	}
+
+	return ops, nil
+}
+
+// secretNames returns the names of every secret in a folder and its
+// subfolders, sorted
+func (p *LocalProvider) secretNames(folder string) ([]string, error) {
+	keys, err := p.store.List(folderPrefix(folder))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, folderPrefix(""))
+	}
+	return keys, nil
+}
+
+// jsonFiles returns the slash-separated paths, without the .json
+// extension, of the JSON files under root, sorted. A missing root has no
+// files.
+func jsonFiles(root string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, strings.TrimSuffix(filepath.ToSlash(rel), ".json"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// joinName joins a folder and a relative secret name
+func joinName(folder, name string) string {
+	if folder == "" {
+		return name
+	}
+	return folder + "/" + name
+}
@@ -0,0 +1,356 @@
+package local
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/providers"
+)
+
+const (
+	// indexKey is the storage key of the search index
+	indexKey = "index"
+
+	// indexVersion is the current search index format version
+	indexVersion = 1
+)
+
+// indexFile is the stored form of the search index. The index holds the
+// tags and metadata of every secret, so it is sealed with the master key
+// like the secrets themselves.
+type indexFile struct {
+	Version int    `json:"version"`
+	Data    string `json:"data"`
+}
+
+// searchIndex holds everything searches look at, without the values
+type searchIndex struct {
+	Secrets map[string]*indexEntry `json:"secrets"`
+}
+
+// indexEntry describes the current version of a secret in the search index
+type indexEntry struct {
+	Schema    string            `json:"schema,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// newIndexEntry returns the search index entry of a secret
+func newIndexEntry(secret *providers.Secret) *indexEntry {
+	return &indexEntry{
+		Schema:    secret.Schema,
+		Tags:      secret.Tags,
+		Metadata:  secret.Metadata,
+		Version:   secret.Version,
+		CreatedAt: secret.CreatedAt,
+		UpdatedAt: secret.UpdatedAt,
+	}
+}
+
+// secret returns the secret described by an entry, without its value
+func (e *indexEntry) secret(name string) *providers.Secret {
+	return &providers.Secret{
+		Name:      name,
+		Schema:    e.Schema,
+		Tags:      e.Tags,
+		Metadata:  e.Metadata,
+		Version:   e.Version,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+// RebuildIndex rebuilds the search index from the stored secrets. Searches
+// rebuild a missing or stale index on their own.
+func (p *LocalProvider) RebuildIndex() error {
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = p.rebuildIndex()
+	return err
+}
+
+// commit applies storage operations together with the matching update of
+// the search index. Writes to current secret versions must carry the
+// secret. An index that cannot be updated is dropped, to be rebuilt by the
+// next search, rather than left stale.
+func (p *LocalProvider) commit(ops []storageOp) error {
+	index, err := p.loadIndex()
+	if err != nil || index == nil {
+		return p.store.Commit(append(ops, storageOp{Key: indexKey, Remove: true}))
+	}
+
+	for _, op := range ops {
+		name := strings.TrimPrefix(op.Key, folderPrefix(""))
+		if name == op.Key {
+			continue
+		}
+
+		switch {
+		case op.Remove:
+			delete(index.Secrets, name)
+		case op.Secret != nil:
+			index.Secrets[name] = newIndexEntry(op.Secret)
+		}
+	}
+
+	indexOp, err := p.indexOp(index)
+	if err != nil {
+		return err
+	}
+	return p.store.Commit(append(ops, indexOp))
+}
+
+// currentIndex returns the search index, or nil if it is missing or stale.
+// The index is stale when it cannot be read or does not list exactly the
+// stored secrets.
+func (p *LocalProvider) currentIndex() (*searchIndex, error) {
+	index, err := p.loadIndex()
+	if err != nil || index == nil {
+		return nil, nil
+	}
+
+	names, err := p.secretNames("")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != len(index.Secrets) {
+		return nil, nil
+	}
+	for _, name := range names {
+		if index.Secrets[name] == nil {
+			return nil, nil
+		}
+	}
+
+	return index, nil
+}
+
+// rebuildIndex decodes every secret and stores a fresh search index. The
+// caller must hold the exclusive store lock.
+func (p *LocalProvider) rebuildIndex() (*searchIndex, error) {
+	secrets, err := p.listSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &searchIndex{Secrets: make(map[string]*indexEntry, len(secrets))}
+	for _, secret := range secrets {
+		index.Secrets[secret.Name] = newIndexEntry(secret)
+	}
+
+	op, err := p.indexOp(index)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.store.Commit([]storageOp{op}); err != nil {
+		return nil, fmt.Errorf("failed to write search index: %w", err)
+	}
+
+	return index, nil
+}
+
+// loadIndex reads and decrypts the search index, returning nil if there is
+// none
+func (p *LocalProvider) loadIndex() (*searchIndex, error) {
+	data, err := p.store.Read(indexKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read search index: %w", err)
+	}
+
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal search index: %w", err)
+	}
+	if file.Version != indexVersion {
+		return nil, nil
+	}
+
+	keys, err := p.getKeys()
+	if err != nil {
+		return nil, err
+	}
+	env, err := indexEnvelope(&file)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := p.indexAssociatedData()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := crypto.Open(keys, env, ad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt search index: %w", err)
+	}
+
+	var index searchIndex
+	if err := json.Unmarshal(plaintext, &index); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal search index: %w", err)
+	}
+	if index.Secrets == nil {
+		index.Secrets = make(map[string]*indexEntry)
+	}
+
+	return &index, nil
+}
+
+// indexOp returns the storage operation that stores the search index,
+// sealed under the current master key
+func (p *LocalProvider) indexOp(index *searchIndex) (storageOp, error) {
+	keys, err := p.getKeys()
+	if err != nil {
+		return storageOp{}, err
+	}
+
+	plaintext, err := json.Marshal(index)
+	if err != nil {
+		return storageOp{}, fmt.Errorf("failed to marshal search index: %w", err)
+	}
+
+	ad, err := p.indexAssociatedData()
+	if err != nil {
+		return storageOp{}, err
+	}
+
+	env, err := crypto.Seal(keys, plaintext, ad)
+	if err != nil {
+		return storageOp{}, fmt.Errorf("failed to encrypt search index: %w", err)
+	}
+
+	data, err := json.Marshal(indexFile{
+		Version: indexVersion,
+		Data:    base64.StdEncoding.EncodeToString(env.Marshal()),
+	})
+	if err != nil {
+		return storageOp{}, fmt.Errorf("failed to marshal search index: %w", err)
+	}
+
+	return storageOp{Key: indexKey, Data: data}, nil
+}
+
+// rewrapIndex re-wraps the data key of the search index under the master
+// key with the given ID. An index that cannot be read is dropped, to be
+// rebuilt when next used.
+func (p *LocalProvider) rewrapIndex(keys crypto.KeyStore, keyID string) error {
+	data, err := p.store.Read(indexKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read search index: %w", err)
+	}
+
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return p.store.Commit([]storageOp{{Key: indexKey, Remove: true}})
+	}
+
+	env, err := indexEnvelope(&file)
+	if err != nil {
+		return p.store.Commit([]storageOp{{Key: indexKey, Remove: true}})
+	}
+	if env.KeyID == keyID {
+		return nil
+	}
+	if err := crypto.Rewrap(keys, env, keyID); err != nil {
+		return fmt.Errorf("failed to rewrap search index: %w", err)
+	}
+	file.Data = base64.StdEncoding.EncodeToString(env.Marshal())
+
+	if data, err = json.Marshal(file); err != nil {
+		return fmt.Errorf("failed to marshal search index: %w", err)
+	}
+	return p.store.Commit([]storageOp{{Key: indexKey, Data: data}})
+}
+
+// indexAssociatedData binds the search index to the store
+func (p *LocalProvider) indexAssociatedData() ([]byte, error) {
+	storeID, err := p.getStoreID()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("keeper:index:v%d:%s", indexVersion, storeID)), nil
+}
+
+// indexEnvelope parses the encrypted data of the search index
+func indexEnvelope(file *indexFile) (*crypto.Envelope, error) {
+	raw, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode search index: %w", err)
+	}
+	return crypto.UnmarshalEnvelope(raw)
+}
+
+// withIndex calls fn with the current search index, rebuilding it first
+// if it is missing or stale
+func (p *LocalProvider) withIndex(fn func(index *searchIndex) error) error {
+	unlock, err := p.rlock()
+	if err != nil {
+		return err
+	}
+
+	index, err := p.currentIndex()
+	if err == nil && index == nil {
+		// Rebuilding writes the index, which needs the exclusive lock
+		unlock()
+		if unlock, err = p.lock(); err != nil {
+			return err
+		}
+		index, err = p.rebuildIndex()
+	}
+	defer unlock()
+	if err != nil {
+		return err
+	}
+
+	return fn(index)
+}
+
+// searchEntries returns the secrets in the index that match, sorted by
+// name. Storage engines with their own indexes first narrow down the
+// entries to those meeting opts.
+func (p *LocalProvider) searchEntries(index *searchIndex, opts providers.SearchOptions, match func(*providers.Secret) bool) ([]*providers.Secret, error) {
+	var names []string
+	if idx, ok := p.store.(indexedStorage); ok {
+		var err error
+		if names, err = idx.SearchIndex(opts); err != nil {
+			return nil, err
+		}
+	} else {
+		for name := range index.Secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var results []*providers.Secret
+	for _, name := range names {
+		entry := index.Secrets[name]
+		if entry == nil {
+			continue
+		}
+
+		secret := entry.secret(name)
+		if match(secret) {
+			results = append(results, secret)
+		}
+	}
+
+	return results, nil
+}
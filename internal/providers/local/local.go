@@ -2,24 +2,43 @@ package local
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/fileutil"
 	"github.com/keeper/internal/keychain"
 	"github.com/keeper/internal/providers"
 )
 
+// journalFile logs multi-file changes so that they can be replayed after a crash
+const journalFile = "journal.json"
+
 // LocalProvider implements the Provider interface using local filesystem storage
 type LocalProvider struct {
-	baseDir   string
-	backupDir string
-	keychain  keychain.Keychain
-	mu        sync.RWMutex
+	baseDir          string
+	backupDir        string
+	backupOpts       backup.Options
+	keychain         keychain.Keychain
+	encryptMetadata  bool
+	migrated         bool
+	lockTimeout      time.Duration
+	historyRetention int
+	trashRetention   time.Duration
+	mu               sync.RWMutex
+
+	store     storage
+	storeRefs int
+	storeMu   sync.Mutex
+
+	keys    *keychain.MasterKeys
+	storeID string
+	keysMu  sync.Mutex
 }
 
 // New creates a new LocalProvider
@@ -29,8 +48,12 @@ func New(baseDir string, kc keychain.Keychain) (*LocalProvider, error) {
 	}
 
 	return &LocalProvider{
-		baseDir:  baseDir,
-		keychain: kc,
+		baseDir:     baseDir,
+		keychain:    kc,
+		lockTimeout: defaultLockTimeout,
+
+		historyRetention: defaultHistoryRetention,
+		trashRetention:   defaultTrashRetention,
 	}, nil
 }
 
@@ -41,6 +64,7 @@ func (p *LocalProvider) Initialize(ctx context.Context) error {
 		filepath.Join(p.baseDir, "secrets"),
 		filepath.Join(p.baseDir, "schemas"),
 		filepath.Join(p.baseDir, "backups"),
+		filepath.Join(p.baseDir, historyDir),
 	}
 
 	for _, dir := range dirs {
@@ -49,45 +73,138 @@ func (p *LocalProvider) Initialize(ctx context.Context) error {
 		}
 	}
 
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Finish any multi-file change interrupted by a crash
+	if _, err := p.journal().Recover(); err != nil {
+		return fmt.Errorf("failed to recover journal: %w", err)
+	}
+
+	if _, err := p.getStoreID(); err != nil {
+		return err
+	}
+
+	// Encrypt any plaintext secrets left by older versions
+	if err := p.migrateLegacySecrets(); err != nil {
+		return fmt.Errorf("failed to migrate plaintext secrets: %w", err)
+	}
+	p.migrated = true
+
 	return nil
 }
 
+// SetEncryptMetadata controls whether metadata and tags are encrypted
+// along with the secret value. When disabled they are stored in the clear
+// so that they can be listed and searched without the master key.
+func (p *LocalProvider) SetEncryptMetadata(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.encryptMetadata = enabled
+}
+
 // Close closes the provider
 func (p *LocalProvider) Close() error {
 	return nil
 }
 
-// GetSecret retrieves a secret by name
+// GetSecret retrieves a secret by name. Expired and disabled secrets are
+// refused unless the GetOptions of ctx allow them.
 func (p *LocalProvider) GetSecret(ctx context.Context, name string) (*providers.Secret, error) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+	name, err := providers.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	path := filepath.Join(p.baseDir, "secrets", name+".json")
-	data, err := ioutil.ReadFile(path)
+	secret, err := p.getSecret(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := providers.GetOptionsOf(ctx).Check(secret, time.Now()); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// getSecret reads the current version of a secret. The caller must hold
+// the store lock.
+func (p *LocalProvider) getSecret(name string) (*providers.Secret, error) {
+	data, err := p.store.Read(secretKey(name))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, providers.ErrSecretNotFound
 		}
-		return nil, fmt.Errorf("failed to read secret file: %w", err)
+		return nil, fmt.Errorf("failed to read secret: %w", err)
 	}
 
-	var secret providers.Secret
-	if err := json.Unmarshal(data, &secret); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
+	return p.decodeSecret(name, data)
+}
+
+// SetSecret stores a secret as a new version, keeping the previous one in
+// the history
+func (p *LocalProvider) SetSecret(ctx context.Context, secret *providers.Secret) error {
+	unlock, err := p.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	return &secret, nil
+	return p.setSecret(secret)
 }
 
-// SetSecret stores a secret
-func (p *LocalProvider) SetSecret(ctx context.Context, secret *providers.Secret) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// setSecret stores a secret as a new version. The caller must hold the
+// store lock.
+func (p *LocalProvider) setSecret(secret *providers.Secret) error {
+	if err := p.prepareSecret(secret); err != nil {
+		return err
+	}
+
+	// Encrypt any plaintext secrets left by older versions
+	if !p.migrated {
+		if err := p.migrateLegacySecrets(); err != nil {
+			return fmt.Errorf("failed to migrate plaintext secrets: %w", err)
+		}
+		p.migrated = true
+	}
+
+	// Encrypt secret and move the current version into the history
+	ops, err := p.writeOps(secret)
+	if err != nil {
+		return err
+	}
+
+	if err := p.commit(ops); err != nil {
+		return fmt.Errorf("failed to write secret: %w", err)
+	}
+
+	return nil
+}
+
+// prepareSecret normalises the name of a secret, validates it against its
+// schema and updates its timestamps before it is stored. Secrets without
+// an expiry time expire after the TTL of their schema, if it has one.
+func (p *LocalProvider) prepareSecret(secret *providers.Secret) error {
+	name, err := providers.NormalizeName(secret.Name)
+	if err != nil {
+		return err
+	}
+	secret.Name = name
 
 	// Load schema if specified
+	var schema *providers.Schema
 	if secret.Schema != "" {
 		schemaPath := filepath.Join(p.baseDir, "schemas", secret.Schema+".json")
-		schema, err := providers.LoadSchema(schemaPath)
+		schema, err = providers.LoadSchema(schemaPath)
 		if err != nil {
 			return fmt.Errorf("failed to load schema: %w", err)
 		}
@@ -110,199 +227,208 @@ func (p *LocalProvider) SetSecret(ctx context.Context, secret *providers.Secret)
 	}
 	secret.UpdatedAt = now
 
-	// Marshal secret
-	data, err := json.MarshalIndent(secret, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal secret: %w", err)
-	}
-
-	// Write to file
-	path := filepath.Join(p.baseDir, "secrets", secret.Name+".json")
-	if err := ioutil.WriteFile(path, data, 0600); err != nil {
-		return fmt.Errorf("failed to write secret file: %w", err)
+	if _, ok := secret.ExpiresAt(); !ok && schema != nil {
+		expiresAt, ok, err := schema.ExpiresAt(now)
+		if err != nil {
+			return err
+		}
+		if ok {
+			secret.SetExpiresAt(expiresAt)
+		}
 	}
 
 	return nil
 }
 
-// DeleteSecret deletes a secret by name
+// journal returns the write-ahead journal of the store
+func (p *LocalProvider) journal() *fileutil.Journal {
+	return fileutil.NewJournal(p.baseDir, journalFile)
+}
+
+// DeleteSecret deletes a secret and its version history, moving them into
+// the trash unless the trash is disabled
 func (p *LocalProvider) DeleteSecret(ctx context.Context, name string) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	name, err := providers.NormalizeName(name)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	path := filepath.Join(p.baseDir, "secrets", name+".json")
-	if err := os.Remove(path); err != nil {
+	if _, err := p.store.Read(secretKey(name)); err != nil {
 		if os.IsNotExist(err) {
 			return providers.ErrSecretNotFound
 		}
-		return fmt.Errorf("failed to delete secret file: %w", err)
+		return fmt.Errorf("failed to delete secret: %w", err)
 	}
 
-	return nil
-}
-
-// ListSecrets lists all secrets
-func (p *LocalProvider) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
-	dir := filepath.Join(p.baseDir, "secrets")
-	files, err := ioutil.ReadDir(dir)
+	ops, err := p.removeOps([]string{name})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secrets directory: %w", err)
+		return err
 	}
 
-	var secrets []*providers.Secret
-	for _, file := range files {
-		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
-
-		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
-		if err != nil {
-			return nil, fmt.Errorf("failed to read secret file %s: %w", file.Name(), err)
-		}
-
-		var secret providers.Secret
-		if err := json.Unmarshal(data, &secret); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal secret %s: %w", file.Name(), err)
-		}
-
-		secrets = append(secrets, &secret)
+	if err := p.commit(ops); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
 	}
 
-	return secrets, nil
+	return nil
 }
 
-// SearchSecrets searches for secrets based on criteria
-func (p *LocalProvider) SearchSecrets(ctx context.Context, opts providers.SearchOptions) ([]*providers.Secret, error) {
-	secrets, err := p.ListSecrets(ctx)
+// deleteOps returns the storage operations that delete a secret and its
+// history
+func (p *LocalProvider) deleteOps(name string) ([]storageOp, error) {
+	versions, err := p.historyVersions(name)
 	if err != nil {
 		return nil, err
 	}
 
-	var results []*providers.Secret
-	for _, secret := range secrets {
-		if !matchesSearch(secret, opts) {
-			continue
-		}
-		results = append(results, secret)
+	ops := []storageOp{{Key: secretKey(name), Remove: true}}
+	for _, version := range versions {
+		ops = append(ops, storageOp{Key: historyKey(name, version), Remove: true})
 	}
+	return ops, nil
+}
 
-	return results, nil
+// ListSecrets lists all secrets
+func (p *LocalProvider) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return p.listSecrets()
 }
 
-// matchesSearch checks if a secret matches the search criteria
-func matchesSearch(secret *providers.Secret, opts providers.SearchOptions) bool {
-	// Check schema
-	if opts.Schema != "" && secret.Schema != opts.Schema {
-		return false
-	}
-
-	// Check tags
-	if len(opts.Tags) > 0 {
-		found := false
-		for _, tag := range opts.Tags {
-			for _, secretTag := range secret.Tags {
-				if tag == secretTag {
-					found = true
-					break
+// ListSecretPages lists secrets a page at a time, in name order. Pages are
+// read from the search index, and values are only decrypted when asked for.
+func (p *LocalProvider) ListSecretPages(ctx context.Context, opts providers.ListOptions) providers.PageIterator {
+	return providers.TokenPages(opts.PageToken, func(ctx context.Context, token string) (*providers.SecretPage, error) {
+		var page *providers.SecretPage
+		err := p.withIndex(func(index *searchIndex) error {
+			var names []string
+			for name := range index.Secrets {
+				if strings.HasPrefix(name, opts.Prefix) {
+					names = append(names, name)
 				}
 			}
-			if !found {
-				return false
-			}
-		}
-	}
+			sort.Strings(names)
 
-	// Check created after
-	if !opts.CreatedAfter.IsZero() && secret.CreatedAt.Before(opts.CreatedAfter) {
-		return false
-	}
+			names, next, err := providers.PaginateNames(names, opts.PageSize, token)
+			if err != nil {
+				return err
+			}
 
-	return true
+			page = &providers.SecretPage{NextPageToken: next}
+			for _, name := range names {
+				secret := index.Secrets[name].secret(name)
+				if opts.IncludeValues {
+					if secret, err = p.getSecret(name); err != nil {
+						return fmt.Errorf("failed to decode secret %s: %w", name, err)
+					}
+				}
+				page.Secrets = append(page.Secrets, secret)
+			}
+			return nil
+		})
+		return page, err
+	})
 }
 
-// SetBackupDir sets the backup directory
-func (p *LocalProvider) SetBackupDir(dir string) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	p.backupDir = dir
-	return nil
+// listSecrets lists all secrets, including those in folders. The caller
+// must hold the store lock.
+func (p *LocalProvider) listSecrets() ([]*providers.Secret, error) {
+	return p.readSecrets(p.secretNames(""))
 }
 
-// Backup creates a backup of all secrets
-func (p *LocalProvider) Backup(ctx context.Context) error {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
-	if p.backupDir == "" {
-		return fmt.Errorf("backup directory not set")
-	}
-
-	// List all secrets
-	secrets, err := p.ListSecrets(ctx)
+// readSecrets decodes the current version of the named secrets, passing
+// through an error from listing them
+func (p *LocalProvider) readSecrets(names []string, err error) ([]*providers.Secret, error) {
 	if err != nil {
-		return fmt.Errorf("failed to list secrets: %w", err)
-	}
-
-	// Create backup directory if it doesn't exist
-	if err := os.MkdirAll(p.backupDir, 0700); err != nil {
-		return fmt.Errorf("failed to create backup directory: %w", err)
+		return nil, err
 	}
 
-	// Backup each secret
-	for _, secret := range secrets {
-		data, err := json.MarshalIndent(secret, "", "  ")
+	var secrets []*providers.Secret
+	for _, name := range names {
+		secret, err := p.getSecret(name)
 		if err != nil {
-			return fmt.Errorf("failed to marshal secret %s: %w", secret.Name, err)
-		}
-
-		path := filepath.Join(p.backupDir, secret.Name+".json")
-		if err := ioutil.WriteFile(path, data, 0600); err != nil {
-			return fmt.Errorf("failed to write backup file: %w", err)
+			return nil, fmt.Errorf("failed to decode secret %s: %w", name, err)
 		}
+		secrets = append(secrets, secret)
 	}
 
-	return nil
+	return secrets, nil
 }
 
-// Restore restores secrets from a backup
-func (p *LocalProvider) Restore(ctx context.Context) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if p.backupDir == "" {
-		return fmt.Errorf("backup directory not set")
-	}
-
-	// Read backup directory
-	files, err := ioutil.ReadDir(p.backupDir)
+// migrateLegacySecrets re-writes plaintext secrets in the encrypted format
+func (p *LocalProvider) migrateLegacySecrets() error {
+	keys, err := p.store.List(folderPrefix(""))
 	if err != nil {
-		return fmt.Errorf("failed to read backup directory: %w", err)
+		return fmt.Errorf("failed to read secrets: %w", err)
 	}
 
-	// Restore each secret
-	for _, file := range files {
-		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+	var ops []storageOp
+	for _, key := range keys {
+		data, err := p.store.Read(key)
+		if err != nil {
+			return fmt.Errorf("failed to read secret %s: %w", key, err)
+		}
+		if !isLegacyFile(data) {
 			continue
 		}
 
-		data, err := ioutil.ReadFile(filepath.Join(p.backupDir, file.Name()))
+		secret, err := p.decodeSecret(strings.TrimPrefix(key, folderPrefix("")), data)
 		if err != nil {
-			return fmt.Errorf("failed to read backup file %s: %w", file.Name(), err)
+			return fmt.Errorf("failed to decode secret %s: %w", key, err)
 		}
 
-		var secret providers.Secret
-		if err := json.Unmarshal(data, &secret); err != nil {
-			return fmt.Errorf("failed to unmarshal backup file %s: %w", file.Name(), err)
+		encoded, err := p.encodeSecret(secret)
+		if err != nil {
+			return err
 		}
+		ops = append(ops, storageOp{Key: key, Data: encoded})
+	}
 
-		if err := p.SetSecret(ctx, &secret); err != nil {
-			return fmt.Errorf("failed to restore secret %s: %w", secret.Name, err)
-		}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := p.store.Commit(ops); err != nil {
+		return fmt.Errorf("failed to write secrets: %w", err)
 	}
 
 	return nil
 }
+
+// SearchSecrets searches for secrets based on criteria. Searches run
+// against the search index and never decrypt secret values, so the
+// results carry no value.
+func (p *LocalProvider) SearchSecrets(ctx context.Context, opts providers.SearchOptions) ([]*providers.Secret, error) {
+	var results []*providers.Secret
+	err := p.withIndex(func(index *searchIndex) error {
+		var err error
+		results, err = p.searchEntries(index, opts, opts.Matches)
+		return err
+	})
+	return results, err
+}
+
+// QuerySecrets implements the providers.Querier interface. Like searches,
+// queries run against the search index and results carry no value.
+func (p *LocalProvider) QuerySecrets(ctx context.Context, query *providers.Query) ([]*providers.Secret, error) {
+	now := time.Now()
+	match := func(secret *providers.Secret) bool {
+		return query.Match(secret, now)
+	}
+
+	var results []*providers.Secret
+	err := p.withIndex(func(index *searchIndex) error {
+		var err error
+		results, err = p.searchEntries(index, query.Options(now), match)
+		return err
+	})
+	return results, err
+}
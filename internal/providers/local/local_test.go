@@ -2,20 +2,105 @@ package local
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/keychain"
 	"github.com/keeper/internal/providers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// memKeychain keeps keys in memory
+type memKeychain struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func newMemKeychain() *memKeychain {
+	return &memKeychain{keys: make(map[string][]byte)}
+}
+
+// Get implements the keychain.Keychain interface
+func (k *memKeychain) Get(name string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[name]
+	if !ok {
+		return nil, keychain.ErrKeyNotFound
+	}
+	return append([]byte(nil), key...), nil
+}
+
+// Set implements the keychain.Keychain interface
+func (k *memKeychain) Set(name string, key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[name] = append([]byte(nil), key...)
+	return nil
+}
+
+// Delete implements the keychain.Keychain interface
+func (k *memKeychain) Delete(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[name]; !ok {
+		return keychain.ErrKeyNotFound
+	}
+	delete(k.keys, name)
+	return nil
+}
+
+// openTestProvider opens and initializes the store in dir
+func openTestProvider(t *testing.T, dir string, kc keychain.Keychain) *LocalProvider {
+	p, err := New(dir, kc)
+	require.NoError(t, err)
+	require.NoError(t, p.Initialize(context.Background()))
+	return p
+}
+
+// newTestProvider opens a store in a new temporary directory
+func newTestProvider(t *testing.T) *LocalProvider {
+	return openTestProvider(t, t.TempDir(), newMemKeychain())
+}
+
+// setTestSecret stores a secret with the given value
+func setTestSecret(t *testing.T, p *LocalProvider, name, value string) {
+	require.NoError(t, p.SetSecret(context.Background(), providers.NewSecret(name, value)))
+}
+
+// listNames lists the names of the secrets whose names start with prefix
+func listNames(t *testing.T, p *LocalProvider, prefix string) []string {
+	ctx := context.Background()
+	var names []string
+	pages := p.ListSecretPages(ctx, providers.ListOptions{Prefix: prefix})
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		require.NoError(t, err)
+		for _, secret := range page.Secrets {
+			names = append(names, secret.Name)
+		}
+	}
+	return names
+}
+
 func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 	log.Printf("Starting TestLocalProvider_BasicSecretOperations")
-	
+
 	// Create temporary directory for tests
 	tempDir, err := os.MkdirTemp("", "keeper-local-test")
 	if err != nil {
@@ -30,32 +115,31 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 	// Create provider
 	secretsDir := filepath.Join(tempDir, "secrets")
 	log.Printf("Creating provider with secrets directory: %s", secretsDir)
-	provider, err := New(secretsDir)
-	if err != nil {
-		t.Fatal(err)
-	}
+	provider := openTestProvider(t, secretsDir, newMemKeychain())
 
 	ctx := context.Background()
 
 	t.Run("Set and Get Secret", func(t *testing.T) {
 		log.Printf("Running Set and Get Secret test")
-		key := "app/db/password"
+		key := "app-db-password"
 		value := "mysecretpassword"
 		metadata := map[string]string{
 			"environment": "production",
-			"owner":      "dbadmin",
+			"owner":       "dbadmin",
 		}
 
 		// Set a secret
 		log.Printf("Setting secret with key: %s", key)
-		err := provider.SetSecret(ctx, key, value, metadata)
+		secret := providers.NewSecret(key, value)
+		secret.Metadata = metadata
+		err := provider.SetSecret(ctx, secret)
 		if err != nil {
 			t.Fatalf("Failed to set secret: %v", err)
 		}
 
 		// Get the secret back
 		log.Printf("Getting secret with key: %s", key)
-		secret, err := provider.GetSecret(ctx, key)
+		secret, err = provider.GetSecret(ctx, key)
 		if err != nil {
 			t.Fatalf("Failed to get secret: %v", err)
 		}
@@ -72,11 +156,11 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 
 	t.Run("Update Secret", func(t *testing.T) {
 		log.Printf("Running Update Secret test")
-		key := "app/api/key"
-		
+		key := "app-api-key"
+
 		// Set initial secret
 		log.Printf("Setting initial secret with key: %s", key)
-		err := provider.SetSecret(ctx, key, "initial-value", nil)
+		err := provider.SetSecret(ctx, providers.NewSecret(key, "initial-value"))
 		if err != nil {
 			t.Fatalf("Failed to set initial secret: %v", err)
 		}
@@ -93,7 +177,9 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 
 		// Update secret
 		log.Printf("Updating secret")
-		err = provider.SetSecret(ctx, key, "updated-value", map[string]string{"updated": "true"})
+		update := providers.NewSecret(key, "updated-value")
+		update.Metadata = map[string]string{"updated": "true"}
+		err = provider.SetSecret(ctx, update)
 		if err != nil {
 			t.Fatalf("Failed to update secret: %v", err)
 		}
@@ -108,7 +194,7 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 		// Verify changes
 		assert.Equal(t, "updated-value", updated.Value)
 		assert.Equal(t, "true", updated.Metadata["updated"])
-		assert.Equal(t, initial.CreatedAt, updated.CreatedAt)
+		assert.True(t, initial.CreatedAt.Equal(updated.CreatedAt))
 		assert.True(t, updated.UpdatedAt.After(initial.UpdatedAt))
 		log.Printf("Successfully verified updated secret")
 
@@ -118,11 +204,11 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 
 	t.Run("Delete Secret", func(t *testing.T) {
 		log.Printf("Running Delete Secret test")
-		key := "app/secret/to-delete"
-		
+		key := "app-secret-to-delete"
+
 		// Set a secret
 		log.Printf("Setting secret to delete")
-		err := provider.SetSecret(ctx, key, "delete-me", nil)
+		err := provider.SetSecret(ctx, providers.NewSecret(key, "delete-me"))
 		if err != nil {
 			t.Fatalf("Failed to set secret: %v", err)
 		}
@@ -144,7 +230,7 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 		// Verify it's gone
 		log.Printf("Verifying secret is deleted")
 		_, err = provider.GetSecret(ctx, key)
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
 		log.Printf("Successfully verified deletion")
 	})
 
@@ -152,9 +238,9 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 		log.Printf("Running List Secrets test")
 		// Create multiple secrets
 		secrets := map[string]string{
-			"app1/secret1": "value1",
-			"app1/secret2": "value2",
-			"app2/secret1": "value3",
+			"app1-secret1": "value1",
+			"app1-secret2": "value2",
+			"app2-secret1": "value3",
 		}
 
 		// Cleanup function
@@ -166,98 +252,38 @@ func TestLocalProvider_BasicSecretOperations(t *testing.T) {
 
 		for k, v := range secrets {
 			log.Printf("Setting test secret: %s", k)
-			err := provider.SetSecret(ctx, k, v, nil)
+			err := provider.SetSecret(ctx, providers.NewSecret(k, v))
 			if err != nil {
 				t.Fatalf("Failed to set test secret %s: %v", k, err)
 			}
 		}
 
-		// List secrets with prefix app1/
-		log.Printf("Listing secrets with prefix app1/")
-		list, err := provider.ListSecrets(ctx, "app1/")
-		if err != nil {
-			t.Fatalf("Failed to list secrets: %v", err)
-		}
+		// List secrets with prefix app1-
+		log.Printf("Listing secrets with prefix app1-")
+		list := listNames(t, provider, "app1-")
 		assert.Len(t, list, 2)
-		assert.Contains(t, list, "app1/secret1")
-		assert.Contains(t, list, "app1/secret2")
-		assert.NotContains(t, list, "app2/secret1")
+		assert.Contains(t, list, "app1-secret1")
+		assert.Contains(t, list, "app1-secret2")
+		assert.NotContains(t, list, "app2-secret1")
 		log.Printf("Successfully verified filtered list")
 
 		// List all secrets
 		log.Printf("Listing all secrets")
-		list, err = provider.ListSecrets(ctx, "")
+		all, err := provider.ListSecrets(ctx)
 		if err != nil {
 			t.Fatalf("Failed to list all secrets: %v", err)
 		}
-		assert.Len(t, list, len(secrets))
-		log.Printf("Successfully verified full list")
-	})
-
-	t.Run("Secret Rotation", func(t *testing.T) {
-		log.Printf("Running Secret Rotation test")
-		key := "app/rotating-secret"
-
-		// Set initial secret
-		log.Printf("Setting initial secret for rotation")
-		err := provider.SetSecret(ctx, key, "initial-value", nil)
-		if err != nil {
-			t.Fatalf("Failed to set initial secret: %v", err)
-		}
-
-		// Cleanup
-		defer provider.DeleteSecret(ctx, key)
-
-		// Set rotation policy
-		log.Printf("Setting rotation policy")
-		now := time.Now()
-		policy := &providers.RotationPolicy{
-			Interval:     24 * time.Hour,
-			Length:       32,
-			CharacterSet: "alphanumeric",
-			LastRotation: now.Add(-48 * time.Hour), // Last rotation was 2 days ago
-			NextRotation: now.Add(-24 * time.Hour), // Next rotation was due yesterday
-		}
-
-		err = provider.SetRotationPolicy(ctx, key, policy)
-		if err != nil {
-			t.Fatalf("Failed to set rotation policy: %v", err)
-		}
-
-		// Get the policy back
-		log.Printf("Getting rotation policy")
-		retrievedPolicy, err := provider.GetRotationPolicy(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to get rotation policy: %v", err)
+		assert.Len(t, all, len(secrets))
+		for _, secret := range all {
+			assert.Equal(t, secrets[secret.Name], secret.Value)
 		}
-		assert.Equal(t, policy.Interval, retrievedPolicy.Interval)
-		assert.Equal(t, policy.CharacterSet, retrievedPolicy.CharacterSet)
-		assert.Equal(t, policy.Length, retrievedPolicy.Length)
-		assert.Equal(t, policy.LastRotation.Unix(), retrievedPolicy.LastRotation.Unix())
-		assert.Equal(t, policy.NextRotation.Unix(), retrievedPolicy.NextRotation.Unix())
-
-		// Rotate the secret
-		log.Printf("Rotating secret")
-		err = provider.RotateSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to rotate secret: %v", err)
-		}
-
-		// Get the rotated secret
-		log.Printf("Getting rotated secret")
-		rotated, err := provider.GetSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to get rotated secret: %v", err)
-		}
-		assert.NotEqual(t, "initial-value", rotated.Value)
-		assert.Equal(t, 32, len(rotated.Value))
-		log.Printf("Successfully verified rotation")
+		log.Printf("Successfully verified full list")
 	})
 }
 
 func TestLocalProvider_ErrorCases(t *testing.T) {
 	log.Printf("Starting TestLocalProvider_ErrorCases")
-	
+
 	// Create temporary directory for tests
 	tempDir, err := os.MkdirTemp("", "keeper-local-test")
 	if err != nil {
@@ -272,291 +298,896 @@ func TestLocalProvider_ErrorCases(t *testing.T) {
 	// Create provider
 	secretsDir := filepath.Join(tempDir, "secrets")
 	log.Printf("Creating provider with secrets directory: %s", secretsDir)
-	provider, err := New(secretsDir)
-	if err != nil {
-		t.Fatal(err)
-	}
+	provider := openTestProvider(t, secretsDir, newMemKeychain())
 
 	ctx := context.Background()
 
 	t.Run("Get Non-existent Secret", func(t *testing.T) {
 		log.Printf("Testing get of non-existent secret")
 		_, err := provider.GetSecret(ctx, "non/existent/secret")
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
 		log.Printf("Got expected error: %v", err)
 	})
 
 	t.Run("Delete Non-existent Secret", func(t *testing.T) {
 		log.Printf("Testing delete of non-existent secret")
 		err := provider.DeleteSecret(ctx, "non/existent/secret")
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
 		log.Printf("Got expected error: %v", err)
 	})
 
 	t.Run("Invalid Secret Path", func(t *testing.T) {
 		log.Printf("Testing invalid secret path")
-		err := provider.SetSecret(ctx, "", "value", nil)
+		err := provider.SetSecret(ctx, providers.NewSecret("", "value"))
 		assert.Error(t, err)
+		err = provider.SetSecret(ctx, providers.NewSecret("../escape", "value"))
+		assert.ErrorIs(t, err, providers.ErrInvalidName)
 		log.Printf("Got expected error: %v", err)
 	})
 
 	t.Run("List Non-existent Directory", func(t *testing.T) {
 		log.Printf("Testing list of non-existent directory")
-		list, err := provider.ListSecrets(ctx, "non/existent/path/")
-		require.NoError(t, err)
+		list := listNames(t, provider, "non/existent/path/")
 		assert.Empty(t, list)
 		log.Printf("Successfully verified empty list")
 	})
 }
 
-func TestLocalProvider_AdvancedRotation(t *testing.T) {
-	log.Printf("Starting TestLocalProvider_AdvancedRotation")
+func TestLocalProvider_EncryptsAtRest(t *testing.T) {
+	ctx := context.Background()
+	for _, sealMetadata := range []bool{false, true} {
+		dir := t.TempDir()
+		kc := newMemKeychain()
+		p := openTestProvider(t, dir, kc)
+		p.SetEncryptMetadata(sealMetadata)
+
+		secret := providers.NewSecret("app-db-password", "plaintext-value")
+		secret.Metadata = map[string]string{"owner": "plaintext-owner"}
+		secret.Tags = []string{"plaintext-tag"}
+		require.NoError(t, p.SetSecret(ctx, secret))
+
+		// The file on disk holds a current-format envelope, never the value
+		data, err := ioutil.ReadFile(filepath.Join(dir, "secrets", "app-db-password.json"))
+		require.NoError(t, err)
+		var file secretFile
+		require.NoError(t, json.Unmarshal(data, &file))
+		assert.Equal(t, formatVersion, file.Version)
+		assert.NotContains(t, string(data), "plaintext-value")
+		if sealMetadata {
+			assert.NotContains(t, string(data), "plaintext-owner")
+			assert.NotContains(t, string(data), "plaintext-tag")
+		} else {
+			assert.Contains(t, string(data), "plaintext-owner")
+		}
 
-	// Create temporary directory for tests
-	tempDir, err := os.MkdirTemp("", "keeper-local-test")
-	if err != nil {
-		t.Fatal(err)
+		// A provider reopened with the same keychain decrypts it
+		got, err := openTestProvider(t, dir, kc).GetSecret(ctx, "app-db-password")
+		require.NoError(t, err)
+		assert.Equal(t, "plaintext-value", got.Value)
+		assert.Equal(t, "plaintext-owner", got.Metadata["owner"])
+		assert.Equal(t, []string{"plaintext-tag"}, got.Tags)
+
+		// Without the master key, nothing can be read
+		_, err = openTestProvider(t, dir, newMemKeychain()).GetSecret(ctx, "app-db-password")
+		assert.Error(t, err)
 	}
-	log.Printf("Created temp directory: %s", tempDir)
-	defer func() {
-		log.Printf("Cleaning up temp directory: %s", tempDir)
-		os.RemoveAll(tempDir)
-	}()
+}
 
-	// Create provider
-	secretsDir := filepath.Join(tempDir, "secrets")
-	log.Printf("Creating provider with secrets directory: %s", secretsDir)
-	provider, err := New(secretsDir)
-	if err != nil {
-		t.Fatal(err)
+func TestLocalProvider_MigratesLegacySecrets(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets", "app-legacy.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{
+  "name": "app-legacy",
+  "value": "legacy-value",
+  "metadata": {"owner": "ops"},
+  "created_at": "2024-01-02T03:04:05Z",
+  "updated_at": "2024-01-02T03:04:05Z"
+}`), 0600))
+
+	// Opening the store encrypts plaintext secrets in place
+	p := openTestProvider(t, dir, newMemKeychain())
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var file secretFile
+	require.NoError(t, json.Unmarshal(data, &file))
+	assert.Equal(t, formatVersion, file.Version)
+	assert.NotContains(t, string(data), "legacy-value")
+
+	secret, err := p.GetSecret(ctx, "app-legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-value", secret.Value)
+	assert.Equal(t, "ops", secret.Metadata["owner"])
+	assert.Equal(t, 2024, secret.CreatedAt.Year())
+}
+
+func TestLocalProvider_DetectsSwappedSecrets(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	kc := newMemKeychain()
+	p := openTestProvider(t, dir, kc)
+	setTestSecret(t, p, "prod-db", "prod-password")
+	setTestSecret(t, p, "dev-db", "dev-password")
+
+	prodPath := filepath.Join(dir, "secrets", "prod-db.json")
+	devPath := filepath.Join(dir, "secrets", "dev-db.json")
+	prod, err := ioutil.ReadFile(prodPath)
+	require.NoError(t, err)
+	dev, err := ioutil.ReadFile(devPath)
+	require.NoError(t, err)
+
+	// Swapped records are detected
+	require.NoError(t, ioutil.WriteFile(prodPath, dev, 0600))
+	require.NoError(t, ioutil.WriteFile(devPath, prod, 0600))
+	_, err = p.GetSecret(ctx, "prod-db")
+	assert.ErrorIs(t, err, crypto.ErrTampered)
+	_, err = p.GetSecret(ctx, "dev-db")
+	assert.ErrorIs(t, err, crypto.ErrTampered)
+
+	// So are records whose name was rewritten to match, as the ciphertext
+	// is bound to the name
+	var file secretFile
+	require.NoError(t, json.Unmarshal(dev, &file))
+	file.Name = "prod-db"
+	relabelled, err := json.Marshal(file)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(prodPath, relabelled, 0600))
+	_, err = p.GetSecret(ctx, "prod-db")
+	assert.ErrorIs(t, err, crypto.ErrTampered)
+
+	// And records copied from another store sharing the master key
+	other := t.TempDir()
+	setTestSecret(t, openTestProvider(t, other, kc), "prod-db", "other-password")
+	copied, err := ioutil.ReadFile(filepath.Join(other, "secrets", "prod-db.json"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(prodPath, copied, 0600))
+	_, err = p.GetSecret(ctx, "prod-db")
+	assert.ErrorIs(t, err, crypto.ErrTampered)
+}
+
+func TestLocalProvider_DetectsEditedHeaders(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := openTestProvider(t, dir, newMemKeychain())
+
+	secret := providers.NewSecret("app-token", "value")
+	secret.Tags = []string{"prod"}
+	secret.Metadata = map[string]string{providers.ExpiresAtKey: "2099-01-01T00:00:00Z"}
+	require.NoError(t, p.SetSecret(ctx, secret))
+
+	path := filepath.Join(dir, "secrets", "app-token.json")
+	original, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	// The fields stored in plaintext beside the ciphertext cannot be
+	// edited without the master key
+	edits := map[string]func(file *secretFile){
+		"Expiry":  func(file *secretFile) { file.Metadata[providers.ExpiresAtKey] = "2199-01-01T00:00:00Z" },
+		"Tags":    func(file *secretFile) { file.Tags = nil },
+		"Schema":  func(file *secretFile) { file.Schema = "database" },
+		"Updated": func(file *secretFile) { file.UpdatedAt = file.UpdatedAt.Add(time.Hour) },
+	}
+	for name, edit := range edits {
+		t.Run(name, func(t *testing.T) {
+			var file secretFile
+			require.NoError(t, json.Unmarshal(original, &file))
+			edit(&file)
+			edited, err := json.Marshal(file)
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(path, edited, 0600))
+
+			_, err = p.GetSecret(ctx, "app-token")
+			assert.ErrorIs(t, err, crypto.ErrTampered)
+		})
 	}
 
+	// The untouched file still decrypts
+	require.NoError(t, ioutil.WriteFile(path, original, 0600))
+	_, err = p.GetSecret(ctx, "app-token")
+	assert.NoError(t, err)
+}
+
+func TestLocalProvider_MigratesVersion3Secrets(t *testing.T) {
 	ctx := context.Background()
+	dir := t.TempDir()
+	kc := newMemKeychain()
+	p := openTestProvider(t, dir, kc)
+
+	// A version 3 file, whose associated data leaves out the metadata
+	file := secretFile{
+		Version:       3,
+		Name:          "app-token",
+		Metadata:      map[string]string{"owner": "ops"},
+		SecretVersion: 1,
+	}
+	keys, err := p.getKeys()
+	require.NoError(t, err)
+	ad, err := p.associatedData(&file)
+	require.NoError(t, err)
+	env, err := crypto.Seal(keys, []byte(`{"value": "v3-value"}`), ad)
+	require.NoError(t, err)
+	file.Data = base64.StdEncoding.EncodeToString(env.Marshal())
+	data, err := json.Marshal(file)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "secrets", "app-token.json")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	// Reopening the store moves it to the current format
+	p = openTestProvider(t, dir, kc)
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &file))
+	assert.Equal(t, formatVersion, file.Version)
+
+	secret, err := p.GetSecret(ctx, "app-token")
+	require.NoError(t, err)
+	assert.Equal(t, "v3-value", secret.Value)
+	assert.Equal(t, "ops", secret.Metadata["owner"])
+}
 
-	t.Run("Custom Generator", func(t *testing.T) {
-		key := "app/custom/secret"
-		log.Printf("Testing rotation with custom generator")
+func TestLocalProvider_DeleteMasterKey(t *testing.T) {
+	ctx := context.Background()
+	kc := newMemKeychain()
+	p := openTestProvider(t, t.TempDir(), kc)
+	setTestSecret(t, p, "app-key", "value")
 
-		// Set initial secret
-		err := provider.SetSecret(ctx, key, "initial-value", nil)
-		if err != nil {
-			t.Fatalf("Failed to set initial secret: %v", err)
-		}
+	require.NoError(t, p.DeleteMasterKey())
+	assert.Empty(t, kc.keys)
 
-		// Cleanup
-		defer provider.DeleteSecret(ctx, key)
-
-		// Set rotation policy with custom generator
-		now := time.Now()
-		policy := &providers.RotationPolicy{
-			Interval:     24 * time.Hour,
-			LastRotation: now.Add(-48 * time.Hour), // Last rotation was 2 days ago
-			NextRotation: now.Add(-24 * time.Hour), // Next rotation was due yesterday
-			CustomGenerator: func() (string, error) {
-				return "custom-generated-value", nil
-			},
-		}
+	// Secrets can no longer be read
+	_, err := p.GetSecret(ctx, "app-key")
+	assert.Error(t, err)
+}
 
-		err = provider.SetRotationPolicy(ctx, key, policy)
-		if err != nil {
-			t.Fatalf("Failed to set rotation policy: %v", err)
+func TestLocalProvider_StoreLock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	kc := newMemKeychain()
+	p := openTestProvider(t, dir, kc)
+	setTestSecret(t, p, "app-key", "value")
+	p.SetLockTimeout(100 * time.Millisecond)
+
+	tests := []struct {
+		name       string
+		exclusive  bool
+		readsBlock bool
+	}{
+		{"Exclusive Lock Blocks Reads And Writes", true, true},
+		{"Shared Lock Blocks Writes", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Another process holding the lock
+			l, err := fileutil.Lock(filepath.Join(dir, lockFile), tt.exclusive, 0)
+			require.NoError(t, err)
+			defer l.Unlock()
+
+			_, err = p.GetSecret(ctx, "app-key")
+			if tt.readsBlock {
+				assert.ErrorIs(t, err, fileutil.ErrLocked)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			err = p.SetSecret(ctx, providers.NewSecret("app-other", "value"))
+			assert.ErrorIs(t, err, fileutil.ErrLocked)
+			assert.Contains(t, err.Error(), "store is locked")
+		})
+	}
+
+	t.Run("Concurrent Writers", func(t *testing.T) {
+		// Two processes writing the same secret lose no version
+		stores := []*LocalProvider{openTestProvider(t, dir, kc), openTestProvider(t, dir, kc)}
+		for _, store := range stores {
+			store.SetHistoryRetention(50)
 		}
 
-		// Rotate the secret
-		err = provider.RotateSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to rotate secret: %v", err)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(store *LocalProvider) {
+				defer wg.Done()
+				assert.NoError(t, store.SetSecret(ctx, providers.NewSecret("app-counter", "value")))
+			}(stores[i%2])
 		}
+		wg.Wait()
 
-		// Get the rotated secret
-		rotated, err := provider.GetSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to get rotated secret: %v", err)
+		versions, err := stores[0].ListSecretVersions(ctx, "app-counter")
+		require.NoError(t, err)
+		require.Len(t, versions, 20)
+		for i, version := range versions {
+			assert.Equal(t, i+1, version.Version)
 		}
-		assert.Equal(t, "custom-generated-value", rotated.Value)
-		assert.Equal(t, "initial-value", rotated.Metadata["previous_value"])
 	})
+}
 
-	t.Run("Not Time to Rotate", func(t *testing.T) {
-		key := "app/not/time"
-		log.Printf("Testing rotation when not yet time")
+func TestLocalProvider_History(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		retention int
+		writes    int
+		retained  []int
+		pruned    int
+	}{
+		{"Full History", 10, 4, []int{1, 2, 3, 4}, 0},
+		{"Pruned History", 2, 5, []int{3, 4, 5}, 2},
+		{"No History", 0, 3, []int{3}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			p.SetHistoryRetention(tt.retention)
+			for i := 1; i <= tt.writes; i++ {
+				setTestSecret(t, p, "app/key", fmt.Sprintf("value-%d", i))
+			}
 
-		// Set initial secret
-		err := provider.SetSecret(ctx, key, "initial-value", nil)
-		if err != nil {
-			t.Fatalf("Failed to set initial secret: %v", err)
-		}
+			versions, err := p.ListSecretVersions(ctx, "app/key")
+			require.NoError(t, err)
+			require.Len(t, versions, len(tt.retained))
+			for i, version := range versions {
+				assert.Equal(t, tt.retained[i], version.Version)
+				assert.Equal(t, fmt.Sprintf("value-%d", tt.retained[i]), version.Value)
+				assert.Equal(t, versions[0].CreatedAt, version.CreatedAt)
+			}
 
-		// Cleanup
-		defer provider.DeleteSecret(ctx, key)
-
-		// Set rotation policy with future rotation time
-		now := time.Now()
-		policy := &providers.RotationPolicy{
-			Interval:     24 * time.Hour,
-			Length:       32,
-			CharacterSet: "alphanumeric",
-			LastRotation: now,                   // Last rotation was now
-			NextRotation: now.Add(24 * time.Hour), // Next rotation is tomorrow
-		}
+			if tt.pruned > 0 {
+				_, err = p.GetSecretVersion(ctx, "app/key", tt.pruned)
+				assert.ErrorIs(t, err, providers.ErrVersionNotFound)
+				_, err = p.RollbackSecret(ctx, "app/key", tt.pruned)
+				assert.ErrorIs(t, err, providers.ErrVersionNotFound)
+			}
 
-		err = provider.SetRotationPolicy(ctx, key, policy)
-		if err != nil {
-			t.Fatalf("Failed to set rotation policy: %v", err)
-		}
+			// Rolling back stores the old value as a new version
+			oldest := tt.retained[0]
+			secret, err := p.RollbackSecret(ctx, "app/key", oldest)
+			require.NoError(t, err)
+			assert.Equal(t, tt.writes+1, secret.Version)
+
+			current, err := p.GetSecret(ctx, "app/key")
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value-%d", oldest), current.Value)
+			assert.Equal(t, tt.writes+1, current.Version)
+			assert.Equal(t, versions[0].CreatedAt, current.CreatedAt)
+
+			if tt.retention > 0 {
+				previous, err := p.GetSecretVersion(ctx, "app/key", tt.writes)
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value-%d", tt.writes), previous.Value)
+			}
+		})
+	}
 
-		// Attempt to rotate
-		err = provider.RotateSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to call rotate: %v", err)
-		}
+	t.Run("Missing Secret", func(t *testing.T) {
+		p := newTestProvider(t)
+		_, err := p.ListSecretVersions(ctx, "app/missing")
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+		_, err = p.RollbackSecret(ctx, "app/missing", 1)
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+	})
+}
 
-		// Verify secret was not rotated
-		secret, err := provider.GetSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to get secret: %v", err)
-		}
-		assert.Equal(t, "initial-value", secret.Value)
-		assert.Empty(t, secret.Metadata["previous_value"])
+func TestLocalProvider_Folders(t *testing.T) {
+	ctx := context.Background()
+	newFolderProvider := func(t *testing.T) *LocalProvider {
+		p := newTestProvider(t)
+		setTestSecret(t, p, "app/a", "a-1")
+		setTestSecret(t, p, "app/a", "a-2")
+		setTestSecret(t, p, "app/b/c", "c")
+		setTestSecret(t, p, "application/d", "d")
+		return p
+	}
+
+	t.Run("List Folder", func(t *testing.T) {
+		p := newFolderProvider(t)
+		secrets, folders, err := p.ListFolder(ctx, "app", false)
+		require.NoError(t, err)
+		require.Len(t, secrets, 1)
+		assert.Equal(t, "app/a", secrets[0].Name)
+		assert.Equal(t, []string{"app/b/"}, folders)
 	})
 
-	t.Run("Different Character Sets", func(t *testing.T) {
-		testCases := []struct {
-			name        string
-			charSet     string
-			validateFn  func(string) bool
-		}{
-			{
-				name:    "numeric",
-				charSet: "numeric",
-				validateFn: func(s string) bool {
-					for _, c := range s {
-						if c < '0' || c > '9' {
-							return false
-						}
-					}
-					return true
-				},
-			},
-			{
-				name:    "hex",
-				charSet: "hex",
-				validateFn: func(s string) bool {
-					for _, c := range s {
-						if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
-							return false
-						}
-					}
-					return true
-				},
-			},
-			{
-				name:    "ascii",
-				charSet: "ascii",
-				validateFn: func(s string) bool {
-					for _, c := range s {
-						if c < '!' || c > '~' {
-							return false
-						}
-					}
-					return true
-				},
-			},
-		}
+	moves := []struct {
+		name     string
+		existing string
+		from     string
+		to       string
+		want     []string
+		wantErr  string
+	}{
+		{name: "Move Folder", from: "app", to: "svc", want: []string{"application/d", "svc/a", "svc/b/c"}},
+		{name: "Move Subfolder To Root", from: "app/b", to: "/", want: []string{"app/a", "application/d", "c"}},
+		{name: "Move Into Itself", from: "app", to: "app/old", wantErr: "cannot move folder app into itself"},
+		{name: "Move Onto Existing Secret", existing: "application/c", from: "app/b", to: "application", wantErr: "cannot move app/b/c: secret application/c already exists"},
+		{name: "Move Missing Folder", from: "missing", to: "svc", wantErr: "folder missing: secret not found"},
+	}
+	for _, tt := range moves {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFolderProvider(t)
+			if tt.existing != "" {
+				setTestSecret(t, p, tt.existing, "value")
+			}
+			before := listNames(t, p, "")
 
-		for _, tc := range testCases {
-			t.Run(tc.name, func(t *testing.T) {
-				key := "app/charset/" + tc.name
-				log.Printf("Testing rotation with %s character set", tc.name)
+			err := p.MoveFolder(ctx, tt.from, tt.to)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, before, listNames(t, p, ""))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, listNames(t, p, ""))
+		})
+	}
 
-				// Set initial secret
-				err := provider.SetSecret(ctx, key, "initial-value", nil)
-				if err != nil {
-					t.Fatalf("Failed to set initial secret: %v", err)
-				}
+	t.Run("Move Keeps History", func(t *testing.T) {
+		p := newFolderProvider(t)
+		require.NoError(t, p.MoveFolder(ctx, "app", "svc"))
 
-				// Cleanup
-				defer provider.DeleteSecret(ctx, key)
-
-				// Set rotation policy
-				now := time.Now()
-				policy := &providers.RotationPolicy{
-					Interval:     24 * time.Hour,
-					Length:       32,
-					CharacterSet: tc.charSet,
-					LastRotation: now.Add(-48 * time.Hour), // Last rotation was 2 days ago
-					NextRotation: now.Add(-24 * time.Hour), // Next rotation was due yesterday
-				}
+		versions, err := p.ListSecretVersions(ctx, "svc/a")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, "a-1", versions[0].Value)
+		assert.Equal(t, "a-2", versions[1].Value)
 
-				err = provider.SetRotationPolicy(ctx, key, policy)
-				if err != nil {
-					t.Fatalf("Failed to set rotation policy: %v", err)
-				}
+		_, err = p.ListSecretVersions(ctx, "app/a")
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+	})
 
-				// Rotate the secret
-				err = provider.RotateSecret(ctx, key)
-				if err != nil {
-					t.Fatalf("Failed to rotate secret: %v", err)
+	t.Run("Delete Folder", func(t *testing.T) {
+		p := newFolderProvider(t)
+		require.NoError(t, p.DeleteFolder(ctx, "app"))
+		assert.Equal(t, []string{"application/d"}, listNames(t, p, ""))
+		assert.ErrorIs(t, p.DeleteFolder(ctx, "app"), providers.ErrSecretNotFound)
+
+		// The folder is restored from the trash with its history
+		require.NoError(t, p.RecoverSecret(ctx, "app"))
+		assert.Equal(t, []string{"app/a", "app/b/c", "application/d"}, listNames(t, p, ""))
+		versions, err := p.ListSecretVersions(ctx, "app/a")
+		require.NoError(t, err)
+		assert.Len(t, versions, 2)
+	})
+}
+
+func TestLocalProvider_MigrateStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	kc := newMemKeychain()
+	p := openTestProvider(t, dir, kc)
+	setTestSecret(t, p, "app/a", "a-1")
+	setTestSecret(t, p, "app/a", "a-2")
+	setTestSecret(t, p, "app/b", "b")
+	setTestSecret(t, p, "old/c", "c")
+	require.NoError(t, p.DeleteSecret(ctx, "old/c"))
+	assert.Equal(t, StorageDir, p.StorageEngine())
+
+	var copied []int
+	for _, tt := range []struct {
+		engine string
+		dbFile bool
+	}{
+		{StorageDB, true},
+		{StorageDir, false},
+	} {
+		t.Run("To "+tt.engine, func(t *testing.T) {
+			n, err := p.MigrateStorage(ctx, tt.engine)
+			require.NoError(t, err)
+			copied = append(copied, n)
+			assert.Equal(t, tt.engine, p.StorageEngine())
+
+			// Only the new copy is left
+			_, err = os.Stat(filepath.Join(dir, dbFile))
+			assert.Equal(t, tt.dbFile, err == nil)
+			_, err = os.Stat(filepath.Join(dir, "secrets", "app", "b.json"))
+			assert.Equal(t, !tt.dbFile, err == nil)
+
+			// Secrets, history and trash are all copied, and a new process
+			// opens the store with the new engine
+			reopened := openTestProvider(t, dir, kc)
+			assert.Equal(t, tt.engine, reopened.StorageEngine())
+			assert.Equal(t, []string{"app/a", "app/b"}, listNames(t, reopened, ""))
+
+			versions, err := reopened.ListSecretVersions(ctx, "app/a")
+			require.NoError(t, err)
+			require.Len(t, versions, 2)
+			assert.Equal(t, "a-1", versions[0].Value)
+			assert.Equal(t, "a-2", versions[1].Value)
+
+			deleted, err := reopened.ListDeletedSecrets(ctx)
+			require.NoError(t, err)
+			require.Len(t, deleted, 1)
+			assert.Equal(t, "old/c", deleted[0].Name)
+
+			_, err = p.MigrateStorage(ctx, tt.engine)
+			assert.EqualError(t, err, "store already uses the "+tt.engine+" storage engine")
+		})
+	}
+	assert.Equal(t, copied[0], copied[1])
+
+	_, err := p.MigrateStorage(ctx, "sqlite")
+	assert.EqualError(t, err, `unknown storage engine "sqlite"`)
+}
+
+func TestLocalProvider_IndexCorruption(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, dir string, data []byte) []byte
+		want    []string
+	}{
+		{"Missing", func(t *testing.T, dir string, data []byte) []byte {
+			return nil
+		}, []string{"app/a", "app/b"}},
+		{"Garbage", func(t *testing.T, dir string, data []byte) []byte {
+			return []byte("not json")
+		}, []string{"app/a", "app/b"}},
+		{"Unknown Version", func(t *testing.T, dir string, data []byte) []byte {
+			return []byte(`{"version": 99, "data": ""}`)
+		}, []string{"app/a", "app/b"}},
+		{"Tampered", func(t *testing.T, dir string, data []byte) []byte {
+			var file indexFile
+			require.NoError(t, json.Unmarshal(data, &file))
+			file.Data = file.Data[:len(file.Data)-8] + "AAAAAAA="
+			tampered, err := json.Marshal(file)
+			require.NoError(t, err)
+			return tampered
+		}, []string{"app/a", "app/b"}},
+		{"Stale", func(t *testing.T, dir string, data []byte) []byte {
+			// A secret removed by hand is still listed
+			require.NoError(t, os.Remove(filepath.Join(dir, "secrets", "app", "b.json")))
+			return data
+		}, []string{"app/a"}},
+	}
+	for _, tt := range tests {
+		for _, write := range []bool{false, true} {
+			name := tt.name
+			if write {
+				name += " Then Write"
+			}
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				p := openTestProvider(t, dir, newMemKeychain())
+				for _, secretName := range []string{"app/a", "app/b"} {
+					secret := providers.NewSecret(secretName, "value")
+					secret.Tags = []string{"db"}
+					require.NoError(t, p.SetSecret(ctx, secret))
+				}
+				require.NoError(t, p.RebuildIndex())
+
+				path := filepath.Join(dir, indexKey+".json")
+				data, err := ioutil.ReadFile(path)
+				require.NoError(t, err)
+				if corrupted := tt.corrupt(t, dir, data); corrupted != nil {
+					require.NoError(t, ioutil.WriteFile(path, corrupted, 0600))
+				} else {
+					require.NoError(t, os.Remove(path))
 				}
 
-				// Get the rotated secret
-				rotated, err := provider.GetSecret(ctx, key)
-				if err != nil {
-					t.Fatalf("Failed to get rotated secret: %v", err)
+				want := append([]string(nil), tt.want...)
+				if write {
+					secret := providers.NewSecret("app/c", "value")
+					secret.Tags = []string{"db"}
+					require.NoError(t, p.SetSecret(ctx, secret))
+					want = append(want, "app/c")
 				}
 
-				assert.NotEqual(t, "initial-value", rotated.Value)
-				assert.Equal(t, 32, len(rotated.Value))
-				assert.True(t, tc.validateFn(rotated.Value))
+				// Searches rebuild the index rather than trust it
+				secrets, err := p.SearchSecrets(ctx, providers.SearchOptions{Tags: []string{"db"}})
+				require.NoError(t, err)
+				names := make([]string, len(secrets))
+				for i, secret := range secrets {
+					names[i] = secret.Name
+				}
+				assert.ElementsMatch(t, want, names)
+
+				// and leave a sound one behind
+				unlock, err := p.rlock()
+				require.NoError(t, err)
+				defer unlock()
+				index, err := p.currentIndex()
+				require.NoError(t, err)
+				require.NotNil(t, index)
+				assert.Len(t, index.Secrets, len(want))
 			})
 		}
-	})
-
-	t.Run("Invalid Character Set", func(t *testing.T) {
-		key := "app/invalid/charset"
-		log.Printf("Testing rotation with invalid character set")
+	}
+}
 
-		// Set initial secret
-		err := provider.SetSecret(ctx, key, "initial-value", nil)
-		if err != nil {
-			t.Fatalf("Failed to set initial secret: %v", err)
+func TestLocalProvider_Trash(t *testing.T) {
+	ctx := context.Background()
+	deletedNames := func(t *testing.T, p *LocalProvider) []string {
+		deleted, err := p.ListDeletedSecrets(ctx)
+		require.NoError(t, err)
+		names := make([]string, len(deleted))
+		for i, secret := range deleted {
+			names[i] = secret.Name
 		}
+		return names
+	}
 
-		// Cleanup
-		defer provider.DeleteSecret(ctx, key)
-
-		// Set rotation policy with invalid character set
-		now := time.Now()
-		policy := &providers.RotationPolicy{
-			Interval:     24 * time.Hour,
-			Length:       32,
-			CharacterSet: "invalid",
-			LastRotation: now.Add(-48 * time.Hour), // Last rotation was 2 days ago
-			NextRotation: now.Add(-24 * time.Hour), // Next rotation was due yesterday
-		}
+	t.Run("Recover Keeps History", func(t *testing.T) {
+		p := newTestProvider(t)
+		setTestSecret(t, p, "app/a", "a-1")
+		setTestSecret(t, p, "app/a", "a-2")
+		require.NoError(t, p.DeleteSecret(ctx, "app/a"))
 
-		err = provider.SetRotationPolicy(ctx, key, policy)
-		if err != nil {
-			t.Fatalf("Failed to set rotation policy: %v", err)
-		}
+		deleted, err := p.ListDeletedSecrets(ctx)
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+		assert.Equal(t, 2, deleted[0].Version)
+		assert.Equal(t, deleted[0].DeletedAt.Add(defaultTrashRetention), deleted[0].PurgeAt)
 
-		// Rotate the secret
-		err = provider.RotateSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to rotate secret: %v", err)
-		}
+		require.NoError(t, p.RecoverSecret(ctx, "app/a"))
+		secret, err := p.GetSecret(ctx, "app/a")
+		require.NoError(t, err)
+		assert.Equal(t, "a-2", secret.Value)
+		versions, err := p.ListSecretVersions(ctx, "app/a")
+		require.NoError(t, err)
+		assert.Len(t, versions, 2)
+		assert.Empty(t, deletedNames(t, p))
+	})
 
-		// Get the rotated secret
-		rotated, err := provider.GetSecret(ctx, key)
-		if err != nil {
-			t.Fatalf("Failed to get rotated secret: %v", err)
-		}
+	t.Run("Recover Most Recent Deletion", func(t *testing.T) {
+		p := newTestProvider(t)
+		setTestSecret(t, p, "app/a", "first")
+		require.NoError(t, p.DeleteSecret(ctx, "app/a"))
+		setTestSecret(t, p, "app/a", "second")
+		require.NoError(t, p.DeleteSecret(ctx, "app/a"))
+		assert.Equal(t, []string{"app/a", "app/a"}, deletedNames(t, p))
 
-		// Should use alphanumeric as default
-		assert.NotEqual(t, "initial-value", rotated.Value)
-		assert.Equal(t, 32, len(rotated.Value))
-		for _, c := range rotated.Value {
-			assert.True(t, (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
-		}
+		require.NoError(t, p.RecoverSecret(ctx, "app/a"))
+		secret, err := p.GetSecret(ctx, "app/a")
+		require.NoError(t, err)
+		assert.Equal(t, "second", secret.Value)
+		assert.Equal(t, []string{"app/a"}, deletedNames(t, p))
+
+		// The older deletion cannot replace the recovered secret
+		assert.EqualError(t, p.RecoverSecret(ctx, "app/a"), "cannot restore app/a: the secret exists")
 	})
+
+	t.Run("Recover Missing Secret", func(t *testing.T) {
+		p := newTestProvider(t)
+		assert.ErrorIs(t, p.RecoverSecret(ctx, "missing"), providers.ErrSecretNotFound)
+	})
+
+	t.Run("Zero Retention", func(t *testing.T) {
+		p := newTestProvider(t)
+		p.SetTrashRetention(0)
+		setTestSecret(t, p, "app/a", "a")
+		require.NoError(t, p.DeleteSecret(ctx, "app/a"))
+
+		assert.Empty(t, deletedNames(t, p))
+		assert.ErrorIs(t, p.RecoverSecret(ctx, "app/a"), providers.ErrSecretNotFound)
+		_, err := p.ListSecretVersions(ctx, "app/a")
+		assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+	})
+
+	purges := []struct {
+		name   string
+		before time.Duration
+		want   int
+		left   []string
+	}{
+		{name: "Purge Before Deletions", before: -time.Hour, want: 0, left: []string{"app/b", "app/a"}},
+		{name: "Purge Everything", before: time.Hour, want: 2, left: []string{}},
+	}
+	for _, tt := range purges {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			setTestSecret(t, p, "app/a", "a")
+			setTestSecret(t, p, "app/b", "b")
+			setTestSecret(t, p, "app/c", "c")
+			require.NoError(t, p.DeleteSecret(ctx, "app/a"))
+			require.NoError(t, p.DeleteSecret(ctx, "app/b"))
+
+			purged, err := p.PurgeDeletedSecrets(ctx, time.Now().Add(tt.before))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, purged)
+			assert.Equal(t, tt.left, deletedNames(t, p))
+			assert.Equal(t, []string{"app/c"}, listNames(t, p, ""))
+		})
+	}
+}
+
+// readEnvelope reads the envelope of a current-format secret file
+func readEnvelope(t *testing.T, path string) *crypto.Envelope {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	var file secretFile
+	require.NoError(t, json.Unmarshal(data, &file))
+	require.Equal(t, formatVersion, file.Version)
+	env, err := file.envelope()
+	require.NoError(t, err)
+	return env
+}
+
+func TestLocalProvider_RotateMasterKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	kc := newMemKeychain()
+	p := openTestProvider(t, dir, kc)
+	setTestSecret(t, p, "app/a", "value-a")
+	setTestSecret(t, p, "app/b", "value-b1")
+	setTestSecret(t, p, "app/b", "value-b2")
+
+	// A plaintext file written by an older version
+	legacyPath := filepath.Join(dir, "secrets", "app", "legacy.json")
+	require.NoError(t, ioutil.WriteFile(legacyPath, []byte(`{"name": "app/legacy", "value": "legacy-value"}`), 0600))
+
+	aPath := filepath.Join(dir, "secrets", "app", "a.json")
+	before := readEnvelope(t, aPath)
+	keys := keychain.NewMasterKeys(kc, masterKeyName)
+	oldKeyID, err := keys.CurrentKeyID()
+	require.NoError(t, err)
+	require.Equal(t, oldKeyID, before.KeyID)
+
+	require.NoError(t, p.RotateMasterKey(ctx, nil))
+
+	// Only the data key is re-wrapped; the ciphertext is unchanged
+	newKeyID, err := keys.CurrentKeyID()
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKeyID, newKeyID)
+	after := readEnvelope(t, aPath)
+	assert.Equal(t, newKeyID, after.KeyID)
+	assert.Equal(t, before.Ciphertext, after.Ciphertext)
+	assert.NotEqual(t, before.WrappedKey, after.WrappedKey)
+
+	// Older formats are re-encrypted, and the old key is gone
+	assert.Equal(t, newKeyID, readEnvelope(t, legacyPath).KeyID)
+	_, err = keys.MasterKey(oldKeyID)
+	assert.ErrorIs(t, err, keychain.ErrKeyNotFound)
+
+	for name, value := range map[string]string{"app/a": "value-a", "app/b": "value-b2", "app/legacy": "legacy-value"} {
+		secret, err := p.GetSecret(ctx, name)
+		require.NoError(t, err)
+		assert.Equal(t, value, secret.Value)
+	}
+	previous, err := p.GetSecretVersion(ctx, "app/b", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "value-b1", previous.Value)
+
+	pending, err := p.PendingRotation()
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+}
+
+func TestLocalProvider_InterruptedRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		finish   func(p *LocalProvider) error
+		keepsKey bool
+	}{
+		{
+			name: "resume",
+			finish: func(p *LocalProvider) error {
+				return p.ResumeRotation(context.Background(), nil)
+			},
+		},
+		{
+			name: "rollback",
+			finish: func(p *LocalProvider) error {
+				return p.RollbackRotation(context.Background(), nil)
+			},
+			keepsKey: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			kc := newMemKeychain()
+			p := openTestProvider(t, dir, kc)
+			setTestSecret(t, p, "app/a", "value-a")
+			setTestSecret(t, p, "app/b", "value-b")
+
+			keys := keychain.NewMasterKeys(kc, masterKeyName)
+			oldKeyID, err := keys.CurrentKeyID()
+			require.NoError(t, err)
+
+			// A cancelled rotation stops after its checkpoint
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			assert.ErrorIs(t, p.RotateMasterKey(ctx, nil), context.Canceled)
+			assert.ErrorIs(t, p.RotateMasterKey(context.Background(), nil), ErrRotationInProgress)
+			pending, err := p.PendingRotation()
+			require.NoError(t, err)
+			require.NotNil(t, pending)
+
+			require.NoError(t, tt.finish(p))
+
+			keyID := pending.NewKeyID
+			if tt.keepsKey {
+				keyID = oldKeyID
+			}
+			for _, name := range []string{"a", "b"} {
+				assert.Equal(t, keyID, readEnvelope(t, filepath.Join(dir, "secrets", "app", name+".json")).KeyID)
+			}
+			current, err := keys.CurrentKeyID()
+			require.NoError(t, err)
+			assert.Equal(t, keyID, current)
+
+			secret, err := p.GetSecret(context.Background(), "app/a")
+			require.NoError(t, err)
+			assert.Equal(t, "value-a", secret.Value)
+
+			pending, err = p.PendingRotation()
+			require.NoError(t, err)
+			assert.Nil(t, pending)
+		})
+	}
+}
+
+func TestLocalProvider_RestoreHistory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := openTestProvider(t, dir, newMemKeychain())
+	setTestSecret(t, p, "app/key", "value-1")
+	setTestSecret(t, p, "app/key", "value-2")
+
+	require.NoError(t, p.SetBackupDir(filepath.Join(t.TempDir(), "backup.tar")))
+	p.SetBackupOptions(backup.Options{Versions: true})
+	require.NoError(t, p.Backup(ctx))
+
+	// Later versions whose current file was then removed by hand
+	setTestSecret(t, p, "app/key", "value-3")
+	setTestSecret(t, p, "app/key", "value-4")
+	require.NoError(t, os.Remove(filepath.Join(dir, "secrets", "app", "key.json")))
+
+	plan, err := p.RestoreBackup(ctx, backup.RestoreOptions{Versions: true})
+	require.NoError(t, err)
+	require.Len(t, plan.Restored(), 1)
+
+	// The restored history follows the versions left in the store
+	versions, err := p.ListSecretVersions(ctx, "app/key")
+	require.NoError(t, err)
+	var values []string
+	for _, version := range versions {
+		values = append(values, version.Value)
+	}
+	assert.Equal(t, []string{"value-1", "value-2", "value-3", "value-1", "value-2"}, values)
+
+	// New versions continue from the restored one
+	setTestSecret(t, p, "app/key", "value-5")
+	secret, err := p.GetSecret(ctx, "app/key")
+	require.NoError(t, err)
+	assert.Equal(t, 6, secret.Version)
+}
+
+func TestLocalProvider_Expiry(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	for name, metadata := range map[string]map[string]string{
+		"app/current":  {providers.ExpiresAtKey: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+		"app/expired":  {providers.ExpiresAtKey: "2020-01-01T00:00:00Z"},
+		"app/disabled": {providers.ExpiresAtKey: "2020-01-01T00:00:00Z", providers.DisabledAtKey: "2020-01-02T00:00:00Z"},
+	} {
+		secret := providers.NewSecret(name, "value")
+		secret.Metadata = metadata
+		require.NoError(t, p.SetSecret(ctx, secret))
+	}
+
+	tests := []struct {
+		name    string
+		opts    providers.GetOptions
+		wantErr map[string]error
+	}{
+		{"Default", providers.GetOptions{}, map[string]error{"app/expired": providers.ErrSecretExpired, "app/disabled": providers.ErrSecretDisabled}},
+		{"Allow Expired", providers.GetOptions{AllowExpired: true}, map[string]error{"app/disabled": providers.ErrSecretDisabled}},
+		{"Allow Disabled", providers.GetOptions{AllowExpired: true, AllowDisabled: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The provider checks expiry itself, with the options of the context
+			for _, name := range []string{"app/current", "app/expired", "app/disabled"} {
+				_, err := p.GetSecret(providers.WithGetOptions(ctx, tt.opts), name)
+				if tt.wantErr[name] != nil {
+					assert.ErrorIs(t, err, tt.wantErr[name], name)
+				} else {
+					assert.NoError(t, err, name)
+				}
+			}
+		})
+	}
 }
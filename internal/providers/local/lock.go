@@ -0,0 +1,91 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/keeper/internal/fileutil"
+)
+
+const (
+	// lockFile is locked by every process accessing the store
+	lockFile = "store.lock"
+
+	// defaultLockTimeout is how long to wait for another process by default
+	defaultLockTimeout = 10 * time.Second
+)
+
+// SetLockTimeout sets how long an operation waits for another process to
+// release the store before failing
+func (p *LocalProvider) SetLockTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lockTimeout = timeout
+}
+
+// lock takes exclusive access to the store, both within this process and
+// across processes, and opens its storage for writing. The returned
+// function releases it.
+func (p *LocalProvider) lock() (func(), error) {
+	p.mu.Lock()
+
+	release, err := p.lockFile(true)
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+
+	if err := p.acquireStorage(true); err != nil {
+		release()
+		p.mu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		p.releaseStorage()
+		release()
+		p.mu.Unlock()
+	}, nil
+}
+
+// rlock takes shared access to the store and opens its storage for
+// reading. The returned function releases it.
+func (p *LocalProvider) rlock() (func(), error) {
+	p.mu.RLock()
+
+	release, err := p.lockFile(false)
+	if err != nil {
+		p.mu.RUnlock()
+		return nil, err
+	}
+
+	if err := p.acquireStorage(false); err != nil {
+		release()
+		p.mu.RUnlock()
+		return nil, err
+	}
+
+	return func() {
+		p.releaseStorage()
+		release()
+		p.mu.RUnlock()
+	}, nil
+}
+
+// lockFile takes the advisory lock on the store's lock file
+func (p *LocalProvider) lockFile(exclusive bool) (func(), error) {
+	l, err := fileutil.Lock(filepath.Join(p.baseDir, lockFile), exclusive, p.lockTimeout)
+	if err != nil {
+		if errors.Is(err, fileutil.ErrLocked) {
+			return nil, fmt.Errorf("store is %w", err)
+		}
+		return nil, err
+	}
+
+	return func() {
+		l.Unlock()
+	}, nil
+}
@@ -0,0 +1,72 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/keeper/internal/config"
+	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/providers"
+)
+
+func init() {
+	providers.Register(providers.Registration{
+		Type:        "local",
+		Description: "Encrypted store on the local filesystem",
+		Params: []providers.Param{
+			{Name: "path", Type: providers.ParamString, Required: true, Description: "directory holding the store"},
+			{Name: "keychain", Type: providers.ParamString, Default: keychain.BackendKeyring, Description: "where master keys are kept: keyring, file, env or vault-transit"},
+			{Name: "key_file", Type: providers.ParamString, Description: "key file of the file and vault-transit keychains, <path>/master.key by default"},
+			{Name: "passphrase_env", Type: providers.ParamString, Description: "variable holding the key file passphrase"},
+			{Name: "passphrase_fd", Type: providers.ParamInt, Description: "file descriptor to read the key file passphrase from"},
+			{Name: "key_env_prefix", Type: providers.ParamString, Description: "prefix of the variables read by the env keychain"},
+			{Name: "transit_address", Type: providers.ParamString, Description: "Vault address of the vault-transit keychain"},
+			{Name: "transit_token", Type: providers.ParamString, Description: "Vault token of the vault-transit keychain"},
+			{Name: "transit_mount", Type: providers.ParamString, Description: "mount path of the transit engine"},
+			{Name: "transit_key", Type: providers.ParamString, Description: "name of the transit key"},
+			{Name: "encrypt_metadata", Type: providers.ParamBool, Default: false, Description: "encrypt metadata and tags along with values"},
+			{Name: "history_retention", Type: providers.ParamInt, Default: defaultHistoryRetention, Description: "previous versions kept per secret"},
+			{Name: "trash_retention_days", Type: providers.ParamInt, Default: int(defaultTrashRetention / (24 * time.Hour)), Description: "days deleted secrets stay recoverable"},
+			{Name: "lock_timeout", Type: providers.ParamDuration, Default: defaultLockTimeout, Description: "how long to wait for another process to release the store"},
+		},
+		Factory: open,
+	})
+}
+
+// open creates a local provider from its registry parameters
+func open(ctx context.Context, params providers.Params) (providers.Provider, error) {
+	dir := params.String("path")
+	encryption := config.EncryptionConfig{
+		Backend:       params.String("keychain"),
+		KeyFile:       params.String("key_file"),
+		PassphraseEnv: params.String("passphrase_env"),
+		PassphraseFD:  params.Int("passphrase_fd"),
+		KeyEnvPrefix:  params.String("key_env_prefix"),
+		Vault: config.VaultTransitConfig{
+			Address: params.String("transit_address"),
+			Token:   params.String("transit_token"),
+			Mount:   params.String("transit_mount"),
+			KeyName: params.String("transit_key"),
+		},
+	}
+	if encryption.KeyFile == "" {
+		encryption.KeyFile = filepath.Join(dir, "master.key")
+	}
+
+	kc, err := keychain.Open(encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keychain: %w", err)
+	}
+
+	p, err := New(dir, kc)
+	if err != nil {
+		return nil, err
+	}
+	p.SetEncryptMetadata(params.Bool("encrypt_metadata"))
+	p.SetHistoryRetention(params.Int("history_retention"))
+	p.SetTrashRetention(time.Duration(params.Int("trash_retention_days")) * 24 * time.Hour)
+	p.SetLockTimeout(params.Duration("lock_timeout"))
+	return p, nil
+}
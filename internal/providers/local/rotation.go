@@ -0,0 +1,444 @@
+package local
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/crypto"
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/keychain"
+	"github.com/keeper/internal/providers"
+)
+
+// rotationFile holds the checkpoint of an in-progress master key rotation
+const rotationFile = "rotation.json"
+
+// ErrRotationInProgress is returned when a rotation is started while an
+// earlier one has neither completed nor been rolled back
+var ErrRotationInProgress = errors.New("a key rotation is already in progress")
+
+// RotationCheckpoint records the progress of a master key rotation so that
+// an interrupted rotation can be resumed or rolled back
+type RotationCheckpoint struct {
+	OldKeyID  string    `json:"old_key_id"`
+	NewKeyID  string    `json:"new_key_id"`
+	StartedAt time.Time `json:"started_at"`
+	Completed []string  `json:"completed"`
+}
+
+// RotationProgress is called after each record is re-wrapped
+type RotationProgress = providers.RotationProgress
+
+// PendingRotation returns the checkpoint of an unfinished rotation, or nil
+func (p *LocalProvider) PendingRotation() (*RotationCheckpoint, error) {
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return p.loadCheckpoint()
+}
+
+// RotateMasterKey generates a new master key, re-wraps the data keys of
+// every secret and backup under it, verifies the result and finally deletes
+// the old key. Progress is checkpointed after every record.
+func (p *LocalProvider) RotateMasterKey(ctx context.Context, progress RotationProgress) error {
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cp, err := p.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp != nil {
+		return ErrRotationInProgress
+	}
+
+	keys, err := p.getKeys()
+	if err != nil {
+		return err
+	}
+
+	oldKeyID, err := keys.CurrentKeyID()
+	if err != nil {
+		return fmt.Errorf("failed to get current master key: %w", err)
+	}
+
+	newKeyID, err := keys.CreateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate master key: %w", err)
+	}
+
+	cp = &RotationCheckpoint{
+		OldKeyID:  oldKeyID,
+		NewKeyID:  newKeyID,
+		StartedAt: time.Now(),
+	}
+	if err := p.saveCheckpoint(cp); err != nil {
+		return err
+	}
+
+	if err := keys.SetCurrentKeyID(newKeyID); err != nil {
+		return err
+	}
+
+	return p.runRotation(ctx, keys, cp, progress)
+}
+
+// ResumeRotation continues an interrupted rotation from its checkpoint
+func (p *LocalProvider) ResumeRotation(ctx context.Context, progress RotationProgress) error {
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cp, err := p.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		return fmt.Errorf("no key rotation in progress")
+	}
+
+	keys, err := p.getKeys()
+	if err != nil {
+		return err
+	}
+	if err := keys.SetCurrentKeyID(cp.NewKeyID); err != nil {
+		return err
+	}
+
+	return p.runRotation(ctx, keys, cp, progress)
+}
+
+// RollbackRotation re-wraps records already moved to the new key back under
+// the old key, then deletes the new key
+func (p *LocalProvider) RollbackRotation(ctx context.Context, progress RotationProgress) error {
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cp, err := p.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		return fmt.Errorf("no key rotation in progress")
+	}
+
+	keys, err := p.getKeys()
+	if err != nil {
+		return err
+	}
+	if err := keys.SetCurrentKeyID(cp.OldKeyID); err != nil {
+		return err
+	}
+
+	records, err := p.encryptedRecords()
+	if err != nil {
+		return err
+	}
+
+	for i, key := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.rewrapRecord(keys, key, cp.OldKeyID); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(i+1, len(records))
+		}
+	}
+
+	if err := p.rewrapIndex(keys, cp.OldKeyID); err != nil {
+		return err
+	}
+
+	if err := keys.DeleteKey(cp.NewKeyID); err != nil && !errors.Is(err, keychain.ErrKeyNotFound) {
+		return fmt.Errorf("failed to delete new master key: %w", err)
+	}
+
+	return p.removeCheckpoint()
+}
+
+// runRotation re-wraps every record not yet recorded in the checkpoint,
+// verifies the store and deletes the old key
+func (p *LocalProvider) runRotation(ctx context.Context, keys *keychain.MasterKeys, cp *RotationCheckpoint, progress RotationProgress) error {
+	records, err := p.encryptedRecords()
+	if err != nil {
+		return err
+	}
+
+	// Checkpoints written before storage engines list file paths
+	done := make(map[string]bool, len(cp.Completed))
+	for _, key := range cp.Completed {
+		done[strings.TrimSuffix(filepath.ToSlash(key), ".json")] = true
+	}
+
+	for i, key := range records {
+		if !done[key] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := p.rewrapRecord(keys, key, cp.NewKeyID); err != nil {
+				return err
+			}
+
+			cp.Completed = append(cp.Completed, key)
+			if err := p.saveCheckpoint(cp); err != nil {
+				return err
+			}
+		}
+		if progress != nil {
+			progress(i+1, len(records))
+		}
+	}
+
+	if err := p.rewrapIndex(keys, cp.NewKeyID); err != nil {
+		return err
+	}
+
+	if err := p.verifyRotation(records, cp.NewKeyID); err != nil {
+		return fmt.Errorf("verification failed, old key kept: %w", err)
+	}
+
+	if err := keys.DeleteKey(cp.OldKeyID); err != nil && !errors.Is(err, keychain.ErrKeyNotFound) {
+		return fmt.Errorf("failed to delete old master key: %w", err)
+	}
+
+	// The pre-envelope key is no longer needed once everything is re-wrapped
+	if err := p.keychain.Delete(masterKeyName); err != nil && !errors.Is(err, keychain.ErrKeyNotFound) {
+		return fmt.Errorf("failed to delete legacy master key: %w", err)
+	}
+
+	return p.removeCheckpoint()
+}
+
+// rewrapRecord re-wraps the data key of a secret record under the master
+// key with the given ID, leaving its ciphertext untouched. Records written
+// in older formats are re-encrypted in the current one instead, which
+// requires keyID to be the current master key.
+func (p *LocalProvider) rewrapRecord(keys crypto.KeyStore, key, keyID string) error {
+	data, err := p.readRecord(key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	var file secretFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", key, err)
+	}
+	if file.Version != formatVersion {
+		return p.reencryptRecord(key)
+	}
+
+	env, err := file.envelope()
+	if err != nil {
+		return err
+	}
+	if env.KeyID == keyID {
+		return nil
+	}
+	if err := crypto.Rewrap(keys, env, keyID); err != nil {
+		return fmt.Errorf("failed to rewrap %s: %w", key, err)
+	}
+	file.Data = base64.StdEncoding.EncodeToString(env.Marshal())
+
+	encoded, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret: %w", err)
+	}
+	if err := p.writeRecord(key, encoded); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// reencryptRecord decrypts a secret record and seals it again under a
+// fresh data key wrapped by the current master key
+func (p *LocalProvider) reencryptRecord(key string) error {
+	data, err := p.readRecord(key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	secret, err := p.decodeSecret(recordSecretName(key), data)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := p.encodeSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	if err := p.writeRecord(key, encoded); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// verifyRotation checks that every record decrypts and is wrapped by keyID
+func (p *LocalProvider) verifyRotation(records []string, keyID string) error {
+	for _, key := range records {
+		data, err := p.readRecord(key)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+
+		var file secretFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to unmarshal %s: %w", key, err)
+		}
+		if file.Version != formatVersion {
+			return fmt.Errorf("%s was not re-encrypted", key)
+		}
+
+		env, err := file.envelope()
+		if err != nil {
+			return err
+		}
+		if env.KeyID != keyID {
+			return fmt.Errorf("%s is still wrapped by key %s", key, env.KeyID)
+		}
+
+		if _, err := p.decodeSecret(recordSecretName(key), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encryptedRecords returns the keys of the current and previous secret
+// versions, including deleted ones, and of the encrypted backup files of
+// the store in a stable order. Plaintext backups are left alone.
+func (p *LocalProvider) encryptedRecords() ([]string, error) {
+	keys, err := p.storageRecords(p.store)
+	if err != nil {
+		return nil, err
+	}
+
+	// The details of trash entries are not encrypted
+	var records []string
+	for _, key := range keys {
+		if _, rest, ok := splitTrashKey(key); !ok || rest != trashInfo {
+			records = append(records, key)
+		}
+	}
+
+	backups, err := jsonFiles(filepath.Join(p.baseDir, "backups"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk backups: %w", err)
+	}
+	for _, name := range backups {
+		key := "backups/" + name
+		data, err := p.readRecord(key)
+		if err != nil {
+			return nil, err
+		}
+		if fileVersion(data) != 0 {
+			records = append(records, key)
+		}
+	}
+
+	sort.Strings(records)
+	return records, nil
+}
+
+// readRecord reads a record of the store. Backups are kept as files
+// whatever the storage engine.
+func (p *LocalProvider) readRecord(key string) ([]byte, error) {
+	if strings.HasPrefix(key, "backups/") {
+		return ioutil.ReadFile(filepath.Join(p.baseDir, filepath.FromSlash(key)+".json"))
+	}
+	return p.store.Read(key)
+}
+
+// writeRecord replaces a record of the store
+func (p *LocalProvider) writeRecord(key string, data []byte) error {
+	if strings.HasPrefix(key, "backups/") {
+		return fileutil.WriteFile(filepath.Join(p.baseDir, filepath.FromSlash(key)+".json"), data, 0600)
+	}
+	return p.store.Commit([]storageOp{{Key: key, Data: data}})
+}
+
+// loadCheckpoint reads the rotation checkpoint, returning nil if none exists
+func (p *LocalProvider) loadCheckpoint() (*RotationCheckpoint, error) {
+	data, err := ioutil.ReadFile(filepath.Join(p.baseDir, rotationFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read rotation checkpoint: %w", err)
+	}
+
+	var cp RotationCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rotation checkpoint: %w", err)
+	}
+
+	return &cp, nil
+}
+
+// saveCheckpoint writes the rotation checkpoint
+func (p *LocalProvider) saveCheckpoint(cp *RotationCheckpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rotation checkpoint: %w", err)
+	}
+
+	if err := fileutil.WriteFile(filepath.Join(p.baseDir, rotationFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write rotation checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// removeCheckpoint deletes the rotation checkpoint
+func (p *LocalProvider) removeCheckpoint() error {
+	if err := os.Remove(filepath.Join(p.baseDir, rotationFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove rotation checkpoint: %w", err)
+	}
+	return nil
+}
+
+// recordSecretName returns the name of the secret stored in a record.
+// Current versions are stored as secrets/<name>, previous versions as
+// history/<name>/<version>, deleted ones below trash/<id>/ and backups as
+// backups/<name>.
+func recordSecretName(key string) string {
+	if _, rest, ok := splitTrashKey(key); ok {
+		return recordSecretName(rest)
+	}
+
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return key
+	}
+	if parts[0] == historyDir {
+		if i := strings.LastIndex(parts[1], "/"); i >= 0 {
+			return parts[1][:i]
+		}
+	}
+	return parts[1]
+}
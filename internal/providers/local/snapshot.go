@@ -0,0 +1,112 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/keeper/internal/backup"
+)
+
+// TakeSnapshot adds a snapshot of every secret and schema of the store to
+// a snapshot repository
+func (p *LocalProvider) TakeSnapshot(ctx context.Context, repo *backup.Repository) (*backup.Snapshot, error) {
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	secrets, err := p.listSecrets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	schemas, err := p.schemaFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := repo.Create(secrets, schemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// RestoreSnapshot returns the store to the state of a snapshot: secrets
+// that changed since are stored as new versions, and secrets created since
+// are deleted. Everything is committed at once, so a failure leaves the
+// store untouched.
+func (p *LocalProvider) RestoreSnapshot(ctx context.Context, repo *backup.Repository, snapshot *backup.Snapshot, dryRun bool) (*backup.RestorePlan, error) {
+	unlock, err := p.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	secrets, err := repo.Secrets(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	schemas, err := repo.Schemas(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	plan, err := backup.PlanRestore(secrets, nil, p.getSecret, backup.RestoreOptions{Conflict: backup.ConflictOverwrite})
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := p.secretNames("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	kept := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		kept[secret.Name] = true
+	}
+	for _, name := range names {
+		if !kept[name] {
+			plan.Removed = append(plan.Removed, name)
+		}
+	}
+	sort.Strings(plan.Removed)
+
+	if dryRun {
+		return plan, nil
+	}
+
+	if !p.migrated {
+		if err := p.migrateLegacySecrets(); err != nil {
+			return nil, fmt.Errorf("failed to migrate plaintext secrets: %w", err)
+		}
+		p.migrated = true
+	}
+
+	written, err := p.restoreSchemas(schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := p.restoreItemOps(plan.Restored())
+	if err == nil && len(plan.Removed) > 0 {
+		var removeOps []storageOp
+		if removeOps, err = p.removeOps(plan.Removed); err == nil {
+			ops = append(ops, removeOps...)
+		}
+	}
+	if err == nil {
+		err = p.commit(ops)
+	}
+	if err != nil {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		return nil, fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	return plan, nil
+}
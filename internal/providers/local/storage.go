@@ -0,0 +1,339 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keeper/internal/fileutil"
+	"github.com/keeper/internal/providers"
+)
+
+const (
+	// StorageDir stores each secret version as a JSON file in a directory tree
+	StorageDir = "dir"
+
+	// StorageDB stores every secret version in a single embedded database file
+	StorageDB = "db"
+
+	// dbFile is the database of stores using the StorageDB engine
+	dbFile = "store.db"
+)
+
+// storage holds the records of a store. Keys are slash-separated paths
+// such as secrets/<name> for current versions and history/<name>/<version>
+// for previous ones.
+type storage interface {
+	// Read returns a record. A missing record satisfies os.IsNotExist.
+	Read(key string) ([]byte, error)
+
+	// List returns the keys below prefix, which ends with a slash, sorted
+	List(prefix string) ([]string, error)
+
+	// Commit applies all operations or, after a crash, none of them
+	Commit(ops []storageOp) error
+
+	// Close releases the storage
+	Close() error
+}
+
+// indexedStorage is implemented by storage engines that can narrow down a
+// search without decoding every secret
+type indexedStorage interface {
+	// SearchIndex returns the names of the secrets that may match opts
+	SearchIndex(opts providers.SearchOptions) ([]string, error)
+}
+
+// storageOp is a single change to a record
+type storageOp struct {
+	Key    string
+	Data   []byte
+	Remove bool
+
+	// Secret is the decoded secret written to a current version, used to
+	// update the search index
+	Secret *providers.Secret
+}
+
+// secretKey returns the key of the current version of a secret
+func secretKey(name string) string {
+	return "secrets/" + name
+}
+
+// folderPrefix returns the prefix of the keys of the secrets in a folder
+// and its subfolders
+func folderPrefix(folder string) string {
+	if folder == "" {
+		return "secrets/"
+	}
+	return "secrets/" + folder + "/"
+}
+
+// StorageEngine returns the storage engine used by the store
+func (p *LocalProvider) StorageEngine() string {
+	if _, err := os.Stat(filepath.Join(p.baseDir, dbFile)); err == nil {
+		return StorageDB
+	}
+	return StorageDir
+}
+
+// openStorage opens the storage engine used by the store. Read-only
+// storage may be shared by several readers.
+func (p *LocalProvider) openStorage(writable bool) (storage, error) {
+	if p.StorageEngine() == StorageDB {
+		return openBoltStorage(filepath.Join(p.baseDir, dbFile), writable, p.lockTimeout)
+	}
+	return &dirStorage{baseDir: p.baseDir, journal: p.journal()}, nil
+}
+
+// acquireStorage opens the storage for the current lock holder, sharing it
+// between concurrent readers. The caller must hold the store lock.
+func (p *LocalProvider) acquireStorage(writable bool) error {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+
+	if p.storeRefs == 0 {
+		s, err := p.openStorage(writable)
+		if err != nil {
+			return fmt.Errorf("failed to open storage: %w", err)
+		}
+		p.store = s
+	}
+	p.storeRefs++
+	return nil
+}
+
+// releaseStorage closes the storage once its last user is done with it
+func (p *LocalProvider) releaseStorage() {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+
+	p.storeRefs--
+	if p.storeRefs == 0 {
+		p.store.Close()
+		p.store = nil
+	}
+}
+
+// MigrateStorage converts the store to another storage engine, copying
+// every current and previous secret version. The old copy is only removed
+// once the new one is complete. It returns the number of records copied.
+func (p *LocalProvider) MigrateStorage(ctx context.Context, engine string) (int, error) {
+	if engine != StorageDir && engine != StorageDB {
+		return 0, fmt.Errorf("unknown storage engine %q", engine)
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if p.StorageEngine() == engine {
+		return 0, fmt.Errorf("store already uses the %s storage engine", engine)
+	}
+
+	records, err := p.storageRecords(p.store)
+	if err != nil {
+		return 0, err
+	}
+
+	var ops []storageOp
+	for _, key := range records {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		data, err := p.store.Read(key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		ops = append(ops, storageOp{Key: key, Data: data})
+	}
+	copied := len(records)
+
+	// The search index is copied as is, or dropped to be rebuilt
+	if data, err := p.store.Read(indexKey); err == nil {
+		ops = append(ops, storageOp{Key: indexKey, Data: data})
+	} else {
+		ops = append(ops, storageOp{Key: indexKey, Remove: true})
+	}
+	records = append(records, indexKey)
+
+	dbPath := filepath.Join(p.baseDir, dbFile)
+	dir := &dirStorage{baseDir: p.baseDir, journal: p.journal()}
+
+	if engine == StorageDB {
+		// Build the database aside and move it into place once complete
+		tmp := dbPath + ".tmp"
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to remove stale database: %w", err)
+		}
+
+		db, err := openBoltStorage(tmp, true, p.lockTimeout)
+		if err != nil {
+			return 0, err
+		}
+		if err := db.Commit(ops); err != nil {
+			db.Close()
+			return 0, fmt.Errorf("failed to write database: %w", err)
+		}
+		if err := db.Close(); err != nil {
+			return 0, fmt.Errorf("failed to close database: %w", err)
+		}
+		if err := os.Rename(tmp, dbPath); err != nil {
+			return 0, fmt.Errorf("failed to move database into place: %w", err)
+		}
+		if err := fileutil.SyncDir(p.baseDir); err != nil {
+			return 0, err
+		}
+
+		db, err = openBoltStorage(dbPath, true, p.lockTimeout)
+		if err != nil {
+			return 0, err
+		}
+		p.store = db
+
+		var removals []storageOp
+		for _, key := range records {
+			removals = append(removals, storageOp{Key: key, Remove: true})
+		}
+		if err := dir.Commit(removals); err != nil {
+			return 0, fmt.Errorf("failed to remove secret files: %w", err)
+		}
+		return copied, nil
+	}
+
+	// Files left behind by an earlier migration may be stale
+	existing, err := p.storageRecords(dir)
+	if err != nil {
+		return 0, err
+	}
+	current := make(map[string]bool, len(records))
+	for _, key := range records {
+		current[key] = true
+	}
+	for _, key := range existing {
+		if !current[key] {
+			ops = append(ops, storageOp{Key: key, Remove: true})
+		}
+	}
+
+	if err := dir.Commit(ops); err != nil {
+		return 0, fmt.Errorf("failed to write secret files: %w", err)
+	}
+
+	if err := p.store.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close database: %w", err)
+	}
+	p.store = dir
+	if err := fileutil.Remove(dbPath); err != nil {
+		return 0, fmt.Errorf("failed to remove database: %w", err)
+	}
+
+	return copied, nil
+}
+
+// storageRecords returns the keys of every current and previous secret
+// version in s, including those in the trash
+func (p *LocalProvider) storageRecords(s storage) ([]string, error) {
+	var records []string
+	for _, prefix := range []string{folderPrefix(""), historyDir + "/", trashDir + "/"} {
+		keys, err := s.List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		records = append(records, keys...)
+	}
+	return records, nil
+}
+
+// dirStorage stores each record as a JSON file under the store directory.
+// Multi-record changes go through the journal.
+type dirStorage struct {
+	baseDir string
+	journal *fileutil.Journal
+}
+
+// Read implements the storage interface
+func (s *dirStorage) Read(key string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(key))
+}
+
+// List implements the storage interface
+func (s *dirStorage) List(prefix string) ([]string, error) {
+	names, err := jsonFiles(filepath.Join(s.baseDir, filepath.FromSlash(prefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = prefix + name
+	}
+	return keys, nil
+}
+
+// Commit implements the storage interface. A single write is atomic on its
+// own and skips the journal.
+func (s *dirStorage) Commit(ops []storageOp) error {
+	if len(ops) == 1 && !ops[0].Remove {
+		path := s.path(ops[0].Key)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", ops[0].Key, err)
+		}
+		return fileutil.WriteFile(path, ops[0].Data, 0600)
+	}
+
+	fileOps := make([]fileutil.Op, len(ops))
+	for i, op := range ops {
+		fileOps[i] = fileutil.Op{
+			Path:   filepath.FromSlash(op.Key) + ".json",
+			Data:   op.Data,
+			Remove: op.Remove,
+		}
+	}
+	if err := s.journal.Commit(fileOps); err != nil {
+		return err
+	}
+
+	// Folders only exist while they hold secrets
+	for _, op := range ops {
+		if op.Remove {
+			s.removeEmptyParents(s.path(op.Key))
+		}
+	}
+	return nil
+}
+
+// Close implements the storage interface
+func (s *dirStorage) Close() error {
+	return nil
+}
+
+// path returns the file holding a record
+func (s *dirStorage) path(key string) string {
+	return filepath.Join(s.baseDir, filepath.FromSlash(key)+".json")
+}
+
+// removeEmptyParents removes the parents of path that are left empty,
+// keeping the top-level store directories
+func (s *dirStorage) removeEmptyParents(path string) {
+	for d := filepath.Dir(path); s.isNestedDir(d); d = filepath.Dir(d) {
+		if os.Remove(d) != nil {
+			return
+		}
+	}
+}
+
+// isNestedDir reports whether dir is below a top-level store directory
+func (s *dirStorage) isNestedDir(dir string) bool {
+	rel, err := filepath.Rel(s.baseDir, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return strings.Contains(filepath.ToSlash(rel), "/")
+}
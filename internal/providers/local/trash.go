@@ -0,0 +1,392 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/providers"
+)
+
+const (
+	// trashDir holds deleted secrets as trash/<id>/<key>, where key is the
+	// key the record had before the deletion
+	trashDir = "trash"
+
+	// trashInfo is the key of the deletion details within a trash entry
+	trashInfo = "info"
+
+	// defaultTrashRetention is how long deleted secrets are kept by default
+	defaultTrashRetention = 30 * 24 * time.Hour
+)
+
+// trashEntry holds the details of a deletion. A folder deletion moves
+// every secret in the folder into a single entry.
+type trashEntry struct {
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+}
+
+// trashedSecret is a secret in a trash entry
+type trashedSecret struct {
+	id    string
+	name  string
+	entry *trashEntry
+}
+
+// SetTrashRetention sets how long deleted secrets are kept in the trash.
+// Secrets deleted longer ago are purged by the next deletion; zero deletes
+// secrets outright.
+func (p *LocalProvider) SetTrashRetention(retention time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if retention < 0 {
+		retention = 0
+	}
+	p.trashRetention = retention
+}
+
+// ListDeletedSecrets returns the secrets in the trash, most recently
+// deleted first
+func (p *LocalProvider) ListDeletedSecrets(ctx context.Context) ([]*providers.DeletedSecret, error) {
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	trashed, err := p.trashedSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []*providers.DeletedSecret
+	for _, t := range trashed {
+		data, err := p.store.Read(trashKey(t.id, secretKey(t.name)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deleted secret %s: %w", t.name, err)
+		}
+
+		secret := &providers.DeletedSecret{
+			Name:      t.name,
+			Version:   secretVersion(data),
+			DeletedAt: t.entry.DeletedAt,
+			DeletedBy: t.entry.DeletedBy,
+		}
+		if p.trashRetention > 0 {
+			secret.PurgeAt = t.entry.DeletedAt.Add(p.trashRetention)
+		}
+		deleted = append(deleted, secret)
+	}
+
+	return deleted, nil
+}
+
+// RecoverSecret restores the most recently deleted secret with the given
+// name, with its history. Given a folder, it restores every secret deleted
+// from the folder, each from its most recent deletion.
+func (p *LocalProvider) RecoverSecret(ctx context.Context, name string) error {
+	name, err := providers.NormalizeName(name)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trashed, err := p.trashedSecrets()
+	if err != nil {
+		return err
+	}
+
+	// Restore the secret itself or, failing that, the folder
+	var restore []*trashedSecret
+	for _, matches := range []func(string) bool{
+		func(n string) bool { return n == name },
+		func(n string) bool { return strings.HasPrefix(n, name+"/") },
+	} {
+		seen := make(map[string]bool)
+		for _, t := range trashed {
+			if matches(t.name) && !seen[t.name] {
+				restore = append(restore, t)
+				seen[t.name] = true
+			}
+		}
+		if len(restore) > 0 {
+			break
+		}
+	}
+	if len(restore) == 0 {
+		return fmt.Errorf("%s is not in the trash: %w", name, providers.ErrSecretNotFound)
+	}
+
+	var ops []storageOp
+	for _, t := range restore {
+		recoverOps, err := p.recoverOps(t)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, recoverOps...)
+	}
+
+	// Entries are removed along with their last secret
+	remaining := make(map[string]int)
+	for _, t := range trashed {
+		remaining[t.id]++
+	}
+	for _, t := range restore {
+		if remaining[t.id]--; remaining[t.id] == 0 {
+			ops = append(ops, storageOp{Key: trashKey(t.id, trashInfo), Remove: true})
+		}
+	}
+
+	if err := p.commit(ops); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// PurgeDeletedSecrets permanently deletes the secrets deleted before a
+// given time, and returns how many were purged
+func (p *LocalProvider) PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error) {
+	unlock, err := p.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	ops, purged, err := p.purgeOps(before)
+	if err != nil {
+		return 0, err
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+
+	if err := p.store.Commit(ops); err != nil {
+		return 0, fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	return purged, nil
+}
+
+// removeOps returns the storage operations that delete secrets. With a
+// trash retention, the secrets and their history are moved into a new
+// trash entry, and entries past the retention are purged.
+func (p *LocalProvider) removeOps(names []string) ([]storageOp, error) {
+	var ops []storageOp
+	if p.trashRetention == 0 {
+		for _, name := range names {
+			deleteOps, err := p.deleteOps(name)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, deleteOps...)
+		}
+		return ops, nil
+	}
+
+	now := time.Now().UTC()
+	ops, _, err := p.purgeOps(now.Add(-p.trashRetention))
+	if err != nil {
+		return nil, err
+	}
+
+	id := strconv.FormatInt(now.UnixNano(), 10)
+	for {
+		if _, err := p.store.Read(trashKey(id, trashInfo)); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+		id = strconv.FormatInt(now.UnixNano(), 10)
+	}
+
+	info, err := json.Marshal(trashEntry{DeletedAt: now, DeletedBy: currentUser()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal trash entry: %w", err)
+	}
+	ops = append(ops, storageOp{Key: trashKey(id, trashInfo), Data: info})
+
+	for _, name := range names {
+		deleteOps, err := p.deleteOps(name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Move each record rather than only removing it
+		for _, op := range deleteOps {
+			data, err := p.store.Read(op.Key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", op.Key, err)
+			}
+			ops = append(ops, op, storageOp{Key: trashKey(id, op.Key), Data: data})
+		}
+	}
+
+	return ops, nil
+}
+
+// recoverOps returns the storage operations that move a trashed secret and
+// its history back into the store
+func (p *LocalProvider) recoverOps(t *trashedSecret) ([]storageOp, error) {
+	if _, err := p.store.Read(secretKey(t.name)); err == nil {
+		return nil, fmt.Errorf("cannot restore %s: the secret exists", t.name)
+	}
+
+	data, err := p.store.Read(trashKey(t.id, secretKey(t.name)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deleted secret %s: %w", t.name, err)
+	}
+	secret, err := p.decodeSecret(t.name, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode deleted secret %s: %w", t.name, err)
+	}
+
+	ops := []storageOp{
+		{Key: trashKey(t.id, secretKey(t.name)), Remove: true},
+		{Key: secretKey(t.name), Data: data, Secret: secret},
+	}
+
+	prefix := trashKey(t.id, historyDir+"/"+t.name+"/")
+	keys, err := p.store.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", t.name, err)
+	}
+	for _, key := range keys {
+		// Keys of secrets in a folder of the same name have more segments
+		version, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			continue
+		}
+
+		data, err := p.store.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		ops = append(ops,
+			storageOp{Key: key, Remove: true},
+			storageOp{Key: historyKey(t.name, version), Data: data},
+		)
+	}
+
+	return ops, nil
+}
+
+// purgeOps returns the storage operations that remove the trash entries of
+// deletions before a given time, and the number of secrets in them
+func (p *LocalProvider) purgeOps(before time.Time) ([]storageOp, int, error) {
+	keys, err := p.store.List(trashDir + "/")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	entries, err := p.trashEntries(keys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ops []storageOp
+	purged := 0
+	for _, key := range keys {
+		id, rest, _ := splitTrashKey(key)
+		if !entries[id].DeletedAt.Before(before) {
+			continue
+		}
+
+		ops = append(ops, storageOp{Key: key, Remove: true})
+		if strings.HasPrefix(rest, folderPrefix("")) {
+			purged++
+		}
+	}
+
+	return ops, purged, nil
+}
+
+// trashedSecrets returns the secrets in the trash, most recently deleted
+// first
+func (p *LocalProvider) trashedSecrets() ([]*trashedSecret, error) {
+	keys, err := p.store.List(trashDir + "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	entries, err := p.trashEntries(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var trashed []*trashedSecret
+	for _, key := range keys {
+		id, rest, _ := splitTrashKey(key)
+		if name, ok := strings.CutPrefix(rest, folderPrefix("")); ok {
+			trashed = append(trashed, &trashedSecret{id: id, name: name, entry: entries[id]})
+		}
+	}
+
+	sort.SliceStable(trashed, func(i, j int) bool {
+		return trashed[i].entry.DeletedAt.After(trashed[j].entry.DeletedAt)
+	})
+	return trashed, nil
+}
+
+// trashEntries reads the deletion details of the trash entries holding
+// the given keys. Entries without details count as deleted long ago.
+func (p *LocalProvider) trashEntries(keys []string) (map[string]*trashEntry, error) {
+	entries := make(map[string]*trashEntry)
+	for _, key := range keys {
+		id, rest, ok := splitTrashKey(key)
+		if !ok {
+			continue
+		}
+		if entries[id] == nil {
+			entries[id] = &trashEntry{}
+		}
+		if rest != trashInfo {
+			continue
+		}
+
+		data, err := p.store.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		if err := json.Unmarshal(data, entries[id]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", key, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// trashKey returns the key of a record in a trash entry
+func trashKey(id, key string) string {
+	return trashDir + "/" + id + "/" + key
+}
+
+// splitTrashKey returns the trash entry of a record in the trash, and the
+// key the record had before the deletion
+func splitTrashKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, trashDir+"/")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, "/")
+}
+
+// currentUser returns the name of the user running the process
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
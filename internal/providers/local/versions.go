@@ -0,0 +1,249 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keeper/internal/providers"
+)
+
+const (
+	// historyDir holds previous versions as history/<name>/<version>.json
+	historyDir = "history"
+
+	// defaultHistoryRetention is the number of previous versions kept by default
+	defaultHistoryRetention = 10
+)
+
+// SetHistoryRetention sets how many previous versions of each secret are
+// kept. Older versions are pruned on the next write; zero keeps no history.
+func (p *LocalProvider) SetHistoryRetention(versions int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if versions < 0 {
+		versions = 0
+	}
+	p.historyRetention = versions
+}
+
+// GetSecretVersion retrieves a specific version of a secret
+func (p *LocalProvider) GetSecretVersion(ctx context.Context, name string, version int) (*providers.Secret, error) {
+	name, err := providers.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return p.getSecretVersion(name, version)
+}
+
+// ListSecretVersions returns every retained version of a secret, oldest first
+func (p *LocalProvider) ListSecretVersions(ctx context.Context, name string) ([]*providers.Secret, error) {
+	name, err := providers.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := p.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := p.getSecret(name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := p.historyVersions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []*providers.Secret
+	for _, version := range versions {
+		secret, err := p.readVersion(name, version)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return append(secrets, current), nil
+}
+
+// RollbackSecret makes an earlier version of a secret current again. The
+// restored value is stored as a new version, so the rollback itself can be
+// undone.
+func (p *LocalProvider) RollbackSecret(ctx context.Context, name string, version int) (*providers.Secret, error) {
+	name, err := providers.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := p.getSecret(name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := p.getSecretVersion(name, version)
+	if err != nil {
+		return nil, err
+	}
+	secret.CreatedAt = current.CreatedAt
+
+	if err := p.setSecret(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// getSecretVersion reads a version of a secret, whether current or in the
+// history. The caller must hold the store lock.
+func (p *LocalProvider) getSecretVersion(name string, version int) (*providers.Secret, error) {
+	current, err := p.getSecret(name)
+	if err != nil {
+		return nil, err
+	}
+	if current.Version == version {
+		return current, nil
+	}
+
+	return p.readVersion(name, version)
+}
+
+// readVersion reads a version of a secret from the history
+func (p *LocalProvider) readVersion(name string, version int) (*providers.Secret, error) {
+	data, err := p.store.Read(historyKey(name, version))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("secret %s version %d: %w", name, version, providers.ErrVersionNotFound)
+		}
+		return nil, fmt.Errorf("failed to read secret version: %w", err)
+	}
+
+	return p.decodeSecret(name, data)
+}
+
+// writeOps returns the storage operations that store secret as a new
+// version, moving the current version into the history and pruning
+// versions beyond the retention count. It sets secret.Version.
+func (p *LocalProvider) writeOps(secret *providers.Secret) ([]storageOp, error) {
+	var ops []storageOp
+
+	secret.Version = 1
+	current, err := p.store.Read(secretKey(secret.Name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secret file: %w", err)
+	}
+	if err == nil {
+		previous := secretVersion(current)
+		secret.Version = previous + 1
+
+		// New versions keep the creation time of the secret
+		if created := secretCreatedAt(current); !created.IsZero() {
+			secret.CreatedAt = created
+		}
+
+		versions, err := p.historyVersions(secret.Name)
+		if err != nil {
+			return nil, err
+		}
+		if p.historyRetention > 0 {
+			ops = append(ops, storageOp{
+				Key:  historyKey(secret.Name, previous),
+				Data: current,
+			})
+			versions = append(versions, previous)
+		}
+
+		for len(versions) > p.historyRetention {
+			ops = append(ops, storageOp{
+				Key:    historyKey(secret.Name, versions[0]),
+				Remove: true,
+			})
+			versions = versions[1:]
+		}
+	}
+
+	data, err := p.encodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ops, storageOp{
+		Key:    secretKey(secret.Name),
+		Data:   data,
+		Secret: secret,
+	}), nil
+}
+
+// historyVersions returns the versions of a secret in the history, oldest first
+func (p *LocalProvider) historyVersions(name string) ([]int, error) {
+	prefix := historyDir + "/" + name + "/"
+
+	keys, err := p.store.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", name, err)
+	}
+
+	var versions []int
+	for _, key := range keys {
+		// Keys of secrets in a folder of the same name have more segments
+		version, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// historyKey returns the key of a previous version of a secret
+func historyKey(name string, version int) string {
+	return historyDir + "/" + name + "/" + strconv.Itoa(version)
+}
+
+// secretVersion returns the version recorded in a secret file. Files
+// written before versioning count as version 1.
+func secretVersion(data []byte) int {
+	var header struct {
+		Version int `json:"secret_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil || header.Version < 1 {
+		return 1
+	}
+	return header.Version
+}
+
+// secretCreatedAt returns the creation time recorded in a secret file
+func secretCreatedAt(data []byte) time.Time {
+	var header struct {
+		CreatedAt time.Time `json:"created_at"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return time.Time{}
+	}
+	return header.CreatedAt
+}
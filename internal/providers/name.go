@@ -0,0 +1,19 @@
+package providers
+
+import "github.com/keeper/pkg/api"
+
+// ErrInvalidName is returned for secret names that are empty or could
+// escape their namespace
+var ErrInvalidName = api.ErrInvalidName
+
+// NormalizeName validates a hierarchical secret name and returns it in
+// canonical form, as api.NormalizeName does
+func NormalizeName(name string) (string, error) {
+	return api.NormalizeName(name)
+}
+
+// NormalizeFolder is like NormalizeName but also accepts the root folder,
+// which is returned as ""
+func NormalizeFolder(folder string) (string, error) {
+	return api.NormalizeFolder(folder)
+}
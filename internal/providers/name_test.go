@@ -0,0 +1,33 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeName(t *testing.T) {
+	valid := map[string]string{
+		"secret":              "secret",
+		"app/db/password":     "app/db/password",
+		"/app//db/password/":  "app/db/password",
+		"app/.env":            "app/.env",
+		"app/config.prod.yml": "app/config.prod.yml",
+	}
+	for name, want := range valid {
+		got, err := NormalizeName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, got)
+	}
+
+	invalid := []string{"", "/", "../secret", "app/../../etc", "app/./x", `app\x`, "app@2", "app/\x00"}
+	for _, name := range invalid {
+		_, err := NormalizeName(name)
+		assert.ErrorIs(t, err, ErrInvalidName, name)
+	}
+
+	folder, err := NormalizeFolder("/")
+	require.NoError(t, err)
+	assert.Equal(t, "", folder)
+}
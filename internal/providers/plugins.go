@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/keeper/pkg/plugin"
+)
+
+// pluginTimeoutParam is the parameter of plugin providers setting how long
+// a call may take. Other parameters are handed to the plugin.
+const pluginTimeoutParam = "plugin_timeout"
+
+// RegisterPlugins registers the provider plugins found in dirs and on PATH
+// as provider types named after their executables: keeper-provider-foo
+// provides type foo. Registered types take precedence over plugins.
+func RegisterPlugins(dirs []string) {
+	for name, path := range plugin.Discover(dirs) {
+		if _, ok := Lookup(name); ok {
+			continue
+		}
+
+		path := path
+		Register(Registration{
+			Type:        name,
+			Description: fmt.Sprintf("Plugin %s", path),
+			Params: []Param{
+				{Name: pluginTimeoutParam, Type: ParamDuration, Default: plugin.DefaultTimeout, Description: "how long a call to the plugin may take"},
+			},
+			PassThrough: true,
+			Factory: func(ctx context.Context, params Params) (Provider, error) {
+				parameters := make(map[string]interface{}, len(params))
+				for key, value := range params {
+					if key != pluginTimeoutParam {
+						parameters[key] = value
+					}
+				}
+				return plugin.Start(ctx, path, parameters, params.Duration(pluginTimeoutParam))
+			},
+		})
+	}
+}
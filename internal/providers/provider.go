@@ -2,83 +2,70 @@ package providers
 
 import (
 	"context"
-	"errors"
-	"time"
-)
-
-// ErrSecretNotFound is returned when a secret is not found
-var ErrSecretNotFound = errors.New("secret not found")
-
-// Secret represents a secret with metadata
-type Secret struct {
-	Name      string            `json:"name"`
-	Value     string            `json:"value"`
-	Metadata  map[string]string `json:"metadata,omitempty"`
-	Tags      []string          `json:"tags,omitempty"`
-	Schema    string            `json:"schema,omitempty"`
-	CreatedAt time.Time         `json:"created_at"`
-	UpdatedAt time.Time         `json:"updated_at"`
-}
-
-// SearchOptions represents options for searching secrets
-type SearchOptions struct {
-	Schema        string    `json:"schema,omitempty"`
-	Tags         []string  `json:"tags,omitempty"`
-	CreatedAfter time.Time `json:"created_after,omitempty"`
-}
-
-// Provider defines the interface for secret management
-type Provider interface {
-	// Initialize initializes the provider
-	Initialize(ctx context.Context) error
-
-	// Close closes the provider
-	Close() error
 
-	// GetSecret retrieves a secret by name
-	GetSecret(ctx context.Context, name string) (*Secret, error)
-
-	// SetSecret stores a secret
-	SetSecret(ctx context.Context, secret *Secret) error
+	"github.com/keeper/pkg/api"
+)
 
-	// DeleteSecret deletes a secret by name
-	DeleteSecret(ctx context.Context, name string) error
+// The provider contract is defined in pkg/api so that providers can be
+// written outside keeper; these aliases keep it at hand for the providers
+// and commands in this module.
+type (
+	Secret        = api.Secret
+	SearchOptions = api.SearchOptions
+	DeletedSecret = api.DeletedSecret
+
+	Provider     = api.Provider
+	Versioner    = api.Versioner
+	Rotator      = api.Rotator
+	Searcher     = api.Searcher
+	Backuper     = api.Backuper
+	Watcher      = api.Watcher
+	Hierarchical = api.Hierarchical
+	Recoverable  = api.Recoverable
+	Capabilities = api.Capabilities
+)
 
-	// ListSecrets lists all secrets
-	ListSecrets(ctx context.Context) ([]*Secret, error)
+var (
+	// ErrSecretNotFound is returned when a secret is not found
+	ErrSecretNotFound = api.ErrSecretNotFound
 
-	// SearchSecrets searches for secrets based on criteria
-	SearchSecrets(ctx context.Context, opts SearchOptions) ([]*Secret, error)
+	// ErrVersionNotFound is returned when a secret version does not exist
+	// or is no longer retained
+	ErrVersionNotFound = api.ErrVersionNotFound
 
-	// SetBackupDir sets the backup directory for the provider
-	SetBackupDir(dir string) error
+	// ErrNotSupported is returned by providers asked for a feature their
+	// backend does not offer
+	ErrNotSupported = api.ErrNotSupported
+)
 
-	// Backup creates a backup of all secrets
-	Backup(ctx context.Context) error
+// NewSecret creates a new secret with the given name and value
+func NewSecret(name, value string) *Secret {
+	return api.NewSecret(name, value)
+}
 
-	// Restore restores secrets from a backup
-	Restore(ctx context.Context) error
+// CapabilitiesOf returns the optional features a provider supports
+func CapabilitiesOf(p Provider) Capabilities {
+	return api.CapabilitiesOf(p)
 }
 
-// Validate checks if the secret is valid
-func (s *Secret) Validate() error {
-	if s.Name == "" {
-		return errors.New("secret name cannot be empty")
+// SearchSecrets returns the secrets of a provider meeting every search
+// criterion. Providers implementing Searcher search natively; the secrets
+// of other providers are listed and filtered in memory.
+func SearchSecrets(ctx context.Context, p Provider, opts SearchOptions) ([]*Secret, error) {
+	if s, ok := p.(Searcher); ok && CapabilitiesOf(p).Search {
+		return s.SearchSecrets(ctx, opts)
 	}
-	if s.Value == "" {
-		return errors.New("secret value cannot be empty")
+
+	secrets, err := CollectPages(ctx, ListSecretPages(ctx, p, ListOptions{IncludeValues: true}))
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-// NewSecret creates a new secret with the given name and value
-func NewSecret(name, value string) *Secret {
-	now := time.Now()
-	return &Secret{
-		Name:      name,
-		Value:     value,
-		Metadata:  make(map[string]string),
-		CreatedAt: now,
-		UpdatedAt: now,
+	var results []*Secret
+	for _, secret := range secrets {
+		if opts.Matches(secret) {
+			results = append(results, secret)
+		}
 	}
+	return results, nil
 }
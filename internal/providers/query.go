@@ -0,0 +1,561 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is returned for search expressions that cannot be parsed
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query is a parsed search expression such as
+//
+//	tag:db AND meta.env=prod AND NOT tag:deprecated
+//
+// Terms are combined with AND, OR and NOT and grouped with parentheses.
+// Terms next to each other are combined with AND. The terms are:
+//
+//	name:app/**  a name glob, where * stays within a folder and ** does not
+//	name:/re/    a name regular expression; bare globs and /re/ also match names
+//	tag:db       secrets with a tag
+//	schema:db    secrets with a schema
+//	meta.env=prod, meta.env!=prod, meta.env  metadata values, or a key being set
+//	created<30d, updated>2024-01-01, expires<7d  timestamps
+//
+// Timestamps compare with a date, or with a duration in s, m, h, d or w
+// meaning an age for created and updated and the time left for expires:
+// updated<30d matches secrets updated in the last 30 days, and expires<7d
+// secrets expiring within 7 days, including those that already expired.
+type Query struct {
+	text string
+	expr Expr
+}
+
+// Expr is a node of a parsed query
+type Expr interface {
+	// Match reports whether a secret matches, measuring relative times
+	// from now
+	Match(secret *Secret, now time.Time) bool
+}
+
+// And matches secrets that match every expression
+type And []Expr
+
+// Or matches secrets that match any expression
+type Or []Expr
+
+// Not matches secrets that do not match an expression
+type Not struct {
+	Expr Expr
+}
+
+// Term is a single condition on a field of a secret
+type Term struct {
+	// Field is name, tag, schema, meta, created, updated or expires
+	Field string
+
+	// Key is the metadata key of meta terms
+	Key string
+
+	// Op is ":" for names, tags and schemas, "=", "!=" or "" (the key is
+	// set) for metadata, and "<", "<=", ">" or ">=" for timestamps
+	Op string
+
+	// Value is the operand as written
+	Value string
+
+	pattern  *regexp.Regexp
+	at       time.Time
+	duration time.Duration
+}
+
+// ParseQuery parses a search expression
+func ParseQuery(text string) (*Query, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidQuery)
+	}
+
+	p := &queryParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, p.tokens[p.pos].text)
+	}
+
+	return &Query{text: text, expr: expr}, nil
+}
+
+// String returns the query as written
+func (q *Query) String() string {
+	return q.text
+}
+
+// Expr returns the root of the parsed query, for providers that translate
+// queries into their own filters
+func (q *Query) Expr() Expr {
+	return q.expr
+}
+
+// Match reports whether a secret matches the query
+func (q *Query) Match(secret *Secret, now time.Time) bool {
+	return q.expr.Match(secret, now)
+}
+
+// Options returns search options holding the conditions that every match
+// of the query meets. Searching with them returns a superset of the
+// matches, which providers can narrow down with Match.
+func (q *Query) Options(now time.Time) SearchOptions {
+	var opts SearchOptions
+
+	terms := []Expr{q.expr}
+	if and, ok := q.expr.(And); ok {
+		terms = and
+	}
+
+	for _, expr := range terms {
+		term, ok := expr.(*Term)
+		if !ok {
+			continue
+		}
+
+		switch term.Field {
+		case "tag":
+			opts.Tags = append(opts.Tags, term.Value)
+		case "schema":
+			if opts.Schema == "" {
+				opts.Schema = term.Value
+			}
+		case "meta":
+			if term.Op == "=" || term.Op == "" {
+				if opts.Metadata == nil {
+					opts.Metadata = make(map[string]string)
+				}
+				opts.Metadata[term.Key] = term.Value
+			}
+		case "created", "updated":
+			threshold, op := term.threshold(now)
+			if op != ">" && op != ">=" {
+				continue
+			}
+			if term.Field == "created" {
+				opts.CreatedAfter = threshold
+			} else {
+				opts.UpdatedAfter = threshold
+			}
+		}
+	}
+
+	return opts
+}
+
+// Match implements the Expr interface
+func (a And) Match(secret *Secret, now time.Time) bool {
+	for _, expr := range a {
+		if !expr.Match(secret, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match implements the Expr interface
+func (o Or) Match(secret *Secret, now time.Time) bool {
+	for _, expr := range o {
+		if expr.Match(secret, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match implements the Expr interface
+func (n Not) Match(secret *Secret, now time.Time) bool {
+	return !n.Expr.Match(secret, now)
+}
+
+// Match implements the Expr interface
+func (t *Term) Match(secret *Secret, now time.Time) bool {
+	switch t.Field {
+	case "name":
+		return t.pattern.MatchString(secret.Name)
+	case "tag":
+		return secret.HasTag(t.Value)
+	case "schema":
+		return secret.Schema == t.Value
+	case "meta":
+		value, ok := secret.Metadata[t.Key]
+		switch t.Op {
+		case "=":
+			return ok && value == t.Value
+		case "!=":
+			return !ok || value != t.Value
+		default:
+			return ok
+		}
+	}
+
+	var at time.Time
+	switch t.Field {
+	case "created":
+		at = secret.CreatedAt
+	case "updated":
+		at = secret.UpdatedAt
+	case "expires":
+		var ok bool
+		if at, ok = secret.ExpiresAt(); !ok {
+			return false
+		}
+	}
+
+	threshold, op := t.threshold(now)
+	switch op {
+	case "<":
+		return at.Before(threshold)
+	case "<=":
+		return !at.After(threshold)
+	case ">":
+		return at.After(threshold)
+	default:
+		return !at.Before(threshold)
+	}
+}
+
+// threshold returns the time a timestamp term compares with, and the
+// comparison to apply to the timestamp. Ages reverse the comparison: a
+// secret updated less than 30 days ago was updated after now minus 30 days.
+func (t *Term) threshold(now time.Time) (time.Time, string) {
+	switch {
+	case t.duration == 0:
+		return t.at, t.Op
+	case t.Field == "expires":
+		return now.Add(t.duration), t.Op
+	}
+
+	return now.Add(-t.duration), reversedOps[t.Op]
+}
+
+// reversedOps maps each timestamp comparison to its reverse
+var reversedOps = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// token is a word, operator or parenthesis of a query
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits a query into tokens. Double quotes group text containing
+// spaces or parentheses, and regular expressions run to their closing slash.
+func tokenize(text string) ([]token, error) {
+	var (
+		tokens  []token
+		current strings.Builder
+		quoted  bool
+		started bool
+	)
+
+	flush := func() {
+		if started {
+			tokens = append(tokens, token{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+		quoted, started = false, false
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated quote", ErrInvalidQuery)
+			}
+			current.WriteString(strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(string(runes[i+1 : end])))
+
+			// Only a fully quoted token is taken literally
+			quoted = !started
+			started = true
+			i = end
+		case r == '/' && (!started || strings.HasSuffix(current.String(), ":")):
+			end := i + 1
+			for end < len(runes) && runes[end] != '/' {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated regular expression", ErrInvalidQuery)
+			}
+			current.WriteString(string(runes[i : end+1]))
+			started = true
+			i = end
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, token{text: string(r)})
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	flush()
+
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over query tokens
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the next token if it is the given keyword or parenthesis
+func (p *queryParser) peek(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == keyword
+}
+
+// parseOr parses expressions separated by OR
+func (p *queryParser) parseOr() (Expr, error) {
+	var exprs Or
+	for {
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !p.peek("OR") {
+			break
+		}
+		p.pos++
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+// parseAnd parses expressions separated by AND or next to each other
+func (p *queryParser) parseAnd() (Expr, error) {
+	var exprs And
+	for {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.peek("AND") {
+			p.pos++
+			continue
+		}
+		if p.pos >= len(p.tokens) || p.peek("OR") || p.peek(")") {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+// parseNot parses an expression with any number of NOT prefixes
+func (p *queryParser) parseNot() (Expr, error) {
+	if p.peek("NOT") {
+		p.pos++
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesised expression or a term
+func (p *queryParser) parsePrimary() (Expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected end of query", ErrInvalidQuery)
+	}
+
+	if p.peek("(") {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrInvalidQuery)
+		}
+		p.pos++
+		return expr, nil
+	}
+
+	tok := p.tokens[p.pos]
+	if !tok.quoted {
+		switch tok.text {
+		case ")", "AND", "OR":
+			return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, tok.text)
+		}
+	}
+	p.pos++
+
+	return parseTerm(tok)
+}
+
+// parseTerm parses a single condition
+func parseTerm(tok token) (*Term, error) {
+	text := tok.text
+	if tok.quoted {
+		return nameTerm(text, false)
+	}
+
+	for _, field := range []string{"name", "tag", "schema"} {
+		value, ok := strings.CutPrefix(text, field+":")
+		if !ok {
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%w: %q needs a value", ErrInvalidQuery, text)
+		}
+		if field == "name" {
+			return nameTerm(value, true)
+		}
+		return &Term{Field: field, Op: ":", Value: value}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(text, "meta."); ok {
+		term := &Term{Field: "meta", Key: rest}
+		if i := strings.IndexAny(rest, "!="); i >= 0 {
+			term.Key, term.Op, term.Value = rest[:i], "=", rest[i+1:]
+			if rest[i] == '!' {
+				if !strings.HasPrefix(rest[i:], "!=") {
+					return nil, fmt.Errorf("%w: %q", ErrInvalidQuery, text)
+				}
+				term.Op, term.Value = "!=", rest[i+2:]
+			}
+		}
+		if term.Key == "" {
+			return nil, fmt.Errorf("%w: %q needs a metadata key", ErrInvalidQuery, text)
+		}
+		return term, nil
+	}
+
+	for _, field := range []string{"created", "updated", "expires"} {
+		rest, ok := strings.CutPrefix(text, field)
+		if !ok || rest == "" || (rest[0] != '<' && rest[0] != '>') {
+			continue
+		}
+
+		op := rest[:1]
+		if strings.HasPrefix(rest[1:], "=") {
+			op = rest[:2]
+		}
+		return timeTerm(field, op, rest[len(op):])
+	}
+
+	return nameTerm(text, true)
+}
+
+// nameTerm returns a term matching names against a glob or, when written
+// as /re/, a regular expression
+func nameTerm(value string, allowRegexp bool) (*Term, error) {
+	term := &Term{Field: "name", Op: ":", Value: value}
+
+	var err error
+	if allowRegexp && len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		term.pattern, err = regexp.Compile(value[1 : len(value)-1])
+	} else {
+		term.pattern, err = regexp.Compile(globPattern(value))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidQuery, value, err)
+	}
+
+	return term, nil
+}
+
+// timeTerm returns a term comparing a timestamp with a date or a duration
+func timeTerm(field, op, value string) (*Term, error) {
+	term := &Term{Field: field, Op: op, Value: value}
+
+	if d, err := ParseDuration(value); err == nil {
+		term.duration = d
+		return term, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			term.at = t
+			return term, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q is neither a date nor a duration", ErrInvalidQuery, value)
+}
+
+// ParseDuration parses a duration that is not negative, accepting days and
+// weeks in addition to the units of time.ParseDuration
+func ParseDuration(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// globPattern converts a name glob into an anchored regular expression
+func globPattern(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
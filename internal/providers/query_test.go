@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	secrets := []*Secret{
+		{
+			Name:      "app/db/password",
+			Tags:      []string{"db"},
+			Schema:    "database",
+			Metadata:  map[string]string{"env": "prod", ExpiresAtKey: "2024-06-03T00:00:00Z"},
+			CreatedAt: now.AddDate(0, -6, 0),
+			UpdatedAt: now.AddDate(0, 0, -2),
+		},
+		{
+			Name:      "app/legacy",
+			Tags:      []string{"db", "deprecated"},
+			Metadata:  map[string]string{"env": "prod"},
+			CreatedAt: now.AddDate(-1, 0, 0),
+			UpdatedAt: now.AddDate(0, -3, 0),
+		},
+		{
+			Name:      "web/api-key",
+			Tags:      []string{"web"},
+			Metadata:  map[string]string{"env": "dev", ExpiresAtKey: "2024-07-01T00:00:00Z"},
+			CreatedAt: now.AddDate(0, 0, -10),
+			UpdatedAt: now.AddDate(0, 0, -10),
+		},
+	}
+
+	tests := map[string][]string{
+		"tag:db AND meta.env=prod AND NOT tag:deprecated": {"app/db/password"},
+		"tag:db meta.env=prod NOT tag:deprecated":         {"app/db/password"},
+		"tag:web OR tag:deprecated":                       {"app/legacy", "web/api-key"},
+		"NOT (tag:db OR tag:web)":                         nil,
+		"schema:database":                                 {"app/db/password"},
+		"meta.env!=prod":                                  {"web/api-key"},
+		"meta.expires_at":                                 {"app/db/password", "web/api-key"},
+		`meta.env="dev"`:                                  {"web/api-key"},
+		"app/*":                                           {"app/legacy"},
+		"app/**":                                          {"app/db/password", "app/legacy"},
+		"name:*/api-?ey":                                  {"web/api-key"},
+		"/^app/.*word$/":                                  {"app/db/password"},
+		"name:/(db|api)/":                                 {"app/db/password", "web/api-key"},
+		"updated<30d":                                     {"app/db/password", "web/api-key"},
+		"updated>30d":                                     {"app/legacy"},
+		"created>=2024-01-01":                             {"web/api-key"},
+		"expires<7d":                                      {"app/db/password"},
+		"expires>1w":                                      {"web/api-key"},
+		`"app/legacy"`:                                    {"app/legacy"},
+	}
+
+	for text, want := range tests {
+		query, err := ParseQuery(text)
+		require.NoError(t, err, text)
+
+		var got []string
+		for _, secret := range secrets {
+			if query.Match(secret, now) {
+				got = append(got, secret.Name)
+			}
+		}
+		assert.Equal(t, want, got, text)
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	query, err := ParseQuery("tag:db schema:database meta.env=prod updated<30d NOT tag:old")
+	require.NoError(t, err)
+	opts := query.Options(now)
+	assert.Equal(t, []string{"db"}, opts.Tags)
+	assert.Equal(t, "database", opts.Schema)
+	assert.Equal(t, map[string]string{"env": "prod"}, opts.Metadata)
+	assert.Equal(t, now.AddDate(0, 0, -30), opts.UpdatedAfter)
+
+	// Alternatives do not constrain the search
+	query, err = ParseQuery("tag:db OR tag:web")
+	require.NoError(t, err)
+	assert.Equal(t, SearchOptions{}, query.Options(now))
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	invalid := []string{"", "tag:", "(tag:db", "tag:db)", "tag:db AND", "OR tag:db", "meta.=x", `tag:"db`, "/unterminated", "updated<soon", "name:/[/"}
+	for _, text := range invalid {
+		_, err := ParseQuery(text)
+		assert.ErrorIs(t, err, ErrInvalidQuery, text)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"0":   0,
+		"0s":  0,
+		"0d":  0,
+		"12h": 12 * time.Hour,
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	}
+	for text, want := range valid {
+		got, err := ParseDuration(text)
+		require.NoError(t, err, text)
+		assert.Equal(t, want, got, text)
+	}
+
+	for _, text := range []string{"", "d", "-1d", "-5m", "soon", "1.5d"} {
+		_, err := ParseDuration(text)
+		assert.Error(t, err, text)
+	}
+}
@@ -0,0 +1,276 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParamType is the type of a provider parameter
+type ParamType string
+
+// Types of provider parameters
+const (
+	ParamString   ParamType = "string"
+	ParamInt      ParamType = "int"
+	ParamBool     ParamType = "bool"
+	ParamDuration ParamType = "duration"
+)
+
+// Param describes a configuration parameter of a provider type
+type Param struct {
+	Name        string    `json:"name"`
+	Type        ParamType `json:"type"`
+	Required    bool      `json:"required,omitempty"`
+	Description string    `json:"description,omitempty"`
+
+	// Default is used when the parameter is not set, and must be of the
+	// Go type of the parameter: string, int, bool or time.Duration
+	Default interface{} `json:"default,omitempty"`
+}
+
+// Factory creates a provider from its validated parameters
+type Factory func(ctx context.Context, params Params) (Provider, error)
+
+// Registration describes a provider type
+type Registration struct {
+	Type        string  `json:"type"`
+	Description string  `json:"description,omitempty"`
+	Params      []Param `json:"params"`
+	Factory     Factory `json:"-"`
+
+	// PassThrough hands parameters missing from Params to the factory
+	// unchecked, for providers that check their own, such as plugins
+	PassThrough bool `json:"pass_through,omitempty"`
+}
+
+// ParamError reports a provider parameter that is missing or of the wrong type
+type ParamError struct {
+	Provider string
+	Param    string
+	Expected ParamType
+
+	// Value is the value that was given, nil if the parameter is missing
+	Value interface{}
+}
+
+func (e *ParamError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("%s provider: parameter %q of type %s is required", e.Provider, e.Param, e.Expected)
+	}
+	return fmt.Sprintf("%s provider: parameter %q must be of type %s, got %T %v", e.Provider, e.Param, e.Expected, e.Value, e.Value)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*Registration)
+)
+
+// Register makes a provider type available by name. It is meant to be
+// called from the init function of provider packages, and panics if the
+// registration is incomplete or the type is already registered.
+func Register(r Registration) {
+	if r.Type == "" || r.Factory == nil {
+		panic("providers: Register needs a type and a factory")
+	}
+	for _, param := range r.Params {
+		if param.Default == nil {
+			continue
+		}
+		if _, err := convertParam(param.Type, param.Default); err != nil {
+			panic(fmt.Sprintf("providers: default of %s parameter %q is not a %s", r.Type, param.Name, param.Type))
+		}
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[r.Type]; ok {
+		panic(fmt.Sprintf("providers: %s provider registered twice", r.Type))
+	}
+	registry[r.Type] = &r
+}
+
+// Lookup returns the registration of a provider type
+func Lookup(providerType string) (*Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[providerType]
+	return r, ok
+}
+
+// Registered returns every registered provider type, sorted by name
+func Registered() []*Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registrations := make([]*Registration, 0, len(registry))
+	for _, r := range registry {
+		registrations = append(registrations, r)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Type < registrations[j].Type
+	})
+	return registrations
+}
+
+// Open creates a provider of a registered type from raw configuration
+// parameters, such as those of config.yaml. The provider still has to be
+// initialized.
+func Open(ctx context.Context, providerType string, parameters map[string]interface{}) (Provider, error) {
+	r, ok := Lookup(providerType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider type: %s", providerType)
+	}
+
+	params, err := r.ParseParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := r.Factory(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s provider: %w", r.Type, err)
+	}
+	return provider, nil
+}
+
+// ParseParams checks raw parameters against the schema of the provider
+// type, converting them to their types and filling in defaults
+func (r *Registration) ParseParams(parameters map[string]interface{}) (Params, error) {
+	known := make(map[string]bool, len(r.Params))
+	params := make(Params, len(r.Params))
+	for _, param := range r.Params {
+		known[param.Name] = true
+
+		raw, ok := parameters[param.Name]
+		if !ok || raw == nil {
+			if param.Required {
+				return nil, &ParamError{Provider: r.Type, Param: param.Name, Expected: param.Type}
+			}
+			if param.Default != nil {
+				params[param.Name], _ = convertParam(param.Type, param.Default)
+			}
+			continue
+		}
+
+		value, err := convertParam(param.Type, raw)
+		if err != nil {
+			return nil, &ParamError{Provider: r.Type, Param: param.Name, Expected: param.Type, Value: raw}
+		}
+		params[param.Name] = value
+	}
+
+	var unknown []string
+	for name, raw := range parameters {
+		if known[name] {
+			continue
+		}
+		if r.PassThrough {
+			params[name] = raw
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s provider: unknown parameter %q (known: %s)", r.Type, unknown[0], strings.Join(r.paramNames(), ", "))
+	}
+
+	return params, nil
+}
+
+// paramNames returns the names of the parameters of the provider type
+func (r *Registration) paramNames() []string {
+	names := make([]string, len(r.Params))
+	for i, param := range r.Params {
+		names[i] = param.Name
+	}
+	return names
+}
+
+// convertParam converts a parameter value, as decoded from YAML or JSON,
+// to the Go type of a parameter type
+func convertParam(paramType ParamType, value interface{}) (interface{}, error) {
+	switch paramType {
+	case ParamString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+
+	case ParamInt:
+		switch n := value.(type) {
+		case int:
+			return n, nil
+		case int64:
+			if n >= math.MinInt && n <= math.MaxInt {
+				return int(n), nil
+			}
+		case uint64:
+			if n <= math.MaxInt {
+				return int(n), nil
+			}
+		case float64:
+			if n == math.Trunc(n) && n >= math.MinInt && n <= math.MaxInt {
+				return int(n), nil
+			}
+		}
+
+	case ParamBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+
+	case ParamDuration:
+		switch d := value.(type) {
+		case time.Duration:
+			return d, nil
+		case string:
+			if parsed, err := ParseDuration(d); err == nil {
+				return parsed, nil
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown parameter type %q", paramType)
+	}
+
+	return nil, fmt.Errorf("not a %s", paramType)
+}
+
+// Params holds the validated parameters of a provider, converted to their
+// types. Parameters that are not set and have no default are absent.
+type Params map[string]interface{}
+
+// String returns a string parameter, or "" if it is not set
+func (p Params) String(name string) string {
+	s, _ := p[name].(string)
+	return s
+}
+
+// Int returns an int parameter, or 0 if it is not set
+func (p Params) Int(name string) int {
+	n, _ := p[name].(int)
+	return n
+}
+
+// Bool returns a bool parameter, or false if it is not set
+func (p Params) Bool(name string) bool {
+	b, _ := p[name].(bool)
+	return b
+}
+
+// Duration returns a duration parameter, or 0 if it is not set
+func (p Params) Duration(name string) time.Duration {
+	d, _ := p[name].(time.Duration)
+	return d
+}
+
+// Has reports whether a parameter is set or has a default
+func (p Params) Has(name string) bool {
+	_, ok := p[name]
+	return ok
+}
@@ -0,0 +1,105 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRegistration = Registration{
+	Type: "registry-test",
+	Params: []Param{
+		{Name: "address", Type: ParamString, Required: true},
+		{Name: "retries", Type: ParamInt, Default: 3},
+		{Name: "insecure", Type: ParamBool, Default: false},
+		{Name: "timeout", Type: ParamDuration, Default: 10 * time.Second},
+		{Name: "namespace", Type: ParamString},
+	},
+	Factory: func(ctx context.Context, params Params) (Provider, error) {
+		return &listOnlyProvider{}, nil
+	},
+}
+
+func TestRegistration_ParseParams(t *testing.T) {
+	// Defaults fill in missing parameters, and YAML and JSON numbers are ints
+	params, err := testRegistration.ParseParams(map[string]interface{}{
+		"address": "https://example.com",
+		"retries": float64(5),
+		"timeout": "2d",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", params.String("address"))
+	assert.Equal(t, 5, params.Int("retries"))
+	assert.False(t, params.Bool("insecure"))
+	assert.Equal(t, 48*time.Hour, params.Duration("timeout"))
+	assert.False(t, params.Has("namespace"))
+
+	params, err = testRegistration.ParseParams(map[string]interface{}{"address": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, params.Int("retries"))
+	assert.Equal(t, 10*time.Second, params.Duration("timeout"))
+
+	// Errors name the parameter and its expected type
+	_, err = testRegistration.ParseParams(map[string]interface{}{})
+	var paramErr *ParamError
+	require.True(t, errors.As(err, &paramErr))
+	assert.Equal(t, "address", paramErr.Param)
+	assert.EqualError(t, err, `registry-test provider: parameter "address" of type string is required`)
+
+	_, err = testRegistration.ParseParams(map[string]interface{}{"address": "a", "retries": "five"})
+	assert.EqualError(t, err, `registry-test provider: parameter "retries" must be of type int, got string five`)
+
+	_, err = testRegistration.ParseParams(map[string]interface{}{"address": "a", "retries": 1.5})
+	assert.EqualError(t, err, `registry-test provider: parameter "retries" must be of type int, got float64 1.5`)
+
+	_, err = testRegistration.ParseParams(map[string]interface{}{"address": "a", "insecure": "yes"})
+	assert.EqualError(t, err, `registry-test provider: parameter "insecure" must be of type bool, got string yes`)
+
+	_, err = testRegistration.ParseParams(map[string]interface{}{"address": "a", "timeout": "soon"})
+	assert.EqualError(t, err, `registry-test provider: parameter "timeout" must be of type duration, got string soon`)
+
+	_, err = testRegistration.ParseParams(map[string]interface{}{"address": "a", "adress": "b"})
+	assert.EqualError(t, err, `registry-test provider: unknown parameter "adress" (known: address, retries, insecure, timeout, namespace)`)
+
+	// Providers checking their own parameters get the others as they are
+	passThrough := testRegistration
+	passThrough.PassThrough = true
+	params, err = passThrough.ParseParams(map[string]interface{}{"address": "a", "bucket": 5})
+	require.NoError(t, err)
+	assert.Equal(t, 5, params["bucket"])
+}
+
+func TestRegister(t *testing.T) {
+	Register(testRegistration)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		delete(registry, testRegistration.Type)
+	})
+
+	r, ok := Lookup("registry-test")
+	require.True(t, ok)
+	assert.Equal(t, "registry-test", r.Type)
+	assert.Contains(t, Registered(), r)
+
+	p, err := Open(context.Background(), "registry-test", map[string]interface{}{"address": "a"})
+	require.NoError(t, err)
+	assert.NotNil(t, p)
+
+	_, err = Open(context.Background(), "registry-test", nil)
+	assert.Error(t, err)
+
+	_, err = Open(context.Background(), "no-such-provider", nil)
+	assert.EqualError(t, err, "unsupported provider type: no-such-provider")
+
+	assert.Panics(t, func() { Register(testRegistration) })
+
+	bad := testRegistration
+	bad.Type = "registry-test-bad-default"
+	bad.Params = []Param{{Name: "retries", Type: ParamInt, Default: "3"}}
+	assert.Panics(t, func() { Register(bad) })
+}
@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/keeper/pkg/api"
+)
+
+// RotationPolicy describes when and how a secret is rotated
+type RotationPolicy = api.RotationPolicy
+
+const (
+	// defaultRotationInterval is how often secrets without a policy are rotated
+	defaultRotationInterval = 30 * 24 * time.Hour
+
+	// defaultRotationLength is the length of generated values
+	defaultRotationLength = 32
+
+	// defaultCharacterSet is the alphabet of generated values
+	defaultCharacterSet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// DefaultRotationPolicy returns the policy of secrets that have none,
+// rotating them every 30 days
+func DefaultRotationPolicy() *RotationPolicy {
+	return &RotationPolicy{
+		Interval: defaultRotationInterval,
+		Length:   defaultRotationLength,
+	}
+}
+
+// GenerateValue returns a new value for a secret rotated under a policy:
+// the value of its custom generator, or random characters from its
+// character set
+func GenerateValue(policy *RotationPolicy) (string, error) {
+	if policy.CustomGenerator != nil {
+		return policy.CustomGenerator()
+	}
+
+	length := policy.Length
+	if length <= 0 {
+		length = defaultRotationLength
+	}
+	charset := []rune(policy.CharacterSet)
+	if len(charset) == 0 {
+		charset = []rune(defaultCharacterSet)
+	}
+
+	value := make([]rune, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate value: %w", err)
+		}
+		value[i] = charset[n.Int64()]
+	}
+	return string(value), nil
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/keeper/internal/providers"
+)
+
+var _ providers.Hierarchical = (*Router)(nil)
+
+// ListFolder lists a folder across the mounts it spans. Mounts below the
+// folder appear as its subfolders, and their secrets are included in
+// recursive listings.
+func (r *Router) ListFolder(ctx context.Context, folder string, recursive bool) ([]*providers.Secret, []string, error) {
+	folder, err := providers.NormalizeFolder(folder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		secrets []*providers.Secret
+		folders []string
+		seen    = make(map[string]bool)
+	)
+	addFolder := func(sub string) {
+		if !seen[sub] {
+			folders = append(folders, sub)
+			seen[sub] = true
+		}
+	}
+
+	for _, m := range r.mountsBelow(folderPrefix(folder)) {
+		// The mount holding the folder lists it; mounts below it are
+		// subfolders, whose secrets only recursive listings need
+		listed := folder
+		if !contains(m.Prefix, folder) {
+			if !recursive {
+				rel := strings.TrimPrefix(m.Prefix, folderPrefix(folder))
+				addFolder(folderPrefix(folder) + strings.SplitN(rel, "/", 2)[0] + "/")
+				continue
+			}
+			listed = m.Prefix
+		}
+
+		h, err := r.hierarchical(ctx, m)
+		if err != nil {
+			return nil, nil, err
+		}
+		found, subfolders, err := h.ListFolder(ctx, listed, recursive)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list folder of %s: %w", m.Provider, err)
+		}
+		secrets = append(secrets, r.owned(m, found)...)
+		for _, sub := range subfolders {
+			addFolder(sub)
+		}
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	sort.Strings(folders)
+	return secrets, folders, nil
+}
+
+// MoveFolder moves a folder within the provider that keeps it. Secrets
+// cannot be moved between providers with their history, so folders whose
+// source or destination span mounts of other providers are not moved.
+func (r *Router) MoveFolder(ctx context.Context, from, to string) error {
+	normalizedFrom, err := providers.NormalizeName(from)
+	if err != nil {
+		return err
+	}
+	normalizedTo, err := providers.NormalizeFolder(to)
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range []string{normalizedFrom, normalizedTo} {
+		if _, ok := r.mountOf(folder); !ok {
+			return fmt.Errorf("%w %q", ErrNoMount, folder)
+		}
+	}
+
+	mounts := append(r.mountsIn(normalizedFrom), r.mountsIn(normalizedTo)...)
+	for _, m := range mounts[1:] {
+		if m.Provider != mounts[0].Provider {
+			return fmt.Errorf("cannot move %s to %s between providers %s and %s: %w",
+				normalizedFrom, "/"+normalizedTo, mounts[0].Provider, m.Provider, providers.ErrNotSupported)
+		}
+	}
+
+	h, err := r.hierarchical(ctx, mounts[0])
+	if err != nil {
+		return err
+	}
+	return h.MoveFolder(ctx, from, to)
+}
+
+// DeleteFolder deletes a folder from every mount it spans. Each provider
+// deletes its part on its own, so a failure can leave the other parts
+// deleted.
+func (r *Router) DeleteFolder(ctx context.Context, folder string) error {
+	folder, err := providers.NormalizeName(folder)
+	if err != nil {
+		return err
+	}
+
+	mounts := r.mountsIn(folder)
+	if len(mounts) == 0 {
+		return fmt.Errorf("%w %q", ErrNoMount, folder)
+	}
+
+	hs := make([]providers.Hierarchical, len(mounts))
+	for i, m := range mounts {
+		if hs[i], err = r.hierarchical(ctx, m); err != nil {
+			return err
+		}
+	}
+
+	deleted := false
+	for i, m := range mounts {
+		// Mounts below the folder are deleted whole
+		target := folder
+		if !contains(m.Prefix, folder) {
+			target = m.Prefix
+		}
+		err := hs[i].DeleteFolder(ctx, target)
+		if errors.Is(err, providers.ErrSecretNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete folder of %s: %w", m.Provider, err)
+		}
+		deleted = true
+	}
+
+	if !deleted {
+		return fmt.Errorf("folder %s: %w", folder, providers.ErrSecretNotFound)
+	}
+	return nil
+}
+
+// hierarchical returns the provider of a mount if it organises secrets in
+// folders
+func (r *Router) hierarchical(ctx context.Context, m Mount) (providers.Hierarchical, error) {
+	p, err := r.provider(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := p.(providers.Hierarchical)
+	if !ok || !providers.CapabilitiesOf(p).Folders {
+		return nil, fmt.Errorf("%s: folders %w", m.Provider, providers.ErrNotSupported)
+	}
+	return h, nil
+}
+
+// mountsIn returns the mount holding a folder, if any, followed by the
+// mounts below the folder
+func (r *Router) mountsIn(folder string) []Mount {
+	var mounts []Mount
+	outer, ok := r.mountOf(folder)
+	if ok {
+		mounts = append(mounts, outer)
+	}
+	for _, m := range r.mounts {
+		if m != outer && !contains(m.Prefix, folder) && contains(folder, m.Prefix) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// contains reports whether a folder, "" being the root, is or contains a name
+func contains(folder, name string) bool {
+	return folder == "" || name == folder || strings.HasPrefix(name, folder+"/")
+}
+
+// folderPrefix returns the prefix of the names in a folder
+func folderPrefix(folder string) string {
+	if folder == "" {
+		return ""
+	}
+	return folder + "/"
+}
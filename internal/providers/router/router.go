@@ -0,0 +1,406 @@
+// Package router is a provider that spreads the secret namespace over
+// several providers. A mount table maps name prefixes, such as "prod", to
+// named providers, and every secret is kept by the provider mounted at the
+// longest prefix of its name. Names are passed on unchanged.
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/providers"
+)
+
+// ErrNoMount is returned for secrets whose names are outside every mount
+var ErrNoMount = errors.New("no mount for secret")
+
+// Opener opens and initializes a named provider
+type Opener func(ctx context.Context, name string) (providers.Provider, error)
+
+// Mount maps the secrets below a prefix to a named provider. The root
+// mount, whose prefix is "", holds the secrets outside other mounts.
+type Mount struct {
+	Prefix   string
+	Provider string
+}
+
+// Router routes every call to the provider mounted at the name of the
+// secret. Providers are opened when first used.
+type Router struct {
+	mounts []Mount
+	open   Opener
+
+	mu         sync.Mutex
+	opened     map[string]providers.Provider
+	backupDir  string
+	backupOpts backup.Options
+}
+
+var (
+	_ providers.Provider  = (*Router)(nil)
+	_ providers.Versioner = (*Router)(nil)
+	_ providers.Rotator   = (*Router)(nil)
+	_ providers.Pager     = (*Router)(nil)
+)
+
+// New creates a router over a mount table mapping prefixes to provider
+// names. "/" mounts a provider at the root.
+func New(mounts map[string]string, open Opener) (*Router, error) {
+	r := &Router{
+		open:   open,
+		opened: make(map[string]providers.Provider),
+	}
+
+	seen := make(map[string]bool, len(mounts))
+	for prefix, name := range mounts {
+		normalized, err := providers.NormalizeFolder(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount %q: %w", prefix, err)
+		}
+		if seen[normalized] {
+			return nil, fmt.Errorf("mount %q is configured twice", "/"+normalized)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("mount %q has no provider", prefix)
+		}
+		seen[normalized] = true
+		r.mounts = append(r.mounts, Mount{Prefix: normalized, Provider: name})
+	}
+	if len(r.mounts) == 0 {
+		return nil, errors.New("mount table is empty")
+	}
+
+	sort.Slice(r.mounts, func(i, j int) bool {
+		return r.mounts[i].Prefix < r.mounts[j].Prefix
+	})
+	return r, nil
+}
+
+// Mounts returns the mount table, sorted by prefix
+func (r *Router) Mounts() []Mount {
+	return append([]Mount(nil), r.mounts...)
+}
+
+// Origin returns the name of the provider that keeps a secret or folder,
+// or "" if it is outside every mount
+func (r *Router) Origin(name string) string {
+	m, ok := r.mountOf(name)
+	if !ok {
+		return ""
+	}
+	return m.Provider
+}
+
+// Initialize implements the Provider interface. Providers are initialized
+// when they are opened.
+func (r *Router) Initialize(ctx context.Context) error {
+	return nil
+}
+
+// Close closes every provider opened so far
+func (r *Router) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var firstErr error
+	for name, p := range r.opened {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close provider %q: %w", name, err)
+		}
+		delete(r.opened, name)
+	}
+	return firstErr
+}
+
+// Capabilities implements the Capable interface. Every capability is
+// reported because the router passes them on; mounted providers without
+// them fail with ErrNotSupported, and searches fall back to listing them.
+func (r *Router) Capabilities() providers.Capabilities {
+	return providers.Capabilities{
+		Versions: true,
+		Rotation: true,
+		Search:   true,
+		Backup:   true,
+		Folders:  true,
+		Trash:    true,
+		Paging:   true,
+	}
+}
+
+// GetSecret retrieves a secret from the provider it is mounted at
+func (r *Router) GetSecret(ctx context.Context, name string) (*providers.Secret, error) {
+	p, _, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetSecret(ctx, name)
+}
+
+// SetSecret stores a secret in the provider it is mounted at
+func (r *Router) SetSecret(ctx context.Context, secret *providers.Secret) error {
+	p, _, err := r.route(ctx, secret.Name)
+	if err != nil {
+		return err
+	}
+	return p.SetSecret(ctx, secret)
+}
+
+// DeleteSecret deletes a secret from the provider it is mounted at
+func (r *Router) DeleteSecret(ctx context.Context, name string) error {
+	p, _, err := r.route(ctx, name)
+	if err != nil {
+		return err
+	}
+	return p.DeleteSecret(ctx, name)
+}
+
+// ListSecrets lists the secrets of every mount, sorted by name
+func (r *Router) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
+	var secrets []*providers.Secret
+	for _, m := range r.mounts {
+		p, err := r.provider(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+
+		listed, err := p.ListSecrets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets of %s: %w", m.Provider, err)
+		}
+		secrets = append(secrets, r.owned(m, listed)...)
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// ListSecretPages implements the Pager interface. Listings within a single
+// mount are paged by its provider; listings spanning several mounts are
+// merged and paged in memory.
+func (r *Router) ListSecretPages(ctx context.Context, opts providers.ListOptions) providers.PageIterator {
+	mounts := r.mountsBelow(opts.Prefix)
+	if len(mounts) == 1 {
+		p, err := r.provider(ctx, mounts[0])
+		if err != nil {
+			return failedPages(err)
+		}
+		return providers.ListSecretPages(ctx, p, opts)
+	}
+
+	var (
+		secrets map[string]*providers.Secret
+		names   []string
+	)
+	return providers.TokenPages(opts.PageToken, func(ctx context.Context, token string) (*providers.SecretPage, error) {
+		if secrets == nil {
+			secrets = make(map[string]*providers.Secret)
+			for _, m := range mounts {
+				p, err := r.provider(ctx, m)
+				if err != nil {
+					return nil, err
+				}
+
+				listed, err := providers.CollectPages(ctx, providers.ListSecretPages(ctx, p, providers.ListOptions{
+					Prefix:        opts.Prefix,
+					IncludeValues: opts.IncludeValues,
+				}))
+				if err != nil {
+					return nil, fmt.Errorf("failed to list secrets of %s: %w", m.Provider, err)
+				}
+				for _, secret := range r.owned(m, listed) {
+					secrets[secret.Name] = secret
+					names = append(names, secret.Name)
+				}
+			}
+			sort.Strings(names)
+		}
+
+		pageNames, next, err := providers.PaginateNames(names, opts.PageSize, token)
+		if err != nil {
+			return nil, err
+		}
+
+		page := &providers.SecretPage{NextPageToken: next}
+		for _, name := range pageNames {
+			page.Secrets = append(page.Secrets, secrets[name])
+		}
+		return page, nil
+	})
+}
+
+// GetSecretVersion retrieves a specific version of a secret
+func (r *Router) GetSecretVersion(ctx context.Context, name string, version int) (*providers.Secret, error) {
+	v, err := r.versioner(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return v.GetSecretVersion(ctx, name, version)
+}
+
+// ListSecretVersions returns every retained version of a secret
+func (r *Router) ListSecretVersions(ctx context.Context, name string) ([]*providers.Secret, error) {
+	v, err := r.versioner(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return v.ListSecretVersions(ctx, name)
+}
+
+// RollbackSecret stores an earlier version of a secret as a new version
+func (r *Router) RollbackSecret(ctx context.Context, name string, version int) (*providers.Secret, error) {
+	v, err := r.versioner(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return v.RollbackSecret(ctx, name, version)
+}
+
+// GetRotationPolicy retrieves the rotation policy of a secret
+func (r *Router) GetRotationPolicy(ctx context.Context, name string) (*providers.RotationPolicy, error) {
+	rot, err := r.rotator(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return rot.GetRotationPolicy(ctx, name)
+}
+
+// SetRotationPolicy sets the rotation policy of a secret
+func (r *Router) SetRotationPolicy(ctx context.Context, name string, policy *providers.RotationPolicy) error {
+	rot, err := r.rotator(ctx, name)
+	if err != nil {
+		return err
+	}
+	return rot.SetRotationPolicy(ctx, name, policy)
+}
+
+// RotateSecret rotates a secret according to its rotation policy
+func (r *Router) RotateSecret(ctx context.Context, name string) error {
+	rot, err := r.rotator(ctx, name)
+	if err != nil {
+		return err
+	}
+	return rot.RotateSecret(ctx, name)
+}
+
+// versioner returns the provider of a secret if it keeps versions
+func (r *Router) versioner(ctx context.Context, name string) (providers.Versioner, error) {
+	p, m, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := p.(providers.Versioner)
+	if !ok || !providers.CapabilitiesOf(p).Versions {
+		return nil, fmt.Errorf("%s: secret versions %w", m.Provider, providers.ErrNotSupported)
+	}
+	return v, nil
+}
+
+// rotator returns the provider of a secret if it rotates secrets
+func (r *Router) rotator(ctx context.Context, name string) (providers.Rotator, error) {
+	p, m, err := r.route(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rot, ok := p.(providers.Rotator)
+	if !ok || !providers.CapabilitiesOf(p).Rotation {
+		return nil, fmt.Errorf("%s: rotation %w", m.Provider, providers.ErrNotSupported)
+	}
+	return rot, nil
+}
+
+// route returns the provider a secret is mounted at, and its mount
+func (r *Router) route(ctx context.Context, name string) (providers.Provider, Mount, error) {
+	normalized, err := providers.NormalizeName(name)
+	if err != nil {
+		return nil, Mount{}, err
+	}
+	m, ok := r.mountOf(normalized)
+	if !ok {
+		return nil, Mount{}, fmt.Errorf("%w %q", ErrNoMount, normalized)
+	}
+	p, err := r.provider(ctx, m)
+	if err != nil {
+		return nil, Mount{}, err
+	}
+	return p, m, nil
+}
+
+// mountOf returns the mount with the longest prefix of a normalized name
+func (r *Router) mountOf(name string) (Mount, bool) {
+	var (
+		found Mount
+		ok    bool
+	)
+	for _, m := range r.mounts {
+		if m.Prefix == "" || name == m.Prefix || strings.HasPrefix(name, m.Prefix+"/") {
+			if !ok || len(m.Prefix) > len(found.Prefix) {
+				found, ok = m, true
+			}
+		}
+	}
+	return found, ok
+}
+
+// mountsBelow returns the mounts that can hold secrets whose names start
+// with prefix: the mount of the folder the prefix is in, and the mounts
+// below it that the prefix matches
+func (r *Router) mountsBelow(prefix string) []Mount {
+	folder := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		folder = prefix[:i]
+	}
+
+	var mounts []Mount
+	outer, hasOuter := r.mountOf(folder)
+	for _, m := range r.mounts {
+		switch {
+		case hasOuter && m == outer:
+			mounts = append(mounts, m)
+		case m.Prefix != "" && strings.HasPrefix(m.Prefix+"/", prefix) && len(m.Prefix) > len(outer.Prefix):
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// owned returns the listed secrets of a mount that are not shadowed by a
+// deeper mount
+func (r *Router) owned(m Mount, secrets []*providers.Secret) []*providers.Secret {
+	var owned []*providers.Secret
+	for _, secret := range secrets {
+		if in, ok := r.mountOf(secret.Name); ok && in == m {
+			owned = append(owned, secret)
+		}
+	}
+	return owned
+}
+
+// provider returns the provider of a mount, opening it on first use
+func (r *Router) provider(ctx context.Context, m Mount) (providers.Provider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.opened[m.Provider]; ok {
+		return p, nil
+	}
+	p, err := r.open(ctx, m.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open provider %q mounted at %q: %w", m.Provider, "/"+m.Prefix, err)
+	}
+	r.opened[m.Provider] = p
+	return p, nil
+}
+
+// failedPages is a PageIterator whose first page fails
+func failedPages(err error) providers.PageIterator {
+	return providers.TokenPages("", func(ctx context.Context, token string) (*providers.SecretPage, error) {
+		return nil, err
+	})
+}
@@ -0,0 +1,359 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/providers"
+	_ "github.com/keeper/internal/providers/local"
+	"github.com/keeper/pkg/plugin/memory"
+	"github.com/keeper/pkg/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter creates a router over memory providers, one per provider
+// name, and returns them by name. The "plain" provider keeps no versions.
+func newTestRouter(t *testing.T, mounts map[string]string) (*Router, map[string]providers.Provider) {
+	backends := map[string]providers.Provider{
+		"local": memory.New(),
+		"vault": memory.New(),
+		"aws":   memory.New(),
+		"plain": struct{ providers.Provider }{memory.New()},
+	}
+	r, err := New(mounts, func(ctx context.Context, name string) (providers.Provider, error) {
+		p, ok := backends[name]
+		if !ok {
+			return nil, errors.New("unknown provider")
+		}
+		return p, nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r, backends
+}
+
+// newLocalRouter creates a router over local stores in temporary
+// directories, one per provider name, with a master key read from the
+// environment. The "memory" provider is a memory provider instead.
+func newLocalRouter(t *testing.T, mounts map[string]string) *Router {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	t.Setenv("KEEPER_KEY_LOCAL_MASTER_KEY", base64.StdEncoding.EncodeToString(key))
+
+	r, err := New(mounts, func(ctx context.Context, name string) (providers.Provider, error) {
+		if name == "memory" {
+			return memory.New(), nil
+		}
+		p, err := providers.Open(ctx, "local", map[string]interface{}{
+			"path":     filepath.Join(t.TempDir(), name),
+			"keychain": "env",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return p, p.Initialize(ctx)
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRouter_Conformance(t *testing.T) {
+	r, _ := newTestRouter(t, map[string]string{"/": "local", "prod": "vault"})
+	plugintest.Conformance(t, r)
+}
+
+func TestRouter_Route(t *testing.T) {
+	ctx := context.Background()
+	r, backends := newTestRouter(t, map[string]string{
+		"dev":      "local",
+		"/prod/":   "vault",
+		"prod/aws": "aws",
+	})
+
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("dev/db/password", "dev")))
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("prod/db/password", "prod")))
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("prod/aws/key", "aws")))
+
+	// Secrets go to the provider mounted at the longest prefix of their name
+	for name, backend := range map[string]string{
+		"dev/db/password":  "local",
+		"prod/db/password": "vault",
+		"prod/aws/key":     "aws",
+	} {
+		_, err := backends[backend].GetSecret(ctx, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, backend, r.Origin(name))
+	}
+	assert.Equal(t, "vault", r.Origin("prod/awsome"))
+
+	got, err := r.GetSecret(ctx, "/prod/db/password")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", got.Value)
+
+	// Names outside every mount are rejected
+	_, err = r.GetSecret(ctx, "staging/db/password")
+	assert.ErrorIs(t, err, ErrNoMount)
+	assert.Equal(t, "", r.Origin("staging"))
+
+	require.NoError(t, r.DeleteSecret(ctx, "prod/aws/key"))
+	_, err = backends["aws"].GetSecret(ctx, "prod/aws/key")
+	assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+}
+
+func TestRouter_List(t *testing.T) {
+	ctx := context.Background()
+	r, backends := newTestRouter(t, map[string]string{"/": "local", "prod": "vault"})
+
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("app/key", "value")))
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("prod/db", "value")))
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("prod/api", "value")))
+
+	// Secrets shadowed by a deeper mount are not listed
+	require.NoError(t, backends["local"].SetSecret(ctx, providers.NewSecret("prod/shadowed", "value")))
+
+	secrets, err := r.ListSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app/key", "prod/api", "prod/db"}, names(secrets))
+
+	// Listings spanning mounts are merged and paged
+	var listed []string
+	pages := r.ListSecretPages(ctx, providers.ListOptions{PageSize: 2})
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Secrets), 2)
+		for _, secret := range page.Secrets {
+			assert.Empty(t, secret.Value)
+		}
+		listed = append(listed, names(page.Secrets)...)
+	}
+	assert.Equal(t, []string{"app/key", "prod/api", "prod/db"}, listed)
+
+	// Listings within a mount go to its provider only
+	secrets, err = providers.CollectPages(ctx, r.ListSecretPages(ctx, providers.ListOptions{Prefix: "prod/"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod/api", "prod/db"}, names(secrets))
+
+	// A partial segment can match mounts and the folder around them
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("production", "value")))
+	secrets, err = providers.CollectPages(ctx, r.ListSecretPages(ctx, providers.ListOptions{Prefix: "prod"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod/api", "prod/db", "production"}, names(secrets))
+}
+
+func TestRouter_Versions(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestRouter(t, map[string]string{"dev": "local", "legacy": "plain"})
+
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("dev/key", "value-1")))
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("dev/key", "value-2")))
+	versions, err := r.ListSecretVersions(ctx, "dev/key")
+	require.NoError(t, err)
+	assert.Len(t, versions, 2)
+
+	// Mounted providers without versions report so
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("legacy/key", "value")))
+	_, err = r.ListSecretVersions(ctx, "legacy/key")
+	assert.ErrorIs(t, err, providers.ErrNotSupported)
+	assert.EqualError(t, err, "plain: secret versions not supported by provider")
+
+	// So do all of them for rotation
+	assert.ErrorIs(t, r.RotateSecret(ctx, "dev/key"), providers.ErrNotSupported)
+}
+
+func TestRouter_Search(t *testing.T) {
+	ctx := context.Background()
+	r := newLocalRouter(t, map[string]string{"/": "local", "prod": "prod-local", "legacy": "memory"})
+	for _, name := range []string{"app/db", "prod/db", "prod/api", "legacy/db"} {
+		secret := providers.NewSecret(name, "value")
+		if name != "prod/api" {
+			secret.Tags = []string{"db"}
+		}
+		require.NoError(t, r.SetSecret(ctx, secret))
+	}
+
+	// Secrets shadowed by a deeper mount are not found
+	root, err := r.provider(ctx, Mount{Provider: "local"})
+	require.NoError(t, err)
+	shadowed := providers.NewSecret("prod/shadowed", "value")
+	shadowed.Tags = []string{"db"}
+	require.NoError(t, root.SetSecret(ctx, shadowed))
+
+	// Each mount is searched natively, or by listing it
+	found, err := providers.SearchSecrets(ctx, r, providers.SearchOptions{Tags: []string{"db"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app/db", "legacy/db", "prod/db"}, names(found))
+
+	query, err := providers.ParseQuery("tag:db AND name:prod/**")
+	require.NoError(t, err)
+	found, err = providers.QuerySecrets(ctx, r, query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod/db"}, names(found))
+}
+
+func TestRouter_Trash(t *testing.T) {
+	ctx := context.Background()
+	r := newLocalRouter(t, map[string]string{"/": "local", "prod": "prod-local", "legacy": "memory"})
+	for _, name := range []string{"app/key", "prod/key", "legacy/key"} {
+		require.NoError(t, r.SetSecret(ctx, providers.NewSecret(name, "value")))
+		require.NoError(t, r.DeleteSecret(ctx, name))
+	}
+
+	// The trash of every mount is listed, most recently deleted first;
+	// the memory provider keeps none
+	deleted, err := r.ListDeletedSecrets(ctx)
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	assert.Equal(t, "prod/key", deleted[0].Name)
+	assert.Equal(t, "app/key", deleted[1].Name)
+
+	// Secrets are recovered by the provider they are mounted at
+	require.NoError(t, r.RecoverSecret(ctx, "prod/key"))
+	_, err = r.GetSecret(ctx, "prod/key")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, r.RecoverSecret(ctx, "legacy/key"), providers.ErrNotSupported)
+
+	require.NoError(t, r.DeleteSecret(ctx, "prod/key"))
+	purged, err := r.PurgeDeletedSecrets(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	deleted, err = r.ListDeletedSecrets(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	// Without any trash, the router has none either
+	r, _ = newTestRouter(t, map[string]string{"/": "local"})
+	_, err = r.ListDeletedSecrets(ctx)
+	assert.ErrorIs(t, err, providers.ErrNotSupported)
+}
+
+func TestRouter_Folders(t *testing.T) {
+	ctx := context.Background()
+	r := newLocalRouter(t, map[string]string{"/": "local", "prod": "prod-local", "team": "local"})
+	for _, name := range []string{"app/a", "app/b/c", "prod/db/password", "team/key"} {
+		require.NoError(t, r.SetSecret(ctx, providers.NewSecret(name, "value")))
+	}
+
+	// Mounts appear as subfolders, and recursive listings span them
+	secrets, folders, err := r.ListFolder(ctx, "/", false)
+	require.NoError(t, err)
+	assert.Empty(t, secrets)
+	assert.Equal(t, []string{"app/", "prod/", "team/"}, folders)
+
+	secrets, _, err = r.ListFolder(ctx, "", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app/a", "app/b/c", "prod/db/password", "team/key"}, names(secrets))
+
+	secrets, folders, err = r.ListFolder(ctx, "prod", false)
+	require.NoError(t, err)
+	assert.Empty(t, secrets)
+	assert.Equal(t, []string{"prod/db/"}, folders)
+
+	// Folders move within a provider, even across its mounts, but not
+	// between providers
+	require.NoError(t, r.MoveFolder(ctx, "app/b", "team/b"))
+	_, err = r.GetSecret(ctx, "team/b/c")
+	assert.NoError(t, err)
+	err = r.MoveFolder(ctx, "app", "prod/app")
+	assert.ErrorIs(t, err, providers.ErrNotSupported)
+
+	// Deleting a folder deletes the mounts below it
+	require.NoError(t, r.DeleteFolder(ctx, "prod"))
+	_, err = r.GetSecret(ctx, "prod/db/password")
+	assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+	assert.ErrorIs(t, r.DeleteFolder(ctx, "prod"), providers.ErrSecretNotFound)
+}
+
+func TestRouter_Store(t *testing.T) {
+	ctx := context.Background()
+	r := newLocalRouter(t, map[string]string{"/": "local", "team": "local"})
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("app/key", "value")))
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("team/key", "value")))
+
+	// Operations on the whole store go to the provider serving every mount
+	require.NoError(t, r.SetBackupDir(filepath.Join(t.TempDir(), "backup.tar")))
+	require.NoError(t, r.Backup(ctx))
+	require.NoError(t, r.DeleteSecret(ctx, "team/key"))
+	plan, err := r.RestoreBackup(ctx, backup.RestoreOptions{})
+	require.NoError(t, err)
+	assert.Len(t, plan.Restored(), 1)
+	_, err = r.GetSecret(ctx, "team/key")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "dir", r.StorageEngine())
+	n, err := r.MigrateStorage(ctx, "db")
+	require.NoError(t, err)
+	assert.Positive(t, n)
+	assert.Equal(t, "db", r.StorageEngine())
+	assert.NoError(t, r.RebuildIndex())
+
+	// They cannot be split between providers
+	r, _ = newTestRouter(t, map[string]string{"/": "local", "prod": "vault"})
+	assert.ErrorIs(t, r.Backup(ctx), providers.ErrNotSupported)
+	assert.ErrorIs(t, r.RebuildIndex(), providers.ErrNotSupported)
+	assert.ErrorIs(t, r.DeleteMasterKey(), providers.ErrNotSupported)
+
+	// Nor passed on to providers without them
+	r, _ = newTestRouter(t, map[string]string{"/": "local"})
+	err = r.RotateMasterKey(ctx, nil)
+	assert.ErrorIs(t, err, providers.ErrNotSupported)
+	assert.EqualError(t, err, "local: master key rotation not supported by provider")
+	assert.Equal(t, "", r.StorageEngine())
+}
+
+func TestRouter_Open(t *testing.T) {
+	ctx := context.Background()
+	opened := make(map[string]int)
+	r, err := New(map[string]string{"dev": "local", "test": "local", "prod": "broken"}, func(ctx context.Context, name string) (providers.Provider, error) {
+		if name == "broken" {
+			return nil, errors.New("no credentials")
+		}
+		opened[name]++
+		return memory.New(), nil
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	// Providers are opened once, when first used
+	assert.Empty(t, opened)
+	require.NoError(t, r.SetSecret(ctx, providers.NewSecret("dev/key", "value")))
+	_, err = r.GetSecret(ctx, "test/key")
+	assert.ErrorIs(t, err, providers.ErrSecretNotFound)
+	assert.Equal(t, map[string]int{"local": 1}, opened)
+
+	_, err = r.GetSecret(ctx, "prod/key")
+	assert.EqualError(t, err, `failed to open provider "broken" mounted at "/prod": no credentials`)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	open := func(ctx context.Context, name string) (providers.Provider, error) {
+		return memory.New(), nil
+	}
+
+	_, err := New(map[string]string{"prod": "vault", "/prod/": "aws"}, open)
+	assert.EqualError(t, err, `mount "/prod" is configured twice`)
+
+	_, err = New(map[string]string{"../prod": "vault"}, open)
+	assert.ErrorIs(t, err, providers.ErrInvalidName)
+
+	_, err = New(nil, open)
+	assert.Error(t, err)
+}
+
+// names returns the names of secrets
+func names(secrets []*providers.Secret) []string {
+	names := make([]string, len(secrets))
+	for i, secret := range secrets {
+		names[i] = secret.Name
+	}
+	return names
+}
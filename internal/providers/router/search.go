@@ -0,0 +1,53 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/keeper/internal/providers"
+)
+
+var (
+	_ providers.Searcher = (*Router)(nil)
+	_ providers.Querier  = (*Router)(nil)
+)
+
+// SearchSecrets searches every mount, natively where its provider can,
+// and merges the results by name
+func (r *Router) SearchSecrets(ctx context.Context, opts providers.SearchOptions) ([]*providers.Secret, error) {
+	return r.searchMounts(ctx, func(p providers.Provider) ([]*providers.Secret, error) {
+		return providers.SearchSecrets(ctx, p, opts)
+	})
+}
+
+// QuerySecrets pushes a query down to every mount and merges the results
+// by name
+func (r *Router) QuerySecrets(ctx context.Context, query *providers.Query) ([]*providers.Secret, error) {
+	return r.searchMounts(ctx, func(p providers.Provider) ([]*providers.Secret, error) {
+		return providers.QuerySecrets(ctx, p, query)
+	})
+}
+
+// searchMounts runs a search on the provider of every mount and returns
+// the secrets each mount owns, sorted by name
+func (r *Router) searchMounts(ctx context.Context, search func(p providers.Provider) ([]*providers.Secret, error)) ([]*providers.Secret, error) {
+	var results []*providers.Secret
+	for _, m := range r.mounts {
+		p, err := r.provider(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+
+		found, err := search(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search secrets of %s: %w", m.Provider, err)
+		}
+		results = append(results, r.owned(m, found)...)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results, nil
+}
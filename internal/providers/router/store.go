@@ -0,0 +1,245 @@
+package router
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/keeper/internal/backup"
+	"github.com/keeper/internal/providers"
+)
+
+var (
+	_ providers.Backuper        = (*Router)(nil)
+	_ providers.KeyRotator      = (*Router)(nil)
+	_ providers.KeyDeleter      = (*Router)(nil)
+	_ providers.Indexer         = (*Router)(nil)
+	_ providers.StorageMigrator = (*Router)(nil)
+	_ backup.Archiver           = (*Router)(nil)
+	_ backup.Snapshotter        = (*Router)(nil)
+)
+
+// Operations on a whole store, such as backups and key rotation, are passed
+// on when every mount is served by the same provider. They cannot be split
+// by name, so mount tables spanning several providers fail with
+// ErrNotSupported.
+
+// SetBackupDir sets the backup path passed on with each backup call
+func (r *Router) SetBackupDir(dir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.backupDir = dir
+	return nil
+}
+
+// SetBackupOptions sets the backup options passed on with each backup call
+func (r *Router) SetBackupOptions(opts backup.Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.backupOpts = opts
+}
+
+// Backup backs up the store of the mounted provider
+func (r *Router) Backup(ctx context.Context) error {
+	a, err := r.archiver(ctx)
+	if err != nil {
+		return err
+	}
+	return a.Backup(ctx)
+}
+
+// Restore restores the store of the mounted provider from a backup
+func (r *Router) Restore(ctx context.Context) error {
+	a, err := r.archiver(ctx)
+	if err != nil {
+		return err
+	}
+	return a.Restore(ctx)
+}
+
+// RestoreBackup plans and carries out a restore into the mounted provider
+func (r *Router) RestoreBackup(ctx context.Context, opts backup.RestoreOptions) (*backup.RestorePlan, error) {
+	a, err := r.archiver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.RestoreBackup(ctx, opts)
+}
+
+// VerifyBackup checks that a backup can be restored into the mounted provider
+func (r *Router) VerifyBackup(ctx context.Context) (*backup.VerifyReport, error) {
+	a, err := r.archiver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.VerifyBackup(ctx)
+}
+
+// TakeSnapshot takes a snapshot of the store of the mounted provider
+func (r *Router) TakeSnapshot(ctx context.Context, repo *backup.Repository) (*backup.Snapshot, error) {
+	p, m, err := r.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := p.(backup.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("%s: snapshots %w", m.Provider, providers.ErrNotSupported)
+	}
+	return s.TakeSnapshot(ctx, repo)
+}
+
+// RestoreSnapshot returns the store of the mounted provider to a snapshot
+func (r *Router) RestoreSnapshot(ctx context.Context, repo *backup.Repository, snapshot *backup.Snapshot, dryRun bool) (*backup.RestorePlan, error) {
+	p, m, err := r.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := p.(backup.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("%s: snapshots %w", m.Provider, providers.ErrNotSupported)
+	}
+	return s.RestoreSnapshot(ctx, repo, snapshot, dryRun)
+}
+
+// RotateMasterKey rotates the master key of the mounted provider
+func (r *Router) RotateMasterKey(ctx context.Context, progress providers.RotationProgress) error {
+	k, err := r.keyRotator(ctx)
+	if err != nil {
+		return err
+	}
+	return k.RotateMasterKey(ctx, progress)
+}
+
+// ResumeRotation continues an interrupted master key rotation of the
+// mounted provider
+func (r *Router) ResumeRotation(ctx context.Context, progress providers.RotationProgress) error {
+	k, err := r.keyRotator(ctx)
+	if err != nil {
+		return err
+	}
+	return k.ResumeRotation(ctx, progress)
+}
+
+// RollbackRotation undoes an interrupted master key rotation of the
+// mounted provider
+func (r *Router) RollbackRotation(ctx context.Context, progress providers.RotationProgress) error {
+	k, err := r.keyRotator(ctx)
+	if err != nil {
+		return err
+	}
+	return k.RollbackRotation(ctx, progress)
+}
+
+// DeleteMasterKey deletes the master key of the mounted provider
+func (r *Router) DeleteMasterKey() error {
+	p, m, err := r.store(context.Background())
+	if err != nil {
+		return err
+	}
+	k, ok := p.(providers.KeyDeleter)
+	if !ok {
+		return fmt.Errorf("%s: master key deletion %w", m.Provider, providers.ErrNotSupported)
+	}
+	return k.DeleteMasterKey()
+}
+
+// RebuildIndex rebuilds the search index of the mounted provider
+func (r *Router) RebuildIndex() error {
+	ctx := context.Background()
+	p, m, err := r.store(ctx)
+	if err != nil {
+		return err
+	}
+	i, ok := p.(providers.Indexer)
+	if !ok {
+		return fmt.Errorf("%s: search indexes %w", m.Provider, providers.ErrNotSupported)
+	}
+	return i.RebuildIndex()
+}
+
+// StorageEngine returns the storage engine of the mounted provider, or ""
+// if it has none or cannot be opened
+func (r *Router) StorageEngine() string {
+	s, err := r.storageMigrator(context.Background())
+	if err != nil {
+		return ""
+	}
+	return s.StorageEngine()
+}
+
+// MigrateStorage moves the store of the mounted provider to another
+// storage engine
+func (r *Router) MigrateStorage(ctx context.Context, engine string) (int, error) {
+	s, err := r.storageMigrator(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.MigrateStorage(ctx, engine)
+}
+
+// archiver returns the mounted provider if it writes backup archives, with
+// the backup path and options set on the router
+func (r *Router) archiver(ctx context.Context) (backup.Archiver, error) {
+	p, m, err := r.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := p.(backup.Archiver)
+	if !ok {
+		return nil, fmt.Errorf("%s: backup archives %w", m.Provider, providers.ErrNotSupported)
+	}
+
+	r.mu.Lock()
+	dir, opts := r.backupDir, r.backupOpts
+	r.mu.Unlock()
+
+	a.SetBackupOptions(opts)
+	if err := a.SetBackupDir(dir); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// keyRotator returns the mounted provider if it rotates its master key
+func (r *Router) keyRotator(ctx context.Context) (providers.KeyRotator, error) {
+	p, m, err := r.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := p.(providers.KeyRotator)
+	if !ok {
+		return nil, fmt.Errorf("%s: master key rotation %w", m.Provider, providers.ErrNotSupported)
+	}
+	return k, nil
+}
+
+// storageMigrator returns the mounted provider if it has storage engines
+func (r *Router) storageMigrator(ctx context.Context) (providers.StorageMigrator, error) {
+	p, m, err := r.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := p.(providers.StorageMigrator)
+	if !ok {
+		return nil, fmt.Errorf("%s: storage engines %w", m.Provider, providers.ErrNotSupported)
+	}
+	return s, nil
+}
+
+// store returns the provider every mount is served by
+func (r *Router) store(ctx context.Context) (providers.Provider, Mount, error) {
+	m := r.mounts[0]
+	for _, other := range r.mounts[1:] {
+		if other.Provider != m.Provider {
+			return nil, Mount{}, fmt.Errorf("mounts span providers %s and %s, whose stores are managed separately: %w",
+				m.Provider, other.Provider, providers.ErrNotSupported)
+		}
+	}
+
+	p, err := r.provider(ctx, m)
+	if err != nil {
+		return nil, Mount{}, err
+	}
+	return p, m, nil
+}
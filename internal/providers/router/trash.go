@@ -0,0 +1,99 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/keeper/internal/providers"
+)
+
+var _ providers.Recoverable = (*Router)(nil)
+
+// ListDeletedSecrets lists the trash of every mounted provider that keeps
+// one, most recently deleted first. Deleted secrets are listed under the
+// mount their name belongs to.
+func (r *Router) ListDeletedSecrets(ctx context.Context) ([]*providers.DeletedSecret, error) {
+	var deleted []*providers.DeletedSecret
+	err := r.eachTrash(ctx, func(m Mount, trash providers.Recoverable) error {
+		listed, err := trash.ListDeletedSecrets(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list trash of %s: %w", m.Provider, err)
+		}
+		for _, secret := range listed {
+			if in, ok := r.mountOf(secret.Name); ok && in.Provider == m.Provider {
+				deleted = append(deleted, secret)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(deleted, func(i, j int) bool {
+		return deleted[i].DeletedAt.After(deleted[j].DeletedAt)
+	})
+	return deleted, nil
+}
+
+// RecoverSecret restores a deleted secret in the provider it is mounted at
+func (r *Router) RecoverSecret(ctx context.Context, name string) error {
+	p, m, err := r.route(ctx, name)
+	if err != nil {
+		return err
+	}
+	trash, ok := p.(providers.Recoverable)
+	if !ok || !providers.CapabilitiesOf(p).Trash {
+		return fmt.Errorf("%s: trash %w", m.Provider, providers.ErrNotSupported)
+	}
+	return trash.RecoverSecret(ctx, name)
+}
+
+// PurgeDeletedSecrets purges the trash of every mounted provider that
+// keeps one, and returns how many secrets were purged in all
+func (r *Router) PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+	err := r.eachTrash(ctx, func(m Mount, trash providers.Recoverable) error {
+		n, err := trash.PurgeDeletedSecrets(ctx, before)
+		purged += n
+		if err != nil {
+			return fmt.Errorf("failed to purge trash of %s: %w", m.Provider, err)
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// eachTrash calls fn once for every mounted provider that keeps deleted
+// secrets, and fails with ErrNotSupported if none does
+func (r *Router) eachTrash(ctx context.Context, fn func(m Mount, trash providers.Recoverable) error) error {
+	found := false
+	seen := make(map[string]bool)
+	for _, m := range r.mounts {
+		if seen[m.Provider] {
+			continue
+		}
+		seen[m.Provider] = true
+
+		p, err := r.provider(ctx, m)
+		if err != nil {
+			return err
+		}
+		trash, ok := p.(providers.Recoverable)
+		if !ok || !providers.CapabilitiesOf(p).Trash {
+			continue
+		}
+
+		found = true
+		if err := fn(m, trash); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("trash %w", providers.ErrNotSupported)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"regexp"
+	"time"
 )
 
 // SchemaField represents a field in a schema
@@ -25,6 +26,10 @@ type Schema struct {
 	Description string                 `json:"description,omitempty"`
 	Version     string                 `json:"version"`
 	Fields      map[string]SchemaField `json:"fields"`
+
+	// TTL is how long secrets with the schema stay valid after they are
+	// stored, such as 24h or 30d
+	TTL string `json:"ttl,omitempty"`
 }
 
 // LoadSchema loads a schema from a file
@@ -42,6 +47,23 @@ func LoadSchema(path string) (*Schema, error) {
 	return &schema, nil
 }
 
+// ExpiresAt returns when a secret stored at the given time expires under
+// the schema TTL, and false if the schema has no TTL
+func (s *Schema) ExpiresAt(stored time.Time) (time.Time, bool, error) {
+	if s.TTL == "" {
+		return time.Time{}, false, nil
+	}
+
+	ttl, err := ParseDuration(s.TTL)
+	if err == nil && ttl == 0 {
+		err = fmt.Errorf("TTL must be positive")
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid TTL in schema %s: %w", s.Name, err)
+	}
+	return stored.Add(ttl), true, nil
+}
+
 // ValidateSecret validates a secret against its schema
 func ValidateSecret(secret *Secret, schema *Schema) error {
 	if schema == nil {
@@ -0,0 +1,37 @@
+package providers
+
+import (
+	"context"
+	"time"
+)
+
+// Querier is implemented by providers that evaluate queries natively
+type Querier interface {
+	// QuerySecrets returns the secrets matching a query
+	QuerySecrets(ctx context.Context, query *Query) ([]*Secret, error)
+}
+
+// QuerySecrets returns the secrets of a provider that match a query. The
+// query is pushed down to providers implementing Querier. Other providers
+// are searched with the conditions every match must meet, and the results
+// are filtered in memory.
+func QuerySecrets(ctx context.Context, p Provider, query *Query) ([]*Secret, error) {
+	if q, ok := p.(Querier); ok {
+		return q.QuerySecrets(ctx, query)
+	}
+
+	now := time.Now()
+	secrets, err := SearchSecrets(ctx, p, query.Options(now))
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Secret
+	for _, secret := range secrets {
+		if query.Match(secret, now) {
+			results = append(results, secret)
+		}
+	}
+
+	return results, nil
+}
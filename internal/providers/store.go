@@ -0,0 +1,44 @@
+package providers
+
+import "context"
+
+// RotationProgress is called after each record is re-wrapped during a
+// master key rotation
+type RotationProgress func(done, total int)
+
+// KeyRotator is implemented by providers that encrypt secrets under a
+// master key of their own
+type KeyRotator interface {
+	// RotateMasterKey moves every secret to a new master key
+	RotateMasterKey(ctx context.Context, progress RotationProgress) error
+
+	// ResumeRotation continues an interrupted rotation
+	ResumeRotation(ctx context.Context, progress RotationProgress) error
+
+	// RollbackRotation undoes an interrupted rotation
+	RollbackRotation(ctx context.Context, progress RotationProgress) error
+}
+
+// KeyDeleter is implemented by providers whose master key can be deleted
+type KeyDeleter interface {
+	// DeleteMasterKey deletes the master key, leaving every secret
+	// encrypted under it unreadable
+	DeleteMasterKey() error
+}
+
+// Indexer is implemented by providers that keep a search index
+type Indexer interface {
+	// RebuildIndex rebuilds the index from the stored secrets
+	RebuildIndex() error
+}
+
+// StorageMigrator is implemented by providers that can keep their secrets
+// in several storage engines
+type StorageMigrator interface {
+	// StorageEngine returns the storage engine in use
+	StorageEngine() string
+
+	// MigrateStorage copies every record to another storage engine and
+	// returns how many were copied
+	MigrateStorage(ctx context.Context, engine string) (int, error)
+}
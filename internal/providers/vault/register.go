@@ -0,0 +1,48 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/keeper/internal/providers"
+)
+
+func init() {
+	providers.Register(providers.Registration{
+		Type:        "vault",
+		Description: "HashiCorp Vault KV secrets engine",
+		Params: []providers.Param{
+			{Name: "address", Type: providers.ParamString, Required: true, Description: "address of the Vault server"},
+			{Name: "token", Type: providers.ParamString, Required: true, Description: "token to authenticate with"},
+			{Name: "mount", Type: providers.ParamString, Default: "secret", Description: "mount path of the KV engine"},
+			{Name: "namespace", Type: providers.ParamString, Description: "Vault Enterprise namespace"},
+			{Name: "ca_cert", Type: providers.ParamString, Description: "CA certificate to verify the server with"},
+			{Name: "tls_skip_verify", Type: providers.ParamBool, Default: false, Description: "skip verification of the server certificate"},
+		},
+		Factory: open,
+	})
+}
+
+// open creates a Vault provider from its registry parameters
+func open(ctx context.Context, params providers.Params) (providers.Provider, error) {
+	vaultConfig := api.DefaultConfig()
+	vaultConfig.Address = params.String("address")
+	if err := vaultConfig.ConfigureTLS(&api.TLSConfig{
+		CACert:   params.String("ca_cert"),
+		Insecure: params.Bool("tls_skip_verify"),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	client, err := api.NewClient(vaultConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client: %w", err)
+	}
+	client.SetToken(params.String("token"))
+	if namespace := params.String("namespace"); namespace != "" {
+		client.SetNamespace(namespace)
+	}
+
+	return New(client, params.String("mount"))
+}
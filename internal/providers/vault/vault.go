@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/keeper/internal/providers"
@@ -33,6 +32,11 @@ func New(client *api.Client, path string) (*VaultProvider, error) {
 	}, nil
 }
 
+// Initialize implements the Provider interface
+func (p *VaultProvider) Initialize(ctx context.Context) error {
+	return nil
+}
+
 // GetSecret retrieves a secret from Vault
 func (p *VaultProvider) GetSecret(ctx context.Context, key string) (*providers.Secret, error) {
 	if key == "" {
@@ -44,7 +48,7 @@ func (p *VaultProvider) GetSecret(ctx context.Context, key string) (*providers.S
 		return nil, fmt.Errorf("failed to read secret: %w", err)
 	}
 	if secret == nil {
-		return nil, fmt.Errorf("secret not found: %s", key)
+		return nil, providers.ErrSecretNotFound
 	}
 
 	value, ok := secret.Data["value"].(string)
@@ -62,23 +66,24 @@ func (p *VaultProvider) GetSecret(ctx context.Context, key string) (*providers.S
 	}
 
 	return &providers.Secret{
+		Name:     key,
 		Value:    value,
 		Metadata: metadata,
 	}, nil
 }
 
 // SetSecret stores a secret in Vault
-func (p *VaultProvider) SetSecret(ctx context.Context, key, value string, metadata map[string]string) error {
-	if key == "" {
-		return fmt.Errorf("key is required")
+func (p *VaultProvider) SetSecret(ctx context.Context, secret *providers.Secret) error {
+	if err := secret.Validate(); err != nil {
+		return err
 	}
 
 	data := map[string]interface{}{
-		"value":    value,
-		"metadata": metadata,
+		"value":    secret.Value,
+		"metadata": secret.Metadata,
 	}
 
-	_, err := p.client.Logical().Write(path.Join(p.path, key), data)
+	_, err := p.client.Logical().Write(path.Join(p.path, secret.Name), data)
 	if err != nil {
 		return fmt.Errorf("failed to write secret: %w", err)
 	}
@@ -100,29 +105,29 @@ func (p *VaultProvider) DeleteSecret(ctx context.Context, key string) error {
 	return nil
 }
 
-// ListSecrets lists all secrets in Vault with the given prefix
-func (p *VaultProvider) ListSecrets(ctx context.Context, prefix string) ([]string, error) {
-	secret, err := p.client.Logical().List(path.Join(p.path, prefix))
+// ListSecrets lists the secrets at the top of the Vault path. Values are
+// left out.
+func (p *VaultProvider) ListSecrets(ctx context.Context) ([]*providers.Secret, error) {
+	secret, err := p.client.Logical().List(p.path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list secrets: %w", err)
 	}
 
-	var keys []string
+	var secrets []*providers.Secret
 	if secret == nil {
-		return keys, nil
+		return secrets, nil
 	}
 
 	if keysList, ok := secret.Data["keys"].([]interface{}); ok {
 		for _, key := range keysList {
-			if str, ok := key.(string); ok {
-				if strings.HasPrefix(str, prefix) {
-					keys = append(keys, str)
-				}
+			// Keys ending in "/" are folders
+			if str, ok := key.(string); ok && !strings.HasSuffix(str, "/") {
+				secrets = append(secrets, &providers.Secret{Name: str})
 			}
 		}
 	}
 
-	return keys, nil
+	return secrets, nil
 }
 
 // GetRotationPolicy retrieves the rotation policy for a secret
@@ -195,51 +200,11 @@ func (p *VaultProvider) RotateSecret(ctx context.Context, key string) error {
 	}
 
 	// Generate new value based on policy
-	newValue := fmt.Sprintf("%d", providers.RandInt(policy.Length, policy.Length))
-
-	return p.SetSecret(ctx, key, newValue, secret.Metadata)
-}
-
-// GetSecretMetadata gets metadata for a secret
-func (p *VaultProvider) GetSecretMetadata(ctx context.Context, key string) (*providers.SecretMetadata, error) {
-	secret, err := p.GetSecret(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get metadata from Vault
-	metadata, err := p.client.Logical().ReadWithData(
-		fmt.Sprintf("metadata/%s/%s", p.path, key),
-		map[string][]string{},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata: %w", err)
-	}
-
-	var policy *providers.RotationPolicy
-	if metadata != nil && metadata.Data != nil {
-		if customMetadata, ok := metadata.Data["custom_metadata"].(map[string]interface{}); ok {
-			if rotationData, ok := customMetadata["rotation_policy"].(map[string]interface{}); ok {
-				interval, _ := time.ParseDuration(rotationData["interval"].(string))
-				lastRotation, _ := time.Parse(time.RFC3339, rotationData["last_rotation"].(string))
-				nextRotation, _ := time.Parse(time.RFC3339, rotationData["next_rotation"].(string))
-				
-				policy = &providers.RotationPolicy{
-					Interval:     interval,
-					LastRotation: lastRotation,
-					NextRotation: nextRotation,
-				}
-			}
-		}
+	if secret.Value, err = providers.GenerateValue(policy); err != nil {
+		return err
 	}
 
-	return &providers.SecretMetadata{
-		Created:         secret.Created,
-		LastModified:    secret.Updated,
-		UserMetadata:    secret.Metadata,
-		RotationPolicy:  policy,
-		PreviousVersion: "", // Vault handles versions internally
-	}, nil
+	return p.SetSecret(ctx, secret)
 }
 
 // Close implements the Provider interface
@@ -18,22 +18,12 @@ type ShareRequest struct {
 
 // ShareSecret shares a secret from the source provider to the target provider
 func ShareSecret(ctx context.Context, req *ShareRequest) error {
-	// Get the secret from source provider
-	secret, err := req.SourceProvider.GetSecret(ctx, req.Key)
+	// Get the secret from source provider, refusing expired secrets
+	secret, err := providers.GetSecret(ctx, req.SourceProvider, req.Key, providers.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get secret from source: %w", err)
 	}
 
-	// Check if the secret has expired
-	if secret.Metadata != nil {
-		if expiresStr, ok := secret.Metadata["expires_at"]; ok {
-			expires, err := time.Parse(time.RFC3339, expiresStr)
-			if err == nil && time.Now().After(expires) {
-				return fmt.Errorf("secret has expired")
-			}
-		}
-	}
-
 	// Create metadata for shared secret
 	metadata := make(map[string]string)
 	if secret.Metadata != nil {
@@ -49,7 +39,12 @@ func ShareSecret(ctx context.Context, req *ShareRequest) error {
 	}
 
 	// Share the secret to target provider
-	err = req.TargetProvider.SetSecret(ctx, req.Key, secret.Value, metadata)
+	shared := &providers.Secret{
+		Name:     req.Key,
+		Value:    secret.Value,
+		Metadata: metadata,
+	}
+	err = req.TargetProvider.SetSecret(ctx, shared)
 	if err != nil {
 		return fmt.Errorf("failed to set secret in target: %w", err)
 	}
@@ -84,7 +79,9 @@ func SyncSharedSecret(ctx context.Context, req *ShareRequest) error {
 	metadata["synced_at"] = time.Now().Format(time.RFC3339)
 
 	// Sync the secret
-	err = req.TargetProvider.SetSecret(ctx, req.Key, sourceSecret.Value, metadata)
+	targetSecret.Value = sourceSecret.Value
+	targetSecret.Metadata = metadata
+	err = req.TargetProvider.SetSecret(ctx, targetSecret)
 	if err != nil {
 		return fmt.Errorf("failed to sync secret in target: %w", err)
 	}
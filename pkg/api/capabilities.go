@@ -0,0 +1,66 @@
+package api
+
+// Capabilities reports which optional features a provider supports
+type Capabilities struct {
+	Versions bool `json:"versions"`
+	Rotation bool `json:"rotation"`
+	Search   bool `json:"search"`
+	Backup   bool `json:"backup"`
+	Watch    bool `json:"watch"`
+	Folders  bool `json:"folders"`
+	Trash    bool `json:"trash"`
+	Paging   bool `json:"paging"`
+}
+
+// Capable is implemented by providers that report their capabilities
+// themselves, for instance because they depend on the backend they talk to
+type Capable interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the capabilities of a provider: those it reports
+// if it implements Capable, or else the capability interfaces it implements.
+// Watching is only reported for providers implementing Watcher, as nothing
+// can pass it on.
+func CapabilitiesOf(p Provider) Capabilities {
+	if c, ok := p.(Capable); ok {
+		caps := c.Capabilities()
+		_, watches := p.(Watcher)
+		caps.Watch = caps.Watch && watches
+		return caps
+	}
+
+	var c Capabilities
+	_, c.Versions = p.(Versioner)
+	_, c.Rotation = p.(Rotator)
+	_, c.Search = p.(Searcher)
+	_, c.Backup = p.(Backuper)
+	_, c.Watch = p.(Watcher)
+	_, c.Folders = p.(Hierarchical)
+	_, c.Trash = p.(Recoverable)
+	_, c.Paging = p.(Pager)
+	return c
+}
+
+// Names returns the names of the supported capabilities
+func (c Capabilities) Names() []string {
+	var names []string
+	for _, capability := range []struct {
+		name      string
+		supported bool
+	}{
+		{"versions", c.Versions},
+		{"rotation", c.Rotation},
+		{"search", c.Search},
+		{"backup", c.Backup},
+		{"watch", c.Watch},
+		{"folders", c.Folders},
+		{"trash", c.Trash},
+		{"paging", c.Paging},
+	} {
+		if capability.supported {
+			names = append(names, capability.name)
+		}
+	}
+	return names
+}
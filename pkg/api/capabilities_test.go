@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type basicProvider struct{}
+
+func (basicProvider) Initialize(ctx context.Context) error { return nil }
+func (basicProvider) Close() error                         { return nil }
+func (basicProvider) GetSecret(ctx context.Context, name string) (*Secret, error) {
+	return nil, ErrSecretNotFound
+}
+func (basicProvider) SetSecret(ctx context.Context, secret *Secret) error { return nil }
+func (basicProvider) DeleteSecret(ctx context.Context, name string) error { return nil }
+func (basicProvider) ListSecrets(ctx context.Context) ([]*Secret, error)  { return nil, nil }
+
+type trashProvider struct{ basicProvider }
+
+func (trashProvider) ListDeletedSecrets(ctx context.Context) ([]*DeletedSecret, error) {
+	return nil, nil
+}
+func (trashProvider) RecoverSecret(ctx context.Context, name string) error { return nil }
+func (trashProvider) PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
+
+type reportingProvider struct{ trashProvider }
+
+func (reportingProvider) Capabilities() Capabilities {
+	return Capabilities{Search: true, Watch: true}
+}
+
+type watchProvider struct{ basicProvider }
+
+func (watchProvider) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	return nil, nil
+}
+
+func TestCapabilitiesOf(t *testing.T) {
+	assert.Equal(t, Capabilities{}, CapabilitiesOf(basicProvider{}))
+	assert.Empty(t, CapabilitiesOf(basicProvider{}).Names())
+
+	caps := CapabilitiesOf(trashProvider{})
+	assert.Equal(t, Capabilities{Trash: true}, caps)
+	assert.Equal(t, []string{"trash"}, caps.Names())
+
+	// Reported capabilities take precedence over the methods, except for
+	// watching, which needs the Watcher methods
+	assert.Equal(t, Capabilities{Search: true}, CapabilitiesOf(reportingProvider{}))
+	assert.Equal(t, Capabilities{Watch: true}, CapabilitiesOf(watchProvider{}))
+}
@@ -0,0 +1,45 @@
+package api
+
+import "context"
+
+// ListOptions represents options for listing secrets a page at a time
+type ListOptions struct {
+	// Prefix limits the listing to secrets whose names start with it
+	Prefix string `json:"prefix,omitempty"`
+
+	// PageSize is the maximum number of secrets in a page; zero uses the
+	// provider's default
+	PageSize int `json:"page_size,omitempty"`
+
+	// PageToken resumes a listing at the page a previous page pointed to
+	PageToken string `json:"page_token,omitempty"`
+
+	// IncludeValues fills in secret values, which are left out by default
+	IncludeValues bool `json:"include_values,omitempty"`
+}
+
+// SecretPage is one page of a secret listing
+type SecretPage struct {
+	Secrets []*Secret `json:"secrets"`
+
+	// NextPageToken resumes the listing after this page. It is empty after
+	// the last page, and for providers whose listings cannot be resumed.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// PageIterator walks the pages of a secret listing
+type PageIterator interface {
+	// More reports whether there are pages left
+	More() bool
+
+	// NextPage fetches the next page
+	NextPage(ctx context.Context) (*SecretPage, error)
+}
+
+// Pager is implemented by providers that list secrets a page at a time,
+// following their native pagination
+type Pager interface {
+	// ListSecretPages returns an iterator over the pages of secrets
+	// matching opts
+	ListSecretPages(ctx context.Context, opts ListOptions) PageIterator
+}
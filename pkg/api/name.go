@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidName is returned for secret names that are empty or could
+// escape their namespace
+var ErrInvalidName = errors.New("invalid secret name")
+
+// NormalizeName validates a hierarchical secret name such as
+// "app/db/password" and returns it in canonical form, without leading,
+// trailing or repeated slashes. Segments cannot be "." or "..", and names
+// cannot contain backslashes, control characters or "@", which separates
+// a name from a version.
+func NormalizeName(name string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "":
+			continue
+		case ".", "..":
+			return "", fmt.Errorf("%w %q: %q is not allowed", ErrInvalidName, name, segment)
+		}
+
+		for _, r := range segment {
+			if r == '\\' || r == '@' || unicode.IsControl(r) {
+				return "", fmt.Errorf("%w %q: %q is not allowed", ErrInvalidName, name, r)
+			}
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return "", fmt.Errorf("%w: name cannot be empty", ErrInvalidName)
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// NormalizeFolder is like NormalizeName but also accepts the root folder,
+// which is returned as ""
+func NormalizeFolder(folder string) (string, error) {
+	if strings.Trim(folder, "/") == "" {
+		return "", nil
+	}
+	return NormalizeName(folder)
+}
@@ -1,39 +1,212 @@
+// Package api defines the contract between keeper and secret providers.
+//
+// Every provider implements Provider. Features that not every backend can
+// offer, such as version history or rotation, are separate interfaces that
+// a provider implements when it supports them; CapabilitiesOf reports which
+// ones a provider has.
 package api
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
-// Secret represents a secret with its metadata
-type Secret struct {
-	Key       string
-	Value     string
-	Version   int
-	Metadata  map[string]string
-	CreatedAt int64
-	UpdatedAt int64
-}
+var (
+	// ErrSecretNotFound is returned when a secret is not found
+	ErrSecretNotFound = errors.New("secret not found")
+
+	// ErrVersionNotFound is returned when a secret version does not exist
+	// or is no longer retained
+	ErrVersionNotFound = errors.New("secret version not found")
+
+	// ErrNotSupported is returned by providers asked for a feature their
+	// backend does not offer
+	ErrNotSupported = errors.New("not supported by provider")
+)
 
 // Provider defines the interface that all secret providers must implement
 type Provider interface {
-	// Initialize sets up the provider with configuration
-	Initialize(config map[string]interface{}) error
+	// Initialize prepares the provider for use
+	Initialize(ctx context.Context) error
 
-	// Get retrieves a secret by its key
-	Get(ctx context.Context, key string) (*Secret, error)
+	// Close cleans up any resources used by the provider
+	Close() error
 
-	// Set stores a secret
-	Set(ctx context.Context, key string, value string, metadata map[string]string) error
+	// GetSecret retrieves a secret by name
+	GetSecret(ctx context.Context, name string) (*Secret, error)
 
-	// Delete removes a secret
-	Delete(ctx context.Context, key string) error
+	// SetSecret stores a secret
+	SetSecret(ctx context.Context, secret *Secret) error
 
-	// List returns all secret keys under a path
-	List(ctx context.Context, path string) ([]string, error)
+	// DeleteSecret deletes a secret by name
+	DeleteSecret(ctx context.Context, name string) error
 
-	// GetVersion retrieves a specific version of a secret
-	GetVersion(ctx context.Context, key string, version int) (*Secret, error)
+	// ListSecrets lists all secrets. Providers that need a request per
+	// secret to read values may leave them out.
+	ListSecrets(ctx context.Context) ([]*Secret, error)
+}
 
-	// Close cleans up any resources used by the provider
-	Close() error
+// Versioner is implemented by providers that keep the version history of
+// secrets
+type Versioner interface {
+	// GetSecretVersion retrieves a specific version of a secret
+	GetSecretVersion(ctx context.Context, name string, version int) (*Secret, error)
+
+	// ListSecretVersions returns every retained version of a secret, oldest first
+	ListSecretVersions(ctx context.Context, name string) ([]*Secret, error)
+
+	// RollbackSecret stores an earlier version of a secret as a new version
+	RollbackSecret(ctx context.Context, name string, version int) (*Secret, error)
+}
+
+// RotationPolicy describes when and how a secret is rotated
+type RotationPolicy struct {
+	Interval     time.Duration `json:"interval"`
+	Length       int           `json:"length,omitempty"`
+	CharacterSet string        `json:"character_set,omitempty"`
+	LastRotation time.Time     `json:"last_rotation,omitempty"`
+	NextRotation time.Time     `json:"next_rotation,omitempty"`
+
+	// CustomGenerator creates new values instead of random characters
+	CustomGenerator func() (string, error) `json:"-"`
+}
+
+// Rotator is implemented by providers that rotate secrets
+type Rotator interface {
+	// GetRotationPolicy retrieves the rotation policy of a secret
+	GetRotationPolicy(ctx context.Context, name string) (*RotationPolicy, error)
+
+	// SetRotationPolicy sets the rotation policy of a secret
+	SetRotationPolicy(ctx context.Context, name string, policy *RotationPolicy) error
+
+	// RotateSecret replaces the value of a secret according to its
+	// rotation policy
+	RotateSecret(ctx context.Context, name string) error
+}
+
+// SearchOptions represents options for searching secrets
+type SearchOptions struct {
+	Schema       string    `json:"schema,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	CreatedAfter time.Time `json:"created_after,omitempty"`
+
+	// Metadata filters on metadata keys; an empty value matches any value
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	UpdatedAfter time.Time         `json:"updated_after,omitempty"`
+}
+
+// Matches checks if a secret meets every search criterion
+func (o SearchOptions) Matches(secret *Secret) bool {
+	// Check schema
+	if o.Schema != "" && secret.Schema != o.Schema {
+		return false
+	}
+
+	// Check tags
+	for _, tag := range o.Tags {
+		if !secret.HasTag(tag) {
+			return false
+		}
+	}
+
+	// Check metadata, where an empty value matches any value
+	for key, value := range o.Metadata {
+		actual, ok := secret.Metadata[key]
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+
+	// Check timestamps
+	if !o.CreatedAfter.IsZero() && secret.CreatedAt.Before(o.CreatedAfter) {
+		return false
+	}
+	if !o.UpdatedAfter.IsZero() && secret.UpdatedAt.Before(o.UpdatedAfter) {
+		return false
+	}
+
+	return true
+}
+
+// Searcher is implemented by providers that search secrets natively
+type Searcher interface {
+	// SearchSecrets returns the secrets meeting every search criterion
+	SearchSecrets(ctx context.Context, opts SearchOptions) ([]*Secret, error)
+}
+
+// Backuper is implemented by providers that back up and restore their
+// secrets themselves
+type Backuper interface {
+	// SetBackupDir sets where backups are written and read
+	SetBackupDir(dir string) error
+
+	// Backup creates a backup of all secrets
+	Backup(ctx context.Context) error
+
+	// Restore restores secrets from a backup
+	Restore(ctx context.Context) error
+}
+
+// Types of secret change events
+const (
+	EventSet     = "set"
+	EventDeleted = "deleted"
+)
+
+// Event is a change to a secret
+type Event struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	Version int       `json:"secret_version,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Watcher is implemented by providers that report changes to secrets
+type Watcher interface {
+	// Watch sends an event for every change to a secret whose name starts
+	// with prefix. The channel is closed once ctx is done.
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+}
+
+// Hierarchical is implemented by providers that organise secrets in
+// folders, using "/" in secret names
+type Hierarchical interface {
+	// ListFolder returns the secrets directly in a folder and the names of
+	// its subfolders, or every secret in the subtree when recursive is set.
+	// The root folder is "".
+	ListFolder(ctx context.Context, folder string, recursive bool) ([]*Secret, []string, error)
+
+	// MoveFolder moves every secret in a folder, with its history, to another folder
+	MoveFolder(ctx context.Context, from, to string) error
+
+	// DeleteFolder deletes every secret in a folder and its subfolders
+	DeleteFolder(ctx context.Context, folder string) error
+}
+
+// DeletedSecret describes a deleted secret that can still be recovered
+type DeletedSecret struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"secret_version,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+
+	// PurgeAt is when the secret is purged for good, if known
+	PurgeAt time.Time `json:"purge_at,omitempty"`
+}
+
+// Recoverable is implemented by providers that keep deleted secrets for a
+// while, so that a deletion can be undone
+type Recoverable interface {
+	// ListDeletedSecrets returns the deleted secrets that can still be
+	// recovered, most recently deleted first
+	ListDeletedSecrets(ctx context.Context) ([]*DeletedSecret, error)
+
+	// RecoverSecret restores the most recently deleted secret with the
+	// given name
+	RecoverSecret(ctx context.Context, name string) error
+
+	// PurgeDeletedSecrets permanently deletes the secrets deleted before a
+	// given time, and returns how many were purged
+	PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error)
 }
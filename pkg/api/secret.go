@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"time"
+)
+
+// ExpiresAtKey is the metadata key holding the RFC 3339 expiry time of a secret
+const ExpiresAtKey = "expires_at"
+
+// Secret represents a secret with metadata
+type Secret struct {
+	Name      string            `json:"name"`
+	Value     string            `json:"value"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Schema    string            `json:"schema,omitempty"`
+	Version   int               `json:"secret_version,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// NewSecret creates a new secret with the given name and value
+func NewSecret(name, value string) *Secret {
+	now := time.Now()
+	return &Secret{
+		Name:      name,
+		Value:     value,
+		Metadata:  make(map[string]string),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Validate checks if the secret is valid
+func (s *Secret) Validate() error {
+	if s.Name == "" {
+		return errors.New("secret name cannot be empty")
+	}
+	if _, err := NormalizeName(s.Name); err != nil {
+		return err
+	}
+	if s.Value == "" {
+		return errors.New("secret value cannot be empty")
+	}
+	return nil
+}
+
+// Clone creates a deep copy of a secret
+func (s *Secret) Clone() *Secret {
+	clone := &Secret{
+		Name:      s.Name,
+		Value:     s.Value,
+		Schema:    s.Schema,
+		Version:   s.Version,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+	}
+
+	// Copy metadata
+	if s.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(s.Metadata))
+		for k, v := range s.Metadata {
+			clone.Metadata[k] = v
+		}
+	}
+
+	// Copy tags
+	if s.Tags != nil {
+		clone.Tags = make([]string, len(s.Tags))
+		copy(clone.Tags, s.Tags)
+	}
+
+	return clone
+}
+
+// String returns a string representation of the secret
+func (s *Secret) String() string {
+	return s.Name
+}
+
+// HasTag reports whether a secret has the given tag
+func (s *Secret) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiresAt returns the expiry time of a secret, and false if it does not
+// expire
+func (s *Secret) ExpiresAt() (time.Time, bool) {
+	value, ok := s.Metadata[ExpiresAtKey]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// SetExpiresAt makes a secret expire at the given time
+func (s *Secret) SetExpiresAt(t time.Time) {
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]string)
+	}
+	s.Metadata[ExpiresAtKey] = t.UTC().Format(time.RFC3339)
+}
+
+// Expired reports whether a secret has expired by the given time
+func (s *Secret) Expired(now time.Time) bool {
+	expiresAt, ok := s.ExpiresAt()
+	return ok && !now.Before(expiresAt)
+}
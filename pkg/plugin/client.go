@@ -0,0 +1,526 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/keeper/pkg/api"
+)
+
+// DefaultTimeout is how long a call to a plugin may take by default
+const DefaultTimeout = 30 * time.Second
+
+// Client is a provider served by a plugin. It implements api.Provider, and
+// the capability interfaces the protocol carries; those the plugin lacks
+// return api.ErrNotSupported.
+type Client struct {
+	name         string
+	capabilities api.Capabilities
+	timeout      time.Duration
+
+	cmd *exec.Cmd
+	w   io.WriteCloser
+
+	writeMu sync.Mutex
+	encoder *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *response
+	done    chan struct{}
+	err     error
+}
+
+var (
+	_ api.Provider     = (*Client)(nil)
+	_ api.Capable      = (*Client)(nil)
+	_ api.Versioner    = (*Client)(nil)
+	_ api.Rotator      = (*Client)(nil)
+	_ api.Searcher     = (*Client)(nil)
+	_ api.Pager        = (*Client)(nil)
+	_ api.Hierarchical = (*Client)(nil)
+	_ api.Recoverable  = (*Client)(nil)
+)
+
+// Start launches a plugin executable and performs the handshake, passing
+// it the configuration parameters of the provider. Each call may take up
+// to timeout, or DefaultTimeout if it is zero.
+func Start(ctx context.Context, path string, parameters map[string]interface{}, timeout time.Duration) (*Client, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+
+	c := newClient(stdout, stdin, timeout)
+	c.cmd = cmd
+	if err := c.handshake(ctx, parameters); err != nil {
+		c.stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connect performs the handshake with a plugin served over a connection,
+// such as a plugin running in the same process in tests
+func Connect(ctx context.Context, r io.Reader, w io.WriteCloser, parameters map[string]interface{}, timeout time.Duration) (*Client, error) {
+	c := newClient(r, w, timeout)
+	if err := c.handshake(ctx, parameters); err != nil {
+		c.stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newClient creates a client and starts reading responses
+func newClient(r io.Reader, w io.WriteCloser, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c := &Client{
+		name:    "plugin",
+		timeout: timeout,
+		w:       w,
+		encoder: json.NewEncoder(w),
+		pending: make(map[uint64]chan *response),
+		done:    make(chan struct{}),
+	}
+	go c.read(r)
+	return c
+}
+
+// Name returns the name the plugin gave in the handshake
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Capabilities returns the capabilities the plugin reported in the handshake
+func (c *Client) Capabilities() api.Capabilities {
+	return c.capabilities
+}
+
+// handshake agrees on the protocol version and configures the plugin
+func (c *Client) handshake(ctx context.Context, parameters map[string]interface{}) error {
+	result, err := c.call(ctx, MethodHandshake, &Args{
+		ProtocolVersion: ProtocolVersion,
+		Parameters:      jsonParameters(parameters),
+	})
+	if err != nil {
+		return fmt.Errorf("plugin handshake failed: %w", err)
+	}
+	if result.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("plugin handshake failed: %w: %d", ErrProtocolVersion, result.ProtocolVersion)
+	}
+
+	if result.Name != "" {
+		c.name = result.Name
+	}
+	if result.Capabilities != nil {
+		c.capabilities = supported(*result.Capabilities)
+	}
+	return nil
+}
+
+// read delivers responses to the calls waiting for them until the
+// connection ends
+func (c *Client) read(r io.Reader) {
+	decoder := json.NewDecoder(r)
+	for {
+		var resp response
+		if err := decoder.Decode(&resp); err != nil {
+			if err == io.EOF {
+				err = errors.New("plugin exited")
+			} else {
+				err = fmt.Errorf("failed to read plugin response: %w", err)
+			}
+
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			close(c.done)
+			return
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+
+		// Responses to calls that timed out are dropped
+		if ok {
+			ch <- &resp
+		}
+	}
+}
+
+// call sends a request to the plugin and waits for its response, for at
+// most the call timeout
+func (c *Client) call(ctx context.Context, method string, args *Args) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	ch := make(chan *response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	err := c.encoder.Encode(&request{JSONRPC: "2.0", ID: id, Method: method, Params: args})
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(id)
+		return nil, fmt.Errorf("failed to send %s to plugin: %w", method, err)
+	}
+
+	select {
+	case resp := <-ch:
+		return resultOf(resp)
+
+	case <-ctx.Done():
+		c.forget(id)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("plugin %s: %s timed out after %s: %w", c.name, method, c.timeout, ctx.Err())
+		}
+		return nil, ctx.Err()
+
+	case <-c.done:
+		// The plugin may have answered just before exiting, as it does on close
+		select {
+		case resp := <-ch:
+			return resultOf(resp)
+		default:
+		}
+
+		c.mu.Lock()
+		err := c.err
+		c.mu.Unlock()
+		return nil, fmt.Errorf("plugin %s: %s: %w", c.name, method, err)
+	}
+}
+
+// resultOf returns the result of a response, or its error
+func resultOf(resp *response) (*Result, error) {
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if resp.Result == nil {
+		return &Result{}, nil
+	}
+	return resp.Result, nil
+}
+
+// forget stops waiting for the response to a call
+func (c *Client) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// stop closes the connection and waits for the plugin to exit, killing it
+// if it does not within the call timeout
+func (c *Client) stop() error {
+	c.w.Close()
+	if c.cmd == nil {
+		return nil
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- c.cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(c.timeout):
+		c.cmd.Process.Kill()
+		return fmt.Errorf("plugin %s did not exit and was killed", c.name)
+	}
+}
+
+// Initialize initializes the provider of the plugin
+func (c *Client) Initialize(ctx context.Context) error {
+	_, err := c.call(ctx, MethodInitialize, nil)
+	return err
+}
+
+// Close closes the provider of the plugin and waits for the plugin to exit
+func (c *Client) Close() error {
+	_, err := c.call(context.Background(), MethodClose, nil)
+	if stopErr := c.stop(); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// GetSecret retrieves a secret by name
+func (c *Client) GetSecret(ctx context.Context, name string) (*api.Secret, error) {
+	result, err := c.call(ctx, MethodGetSecret, &Args{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return result.Secret, nil
+}
+
+// SetSecret stores a secret, filling in what the plugin set, such as its version
+func (c *Client) SetSecret(ctx context.Context, secret *api.Secret) error {
+	result, err := c.call(ctx, MethodSetSecret, &Args{Secret: secret})
+	if err != nil {
+		return err
+	}
+	if result.Secret != nil {
+		*secret = *result.Secret
+	}
+	return nil
+}
+
+// DeleteSecret deletes a secret by name
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	_, err := c.call(ctx, MethodDeleteSecret, &Args{Name: name})
+	return err
+}
+
+// ListSecrets lists all secrets
+func (c *Client) ListSecrets(ctx context.Context) ([]*api.Secret, error) {
+	result, err := c.call(ctx, MethodListSecrets, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Secrets, nil
+}
+
+// GetSecretVersion retrieves a specific version of a secret
+func (c *Client) GetSecretVersion(ctx context.Context, name string, version int) (*api.Secret, error) {
+	if !c.capabilities.Versions {
+		return nil, errNotSupported(MethodGetSecretVersion)
+	}
+	result, err := c.call(ctx, MethodGetSecretVersion, &Args{Name: name, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	return result.Secret, nil
+}
+
+// ListSecretVersions returns every retained version of a secret, oldest first
+func (c *Client) ListSecretVersions(ctx context.Context, name string) ([]*api.Secret, error) {
+	if !c.capabilities.Versions {
+		return nil, errNotSupported(MethodListSecretVersions)
+	}
+	result, err := c.call(ctx, MethodListSecretVersions, &Args{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return result.Secrets, nil
+}
+
+// RollbackSecret stores an earlier version of a secret as a new version
+func (c *Client) RollbackSecret(ctx context.Context, name string, version int) (*api.Secret, error) {
+	if !c.capabilities.Versions {
+		return nil, errNotSupported(MethodRollbackSecret)
+	}
+	result, err := c.call(ctx, MethodRollbackSecret, &Args{Name: name, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	return result.Secret, nil
+}
+
+// GetRotationPolicy retrieves the rotation policy of a secret
+func (c *Client) GetRotationPolicy(ctx context.Context, name string) (*api.RotationPolicy, error) {
+	if !c.capabilities.Rotation {
+		return nil, errNotSupported(MethodGetRotationPolicy)
+	}
+	result, err := c.call(ctx, MethodGetRotationPolicy, &Args{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return result.Policy, nil
+}
+
+// SetRotationPolicy sets the rotation policy of a secret. Custom
+// generators run in keeper and cannot be sent to plugins.
+func (c *Client) SetRotationPolicy(ctx context.Context, name string, policy *api.RotationPolicy) error {
+	if !c.capabilities.Rotation {
+		return errNotSupported(MethodSetRotationPolicy)
+	}
+	if policy.CustomGenerator != nil {
+		return fmt.Errorf("custom generators: %w", api.ErrNotSupported)
+	}
+	_, err := c.call(ctx, MethodSetRotationPolicy, &Args{Name: name, Policy: policy})
+	return err
+}
+
+// RotateSecret replaces the value of a secret according to its rotation policy
+func (c *Client) RotateSecret(ctx context.Context, name string) error {
+	if !c.capabilities.Rotation {
+		return errNotSupported(MethodRotateSecret)
+	}
+	_, err := c.call(ctx, MethodRotateSecret, &Args{Name: name})
+	return err
+}
+
+// SearchSecrets returns the secrets meeting every search criterion
+func (c *Client) SearchSecrets(ctx context.Context, opts api.SearchOptions) ([]*api.Secret, error) {
+	if !c.capabilities.Search {
+		return nil, errNotSupported(MethodSearchSecrets)
+	}
+	result, err := c.call(ctx, MethodSearchSecrets, &Args{Search: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return result.Secrets, nil
+}
+
+// ListSecretPages returns an iterator over the pages of secrets matching
+// opts, fetching each page with a call of its own
+func (c *Client) ListSecretPages(ctx context.Context, opts api.ListOptions) api.PageIterator {
+	return &pageIterator{client: c, opts: opts}
+}
+
+// pageIterator walks the pages of a plugin listing
+type pageIterator struct {
+	client *Client
+	opts   api.ListOptions
+	done   bool
+}
+
+func (it *pageIterator) More() bool {
+	return !it.done
+}
+
+func (it *pageIterator) NextPage(ctx context.Context) (*api.SecretPage, error) {
+	if !it.client.capabilities.Paging {
+		return nil, errNotSupported(MethodListSecretPages)
+	}
+	opts := it.opts
+	result, err := it.client.call(ctx, MethodListSecretPages, &Args{List: &opts})
+	if err != nil {
+		return nil, err
+	}
+
+	page := result.Page
+	if page == nil {
+		page = &api.SecretPage{}
+	}
+	it.opts.PageToken = page.NextPageToken
+	it.done = page.NextPageToken == ""
+	return page, nil
+}
+
+// ListFolder returns the secrets directly in a folder and the names of its subfolders
+func (c *Client) ListFolder(ctx context.Context, folder string, recursive bool) ([]*api.Secret, []string, error) {
+	if !c.capabilities.Folders {
+		return nil, nil, errNotSupported(MethodListFolder)
+	}
+	result, err := c.call(ctx, MethodListFolder, &Args{Folder: folder, Recursive: recursive})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Secrets, result.Folders, nil
+}
+
+// MoveFolder moves every secret in a folder to another folder
+func (c *Client) MoveFolder(ctx context.Context, from, to string) error {
+	if !c.capabilities.Folders {
+		return errNotSupported(MethodMoveFolder)
+	}
+	_, err := c.call(ctx, MethodMoveFolder, &Args{From: from, To: to})
+	return err
+}
+
+// DeleteFolder deletes every secret in a folder and its subfolders
+func (c *Client) DeleteFolder(ctx context.Context, folder string) error {
+	if !c.capabilities.Folders {
+		return errNotSupported(MethodDeleteFolder)
+	}
+	_, err := c.call(ctx, MethodDeleteFolder, &Args{Folder: folder})
+	return err
+}
+
+// ListDeletedSecrets returns the deleted secrets that can still be recovered
+func (c *Client) ListDeletedSecrets(ctx context.Context) ([]*api.DeletedSecret, error) {
+	if !c.capabilities.Trash {
+		return nil, errNotSupported(MethodListDeletedSecrets)
+	}
+	result, err := c.call(ctx, MethodListDeletedSecrets, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Deleted, nil
+}
+
+// RecoverSecret restores the most recently deleted secret with the given name
+func (c *Client) RecoverSecret(ctx context.Context, name string) error {
+	if !c.capabilities.Trash {
+		return errNotSupported(MethodRecoverSecret)
+	}
+	_, err := c.call(ctx, MethodRecoverSecret, &Args{Name: name})
+	return err
+}
+
+// PurgeDeletedSecrets permanently deletes the secrets deleted before a given time
+func (c *Client) PurgeDeletedSecrets(ctx context.Context, before time.Time) (int, error) {
+	if !c.capabilities.Trash {
+		return 0, errNotSupported(MethodPurgeDeletedSecrets)
+	}
+	result, err := c.call(ctx, MethodPurgeDeletedSecrets, &Args{Before: &before})
+	if err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// jsonParameters converts parameters decoded from YAML, whose nested maps
+// have interface{} keys, to values that can be encoded as JSON
+func jsonParameters(parameters map[string]interface{}) map[string]interface{} {
+	if parameters == nil {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(parameters))
+	for key, value := range parameters {
+		converted[key] = jsonValue(value)
+	}
+	return converted
+}
+
+// jsonValue converts a value decoded from YAML to one that can be encoded as JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case map[string]interface{}:
+		return jsonParameters(v)
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = jsonValue(item)
+		}
+		return converted
+	default:
+		return value
+	}
+}
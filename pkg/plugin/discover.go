@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Discover returns the plugin executables found in dirs and then in the
+// directories of PATH, by provider name. When several share a name, the
+// first one found is used.
+func Discover(dirs []string) map[string]string {
+	found := make(map[string]string)
+	for _, dir := range append(dirs, filepath.SplitList(os.Getenv("PATH"))...) {
+		if dir == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			// Missing and unreadable directories have no plugins
+			continue
+		}
+
+		for _, entry := range entries {
+			name, ok := pluginName(entry)
+			if !ok {
+				continue
+			}
+			if _, ok := found[name]; !ok {
+				found[name] = filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+	return found
+}
+
+// pluginName returns the provider name of a plugin executable
+func pluginName(entry os.FileInfo) (string, bool) {
+	if !entry.Mode().IsRegular() || !strings.HasPrefix(entry.Name(), Prefix) {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(entry.Name(), Prefix)
+	if runtime.GOOS == "windows" {
+		var ok bool
+		if name, ok = strings.CutSuffix(name, ".exe"); !ok {
+			return "", false
+		}
+	} else if entry.Mode().Perm()&0111 == 0 {
+		return "", false
+	}
+	return name, name != ""
+}
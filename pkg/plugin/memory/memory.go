@@ -0,0 +1,159 @@
+// Package memory is a provider keeping secrets in memory. It is the
+// provider of the reference plugin, keeper-provider-memory, and a starting
+// point for writing plugins.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keeper/pkg/api"
+)
+
+// Provider keeps secrets, and their previous versions, in memory
+type Provider struct {
+	mu       sync.RWMutex
+	versions map[string][]*api.Secret
+}
+
+var (
+	_ api.Provider  = (*Provider)(nil)
+	_ api.Versioner = (*Provider)(nil)
+)
+
+// New creates an empty memory provider
+func New() *Provider {
+	return &Provider{
+		versions: make(map[string][]*api.Secret),
+	}
+}
+
+// Initialize implements the Provider interface
+func (p *Provider) Initialize(ctx context.Context) error {
+	return nil
+}
+
+// Close implements the Provider interface
+func (p *Provider) Close() error {
+	return nil
+}
+
+// GetSecret retrieves the current version of a secret
+func (p *Provider) GetSecret(ctx context.Context, name string) (*api.Secret, error) {
+	name, err := api.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	versions := p.versions[name]
+	if len(versions) == 0 {
+		return nil, api.ErrSecretNotFound
+	}
+	return versions[len(versions)-1].Clone(), nil
+}
+
+// SetSecret stores a secret as a new version
+func (p *Provider) SetSecret(ctx context.Context, secret *api.Secret) error {
+	if err := secret.Validate(); err != nil {
+		return err
+	}
+	name, err := api.NormalizeName(secret.Name)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	versions := p.versions[name]
+	secret.Name = name
+	secret.Version = len(versions) + 1
+	secret.UpdatedAt = now
+	if len(versions) > 0 {
+		secret.CreatedAt = versions[0].CreatedAt
+	} else if secret.CreatedAt.IsZero() {
+		secret.CreatedAt = now
+	}
+	p.versions[name] = append(versions, secret.Clone())
+	return nil
+}
+
+// DeleteSecret deletes a secret and its history
+func (p *Provider) DeleteSecret(ctx context.Context, name string) error {
+	name, err := api.NormalizeName(name)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.versions[name]; !ok {
+		return api.ErrSecretNotFound
+	}
+	delete(p.versions, name)
+	return nil
+}
+
+// ListSecrets lists all secrets, sorted by name
+func (p *Provider) ListSecrets(ctx context.Context) ([]*api.Secret, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	secrets := make([]*api.Secret, 0, len(p.versions))
+	for _, versions := range p.versions {
+		secrets = append(secrets, versions[len(versions)-1].Clone())
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// GetSecretVersion retrieves a specific version of a secret
+func (p *Provider) GetSecretVersion(ctx context.Context, name string, version int) (*api.Secret, error) {
+	versions, err := p.ListSecretVersions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > len(versions) {
+		return nil, api.ErrVersionNotFound
+	}
+	return versions[version-1], nil
+}
+
+// ListSecretVersions returns every version of a secret, oldest first
+func (p *Provider) ListSecretVersions(ctx context.Context, name string) ([]*api.Secret, error) {
+	name, err := api.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	versions := p.versions[name]
+	if len(versions) == 0 {
+		return nil, api.ErrSecretNotFound
+	}
+
+	secrets := make([]*api.Secret, len(versions))
+	for i, version := range versions {
+		secrets[i] = version.Clone()
+	}
+	return secrets, nil
+}
+
+// RollbackSecret stores an earlier version of a secret as a new version
+func (p *Provider) RollbackSecret(ctx context.Context, name string, version int) (*api.Secret, error) {
+	previous, err := p.GetSecretVersion(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.SetSecret(ctx, previous); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
@@ -0,0 +1,11 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/keeper/pkg/plugin/plugintest"
+)
+
+func TestConformance(t *testing.T) {
+	plugintest.Conformance(t, New())
+}
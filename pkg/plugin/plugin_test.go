@@ -0,0 +1,127 @@
+package plugin_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keeper/pkg/api"
+	"github.com/keeper/pkg/plugin"
+	"github.com/keeper/pkg/plugin/memory"
+	"github.com/keeper/pkg/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryFactory creates memory providers, rejecting any parameter
+func memoryFactory(ctx context.Context, parameters map[string]interface{}) (api.Provider, error) {
+	for name := range parameters {
+		return nil, fmt.Errorf("unknown parameter %q", name)
+	}
+	return memory.New(), nil
+}
+
+// connect serves a plugin in the process and connects a client to it
+func connect(t *testing.T, factory plugin.Factory, parameters map[string]interface{}, timeout time.Duration) (*plugin.Client, error) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	go func() {
+		plugin.ServeConn(context.Background(), "test", factory, serverR, serverW)
+		serverW.Close()
+	}()
+	return plugin.Connect(context.Background(), clientR, clientW, parameters, timeout)
+}
+
+func TestClient_Conformance(t *testing.T) {
+	client, err := connect(t, memoryFactory, nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, client.Initialize(context.Background()))
+
+	assert.Equal(t, "test", client.Name())
+	assert.Equal(t, []string{"versions"}, api.CapabilitiesOf(client).Names())
+
+	plugintest.Conformance(t, client)
+
+	// Capabilities the plugin lacks are not supported
+	_, err = client.SearchSecrets(context.Background(), api.SearchOptions{})
+	assert.ErrorIs(t, err, api.ErrNotSupported)
+
+	require.NoError(t, client.Close())
+	_, err = client.GetSecret(context.Background(), "conformance-set")
+	assert.Error(t, err)
+}
+
+func TestClient_Handshake(t *testing.T) {
+	_, err := connect(t, memoryFactory, map[string]interface{}{"nested": map[interface{}]interface{}{"a": 1}}, 0)
+	assert.EqualError(t, err, `plugin handshake failed: unknown parameter "nested"`)
+}
+
+func TestServe_ProtocolVersion(t *testing.T) {
+	in := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"handshake","params":{"protocol_version":99}}` + "\n")
+	var out bytes.Buffer
+	require.NoError(t, plugin.ServeConn(context.Background(), "test", memoryFactory, in, &out))
+	assert.Contains(t, out.String(), fmt.Sprintf(`"code":%d`, plugin.CodeProtocolVersion))
+}
+
+// slowProvider never answers GetSecret
+type slowProvider struct {
+	*memory.Provider
+	release chan struct{}
+}
+
+func (p *slowProvider) GetSecret(ctx context.Context, name string) (*api.Secret, error) {
+	<-p.release
+	return nil, api.ErrSecretNotFound
+}
+
+func TestClient_Timeout(t *testing.T) {
+	slow := &slowProvider{Provider: memory.New(), release: make(chan struct{})}
+	defer close(slow.release)
+	client, err := connect(t, func(ctx context.Context, parameters map[string]interface{}) (api.Provider, error) {
+		return slow, nil
+	}, nil, 50*time.Millisecond)
+	require.NoError(t, err)
+
+	_, err = client.GetSecret(context.Background(), "name")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "get_secret timed out after 50ms")
+
+	// Other calls are served in the meantime
+	require.NoError(t, client.SetSecret(context.Background(), api.NewSecret("name", "value")))
+}
+
+func TestStart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the reference plugin")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	dir := t.TempDir()
+	build := exec.Command(goTool, "build", "-o", filepath.Join(dir, plugin.Prefix+"memory"), "github.com/keeper/cmd/keeper-provider-memory")
+	output, err := build.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	path, ok := plugin.Discover([]string{dir})["memory"]
+	require.True(t, ok)
+
+	client, err := plugin.Start(context.Background(), path, nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, client.Initialize(context.Background()))
+	assert.Equal(t, "memory", client.Name())
+
+	plugintest.Conformance(t, client)
+	require.NoError(t, client.Close())
+
+	_, err = plugin.Start(context.Background(), path, map[string]interface{}{"path": "/tmp"}, 0)
+	assert.EqualError(t, err, `plugin handshake failed: memory provider: unknown parameter "path"`)
+}
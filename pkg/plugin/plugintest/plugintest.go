@@ -0,0 +1,166 @@
+// Package plugintest checks that a provider follows the provider contract.
+// Plugin authors run Conformance against their plugin, started with
+// plugin.Start, to check it behaves as keeper expects.
+package plugintest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keeper/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Conformance tests an initialized provider against the provider contract,
+// and the capabilities it reports. The provider must not hold secrets whose
+// names start with "conformance-".
+func Conformance(t *testing.T, p api.Provider) {
+	ctx := context.Background()
+
+	t.Run("SetGet", func(t *testing.T) {
+		secret := api.NewSecret("conformance-set", "value-1")
+		secret.Metadata["owner"] = "team"
+		secret.Tags = []string{"prod"}
+		require.NoError(t, p.SetSecret(ctx, secret))
+
+		got, err := p.GetSecret(ctx, "conformance-set")
+		require.NoError(t, err)
+		assert.Equal(t, "conformance-set", got.Name)
+		assert.Equal(t, "value-1", got.Value)
+		assert.Equal(t, "team", got.Metadata["owner"])
+		assert.Equal(t, []string{"prod"}, got.Tags)
+
+		// Setting a secret again replaces its value
+		require.NoError(t, p.SetSecret(ctx, api.NewSecret("conformance-set", "value-2")))
+		got, err = p.GetSecret(ctx, "conformance-set")
+		require.NoError(t, err)
+		assert.Equal(t, "value-2", got.Value)
+	})
+
+	t.Run("InvalidSecrets", func(t *testing.T) {
+		assert.ErrorIs(t, p.SetSecret(ctx, api.NewSecret("../conformance-escape", "value")), api.ErrInvalidName)
+		assert.Error(t, p.SetSecret(ctx, api.NewSecret("conformance-empty", "")))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := p.GetSecret(ctx, "conformance-missing")
+		assert.ErrorIs(t, err, api.ErrSecretNotFound)
+		assert.ErrorIs(t, p.DeleteSecret(ctx, "conformance-missing"), api.ErrSecretNotFound)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		require.NoError(t, p.SetSecret(ctx, api.NewSecret("conformance-list", "value")))
+
+		secrets, err := p.ListSecrets(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, names(secrets), "conformance-list")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, p.SetSecret(ctx, api.NewSecret("conformance-delete", "value")))
+		require.NoError(t, p.DeleteSecret(ctx, "conformance-delete"))
+
+		_, err := p.GetSecret(ctx, "conformance-delete")
+		assert.ErrorIs(t, err, api.ErrSecretNotFound)
+
+		secrets, err := p.ListSecrets(ctx)
+		require.NoError(t, err)
+		assert.NotContains(t, names(secrets), "conformance-delete")
+	})
+
+	capabilities := api.CapabilitiesOf(p)
+	t.Run("Capabilities", func(t *testing.T) {
+		// Reported capabilities come with their interfaces
+		for _, check := range []struct {
+			name        string
+			reported    bool
+			implemented bool
+		}{
+			{"versions", capabilities.Versions, implements[api.Versioner](p)},
+			{"rotation", capabilities.Rotation, implements[api.Rotator](p)},
+			{"search", capabilities.Search, implements[api.Searcher](p)},
+			{"backup", capabilities.Backup, implements[api.Backuper](p)},
+			{"watch", capabilities.Watch, implements[api.Watcher](p)},
+			{"folders", capabilities.Folders, implements[api.Hierarchical](p)},
+			{"trash", capabilities.Trash, implements[api.Recoverable](p)},
+			{"paging", capabilities.Paging, implements[api.Pager](p)},
+		} {
+			if check.reported {
+				assert.True(t, check.implemented, "%s is reported but not implemented", check.name)
+			}
+		}
+	})
+
+	if capabilities.Versions {
+		t.Run("Versions", func(t *testing.T) {
+			versioner := p.(api.Versioner)
+			require.NoError(t, p.SetSecret(ctx, api.NewSecret("conformance-versions", "value-1")))
+			require.NoError(t, p.SetSecret(ctx, api.NewSecret("conformance-versions", "value-2")))
+
+			versions, err := versioner.ListSecretVersions(ctx, "conformance-versions")
+			require.NoError(t, err)
+			require.Len(t, versions, 2)
+			assert.Equal(t, "value-1", versions[0].Value)
+			assert.Equal(t, "value-2", versions[1].Value)
+
+			first, err := versioner.GetSecretVersion(ctx, "conformance-versions", versions[0].Version)
+			require.NoError(t, err)
+			assert.Equal(t, "value-1", first.Value)
+
+			_, err = versioner.GetSecretVersion(ctx, "conformance-versions", 1000)
+			assert.ErrorIs(t, err, api.ErrVersionNotFound)
+
+			_, err = versioner.RollbackSecret(ctx, "conformance-versions", versions[0].Version)
+			require.NoError(t, err)
+			current, err := p.GetSecret(ctx, "conformance-versions")
+			require.NoError(t, err)
+			assert.Equal(t, "value-1", current.Value)
+		})
+	}
+
+	if capabilities.Search {
+		t.Run("Search", func(t *testing.T) {
+			secret := api.NewSecret("conformance-search", "value")
+			secret.Tags = []string{"conformance-tag"}
+			require.NoError(t, p.SetSecret(ctx, secret))
+
+			secrets, err := p.(api.Searcher).SearchSecrets(ctx, api.SearchOptions{Tags: []string{"conformance-tag"}})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"conformance-search"}, names(secrets))
+		})
+	}
+
+	if capabilities.Paging {
+		t.Run("Paging", func(t *testing.T) {
+			for _, name := range []string{"conformance-page-a", "conformance-page-b", "conformance-page-c"} {
+				require.NoError(t, p.SetSecret(ctx, api.NewSecret(name, "value")))
+			}
+
+			var listed []string
+			pages := p.(api.Pager).ListSecretPages(ctx, api.ListOptions{Prefix: "conformance-page-", PageSize: 2})
+			for pages.More() {
+				page, err := pages.NextPage(ctx)
+				require.NoError(t, err)
+				assert.LessOrEqual(t, len(page.Secrets), 2)
+				listed = append(listed, names(page.Secrets)...)
+			}
+			assert.ElementsMatch(t, []string{"conformance-page-a", "conformance-page-b", "conformance-page-c"}, listed)
+		})
+	}
+}
+
+// names returns the names of secrets
+func names(secrets []*api.Secret) []string {
+	names := make([]string, len(secrets))
+	for i, secret := range secrets {
+		names[i] = secret.Name
+	}
+	return names
+}
+
+// implements reports whether a provider implements an interface
+func implements[T any](p api.Provider) bool {
+	_, ok := p.(T)
+	return ok
+}
@@ -0,0 +1,186 @@
+// Package plugin runs secret providers as separate processes.
+//
+// A plugin is an executable named keeper-provider-<name>. Keeper starts it
+// and exchanges JSON-RPC 2.0 messages with it over its standard input and
+// output, one message per line; the standard error of the plugin is passed
+// through for its logs. The methods mirror those of api.Provider and its
+// capability interfaces.
+//
+// The first call is always "handshake", carrying the protocol version and
+// the configuration parameters of the provider. The plugin answers with its
+// name and capabilities, or an error if it does not speak that version or
+// the parameters are invalid. "close" is the last call, after which the
+// plugin exits.
+//
+// Plugins are written with Serve, and launched with Start.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/keeper/pkg/api"
+)
+
+// ProtocolVersion is the version of the plugin protocol. It changes when
+// plugins built for earlier versions would misbehave.
+const ProtocolVersion = 1
+
+// Prefix starts the names of plugin executables
+const Prefix = "keeper-provider-"
+
+// Methods of the plugin protocol
+const (
+	MethodHandshake  = "handshake"
+	MethodInitialize = "initialize"
+	MethodClose      = "close"
+
+	MethodGetSecret    = "get_secret"
+	MethodSetSecret    = "set_secret"
+	MethodDeleteSecret = "delete_secret"
+	MethodListSecrets  = "list_secrets"
+
+	MethodGetSecretVersion   = "get_secret_version"
+	MethodListSecretVersions = "list_secret_versions"
+	MethodRollbackSecret     = "rollback_secret"
+
+	MethodGetRotationPolicy = "get_rotation_policy"
+	MethodSetRotationPolicy = "set_rotation_policy"
+	MethodRotateSecret      = "rotate_secret"
+
+	MethodSearchSecrets   = "search_secrets"
+	MethodListSecretPages = "list_secret_pages"
+
+	MethodListFolder   = "list_folder"
+	MethodMoveFolder   = "move_folder"
+	MethodDeleteFolder = "delete_folder"
+
+	MethodListDeletedSecrets  = "list_deleted_secrets"
+	MethodRecoverSecret       = "recover_secret"
+	MethodPurgeDeletedSecrets = "purge_deleted_secrets"
+)
+
+// Error codes of the plugin protocol. Codes from -32768 to -32000 are
+// those of JSON-RPC; the others carry the errors of pkg/api.
+const (
+	CodeParseError     = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternal       = -32603
+
+	CodeSecretNotFound  = 1
+	CodeVersionNotFound = 2
+	CodeNotSupported    = 3
+	CodeInvalidName     = 4
+	CodeProtocolVersion = 5
+)
+
+// ErrProtocolVersion is returned when keeper and a plugin speak different
+// versions of the protocol
+var ErrProtocolVersion = errors.New("unsupported plugin protocol version")
+
+// codeErrors maps error codes to the errors they stand for
+var codeErrors = map[int]error{
+	CodeSecretNotFound:  api.ErrSecretNotFound,
+	CodeVersionNotFound: api.ErrVersionNotFound,
+	CodeNotSupported:    api.ErrNotSupported,
+	CodeInvalidName:     api.ErrInvalidName,
+	CodeProtocolVersion: ErrProtocolVersion,
+}
+
+// request is a JSON-RPC request
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      uint64 `json:"id"`
+	Method  string `json:"method"`
+	Params  *Args  `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response
+type response struct {
+	JSONRPC string  `json:"jsonrpc"`
+	ID      uint64  `json:"id"`
+	Result  *Result `json:"result,omitempty"`
+	Error   *Error  `json:"error,omitempty"`
+}
+
+// Args holds the arguments of a call. Each method uses the fields named
+// after its parameters.
+type Args struct {
+	// Handshake
+	ProtocolVersion int                    `json:"protocol_version,omitempty"`
+	Parameters      map[string]interface{} `json:"parameters,omitempty"`
+
+	Name    string              `json:"name,omitempty"`
+	Version int                 `json:"version,omitempty"`
+	Secret  *api.Secret         `json:"secret,omitempty"`
+	Policy  *api.RotationPolicy `json:"policy,omitempty"`
+	Search  *api.SearchOptions  `json:"search,omitempty"`
+	List    *api.ListOptions    `json:"list,omitempty"`
+
+	Folder    string     `json:"folder,omitempty"`
+	Recursive bool       `json:"recursive,omitempty"`
+	From      string     `json:"from,omitempty"`
+	To        string     `json:"to,omitempty"`
+	Before    *time.Time `json:"before,omitempty"`
+}
+
+// Result holds the result of a call
+type Result struct {
+	// Handshake
+	ProtocolVersion int               `json:"protocol_version,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	Capabilities    *api.Capabilities `json:"capabilities,omitempty"`
+
+	Secret  *api.Secret          `json:"secret,omitempty"`
+	Secrets []*api.Secret        `json:"secrets,omitempty"`
+	Policy  *api.RotationPolicy  `json:"policy,omitempty"`
+	Page    *api.SecretPage      `json:"page,omitempty"`
+	Folders []string             `json:"folders,omitempty"`
+	Deleted []*api.DeletedSecret `json:"deleted,omitempty"`
+	Count   int                  `json:"count,omitempty"`
+}
+
+// Error is an error returned by a plugin
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the pkg/api error the code stands for, so that errors.Is
+// works across the process boundary
+func (e *Error) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// errorOf converts an error to the protocol error sent to keeper
+func errorOf(err error) *Error {
+	var protocolErr *Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr
+	}
+	for code, target := range codeErrors {
+		if errors.Is(err, target) {
+			return &Error{Code: code, Message: err.Error()}
+		}
+	}
+	return &Error{Code: CodeInternal, Message: err.Error()}
+}
+
+// supported masks the capabilities the protocol cannot carry: backups are
+// written where the plugin runs, and watching needs notifications
+func supported(c api.Capabilities) api.Capabilities {
+	c.Backup = false
+	c.Watch = false
+	return c
+}
+
+// errNotSupported is returned for calls to capabilities a plugin lacks
+func errNotSupported(method string) error {
+	return fmt.Errorf("%s: %w", method, api.ErrNotSupported)
+}
@@ -0,0 +1,270 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/keeper/pkg/api"
+)
+
+// Factory creates the provider of a plugin from the configuration
+// parameters sent in the handshake
+type Factory func(ctx context.Context, parameters map[string]interface{}) (api.Provider, error)
+
+// Serve runs a plugin over standard input and output until keeper closes
+// it. Plugin executables call it from main.
+func Serve(name string, factory Factory) error {
+	return ServeConn(context.Background(), name, factory, os.Stdin, os.Stdout)
+}
+
+// ServeConn runs a plugin over a connection until keeper closes it or
+// the connection ends
+func ServeConn(ctx context.Context, name string, factory Factory, r io.Reader, w io.Writer) error {
+	s := &server{
+		name:    name,
+		factory: factory,
+		encoder: json.NewEncoder(w),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	decoder := json.NewDecoder(r)
+	for {
+		var req request
+		if err := decoder.Decode(&req); err != nil {
+			s.calls.Wait()
+			if err == io.EOF {
+				return nil
+			}
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				s.reply(0, nil, &Error{Code: CodeParseError, Message: err.Error()})
+			}
+			return fmt.Errorf("failed to read request: %w", err)
+		}
+
+		switch req.Method {
+		case MethodHandshake:
+			// The provider must exist before any other call is served
+			result, err := s.handshake(ctx, req.Params)
+			s.reply(req.ID, result, err)
+
+		case MethodClose:
+			s.calls.Wait()
+			var err error
+			if s.provider != nil {
+				err = s.provider.Close()
+			}
+			s.reply(req.ID, &Result{}, err)
+			return nil
+
+		default:
+			s.calls.Add(1)
+			go func(req request) {
+				defer s.calls.Done()
+				result, err := s.dispatch(ctx, req.Method, req.Params)
+				s.reply(req.ID, result, err)
+			}(req)
+		}
+	}
+}
+
+// server serves the calls of one connection
+type server struct {
+	name     string
+	factory  Factory
+	provider api.Provider
+	calls    sync.WaitGroup
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// reply sends the response to a call
+func (s *server) reply(id uint64, result *Result, err error) {
+	resp := response{JSONRPC: "2.0", ID: id, Result: result}
+	if err != nil {
+		resp.Result = nil
+		resp.Error = errorOf(err)
+	} else if resp.Result == nil {
+		resp.Result = &Result{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The connection is gone if this fails; the next read will end the loop
+	_ = s.encoder.Encode(&resp)
+}
+
+// handshake checks the protocol version and creates the provider
+func (s *server) handshake(ctx context.Context, args *Args) (*Result, error) {
+	if args == nil || args.ProtocolVersion != ProtocolVersion {
+		version := 0
+		if args != nil {
+			version = args.ProtocolVersion
+		}
+		return nil, &Error{
+			Code:    CodeProtocolVersion,
+			Message: fmt.Sprintf("%s plugin speaks protocol version %d, not %d", s.name, ProtocolVersion, version),
+		}
+	}
+	if s.provider != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "handshake already done"}
+	}
+
+	provider, err := s.factory(ctx, args.Parameters)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	s.provider = provider
+
+	capabilities := supported(api.CapabilitiesOf(provider))
+	return &Result{
+		ProtocolVersion: ProtocolVersion,
+		Name:            s.name,
+		Capabilities:    &capabilities,
+	}, nil
+}
+
+// dispatch calls the provider method of a protocol method
+func (s *server) dispatch(ctx context.Context, method string, args *Args) (*Result, error) {
+	p := s.provider
+	if p == nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "no handshake"}
+	}
+	if args == nil {
+		args = &Args{}
+	}
+	capabilities := supported(api.CapabilitiesOf(p))
+
+	switch method {
+	case MethodInitialize:
+		return nil, p.Initialize(ctx)
+
+	case MethodGetSecret:
+		secret, err := p.GetSecret(ctx, args.Name)
+		return &Result{Secret: secret}, err
+
+	case MethodSetSecret:
+		if args.Secret == nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: "secret is required"}
+		}
+		if err := p.SetSecret(ctx, args.Secret); err != nil {
+			return nil, err
+		}
+		// Providers fill in versions and timestamps
+		return &Result{Secret: args.Secret}, nil
+
+	case MethodDeleteSecret:
+		return nil, p.DeleteSecret(ctx, args.Name)
+
+	case MethodListSecrets:
+		secrets, err := p.ListSecrets(ctx)
+		return &Result{Secrets: secrets}, err
+
+	case MethodGetSecretVersion, MethodListSecretVersions, MethodRollbackSecret:
+		versioner, ok := p.(api.Versioner)
+		if !ok || !capabilities.Versions {
+			return nil, errNotSupported(method)
+		}
+		switch method {
+		case MethodGetSecretVersion:
+			secret, err := versioner.GetSecretVersion(ctx, args.Name, args.Version)
+			return &Result{Secret: secret}, err
+		case MethodListSecretVersions:
+			secrets, err := versioner.ListSecretVersions(ctx, args.Name)
+			return &Result{Secrets: secrets}, err
+		default:
+			secret, err := versioner.RollbackSecret(ctx, args.Name, args.Version)
+			return &Result{Secret: secret}, err
+		}
+
+	case MethodGetRotationPolicy, MethodSetRotationPolicy, MethodRotateSecret:
+		rotator, ok := p.(api.Rotator)
+		if !ok || !capabilities.Rotation {
+			return nil, errNotSupported(method)
+		}
+		switch method {
+		case MethodGetRotationPolicy:
+			policy, err := rotator.GetRotationPolicy(ctx, args.Name)
+			return &Result{Policy: policy}, err
+		case MethodSetRotationPolicy:
+			if args.Policy == nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: "policy is required"}
+			}
+			return nil, rotator.SetRotationPolicy(ctx, args.Name, args.Policy)
+		default:
+			return nil, rotator.RotateSecret(ctx, args.Name)
+		}
+
+	case MethodSearchSecrets:
+		searcher, ok := p.(api.Searcher)
+		if !ok || !capabilities.Search {
+			return nil, errNotSupported(method)
+		}
+		var opts api.SearchOptions
+		if args.Search != nil {
+			opts = *args.Search
+		}
+		secrets, err := searcher.SearchSecrets(ctx, opts)
+		return &Result{Secrets: secrets}, err
+
+	case MethodListSecretPages:
+		pager, ok := p.(api.Pager)
+		if !ok || !capabilities.Paging {
+			return nil, errNotSupported(method)
+		}
+		var opts api.ListOptions
+		if args.List != nil {
+			opts = *args.List
+		}
+		// Page tokens resume a listing, so each page is a call of its own
+		page, err := pager.ListSecretPages(ctx, opts).NextPage(ctx)
+		return &Result{Page: page}, err
+
+	case MethodListFolder, MethodMoveFolder, MethodDeleteFolder:
+		hierarchical, ok := p.(api.Hierarchical)
+		if !ok || !capabilities.Folders {
+			return nil, errNotSupported(method)
+		}
+		switch method {
+		case MethodListFolder:
+			secrets, folders, err := hierarchical.ListFolder(ctx, args.Folder, args.Recursive)
+			return &Result{Secrets: secrets, Folders: folders}, err
+		case MethodMoveFolder:
+			return nil, hierarchical.MoveFolder(ctx, args.From, args.To)
+		default:
+			return nil, hierarchical.DeleteFolder(ctx, args.Folder)
+		}
+
+	case MethodListDeletedSecrets, MethodRecoverSecret, MethodPurgeDeletedSecrets:
+		recoverable, ok := p.(api.Recoverable)
+		if !ok || !capabilities.Trash {
+			return nil, errNotSupported(method)
+		}
+		switch method {
+		case MethodListDeletedSecrets:
+			deleted, err := recoverable.ListDeletedSecrets(ctx)
+			return &Result{Deleted: deleted}, err
+		case MethodRecoverSecret:
+			return nil, recoverable.RecoverSecret(ctx, args.Name)
+		default:
+			var before time.Time
+			if args.Before != nil {
+				before = *args.Before
+			}
+			count, err := recoverable.PurgeDeletedSecrets(ctx, before)
+			return &Result{Count: count}, err
+		}
+
+	default:
+		return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+	}
+}